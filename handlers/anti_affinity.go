@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// AntiAffinityLabel label naming comma-separated functions which a function should not share
+// a node with. Swarm has no anti-affinity between services, so it is approximated with a
+// spread preference on AntiAffinityNodeLabel, which spreads the replicas of the function
+// evenly between the nodes rather than stacking them on the nodes the named functions
+// already run on. Swarm only spreads the tasks of one service, so the named functions are
+// not moved and the function can still share a node with them.
+const AntiAffinityLabel = "com.openfaas.placement.anti_affinity"
+
+// AntiAffinityNodeLabel node label shared by the nodes which the functions of
+// AntiAffinityLabel are spread over, each node should be given its own value
+const AntiAffinityNodeLabel = "com.openfaas.node"
+
+// antiAffinityClient is the subset of Docker Client methods required to apply AntiAffinityLabel
+type antiAffinityClient interface {
+	NodeLister
+}
+
+// getAntiAffinity returns the function names from AntiAffinityLabel
+func getAntiAffinity(request *typesv1.FunctionDeployment) ([]string, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[AntiAffinityLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	var functions []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		if name == request.Service {
+			return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: a function can not avoid itself", AntiAffinityLabel)
+		}
+		functions = append(functions, name)
+	}
+
+	if len(functions) == 0 {
+		return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: no function given", AntiAffinityLabel)
+	}
+
+	return functions, nil
+}
+
+// applyAntiAffinity adds a spread preference on AntiAffinityNodeLabel to spec when it
+// avoids any functions, it returns a warning when no node has the label to spread over
+func applyAntiAffinity(c antiAffinityClient, spec *swarm.ServiceSpec, functions []string) (string, error) {
+	if len(functions) == 0 {
+		return "", nil
+	}
+
+	if spec.TaskTemplate.Placement == nil {
+		spec.TaskTemplate.Placement = &swarm.Placement{}
+	}
+
+	descriptor := "node.labels." + AntiAffinityNodeLabel
+
+	spread := false
+	for _, preference := range spec.TaskTemplate.Placement.Preferences {
+		if preference.Spread != nil && preference.Spread.SpreadDescriptor == descriptor {
+			spread = true
+			break
+		}
+	}
+
+	if !spread {
+		spec.TaskTemplate.Placement.Preferences = append(spec.TaskTemplate.Placement.Preferences, swarm.PlacementPreference{
+			Spread: &swarm.SpreadOver{SpreadDescriptor: descriptor},
+		})
+	}
+
+	nodes, err := c.NodeList(context.Background(), types.NodeListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		if _, exists := node.Spec.Labels[AntiAffinityNodeLabel]; exists {
+			return "", nil
+		}
+	}
+
+	return fmt.Sprintf("label %s: no node has the label %s to spread over, the function may share a node with %s", AntiAffinityLabel, AntiAffinityNodeLabel, strings.Join(functions, ", ")), nil
+}
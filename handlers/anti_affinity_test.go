@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func Test_DeployFunction_AntiAffinity(t *testing.T) {
+	spreadOverNodes := swarm.PlacementPreference{Spread: &swarm.SpreadOver{SpreadDescriptor: "node.labels." + AntiAffinityNodeLabel}}
+	spreadOverZones := swarm.PlacementPreference{Spread: &swarm.SpreadOver{SpreadDescriptor: "node.labels.zone"}}
+
+	scenarios := []struct {
+		name            string
+		labels          map[string]string
+		nodeLabels      bool
+		wantPreferences []swarm.PlacementPreference
+		wantWarning     bool
+	}{
+		{
+			name:   "no anti-affinity",
+			labels: map[string]string{},
+		},
+		{
+			name:            "spread over the node label",
+			labels:          map[string]string{AntiAffinityLabel: "db"},
+			nodeLabels:      true,
+			wantPreferences: []swarm.PlacementPreference{spreadOverNodes},
+		},
+		{
+			name:            "after the placement preferences of the function",
+			labels:          map[string]string{AntiAffinityLabel: "db", PlacementPreferenceLabel: "node.labels.zone"},
+			nodeLabels:      true,
+			wantPreferences: []swarm.PlacementPreference{spreadOverZones, spreadOverNodes},
+		},
+		{
+			name:            "not repeated when already a placement preference",
+			labels:          map[string]string{AntiAffinityLabel: "db", PlacementPreferenceLabel: "node.labels." + AntiAffinityNodeLabel},
+			nodeLabels:      true,
+			wantPreferences: []swarm.PlacementPreference{spreadOverNodes},
+		},
+		{
+			name:            "no node has the node label",
+			labels:          map[string]string{AntiAffinityLabel: "db"},
+			wantPreferences: []swarm.PlacementPreference{spreadOverNodes},
+			wantWarning:     true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.nodes = []swarm.Node{readyNode("node1"), readyNode("node2"), readyNode("node3")}
+			if s.nodeLabels {
+				for i := range c.nodes {
+					c.nodes[i].Spec.Labels = map[string]string{AntiAffinityNodeLabel: c.nodes[i].ID}
+				}
+			}
+
+			request := batchRequest("figlet")
+			request.Labels = &s.labels
+
+			status, warnings, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+			if status != http.StatusAccepted {
+				t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+			}
+
+			placement := c.specs[0].TaskTemplate.Placement
+			if preferences := placement.Preferences; !reflect.DeepEqual(preferences, s.wantPreferences) {
+				t.Errorf("want: preferences %v got: %v", s.wantPreferences, preferences)
+			}
+
+			want := []string{"node.platform.os == linux"}
+			if !reflect.DeepEqual(placement.Constraints, want) {
+				t.Errorf("want: constraints %v got: %v", want, placement.Constraints)
+			}
+
+			hasWarning := len(warnings) == 1 && strings.Contains(warnings[0], AntiAffinityLabel)
+			if hasWarning != s.wantWarning {
+				t.Errorf("want: warning %v got: %v", s.wantWarning, warnings)
+			}
+		})
+	}
+}
+
+func Test_GetAntiAffinity_Invalid(t *testing.T) {
+	for _, value := range []string{"figlet", " , "} {
+		request := batchRequest("figlet")
+		request.Labels = &map[string]string{AntiAffinityLabel: value}
+
+		if _, err := getAntiAffinity(&request.FunctionDeployment); errorCode(err, "") != ErrCodeInvalidPlacement {
+			t.Errorf("want: error code %s for %q got: %v", ErrCodeInvalidPlacement, value, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// batchDeployWorkers is how many functions of a batch are deployed at the same time
+const batchDeployWorkers = 4
+
+// BatchDeployResult is the outcome of deploying one function of a batch
+type BatchDeployResult struct {
+	Service string       `json:"service"`
+	Status  int          `json:"status"`
+	Error   *DeployError `json:"error,omitempty"`
+
+	// Warnings are problems found with a function which was deployed anyway
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// MakeBatchDeployHandler deploys an array of functions concurrently and returns a result
+// for each one, in the order of the request. When every function is accepted the status
+// is 202, otherwise 207 Multi-Status is returned and the results must be checked. An
+// Idempotency-Key applies to each function of the batch, so a retried batch only deploys
+// the functions which were not accepted before.
+func MakeBatchDeployHandler(c DeployClient, config DeployConfig) http.HandlerFunc {
+	deployed := newIdempotencyCache(config.IdempotencyTTL)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, _ := ioutil.ReadAll(r.Body)
+
+		requests := []CreateFunctionRequest{}
+		if err := json.Unmarshal(body, &requests); err != nil {
+			log.Println("Error parsing batch request:", err)
+			writeDeployError(w, http.StatusBadRequest, err, ErrCodeInvalidRequest)
+			return
+		}
+
+		if len(requests) == 0 {
+			writeDeployError(w, http.StatusBadRequest, newDeployError(ErrCodeInvalidRequest, "no functions given"), ErrCodeInvalidRequest)
+			return
+		}
+
+		var key string
+		if config.IdempotencyTTL > 0 {
+			key = r.Header.Get(IdempotencyKeyHeader)
+		}
+
+		results := deployBatch(c, config, requests, batchDeployWorkers, deployed, key)
+
+		status := http.StatusAccepted
+		for _, result := range results {
+			if result.Error != nil {
+				status = http.StatusMultiStatus
+				break
+			}
+		}
+
+		resultsBytes, _ := json.Marshal(results)
+
+		writeJSON(w, status, resultsBytes)
+	}
+}
+
+// deployBatch deploys the requests using at most workers goroutines, when key is set the
+// functions already deployed with it are skipped
+func deployBatch(c DeployClient, config DeployConfig, requests []CreateFunctionRequest, workers int, deployed *idempotencyCache, key string) []BatchDeployResult {
+	results := make([]BatchDeployResult, len(requests))
+	indexes := make(chan int)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers && i < len(requests); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				request := requests[index]
+				results[index] = deployBatchEntry(c, config, &request, deployed, key)
+			}
+		}()
+	}
+
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	return results
+}
+
+// deployBatchEntry deploys one function of a batch, reserving key for it first when set
+func deployBatchEntry(c DeployClient, config DeployConfig, request *CreateFunctionRequest, deployed *idempotencyCache, key string) BatchDeployResult {
+	result := BatchDeployResult{Service: request.Service}
+
+	var idempotencyKeyValue string
+	if len(key) > 0 {
+		idempotencyKeyValue = idempotencyKey(key, request.Service)
+		if !deployed.Reserve(idempotencyKeyValue) {
+			if deployed.Seen(idempotencyKeyValue) {
+				log.Printf("Batch deployment of %s already accepted for %s: %s\n", request.Service, IdempotencyKeyHeader, key)
+				result.Status = http.StatusAccepted
+				return result
+			}
+
+			result.Status = http.StatusConflict
+			result.Error = newDeployError(ErrCodeDeployInProgress, "deployment of %s is in progress for %s: %s", request.Service, IdempotencyKeyHeader, key)
+			return result
+		}
+	}
+
+	status, warnings, err := deployFunction(c, config, request, nil)
+	result.Status = status
+	result.Warnings = warnings
+	if err != nil {
+		log.Printf("Batch deployment of %s failed: %s\n", request.Service, err)
+		result.Error = toDeployError(err, ErrCodeDeployFailed)
+
+		if len(idempotencyKeyValue) > 0 {
+			deployed.Release(idempotencyKeyValue)
+		}
+		return result
+	}
+
+	if len(idempotencyKeyValue) > 0 {
+		deployed.Add(idempotencyKeyValue)
+	}
+
+	return result
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+type fakeDeployClient struct {
+	*fakeDockerSecretAPIClient
+	fakeNetworkLister
+
+	mu       sync.Mutex
+	created  []string
+	options  []types.ServiceCreateOptions
+	specs    []swarm.ServiceSpec
+	scaled   []uint64
+	updated  []swarm.ServiceSpec
+	updates  []types.ServiceUpdateOptions
+	rejected map[string]bool
+
+	nodes    []swarm.Node
+	tasks    []swarm.Task
+	services []swarm.Service
+
+	// images are the images DistributionInspect resolves
+	images map[string]bool
+
+	// distributionErr is returned by DistributionInspect for the images it does not resolve
+	distributionErr error
+
+	// blockCreate makes ServiceCreate hang until its context is cancelled
+	blockCreate bool
+
+	// updateErr is returned by ServiceUpdate
+	updateErr error
+
+	// recordUpdates makes ServiceUpdate replace the spec ServiceInspectWithRaw returns, the
+	// replaced spec is kept as the previous spec of the service like Swarm does
+	recordUpdates bool
+	previous      map[string]swarm.ServiceSpec
+}
+
+func (f *fakeDeployClient) ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error) {
+	if f.blockCreate {
+		<-ctx.Done()
+		return types.ServiceCreateResponse{}, ctx.Err()
+	}
+
+	if f.rejected[service.Name] {
+		return types.ServiceCreateResponse{}, fmt.Errorf("service %s already exists", service.Name)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, service.Name)
+	f.options = append(f.options, options)
+	f.specs = append(f.specs, service)
+
+	return types.ServiceCreateResponse{ID: service.Name}, nil
+}
+
+func (f *fakeDeployClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.specs) - 1; i >= 0; i-- {
+		if f.specs[i].Name == serviceID {
+			service := swarm.Service{ID: serviceID, Spec: f.specs[i]}
+			if previous, ok := f.previous[serviceID]; ok {
+				// the caller changes the spec it is given in place, which must not change the
+				// specs recorded here
+				service.Spec = copySpec(service.Spec)
+				previous = copySpec(previous)
+				service.PreviousSpec = &previous
+			}
+			return service, nil, nil
+		}
+	}
+
+	return swarm.Service{}, nil, fakeNotFoundError{name: serviceID}
+}
+
+func (f *fakeDeployClient) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error) {
+	if f.updateErr != nil {
+		return types.ServiceUpdateResponse{}, f.updateErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scaled = append(f.scaled, *service.Mode.Replicated.Replicas)
+	f.updated = append(f.updated, service)
+	f.updates = append(f.updates, options)
+
+	if f.recordUpdates {
+		f.recordUpdate(serviceID, service, options)
+	}
+
+	return types.ServiceUpdateResponse{}, nil
+}
+
+// copySpec returns a deep copy of spec
+func copySpec(spec swarm.ServiceSpec) swarm.ServiceSpec {
+	var copied swarm.ServiceSpec
+	data, _ := json.Marshal(spec)
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// recordUpdate makes service the current spec of serviceID, or its previous spec when the
+// update is a rollback
+func (f *fakeDeployClient) recordUpdate(serviceID string, service swarm.ServiceSpec, options types.ServiceUpdateOptions) {
+	if f.previous == nil {
+		f.previous = map[string]swarm.ServiceSpec{}
+	}
+
+	for i := len(f.specs) - 1; i >= 0; i-- {
+		if f.specs[i].Name != serviceID {
+			continue
+		}
+
+		current := f.specs[i]
+		if options.Rollback == "previous" {
+			service = f.previous[serviceID]
+		}
+		f.previous[serviceID] = current
+		f.specs = append(f.specs, service)
+		return
+	}
+}
+
+func (f *fakeDeployClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeDeployClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	return f.services, nil
+}
+
+func (f *fakeDeployClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registrytypes.DistributionInspect, error) {
+	if !f.images[image] {
+		if f.distributionErr != nil {
+			return registrytypes.DistributionInspect{}, f.distributionErr
+		}
+		return registrytypes.DistributionInspect{}, fmt.Errorf("manifest unknown: %s", image)
+	}
+
+	return registrytypes.DistributionInspect{}, nil
+}
+
+func (f *fakeDeployClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	return f.tasks, nil
+}
+
+func newFakeDeployClient(rejected ...string) *fakeDeployClient {
+	secrets := newFakeDockerSecretAPIClient()
+	f := &fakeDeployClient{
+		fakeDockerSecretAPIClient: &secrets,
+		fakeNetworkLister: fakeNetworkLister{
+			networks: []types.NetworkResource{
+				{Name: "func_functions", Labels: map[string]string{"openfaas": "true"}},
+			},
+		},
+		rejected: map[string]bool{},
+	}
+	for _, name := range rejected {
+		f.rejected[name] = true
+	}
+
+	return f
+}
+
+func doBatchDeploy(t *testing.T, c DeployClient, requests []CreateFunctionRequest) (int, []BatchDeployResult) {
+	body, _ := json.Marshal(requests)
+	req := httptest.NewRequest(http.MethodPost, "/system/functions/batch", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	MakeBatchDeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+	results := []BatchDeployResult{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	return rr.Code, results
+}
+
+func batchRequest(service string) CreateFunctionRequest {
+	return CreateFunctionRequest{
+		FunctionDeployment: typesv1.FunctionDeployment{
+			Service: service,
+			Image:   "functions/alpine:latest",
+		},
+	}
+}
+
+func Test_BatchDeploy_AllSucceed(t *testing.T) {
+	c := newFakeDeployClient()
+	requests := []CreateFunctionRequest{}
+	for i := 0; i < 10; i++ {
+		requests = append(requests, batchRequest(fmt.Sprintf("fn%d", i)))
+	}
+
+	status, results := doBatchDeploy(t, c, requests)
+
+	if status != http.StatusAccepted {
+		t.Errorf("want: %d got: %d", http.StatusAccepted, status)
+	}
+	if len(c.created) != len(requests) {
+		t.Errorf("want: %d services created got: %d", len(requests), len(c.created))
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("want: %d results got: %d", len(requests), len(results))
+	}
+
+	for i, result := range results {
+		if result.Service != requests[i].Service {
+			t.Errorf("want: %s got: %s", requests[i].Service, result.Service)
+		}
+		if result.Status != http.StatusAccepted || result.Error != nil {
+			t.Errorf("%s want: %d without error got: %d %v", result.Service, http.StatusAccepted, result.Status, result.Error)
+		}
+	}
+}
+
+func Test_BatchDeploy_MixedFailures(t *testing.T) {
+	c := newFakeDeployClient("taken")
+
+	badMemory := batchRequest("bad-memory")
+	badMemory.Limits = &typesv1.FunctionResources{Memory: "lots"}
+
+	requests := []CreateFunctionRequest{
+		batchRequest("ok"),
+		badMemory,
+		batchRequest("taken"),
+	}
+
+	status, results := doBatchDeploy(t, c, requests)
+
+	if status != http.StatusMultiStatus {
+		t.Errorf("want: %d got: %d", http.StatusMultiStatus, status)
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("want: %d results got: %d", len(requests), len(results))
+	}
+
+	want := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusAccepted, ""},
+		{http.StatusBadRequest, ErrCodeInvalidMemory},
+		{http.StatusBadRequest, ErrCodeDeployFailed},
+	}
+
+	for i, result := range results {
+		if result.Status != want[i].status {
+			t.Errorf("%s want: %d got: %d", result.Service, want[i].status, result.Status)
+		}
+
+		code := ""
+		if result.Error != nil {
+			code = result.Error.Code
+		}
+		if code != want[i].code {
+			t.Errorf("%s want: %q got: %q", result.Service, want[i].code, code)
+		}
+	}
+}
+
+func Test_BatchDeploy_IdempotencyKeyPerFunction(t *testing.T) {
+	c := newFakeDeployClient("fn1")
+	handler := MakeBatchDeployHandler(c, DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		IdempotencyTTL:      time.Minute,
+	})
+
+	doBatch := func() (int, []BatchDeployResult) {
+		body, _ := json.Marshal([]CreateFunctionRequest{batchRequest("fn0"), batchRequest("fn1")})
+		req := httptest.NewRequest(http.MethodPost, "/system/functions/batch", bytes.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "a1b2")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		results := []BatchDeployResult{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+			t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+		}
+		return rr.Code, results
+	}
+
+	if status, _ := doBatch(); status != http.StatusMultiStatus {
+		t.Fatalf("want: %d got: %d", http.StatusMultiStatus, status)
+	}
+
+	delete(c.rejected, "fn1")
+
+	status, results := doBatch()
+	if status != http.StatusAccepted {
+		t.Fatalf("want: %d for the retried batch got: %d %v", http.StatusAccepted, status, results)
+	}
+
+	want := []string{"fn0", "fn1"}
+	if !reflect.DeepEqual(c.created, want) {
+		t.Errorf("want: only the failed function created again %v got: %v", want, c.created)
+	}
+}
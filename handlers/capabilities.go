@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Feature flags reported by the capabilities endpoint
+const (
+	// FeatureConfigs functions can mount Swarm configs
+	FeatureConfigs = "configs"
+	// FeatureMounts functions can have tmpfs scratch space and bind or volume mounts, see
+	// ScratchSizeLabel and MountsLabel
+	FeatureMounts = "mounts"
+	// FeatureGPU functions can reserve GPUs
+	FeatureGPU = "gpu"
+	// FeatureNamespaces functions can be grouped into namespaces, see NamespaceLabel
+	FeatureNamespaces = "namespaces"
+	// FeatureLogsStreaming function logs can be followed, see MakeLogHandler
+	FeatureLogsStreaming = "logs-streaming"
+)
+
+// capabilities are the features supported by this build of the provider. Configs are not
+// implemented and GPUs can not be reserved through the Swarm service API.
+var capabilities = map[string]bool{
+	FeatureConfigs:       false,
+	FeatureMounts:        true,
+	FeatureGPU:           false,
+	FeatureNamespaces:    true,
+	FeatureLogsStreaming: true,
+}
+
+// Capabilities lists the features of the provider, so clients can hide what is unsupported
+type Capabilities struct {
+	// Features are the names of the supported features, sorted
+	Features []string `json:"features"`
+
+	// Unsupported are the names of the known features which are not supported, sorted
+	Unsupported []string `json:"unsupported"`
+}
+
+// MakeCapabilitiesHandler returns the features supported by the provider
+func MakeCapabilitiesHandler() http.HandlerFunc {
+	body, _ := json.Marshal(readCapabilities(capabilities))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+func readCapabilities(features map[string]bool) Capabilities {
+	result := Capabilities{
+		Features:    []string{},
+		Unsupported: []string{},
+	}
+
+	for name, supported := range features {
+		if supported {
+			result.Features = append(result.Features, name)
+		} else {
+			result.Unsupported = append(result.Unsupported, name)
+		}
+	}
+
+	sort.Strings(result.Features)
+	sort.Strings(result.Unsupported)
+
+	return result
+}
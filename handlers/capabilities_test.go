@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_CapabilitiesHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/system/capabilities", nil)
+	rr := httptest.NewRecorder()
+	MakeCapabilitiesHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("want: content type '%s' got: '%s'", "application/json", contentType)
+	}
+
+	body := map[string][]string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	want := map[string][]string{
+		"features":    {FeatureLogsStreaming, FeatureMounts, FeatureNamespaces},
+		"unsupported": {FeatureConfigs, FeatureGPU},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("want: %v got: %v", want, body)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+const (
+	// ConstraintCheckOff deploys functions without checking their placement constraints
+	ConstraintCheckOff = ""
+
+	// ConstraintCheckWarn deploys functions which no node can run, with a warning
+	ConstraintCheckWarn = "warn"
+
+	// ConstraintCheckError rejects functions which no node can run
+	ConstraintCheckError = "error"
+)
+
+// checkConstraints returns a warning when no node in the swarm satisfies all of the
+// placement constraints, the tasks of such a service would stay pending forever
+func checkConstraints(c NodeLister, constraints []string) (string, error) {
+	if len(constraints) == 0 {
+		return "", nil
+	}
+
+	nodes, err := c.NodeList(context.Background(), types.NodeListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		if node.Spec.Availability == swarm.NodeAvailabilityDrain {
+			continue
+		}
+
+		if nodeMatchesConstraints(node, constraints) {
+			return "", nil
+		}
+	}
+
+	return fmt.Sprintf("no node satisfies the placement constraints: %s", strings.Join(constraints, ", ")), nil
+}
+
+// nodeMatchesConstraints returns true when node satisfies every constraint. Constraints
+// which can not be evaluated are assumed to match and are left for Swarm to validate.
+func nodeMatchesConstraints(node swarm.Node, constraints []string) bool {
+	for _, constraint := range constraints {
+		key, value, equal, ok := parseConstraint(constraint)
+		if !ok {
+			continue
+		}
+
+		actual, known := nodeConstraintValue(node, key)
+		if !known {
+			continue
+		}
+
+		if strings.EqualFold(actual, value) != equal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// constraintKeyExpression matches the key of a placement constraint, such as node.role or
+// node.labels.zone
+var constraintKeyExpression = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+
+// validateConstraints returns an ErrCodeInvalidPlacement error for the first constraint which
+// is not a key, one == or != operator and a value, so a malformed constraint is rejected
+// before it reaches Swarm
+func validateConstraints(constraints []string) error {
+	for _, constraint := range constraints {
+		key, value, _, ok := parseConstraint(constraint)
+		if !ok || !constraintKeyExpression.MatchString(key) || len(value) == 0 || strings.ContainsAny(value, "=!<>") {
+			return newFieldError(ErrCodeInvalidPlacement, "constraints", constraint, "should be a key, the operator == or != and a value, such as node.labels.maintenance != true")
+		}
+	}
+
+	return nil
+}
+
+// parseConstraint splits a constraint such as "node.role == manager" into its key and
+// value, equal is false for the != operator
+func parseConstraint(constraint string) (key string, value string, equal bool, ok bool) {
+	for _, operator := range []string{"==", "!="} {
+		parts := strings.SplitN(constraint, operator, 2)
+		if len(parts) == 2 {
+			key = strings.TrimSpace(parts[0])
+			value = strings.TrimSpace(parts[1])
+			return key, value, operator == "==", len(key) > 0
+		}
+	}
+
+	return "", "", false, false
+}
+
+// nodeConstraintValue returns the value of a constraint key for node, known is false when
+// the key is not supported
+func nodeConstraintValue(node swarm.Node, key string) (value string, known bool) {
+	switch {
+	case key == "node.id":
+		return node.ID, true
+	case key == "node.hostname":
+		return node.Description.Hostname, true
+	case key == "node.role":
+		return string(node.Spec.Role), true
+	case key == "node.platform.os":
+		return node.Description.Platform.OS, true
+	case key == "node.platform.arch":
+		return node.Description.Platform.Architecture, true
+	case strings.HasPrefix(key, "node.labels."):
+		return node.Spec.Labels[strings.TrimPrefix(key, "node.labels.")], true
+	case strings.HasPrefix(key, "engine.labels."):
+		return node.Description.Engine.Labels[strings.TrimPrefix(key, "engine.labels.")], true
+	}
+
+	return "", false
+}
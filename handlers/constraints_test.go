@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func constraintNodes() []swarm.Node {
+	worker := swarm.Node{ID: "node-a"}
+	worker.Spec.Role = swarm.NodeRoleWorker
+	worker.Spec.Labels = map[string]string{"zone": "eu-west-1a"}
+	worker.Description.Hostname = "worker-1"
+	worker.Description.Platform.OS = "linux"
+
+	drained := swarm.Node{ID: "node-b"}
+	drained.Spec.Availability = swarm.NodeAvailabilityDrain
+	drained.Spec.Labels = map[string]string{"gpu": "true"}
+	drained.Description.Platform.OS = "linux"
+
+	return []swarm.Node{worker, drained}
+}
+
+func Test_CheckConstraints(t *testing.T) {
+	c := newFakeDeployClient()
+	c.nodes = constraintNodes()
+
+	scenarios := []struct {
+		name        string
+		constraints []string
+		satisfiable bool
+	}{
+		{name: "no constraints", constraints: nil, satisfiable: true},
+		{name: "default constraints", constraints: []string{"node.platform.os == linux"}, satisfiable: true},
+		{name: "node label", constraints: []string{"node.platform.os == linux", "node.labels.zone==eu-west-1a"}, satisfiable: true},
+		{name: "not equal", constraints: []string{"node.role != manager"}, satisfiable: true},
+		{name: "unsupported key", constraints: []string{"node.unknown == value"}, satisfiable: true},
+		{name: "missing node label", constraints: []string{"node.labels.gpu==true"}, satisfiable: false},
+		{name: "no node with both", constraints: []string{"node.hostname == worker-1", "node.role == manager"}, satisfiable: false},
+		{name: "windows", constraints: []string{"node.platform.os == windows"}, satisfiable: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			warning, err := checkConstraints(c, s.constraints)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if satisfiable := len(warning) == 0; satisfiable != s.satisfiable {
+				t.Errorf("want: satisfiable %v got: %v, warning: %q", s.satisfiable, satisfiable, warning)
+			}
+		})
+	}
+}
+
+func Test_DeployHandler_ConstraintCheck(t *testing.T) {
+	gpuRequest := batchRequest("figlet")
+	gpuRequest.Constraints = []string{"node.labels.gpu==true"}
+
+	scenarios := []struct {
+		name        string
+		check       string
+		wantStatus  int
+		wantWarning bool
+		wantCreated int
+	}{
+		{name: "off", check: ConstraintCheckOff, wantStatus: http.StatusAccepted, wantWarning: false, wantCreated: 1},
+		{name: "warn", check: ConstraintCheckWarn, wantStatus: http.StatusAccepted, wantWarning: true, wantCreated: 1},
+		{name: "error", check: ConstraintCheckError, wantStatus: http.StatusBadRequest, wantWarning: false, wantCreated: 0},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.nodes = constraintNodes()
+
+			config := DeployConfig{
+				MaxLabelValueLength: DefaultMaxLabelValueLength,
+				ConstraintCheck:     s.check,
+			}
+
+			body, _ := json.Marshal(gpuRequest)
+			req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(c, config).ServeHTTP(rr, req)
+
+			if rr.Code != s.wantStatus {
+				t.Errorf("want: %d got: %d %s", s.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			warning := rr.Header().Get("Warning")
+			if hasWarning := strings.Contains(warning, "node.labels.gpu==true"); hasWarning != s.wantWarning {
+				t.Errorf("want: warning %v got: %q", s.wantWarning, warning)
+			}
+
+			if len(c.created) != s.wantCreated {
+				t.Errorf("want: %d services created got: %v", s.wantCreated, c.created)
+			}
+
+			if s.check == ConstraintCheckError {
+				deployErr := DeployError{}
+				if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+					t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+				}
+				if deployErr.Code != ErrCodeInvalidPlacement {
+					t.Errorf("want: %s got: %s", ErrCodeInvalidPlacement, deployErr.Code)
+				}
+			}
+		})
+	}
+}
+
+func Test_DeployHandler_ConstraintCheckSatisfiable(t *testing.T) {
+	c := newFakeDeployClient()
+	c.nodes = constraintNodes()
+
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		ConstraintCheck:     ConstraintCheckError,
+	}
+
+	body, _ := json.Marshal(batchRequest("figlet"))
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, config).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if warning := rr.Header().Get("Warning"); len(warning) > 0 {
+		t.Errorf("want: no warning got: %q", warning)
+	}
+}
+
+func Test_DeployFunction_ConstraintSyntax(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		constraint string
+		wantStatus int
+	}{
+		{name: "negation", constraint: "node.labels.maintenance != true", wantStatus: http.StatusAccepted},
+		{name: "equality", constraint: "node.role == worker", wantStatus: http.StatusAccepted},
+		{name: "single equals", constraint: "node.role = worker", wantStatus: http.StatusBadRequest},
+		{name: "comparison", constraint: "node.labels.cpus >= 4", wantStatus: http.StatusBadRequest},
+		{name: "extra operator", constraint: "node.role !== worker", wantStatus: http.StatusBadRequest},
+		{name: "doubled operator", constraint: "node.role == == worker", wantStatus: http.StatusBadRequest},
+		{name: "missing value", constraint: "node.role ==", wantStatus: http.StatusBadRequest},
+		{name: "missing key", constraint: "!= worker", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			request := batchRequest("figlet")
+			request.Constraints = []string{s.constraint}
+
+			status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+			if status != s.wantStatus {
+				t.Fatalf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if s.wantStatus == http.StatusBadRequest {
+				if code := errorCode(err, ""); code != ErrCodeInvalidPlacement {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidPlacement, code)
+				}
+				if len(c.created) != 0 {
+					t.Errorf("want: no services created got: %v", c.created)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// CgroupParentLabel label naming the cgroup parent for a function's containers, i.e.
+// "/accounting/functions" or the systemd slice "functions.slice". Swarm services can not
+// set a cgroup parent, so the value is validated and kept as a label for external
+// accounting, and the deploy is given a warning that it was not applied.
+const CgroupParentLabel = "com.openfaas.cgroup_parent"
+
+// OOMScoreAdjLabel label setting the OOM score adjustment of a function's containers, from
+// -1000 to 1000, higher values are killed first under memory pressure. Swarm services can not
+// set it, so as with CgroupParentLabel the value is validated and kept as a label, and the
+// deploy is given a warning that it was not applied.
+const OOMScoreAdjLabel = "com.openfaas.oom_score_adj"
+
+// cgroupSegmentExpression matches one segment of a cgroup path
+var cgroupSegmentExpression = regexp.MustCompile(`^[a-zA-Z0-9_.@-]+$`)
+
+// containerSettingWarnings returns a warning for each container setting label which Swarm
+// can not apply, the labels must have been checked with validateRequest
+func containerSettingWarnings(request *typesv1.FunctionDeployment) []string {
+	warnings := []string{}
+
+	if warning, _ := cgroupParentWarning(request); len(warning) > 0 {
+		warnings = append(warnings, warning)
+	}
+
+	if warning, _ := oomScoreAdjWarning(request); len(warning) > 0 {
+		warnings = append(warnings, warning)
+	}
+
+	return warnings
+}
+
+// cgroupParentWarning validates CgroupParentLabel and returns the warning for a function
+// which sets it, or an empty string when it is not set
+func cgroupParentWarning(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return "", nil
+	}
+
+	value, exists := (*request.Labels)[CgroupParentLabel]
+	if !exists {
+		return "", nil
+	}
+
+	if !validCgroupParent(value) {
+		return "", newFieldError(ErrCodeInvalidLabel, CgroupParentLabel, value, "should be a cgroup path such as /accounting/functions or a systemd slice such as functions.slice")
+	}
+
+	return fmt.Sprintf("label %s: Swarm services can not set a cgroup parent, %s is not applied to the containers", CgroupParentLabel, value), nil
+}
+
+// validCgroupParent returns true for an absolute or relative cgroup path without empty,
+// "." or ".." segments
+func validCgroupParent(value string) bool {
+	if len(value) == 0 || len(value) > 4096 {
+		return false
+	}
+
+	for _, segment := range strings.Split(strings.TrimPrefix(value, "/"), "/") {
+		if segment == "." || segment == ".." || !cgroupSegmentExpression.MatchString(segment) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// oomScoreAdjWarning validates OOMScoreAdjLabel and returns the warning for a function which
+// sets it, or an empty string when it is not set
+func oomScoreAdjWarning(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return "", nil
+	}
+
+	value, exists := (*request.Labels)[OOMScoreAdjLabel]
+	if !exists {
+		return "", nil
+	}
+
+	score, err := strconv.Atoi(value)
+	if err != nil || score < -1000 || score > 1000 {
+		return "", newFieldError(ErrCodeInvalidLabel, OOMScoreAdjLabel, value, "should be a whole number from -1000 to 1000")
+	}
+
+	return fmt.Sprintf("label %s: Swarm services can not set an OOM score adjustment, %d is not applied to the containers", OOMScoreAdjLabel, score), nil
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+func Test_CgroupParentWarning(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		labels      *map[string]string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "not set", labels: nil},
+		{name: "absolute path", labels: &map[string]string{CgroupParentLabel: "/accounting/functions"}, wantWarning: true},
+		{name: "systemd slice", labels: &map[string]string{CgroupParentLabel: "functions.slice"}, wantWarning: true},
+		{name: "parent segment", labels: &map[string]string{CgroupParentLabel: "/accounting/../root"}, wantErr: true},
+		{name: "empty segment", labels: &map[string]string{CgroupParentLabel: "/accounting//functions"}, wantErr: true},
+		{name: "invalid characters", labels: &map[string]string{CgroupParentLabel: "/accounting/fn s"}, wantErr: true},
+		{name: "empty", labels: &map[string]string{CgroupParentLabel: ""}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			warning, err := cgroupParentWarning(&typesv1.FunctionDeployment{Labels: s.labels})
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if hasWarning := len(warning) > 0; hasWarning != s.wantWarning {
+				t.Errorf("want: warning %t got: %q", s.wantWarning, warning)
+			}
+		})
+	}
+}
+
+func Test_DeployFunction_CgroupParent(t *testing.T) {
+	c := newFakeDeployClient()
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{CgroupParentLabel: "/accounting/functions"}
+
+	status, warnings, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("want: one warning got: %v", warnings)
+	}
+	if got := c.specs[0].Labels[CgroupParentLabel]; got != "/accounting/functions" {
+		t.Errorf("want: label kept for external accounting got: %q", got)
+	}
+}
+
+func Test_DeployFunction_OOMScoreAdj(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		value      string
+		wantStatus int
+	}{
+		{name: "lowest", value: "-1000", wantStatus: http.StatusAccepted},
+		{name: "highest", value: "1000", wantStatus: http.StatusAccepted},
+		{name: "below range", value: "-1001", wantStatus: http.StatusBadRequest},
+		{name: "above range", value: "1001", wantStatus: http.StatusBadRequest},
+		{name: "not a number", value: "high", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			request := batchRequest("figlet")
+			request.Labels = &map[string]string{OOMScoreAdjLabel: s.value}
+
+			status, warnings, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+			if status != s.wantStatus {
+				t.Fatalf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if s.wantStatus != http.StatusAccepted {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if got := c.specs[0].Labels[OOMScoreAdjLabel]; got != s.value {
+				t.Errorf("want: label %s=%s on the service got: %q", OOMScoreAdjLabel, s.value, got)
+			}
+			if len(warnings) != 1 {
+				t.Errorf("want: one warning that the score is not applied got: %v", warnings)
+			}
+		})
+	}
+}
@@ -7,18 +7,40 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
-// DeleteHandler delete a function
-func DeleteHandler(c *client.Client) http.HandlerFunc {
+// DeleteClient is the subset of Docker Client methods required to delete functions
+type DeleteClient interface {
+	client.SecretAPIClient
+	ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error)
+	ServiceRemove(ctx context.Context, serviceID string) error
+}
+
+// DeleteSummary lists the functions removed by a label selector delete
+type DeleteSummary struct {
+	Removed []string `json:"removed"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// DeleteHandler delete a function, or all of the functions matching the label selector
+// in the "label" query parameter when "confirm=true" is also given. Only the services with
+// functionLabel are removed.
+func DeleteHandler(c DeleteClient, functionLabel string) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		if selector := r.URL.Query().Get("label"); len(selector) > 0 {
+			deleteBySelector(c, w, r, selector, functionLabel)
+			return
+		}
+
 		req := requests.DeleteFunctionRequest{}
 		defer r.Body.Close()
 		reqData, _ := ioutil.ReadAll(r.Body)
@@ -45,16 +67,13 @@ func DeleteHandler(c *client.Client) http.HandlerFunc {
 		// TODO: Filter only "faas" functions (via metadata?)
 		var serviceIDs []string
 		for _, service := range services {
-			isFunction := len(service.Spec.TaskTemplate.ContainerSpec.Labels["function"]) > 0
-
-			if isFunction && req.FunctionName == service.Spec.Name {
+			if isFunctionService(service, functionLabel) && req.FunctionName == service.Spec.Name {
 				serviceIDs = append(serviceIDs, service.ID)
 			}
 		}
 
 		if len(serviceIDs) == 0 {
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(fmt.Sprintf("No such service found: %s.", req.FunctionName)))
+			writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", req.FunctionName))
 			return
 		}
 
@@ -71,8 +90,73 @@ func DeleteHandler(c *client.Client) http.HandlerFunc {
 			log.Println(serviceRemoveErrors)
 			w.WriteHeader(http.StatusInternalServerError)
 		} else {
+			if err := removeInlineSecrets(c, req.FunctionName); err != nil {
+				log.Println(err)
+			}
+
 			w.WriteHeader(http.StatusAccepted)
 		}
 
 	}
 }
+
+// deleteBySelector removes every function with a label matching selector and writes a
+// DeleteSummary of the functions removed
+func deleteBySelector(c DeleteClient, w http.ResponseWriter, r *http.Request, selector string, functionLabel string) {
+	if r.URL.Query().Get("confirm") != "true" {
+		writeText(w, http.StatusBadRequest, "Deleting by label selector requires confirm=true.")
+		return
+	}
+
+	if strings.HasPrefix(selector, "=") || strings.HasSuffix(selector, "=") {
+		writeText(w, http.StatusBadRequest, fmt.Sprintf("Invalid label selector: %s, should be key or key=value.", selector))
+		return
+	}
+
+	log.Printf("Attempting to remove services with label %s\n", selector)
+
+	serviceFilter := filters.NewArgs()
+	serviceFilter.Add("label", selector)
+
+	services, err := c.ServiceList(context.Background(), types.ServiceListOptions{Filters: serviceFilter})
+	if err != nil {
+		log.Printf("Error listing services: %s\n", err)
+		writeText(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	summary := DeleteSummary{
+		Removed: []string{},
+	}
+
+	for _, service := range services {
+		if !isFunctionService(service, functionLabel) {
+			continue
+		}
+
+		if err := c.ServiceRemove(context.Background(), service.ID); err != nil {
+			log.Printf("Error removing service %s: %s\n", service.Spec.Name, err)
+			summary.Failed = append(summary.Failed, service.Spec.Name)
+			continue
+		}
+
+		if err := removeInlineSecrets(c, service.Spec.Name); err != nil {
+			log.Println(err)
+		}
+
+		summary.Removed = append(summary.Removed, service.Spec.Name)
+	}
+
+	if len(summary.Removed) == 0 && len(summary.Failed) == 0 {
+		writeText(w, http.StatusNotFound, fmt.Sprintf("No functions found with label: %s.", selector))
+		return
+	}
+
+	status := http.StatusAccepted
+	if len(summary.Failed) > 0 {
+		status = http.StatusInternalServerError
+	}
+
+	summaryBytes, _ := json.Marshal(summary)
+	writeJSON(w, status, summaryBytes)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+type fakeDeleteClient struct {
+	*fakeDockerSecretAPIClient
+
+	services []swarm.Service
+	removed  []string
+}
+
+func (f *fakeDeleteClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	matched := []swarm.Service{}
+	for _, service := range f.services {
+		for k, v := range service.Spec.Labels {
+			if options.Filters.ExactMatch("label", k+"="+v) {
+				matched = append(matched, service)
+				break
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+func (f *fakeDeleteClient) ServiceRemove(ctx context.Context, serviceID string) error {
+	f.removed = append(f.removed, serviceID)
+	return nil
+}
+
+func labelledFunction(name string, labels map[string]string) swarm.Service {
+	service := swarm.Service{ID: "svc-" + name}
+	service.Spec.Name = name
+	service.Spec.Labels = labels
+	service.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Labels: map[string]string{"function": "true"},
+	}
+	return service
+}
+
+func newFakeDeleteClient() *fakeDeleteClient {
+	secrets := newFakeDockerSecretAPIClient()
+	return &fakeDeleteClient{
+		fakeDockerSecretAPIClient: &secrets,
+		services: []swarm.Service{
+			labelledFunction("checkout", map[string]string{"team": "payments"}),
+			labelledFunction("refunds", map[string]string{"team": "payments"}),
+			labelledFunction("figlet", map[string]string{"team": "tools"}),
+		},
+	}
+}
+
+func doDeleteBySelector(c DeleteClient, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/system/functions?"+query, nil)
+	rr := httptest.NewRecorder()
+	DeleteHandler(c, DefaultFunctionLabel).ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_DeleteHandler_SelectorSingleMatch(t *testing.T) {
+	c := newFakeDeleteClient()
+
+	rr := doDeleteBySelector(c, "label=team%3Dtools&confirm=true")
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	summary := DeleteSummary{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	if want := []string{"figlet"}; !reflect.DeepEqual(summary.Removed, want) {
+		t.Errorf("want: %v got: %v", want, summary.Removed)
+	}
+	if want := []string{"svc-figlet"}; !reflect.DeepEqual(c.removed, want) {
+		t.Errorf("want: %v removed got: %v", want, c.removed)
+	}
+}
+
+func Test_DeleteHandler_SelectorMultiMatch(t *testing.T) {
+	c := newFakeDeleteClient()
+
+	rr := doDeleteBySelector(c, "label=team%3Dpayments&confirm=true")
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	summary := DeleteSummary{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	sort.Strings(summary.Removed)
+	if want := []string{"checkout", "refunds"}; !reflect.DeepEqual(summary.Removed, want) {
+		t.Errorf("want: %v got: %v", want, summary.Removed)
+	}
+	if len(c.removed) != 2 {
+		t.Errorf("want: %d services removed got: %v", 2, c.removed)
+	}
+}
+
+func Test_DeleteHandler_SelectorRequiresConfirm(t *testing.T) {
+	c := newFakeDeleteClient()
+
+	rr := doDeleteBySelector(c, "label=team%3Dpayments")
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+	if len(c.removed) != 0 {
+		t.Errorf("want: no services removed got: %v", c.removed)
+	}
+}
+
+func Test_DeleteHandler_SelectorNoMatch(t *testing.T) {
+	c := newFakeDeleteClient()
+
+	rr := doDeleteBySelector(c, "label=team%3Dsearch&confirm=true")
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
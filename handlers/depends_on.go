@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// DependsOnLabel label naming a function which must have a running replica before this
+// function is created. The deploy only waits when DeployConfig.DependencyTimeout is set.
+const DependsOnLabel = "com.openfaas.depends_on"
+
+// dependencyPollInterval is the delay between reading the tasks of a dependency
+const dependencyPollInterval = time.Second
+
+// getDependency returns the function named by DependsOnLabel, or an empty string when the
+// label is not set
+func getDependency(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return "", nil
+	}
+
+	value, exists := (*request.Labels)[DependsOnLabel]
+	if !exists {
+		return "", nil
+	}
+
+	if len(value) > maxFunctionNameLength || !serviceNameExpression.MatchString(value) {
+		return "", newFieldError(ErrCodeInvalidLabel, DependsOnLabel, value, "should be the name of a function")
+	}
+
+	if value == request.Service {
+		return "", newFieldError(ErrCodeInvalidLabel, DependsOnLabel, value, "a function can not depend on itself")
+	}
+
+	return value, nil
+}
+
+// waitForDependency polls the tasks of the dependency every interval until one of them is
+// running, or timeout passes. The dependency may not have been deployed yet, such as when
+// both are in the same batch, so failures to list its tasks are retried until the timeout.
+func waitForDependency(c TaskLister, dependency string, timeout time.Duration, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", dependency)
+	taskFilter.Add("desired-state", "running")
+
+	var listErr error
+	for {
+		tasks, err := c.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+		listErr = err
+
+		for _, task := range tasks {
+			if task.Status.State == swarm.TaskStateRunning {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			reason := ""
+			if listErr != nil {
+				reason = fmt.Sprintf(": %s", listErr)
+			}
+
+			return newDeployError(ErrCodeDependencyNotReady, "dependency %s has no running replicas after %s%s", dependency, timeout, reason)
+		case <-time.After(interval):
+		}
+	}
+}
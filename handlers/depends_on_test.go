@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func Test_DeployFunction_DependsOn(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		dependency string
+		tasks      []swarm.Task
+		timeout    time.Duration
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "dependency ready", dependency: "database", tasks: []swarm.Task{summaryTask("database", swarm.TaskStateRunning)}, timeout: time.Second, wantStatus: http.StatusAccepted},
+		{name: "dependency not ready", dependency: "database", tasks: []swarm.Task{summaryTask("database", swarm.TaskStateStarting)}, timeout: 20 * time.Millisecond, wantStatus: http.StatusFailedDependency, wantCode: ErrCodeDependencyNotReady},
+		{name: "dependency missing", dependency: "database", timeout: 20 * time.Millisecond, wantStatus: http.StatusFailedDependency, wantCode: ErrCodeDependencyNotReady},
+		{name: "waiting disabled", dependency: "database", wantStatus: http.StatusAccepted},
+		{name: "invalid name", dependency: "data.base", timeout: time.Second, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidLabel},
+		{name: "depends on itself", dependency: "figlet", timeout: time.Second, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidLabel},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.tasks = s.tasks
+
+			request := batchRequest("figlet")
+			request.Labels = &map[string]string{DependsOnLabel: s.dependency}
+
+			config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, DependencyTimeout: s.timeout}
+			status, _, err := deployFunction(c, config, &request, nil)
+			if status != s.wantStatus {
+				t.Fatalf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if len(s.wantCode) == 0 {
+				return
+			}
+
+			if code := errorCode(err, ""); code != s.wantCode {
+				t.Errorf("want: error code %s got: %s", s.wantCode, code)
+			}
+
+			if len(c.created) != 0 {
+				t.Errorf("want: no services created got: %v", c.created)
+			}
+		})
+	}
+}
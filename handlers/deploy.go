@@ -8,15 +8,21 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/mount"
 
+	"github.com/docker/cli/opts"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
@@ -27,90 +33,691 @@ import (
 
 const annotationLabelPrefix = "com.openfaas.annotations."
 
+// reservedLabelPrefix is the prefix of the labels which configure a function
+const reservedLabelPrefix = "com.openfaas."
+
+// PlacementPreferenceLabel label listing comma-separated node or engine labels to spread
+// replicas over, in priority order
+const PlacementPreferenceLabel = "com.openfaas.placement.preference"
+
+// PlacementNodeIDsLabel label pinning a function to a node by its ID. Swarm constraints
+// can only be combined with AND, so a single node ID is supported, use a node label
+// constraint to run a function on a set of nodes.
+const PlacementNodeIDsLabel = "com.openfaas.placement.node_ids"
+
+// StickyPlacementLabel label which, when "true", pins a function's replicas to the node its
+// oldest running task was scheduled on, so warm caches survive restarts. Swarm has no node
+// affinity, so the node is only known once a task has been scheduled and is pinned by the
+// next update of the function. Until then, and after the node leaves the swarm, the
+// replicas can run on any node. All replicas run on the one node.
+const StickyPlacementLabel = "com.openfaas.placement.sticky"
+
+// stickyNodeLabel records the node a sticky function is pinned to
+const stickyNodeLabel = "com.openfaas.placement.sticky_node"
+
+// RestartMaxAttemptsLabel label overriding how many times a function's tasks are rescheduled
+const RestartMaxAttemptsLabel = "com.openfaas.restart.max_attempts"
+
+// RestartConditionLabel label overriding when a function's tasks are restarted, one of
+// "any", "on-failure" or "none"
+const RestartConditionLabel = "com.openfaas.restart.condition"
+
+// RestartWindowLabel label setting how far back the restarts of a function's tasks are counted
+// towards RestartMaxAttemptsLabel, as a duration such as "1h". Without it every restart counts,
+// so a function which crashes now and then over a long time is eventually stopped for good.
+const RestartWindowLabel = "com.openfaas.restart.window"
+
+// StopGracePeriodLabel label setting how long a function's tasks are given to exit after
+// SIGTERM before they are killed, as a duration such as "2m"
+const StopGracePeriodLabel = "com.openfaas.stop_grace_period"
+
+// StopSignalLabel label setting the signal sent to a function's tasks to stop them, as a
+// name such as "SIGINT" or "INT", or a number such as "2"
+const StopSignalLabel = "com.openfaas.stop_signal"
+
+// stopSignals are the Linux signals by number, which StopSignalLabel accepts
+var stopSignals = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT", 7: "SIGBUS",
+	8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2", 13: "SIGPIPE",
+	14: "SIGALRM", 15: "SIGTERM", 16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT", 19: "SIGSTOP",
+	20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU", 25: "SIGXFSZ",
+	26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR", 31: "SIGSYS",
+}
+
+// maxRestartAttempts caps RestartMaxAttemptsLabel
+const maxRestartAttempts = 100
+
+// NetworkModeLabel label to run a function on the host network instead of an overlay
+const NetworkModeLabel = "com.openfaas.network_mode"
+
+const hostNetworkMode = "host"
+
+// ingressNetwork is the routing mesh network. Swarm attaches a service to it when the
+// service publishes ports in ingress mode and rejects explicit attachments to it.
+const ingressNetwork = "ingress"
+
+// PullPolicyLabel label controlling when a function's image is pulled, one of
+// PullPolicyAlways, PullPolicyIfNotPresent or PullPolicyNever
+const PullPolicyLabel = "com.openfaas.pull_policy"
+
+const (
+	// PullPolicyAlways resolves the image digest on every deploy and forces the tasks of an
+	// update to be replaced, so that a moved tag is always pulled
+	PullPolicyAlways = "always"
+	// PullPolicyIfNotPresent deploys the image as given without resolving its digest
+	PullPolicyIfNotPresent = "if-not-present"
+	// PullPolicyNever deploys the image as given and skips VerifyImage, the image is expected
+	// to be on the nodes already
+	PullPolicyNever = "never"
+)
+
+// ScaleTargetLabel label with the per-replica target used by the gateway's autoscaler
+const ScaleTargetLabel = "com.openfaas.scale.target"
+
+// ScaleTypeLabel label with the metric the gateway's autoscaler uses for ScaleTargetLabel
+const ScaleTypeLabel = "com.openfaas.scale.type"
+
+// Scale types accepted in ScaleTypeLabel
+const (
+	ScaleTypeRPS      = "rps"
+	ScaleTypeCPU      = "cpu"
+	ScaleTypeCapacity = "capacity"
+)
+
+// HealthcheckHTTPPathLabel label with a path on the function's HTTP port which returns a
+// successful status when the function is ready, it is checked with curl from inside the container
+const HealthcheckHTTPPathLabel = "com.openfaas.healthcheck.http_path"
+
+// HealthcheckDisableLabel label which disables the HEALTHCHECK of a function's image when "true"
+const HealthcheckDisableLabel = "com.openfaas.healthcheck.disable"
+
+// healthcheckPort is the port the watchdog listens on inside the function container
+const healthcheckPort = 8080
+
+// HostnameLabel label setting the hostname of a function's containers
+const HostnameLabel = "com.openfaas.hostname"
+
+// hostnameLabelExpression matches one dot-separated label of an RFC 1123 hostname
+var hostnameLabelExpression = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// SecurityOptLabel label listing the security options of a function's containers, separated
+// by ",". Swarm services only support the SELinux options of docker run --security-opt, i.e.
+// "label=type:svirt_apache_t" or "label=disable"; seccomp and apparmor profiles are rejected.
+// The categories of a level may be separated by "," too, i.e. "label=level:s0:c100,c200".
+const SecurityOptLabel = "com.openfaas.security_opt"
+
+// EnvPlaceholdersLabel label which substitutes the placeholders in envPlaceholders within
+// the environment variables of a function when "true"
+const EnvPlaceholdersLabel = "com.openfaas.env.placeholders"
+
+// envPlaceholders maps the supported ${NAME} placeholders to the Swarm service templates
+// which are filled in for each task
+var envPlaceholders = map[string]string{
+	"NODE_HOSTNAME": "{{.Node.Hostname}}",
+	"NODE_ID":       "{{.Node.ID}}",
+	"SERVICE_NAME":  "{{.Service.Name}}",
+	"SERVICE_ID":    "{{.Service.ID}}",
+	"TASK_NAME":     "{{.Task.Name}}",
+	"TASK_SLOT":     "{{.Task.Slot}}",
+}
+
+// envPlaceholderExpression matches a ${NAME} placeholder
+var envPlaceholderExpression = regexp.MustCompile(`\$\{([A-Z_]+)\}`)
+
+// TaskLabelPrefix prefixes the labels which are only set on a function's containers, with
+// the prefix removed, i.e. "com.openfaas.task_label.logging=splunk" sets "logging=splunk"
+const TaskLabelPrefix = "com.openfaas.task_label."
+
+// ScratchSizeLabel label which mounts a tmpfs of the given size, i.e. "512m", as scratch
+// space for a function. It is most useful with a read-only root filesystem.
+const ScratchSizeLabel = "com.openfaas.scratch.size"
+
+// ScratchPathLabel label setting where the scratch space is mounted, defaultScratchPath
+// when not set
+const ScratchPathLabel = "com.openfaas.scratch.path"
+
+// defaultScratchPath is where the scratch space of a function is mounted
+const defaultScratchPath = "/scratch"
+
+// ShmSizeLabel label setting the size of /dev/shm, i.e. "1g", in place of the Docker default
+// of 64m. Swarm services can not set the shm size, so a tmpfs of the size is mounted over
+// /dev/shm as docker stack does.
+const ShmSizeLabel = "com.openfaas.shm_size"
+
+// shmPath is where shared memory is mounted in a container
+const shmPath = "/dev/shm"
+
+// PortsLabel label listing the ports to publish for a function, separated by ";". Each entry
+// uses the docker service --publish syntax, i.e. "8080:8080" or
+// "published=8080,target=8080,mode=host" to bypass the routing mesh.
+const PortsLabel = "com.openfaas.ports"
+
+// TeamLabel is the cost allocation label naming the team which owns a function, it selects the
+// default network of the function when DeployConfig.TeamNetworks maps the team
+const TeamLabel = "com.openfaas.cost.team"
+
+// DefaultNetworkLabel is the label selector for the network functions are attached to
+const DefaultNetworkLabel = "openfaas=true"
+
+// DefaultMaxLabelValueLength is the maximum size in bytes of a label or annotation value
+const DefaultMaxLabelValueLength = 4096
+
 var linuxOnlyConstraints = []string{"node.platform.os == linux"}
 
+// DeployConfig holds the provider settings applied when creating or updating functions
+type DeployConfig struct {
+	// MaxRestarts is how many times to reschedule a function
+	MaxRestarts uint64
+
+	// RestartCondition is when to restart a function's tasks unless RestartConditionLabel
+	// is set, swarm.RestartPolicyConditionAny when empty
+	RestartCondition swarm.RestartPolicyCondition
+
+	// RestartDelay is the delay between container restarts
+	RestartDelay time.Duration
+
+	// MaxLabelValueLength is the maximum size in bytes of a label or annotation value
+	MaxLabelValueLength int
+
+	// EnableInlineSecrets allows secret values to be passed in the deploy request
+	EnableInlineSecrets bool
+
+	// NetworkLabel is the key=value label selector used to find the network functions are
+	// attached to when a request does not specify one, DefaultNetworkLabel when empty
+	NetworkLabel string
+
+	// TeamNetworks maps the values of TeamLabel to the network functions of the team are
+	// attached to when a request does not specify one, in place of NetworkLabel
+	TeamNetworks map[string]string
+
+	// IdempotencyTTL is how long a successful deploy is remembered for its Idempotency-Key
+	// header, zero disables idempotency keys
+	IdempotencyTTL time.Duration
+
+	// PrePullTimeout is how long a deploy with PrePullLabel waits for the image to be pulled
+	// on every eligible node, zero waits forever
+	PrePullTimeout time.Duration
+
+	// DefaultConstraints are the placement constraints of a function which does not set its
+	// own. When nil functions are constrained to Linux nodes, an empty list applies none.
+	DefaultConstraints []string
+
+	// LabelConstraints maps label keys to placement constraint templates, a function with the
+	// label is given the constraint with labelConstraintValue replaced by the label value
+	LabelConstraints map[string]string
+
+	// FunctionLabel is the label holding the name of a function, DefaultFunctionLabel when empty
+	FunctionLabel string
+
+	// DeprecatedLabels maps deprecated label keys to the keys which replace them, requests
+	// using a deprecated key are given a warning
+	DeprecatedLabels map[string]string
+
+	// ReplicaQuota caps the desired replicas of the functions in each namespace, zero for no
+	// quota
+	ReplicaQuota uint64
+
+	// MaxFunctions caps how many functions can be deployed, new functions are rejected once
+	// it is reached, zero for no limit
+	MaxFunctions int
+
+	// Metrics accumulates the time taken by each phase of a deploy, nil to only log them
+	Metrics *DeployMetrics
+
+	// Limiter limits the service creates and updates in progress at the same time, nil for
+	// no limit
+	Limiter *DeployLimiter
+
+	// DependencyTimeout is how long a deploy waits for the function named by DependsOnLabel
+	// to have a running replica before giving up, zero does not wait
+	DependencyTimeout time.Duration
+
+	// DeployTimeout is how long to wait for Swarm to create the service before giving up
+	// with 504 Gateway Timeout, zero waits forever
+	DeployTimeout time.Duration
+
+	// RequiredLabels must be set on every function, i.e. the cost allocation labels when
+	// the provider runs in cost-tracking mode
+	RequiredLabels []string
+
+	// DefaultLimits are the memory and CPU limits applied when a function does not set its
+	// own, nil applies no limits
+	DefaultLimits *typesv1.FunctionResources
+
+	// BaseLabels are added to every function, labels of the function take precedence and
+	// labels prefixed with com.openfaas. are never taken from the base labels
+	BaseLabels map[string]string
+
+	// SecretMountPath is the directory secrets are mounted in, DefaultSecretMountPath when empty
+	SecretMountPath string
+
+	// DefaultRegistry is the registry host used for images which do not name one, Docker
+	// Hub when empty
+	DefaultRegistry string
+
+	// AllowedRegistries are the registry and repository prefixes images can be deployed
+	// from, any image is allowed when empty
+	AllowedRegistries []string
+
+	// BindMountSources are the host paths functions can bind mount with MountsLabel, bind
+	// mounts are rejected when empty
+	BindMountSources []string
+
+	// VerifyImage looks up the image of a function in its registry before the service is
+	// created, so a missing image is rejected rather than left failing to pull
+	VerifyImage bool
+
+	// ConstraintCheck checks that a node satisfies the placement constraints of a function
+	// before it is deployed. ConstraintCheckWarn deploys it anyway and returns a Warning
+	// header, ConstraintCheckError rejects it.
+	ConstraintCheck string
+}
+
+// CreateFunctionRequest is the deploy request accepted by faas-swarm, it extends the
+// OpenFaaS FunctionDeployment with fields specific to Swarm
+type CreateFunctionRequest struct {
+	typesv1.FunctionDeployment
+
+	// InlineSecrets are secret values keyed by the name they are mounted as, each one is
+	// created as a one-shot Swarm secret owned by the function and removed with it
+	InlineSecrets map[string]string `json:"inlineSecrets,omitempty"`
+}
+
+// DeployClient is the subset of Docker Client methods required to deploy a function
+type DeployClient interface {
+	client.SecretAPIClient
+	NetworkLister
+	NetworkInspector
+	DistributionInspector
+	NodeLister
+	TaskLister
+	ServiceLister
+	ServiceInspector
+	ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error)
+	ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error)
+}
+
 // DeployHandler creates a new function (service) inside the swarm network.
-func DeployHandler(c *client.Client, maxRestarts uint64, restartDelay time.Duration) http.HandlerFunc {
+// Rejected deployments are reported with a DeployError JSON envelope. With ?dryRun=true the
+// request is only validated, and compared with the function when it already exists. With
+// ?upsert=true a function which already exists is updated rather than rejected, keeping the
+// replicas it is scaled to.
+func DeployHandler(c DeployClient, config DeployConfig) http.HandlerFunc {
+	deployed := newIdempotencyCache(config.IdempotencyTTL)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 		body, _ := ioutil.ReadAll(r.Body)
 
-		request := typesv1.FunctionDeployment{}
+		request := CreateFunctionRequest{}
 		err := json.Unmarshal(body, &request)
 		if err != nil {
 			log.Println("Error parsing request:", err)
-			w.WriteHeader(http.StatusBadRequest)
+			writeDeployError(w, http.StatusBadRequest, err, ErrCodeInvalidRequest)
+			return
+		}
+
+		if isDryRun(r) {
+			dryRunDeploy(w, c, config, &request)
 			return
 		}
 
-		options := types.ServiceCreateOptions{}
-		if len(request.RegistryAuth) > 0 {
-			auth, err := BuildEncodedAuthConfig(request.RegistryAuth, request.Image)
+		var idempotencyKeyValue string
+		if key := r.Header.Get(IdempotencyKeyHeader); len(key) > 0 && config.IdempotencyTTL > 0 {
+			idempotencyKeyValue = idempotencyKey(key, request.Service)
+			if !deployed.Reserve(idempotencyKeyValue) {
+				if deployed.Seen(idempotencyKeyValue) {
+					log.Printf("Deployment of %s already accepted for %s: %s\n", request.Service, IdempotencyKeyHeader, key)
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+
+				err := fmt.Errorf("deployment of %s is in progress for %s: %s", request.Service, IdempotencyKeyHeader, key)
+				writeDeployError(w, http.StatusConflict, err, ErrCodeDeployInProgress)
+				return
+			}
+		}
+
+		// release the key when the function is not deployed, so the request can be retried
+		added := false
+		defer func() {
+			if len(idempotencyKeyValue) > 0 && !added {
+				deployed.Release(idempotencyKeyValue)
+			}
+		}()
+
+		progress := newDeployProgress(w, r)
+
+		exists := false
+		if isUpsert(r) {
+			exists, err = serviceExists(c, request.Service)
 			if err != nil {
-				log.Println("Error building registry auth configuration:", err)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("Invalid registry auth"))
+				log.Printf("Error inspecting service %s: %s\n", request.Service, err)
+				writeDeployError(w, http.StatusInternalServerError, err, ErrCodeDeployFailed)
 				return
 			}
-			options.EncodedRegistryAuth = auth
 		}
 
-		secrets, err := makeSecretsArray(c, request.Secrets)
+		var status int
+		var warnings []string
+		if exists {
+			status, warnings, err = upsertFunction(r.Context(), c, config, &request)
+		} else {
+			status, warnings, err = deployFunction(c, config, &request, progress)
+		}
 		if err != nil {
-			log.Printf("Deployment error: %s\n", err)
+			if progress.Started() {
+				progress.Error(err)
+				return
+			}
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Deployment error: " + err.Error()))
+			writeDeployError(w, status, err, ErrCodeDeployFailed)
 			return
 		}
 
-		if len(request.Network) == 0 {
-			networkValue, networkErr := lookupNetwork(c)
-			if networkErr != nil {
-				log.Printf("Error querying networks: %s\n", networkErr)
-			} else {
-				request.Network = networkValue
-			}
+		if len(idempotencyKeyValue) > 0 {
+			deployed.Add(idempotencyKeyValue)
+			added = true
+		}
+
+		if progress.Started() {
+			watchDeployTasks(c, request.Service, progress)
+			return
 		}
 
-		spec, err := makeSpec(&request, maxRestarts, restartDelay, secrets)
+		for _, warning := range warnings {
+			w.Header().Add("Warning", fmt.Sprintf("199 - %q", warning))
+		}
+
+		w.WriteHeader(status)
+	}
+}
+
+// deployPlan is a validated deploy request along with the spec of the service to create
+type deployPlan struct {
+	spec          swarm.ServiceSpec
+	options       types.ServiceCreateOptions
+	inlineSecrets []*swarm.SecretReference
+	warnings      []string
+	prePull       bool
+}
+
+// planDeploy validates a request and builds the spec of its service, reporting any warnings
+// to progress and the time taken by each phase to timings, which may be nil. When dryRun is
+// set the inline secrets are not created, so nothing is changed in the swarm. Inline secrets
+// created for a plan which fails are removed.
+func planDeploy(c DeployClient, config DeployConfig, request *CreateFunctionRequest, progress *deployProgress, timings *deployTimings, dryRun bool) (deployPlan, int, error) {
+	request.Image = qualifyImage(request.Image, config.DefaultRegistry)
+
+	if err := checkImageAllowed(request.Image, config.AllowedRegistries); err != nil {
+		log.Printf("Rejected image for %s: %s\n", request.Service, err)
+		return deployPlan{}, imageCheckStatus(err), err
+	}
+
+	warnings := renameDeprecatedLabels(&request.FunctionDeployment, config.DeprecatedLabels)
+
+	if err := validateRequest(c, config, request, false); err != nil {
+		log.Printf("Invalid request for %s: %s\n", request.Service, err)
+		return deployPlan{}, http.StatusBadRequest, toDeployError(err, ErrCodeInvalidRequest)
+	}
+
+	warnings = append(warnings, containerSettingWarnings(&request.FunctionDeployment)...)
+
+	options := types.ServiceCreateOptions{}
+	if len(request.RegistryAuth) > 0 {
+		auth, err := BuildEncodedAuthConfig(request.RegistryAuth, request.Image)
 		if err != nil {
+			log.Println("Error building registry auth configuration:", err)
+			return deployPlan{}, http.StatusBadRequest, newDeployError(ErrCodeInvalidRegistryAuth, "Invalid registry auth: %s", err)
+		}
+		options.EncodedRegistryAuth = auth
+	}
 
-			log.Printf("Error creating specification: %s\n", err)
+	pullPolicy, err := getPullPolicy(&request.FunctionDeployment)
+	if err != nil {
+		return deployPlan{}, http.StatusBadRequest, toDeployError(err, ErrCodeInvalidLabel)
+	}
+	// with PullPolicyAlways the Docker client pins the image to the digest the registry
+	// resolves. A multi-arch tag is pinned to the digest of its manifest list, not of one
+	// architecture, and every platform in the list is added to the placement, so each node
+	// still pulls the image for its arch.
+	options.QueryRegistry = pullPolicy == PullPolicyAlways
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Deployment error: " + err.Error()))
-			return
+	// the nodes run the image they already have with PullPolicyNever, so it need not be in a registry
+	if config.VerifyImage && pullPolicy != PullPolicyNever {
+		if err := verifyImage(c, request.Image, options.EncodedRegistryAuth); err != nil {
+			log.Printf("Error verifying the image of %s: %s\n", request.Service, err)
+			return deployPlan{}, verifyImageStatus(err), err
+		}
+	}
+
+	if err := validatePlacementNodes(c, &request.FunctionDeployment); err != nil {
+		log.Printf("Error validating placement: %s\n", err)
+		return deployPlan{}, http.StatusBadRequest, toDeployError(err, ErrCodeInvalidPlacement)
+	}
+
+	networkDone := timings.Start(DeployTimingNetwork)
+	if len(request.Network) == 0 && !isHostNetworkMode(&request.FunctionDeployment) {
+		networkValue, networkErr := defaultNetwork(c, config, &request.FunctionDeployment)
+		if networkErr != nil {
+			networkDone()
+			log.Printf("Error querying networks: %s\n", networkErr)
+			return deployPlan{}, http.StatusInternalServerError, toDeployError(networkErr, ErrCodeInvalidNetwork)
+		}
+		request.Network = networkValue
+
+		if len(request.Network) == 0 {
+			networkDone()
+			return deployPlan{}, http.StatusBadRequest, errNoNetwork(config.NetworkLabel)
+		}
+	}
+
+	err = checkNetworkEncryption(c, &request.FunctionDeployment)
+	networkDone()
+	if err != nil {
+		log.Printf("Error checking the network of %s: %s\n", request.Service, err)
+		return deployPlan{}, networkCheckStatus(err), toDeployError(err, ErrCodeInvalidNetwork)
+	}
+
+	secretsDone := timings.Start(DeployTimingSecrets)
+	secrets, err := makeSecretsArray(c, request.Secrets, config.SecretMountPath)
+	if err != nil {
+		secretsDone()
+		log.Printf("Deployment error: %s\n", err)
+		return deployPlan{}, http.StatusBadRequest, toDeployError(err, ErrCodeInvalidSecret)
+	}
+
+	var inlineSecrets []*swarm.SecretReference
+	// inline secrets were checked by validateRequest
+	if len(request.InlineSecrets) > 0 {
+		if dryRun {
+			inlineSecrets = planInlineSecrets(request.Service, request.InlineSecrets, config.SecretMountPath)
+		} else {
+			inlineSecrets, err = createInlineSecrets(c, request.Service, request.InlineSecrets, config.SecretMountPath)
 		}
+		if err != nil {
+			secretsDone()
+			log.Printf("Deployment error: %s\n", err)
+			return deployPlan{}, http.StatusInternalServerError, toDeployError(err, ErrCodeDeployFailed)
+		}
+		secrets = append(secrets, inlineSecrets...)
+	}
+	secretsDone()
+
+	specDone := timings.Start(DeployTimingSpec)
+	spec, err := makeSpec(&request.FunctionDeployment, config, secrets)
+	specDone()
+	if err != nil {
+
+		log.Printf("Error creating specification: %s\n", err)
+		removeSecretReferences(c, inlineSecrets)
+
+		return deployPlan{}, http.StatusBadRequest, toDeployError(err, ErrCodeInvalidRequest)
+	}
+
+	prePull, err := isPrePull(&request.FunctionDeployment)
+	if err != nil {
+		removeSecretReferences(c, inlineSecrets)
+		return deployPlan{}, http.StatusBadRequest, err
+	}
+
+	// the label was validated by makeSpec
+	antiAffinity, _ := getAntiAffinity(&request.FunctionDeployment)
+	if warning, err := applyAntiAffinity(c, &spec, antiAffinity); err != nil {
+		log.Printf("Error listing the nodes to spread %s over, anti-affinity with %s is not checked: %s\n", request.Service, strings.Join(antiAffinity, ", "), err)
+	} else if len(warning) > 0 {
+		warnings = append(warnings, warning)
+	}
+
+	for _, warning := range warnings {
+		log.Printf("Deploying %s: %s\n", request.Service, warning)
+		progress.Report(DeployPhaseWarning, "%s", warning)
+	}
 
-		response, err := c.ServiceCreate(context.Background(), spec, options)
+	if config.ConstraintCheck != ConstraintCheckOff {
+		warning, err := checkConstraints(c, spec.TaskTemplate.Placement.Constraints)
 		if err != nil {
+			log.Printf("Error checking placement constraints: %s\n", err)
+		} else if len(warning) > 0 {
+			if config.ConstraintCheck == ConstraintCheckError {
+				removeSecretReferences(c, inlineSecrets)
+				return deployPlan{}, http.StatusBadRequest, newDeployError(ErrCodeInvalidPlacement, "%s", warning)
+			}
 
-			log.Printf("Error creating service: %s\n", err)
+			log.Printf("Deploying %s: %s\n", request.Service, warning)
+			warnings = append(warnings, warning)
+			progress.Report(DeployPhaseWarning, "%s", warning)
+		}
+	}
 
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Deployment error: " + err.Error()))
-			return
+	return deployPlan{
+		spec:          spec,
+		options:       options,
+		inlineSecrets: inlineSecrets,
+		warnings:      warnings,
+		prePull:       prePull,
+	}, http.StatusOK, nil
+}
+
+// deployFunction creates the service for a request and returns the HTTP status to report
+// along with any warnings, any error returned is a DeployError. Once the request is valid
+// each phase of the deploy is reported to progress, which may be nil.
+func deployFunction(c DeployClient, config DeployConfig, request *CreateFunctionRequest, progress *deployProgress) (int, []string, error) {
+	timings := &deployTimings{}
+	defer logDeployTimings(request.Service, timings, config.Metrics)
+
+	plan, status, err := planDeploy(c, config, request, progress, timings, false)
+	if err != nil {
+		return status, nil, err
+	}
+
+	spec, options, inlineSecrets, warnings := plan.spec, plan.options, plan.inlineSecrets, plan.warnings
+
+	// the label was validated by planDeploy
+	if dependency, _ := getDependency(&request.FunctionDeployment); len(dependency) > 0 && config.DependencyTimeout > 0 {
+		progress.Report(DeployPhaseWaiting, "waiting for %s to have a running replica", dependency)
+
+		if err := waitForDependency(c, dependency, config.DependencyTimeout, dependencyPollInterval); err != nil {
+			log.Printf("Error waiting for the dependency of %s: %s\n", request.Service, err)
+			removeSecretReferences(c, inlineSecrets)
+			return http.StatusFailedDependency, nil, err
+		}
+	}
+
+	if options.QueryRegistry {
+		progress.Report(DeployPhasePulling, "resolving %s", request.Image)
+	}
+	progress.Report(DeployPhaseCreating, "creating %s", request.Service)
+
+	ctx := context.Background()
+	if config.DeployTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.DeployTimeout)
+		defer cancel()
+	}
+
+	if err := checkFunctionCount(c, config.MaxFunctions, config.FunctionLabel, request.Service); err != nil {
+		log.Printf("Error checking the function count for %s: %s\n", request.Service, err)
+		removeSecretReferences(c, inlineSecrets)
+		return quotaStatus(err), nil, toDeployError(err, ErrCodeDeployFailed)
+	}
+
+	if err := checkReplicaQuota(c, config.ReplicaQuota, config.FunctionLabel, requestNamespace(&request.FunctionDeployment), request.Service, *spec.Mode.Replicated.Replicas); err != nil {
+		log.Printf("Error checking the replica quota for %s: %s\n", request.Service, err)
+		removeSecretReferences(c, inlineSecrets)
+		return quotaStatus(err), nil, toDeployError(err, ErrCodeDeployFailed)
+	}
+
+	if err := config.Limiter.Acquire(ctx); err != nil {
+		log.Printf("Error waiting to create service %s: %s\n", request.Service, err)
+		removeSecretReferences(c, inlineSecrets)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return http.StatusGatewayTimeout, nil, newDeployError(ErrCodeDeployTimeout, "timed out after %s waiting for other deployments of %s to finish", config.DeployTimeout, request.Service)
 		}
 
-		if len(response.Warnings) > 0 {
-			log.Println(response.Warnings)
+		return throttleStatus(err), nil, toDeployError(err, ErrCodeDeployThrottled)
+	}
+
+	createDone := timings.Start(DeployTimingCreate)
+	response, err := c.ServiceCreate(ctx, spec, options)
+	createDone()
+	// the slot only limits the creates sent to Swarm, it is released before the pre-pull so a
+	// slow pull does not hold up other deployments
+	config.Limiter.Release()
+	if err != nil {
+
+		log.Printf("Error creating service: %s\n", err)
+		removeSecretReferences(c, inlineSecrets)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return http.StatusGatewayTimeout, nil, newDeployError(ErrCodeDeployTimeout, "timed out after %s waiting for Swarm to create %s", config.DeployTimeout, request.Service)
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeDeployFailed)
+	}
+
+	if len(response.Warnings) > 0 {
+		log.Println(response.Warnings)
+	}
+
+	progress.Report(DeployPhaseAccepted, "%s accepted", request.Service)
+
+	if plan.prePull {
+		progress.Report(DeployPhasePrePulling, "pulling %s on every eligible node", request.Image)
+
+		if err := prePullImage(c, response.ID, spec, config); err != nil {
+			warning := fmt.Sprintf("pre-pull of %s incomplete: %s", request.Image, err)
+			log.Printf("Deploying %s: %s\n", request.Service, warning)
+			warnings = append(warnings, warning)
+			progress.Report(DeployPhaseWarning, "%s", warning)
+		}
 	}
+
+	return http.StatusAccepted, warnings, nil
+}
+
+// NetworkLister is the subset of Docker Client methods required to look up the function network
+type NetworkLister interface {
+	NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error)
 }
 
-func lookupNetwork(c *client.Client) (string, error) {
+// lookupNetwork returns the first network matching the label selector, given as key=value,
+// or "" when none matches. An error listing the networks is returned.
+func lookupNetwork(c NetworkLister, labelSelector string) (string, error) {
+	if len(labelSelector) == 0 {
+		labelSelector = DefaultNetworkLabel
+	}
+
 	networkFilters := filters.NewArgs()
-	networkFilters.Add("label", "openfaas=true")
+	networkFilters.Add("label", labelSelector)
 	networkListOptions := types.NetworkListOptions{
 		Filters: networkFilters,
 	}
 
 	networks, networkErr := c.NetworkList(context.Background(), networkListOptions)
 	if networkErr != nil {
-		return "", nil
+		return "", fmt.Errorf("error listing the networks with label %s: %s", labelSelector, networkErr)
 	}
 
 	if len(networks) > 0 {
@@ -120,104 +727,863 @@ func lookupNetwork(c *client.Client) (string, error) {
 	return "", nil
 }
 
-func makeSpec(request *typesv1.FunctionDeployment, maxRestarts uint64, restartDelay time.Duration, secrets []*swarm.SecretReference) (swarm.ServiceSpec, error) {
-	constraints := []string{}
+// defaultNetwork returns the network for a request which does not name one: the network
+// mapped to the value of TeamLabel in config.TeamNetworks, or otherwise the network found by
+// lookupNetwork
+func defaultNetwork(c NetworkLister, config DeployConfig, request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels != nil {
+		if network, exists := config.TeamNetworks[(*request.Labels)[TeamLabel]]; exists {
+			return network, nil
+		}
+	}
 
-	if request.Constraints != nil && len(request.Constraints) > 0 {
-		constraints = request.Constraints
-	} else {
-		constraints = linuxOnlyConstraints
+	return lookupNetwork(c, config.NetworkLabel)
+}
+
+// errNoNetwork is returned when a request has no network and none has the function network label
+func errNoNetwork(labelSelector string) error {
+	if len(labelSelector) == 0 {
+		labelSelector = DefaultNetworkLabel
 	}
 
-	labels, err := buildLabels(request)
+	return newDeployError(ErrCodeInvalidNetwork, "no openfaas network found with label %s and none specified", labelSelector)
+}
+
+func makeSpec(request *typesv1.FunctionDeployment, config DeployConfig, secrets []*swarm.SecretReference) (swarm.ServiceSpec, error) {
+	labels, err := buildLabels(request, config.BaseLabels, config.FunctionLabel, config.MaxLabelValueLength)
 	if err != nil {
 		nilSpec := swarm.ServiceSpec{}
 		return nilSpec, err
 	}
 
-	resources := buildResources(request)
+	if err := requireLabels(request, config.RequiredLabels); err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
 
-	nets := []swarm.NetworkAttachmentConfig{
-		{
-			Target: request.Network,
-		},
+	serviceLabels, containerLabels, err := splitTaskLabels(labels)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
 	}
 
-	spec := swarm.ServiceSpec{
-		Annotations: swarm.Annotations{
-			Name:   request.Service,
-			Labels: labels,
-		},
-		TaskTemplate: swarm.TaskSpec{
-			RestartPolicy: &swarm.RestartPolicy{
-				MaxAttempts: &maxRestarts,
-				Condition:   swarm.RestartPolicyConditionAny,
-				Delay:       &restartDelay,
-			},
-			ContainerSpec: &swarm.ContainerSpec{
-				Image:    request.Image,
-				Labels:   labels,
-				Secrets:  secrets,
-				ReadOnly: request.ReadOnlyRootFilesystem,
-			},
-			Networks:  nets,
-			Resources: resources,
-			Placement: &swarm.Placement{
-				Constraints: constraints,
-			},
-		},
-		Mode: swarm.ServiceMode{
-			Replicated: &swarm.ReplicatedService{
-				Replicas: getMinReplicas(request),
-			},
-		},
+	placement, err := buildPlacement(request, config.LabelConstraints, config.DefaultConstraints)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	maxRestarts, err := getMaxRestarts(request, config.MaxRestarts)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	restartCondition, err := getRestartCondition(request, config.RestartCondition)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	restartWindow, err := getRestartWindow(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	stopGracePeriod, err := getStopGracePeriod(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+	restartDelay := getRestartDelay(config.RestartDelay, stopGracePeriod)
+
+	stopSignal, err := getStopSignal(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	resources, err := buildResources(request, config.DefaultLimits)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	nets, err := buildNetworks(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	ports, err := buildPorts(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	healthcheck, err := buildHealthcheck(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	hostname, err := getHostname(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	privileges, err := buildPrivileges(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	scratch, err := buildScratchMount(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	shm, err := buildShmMount(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	mounts, err := buildMounts(request, config.BindMountSources)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	spec := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   request.Service,
+			Labels: serviceLabels,
+		},
+		TaskTemplate: swarm.TaskSpec{
+			RestartPolicy: &swarm.RestartPolicy{
+				MaxAttempts: &maxRestarts,
+				Condition:   restartCondition,
+				Delay:       &restartDelay,
+				Window:      restartWindow,
+			},
+			ContainerSpec: &swarm.ContainerSpec{
+				Image:           request.Image,
+				Hostname:        hostname,
+				Labels:          containerLabels,
+				Secrets:         secrets,
+				ReadOnly:        request.ReadOnlyRootFilesystem,
+				Healthcheck:     healthcheck,
+				Privileges:      privileges,
+				StopGracePeriod: stopGracePeriod,
+				StopSignal:      stopSignal,
+			},
+			Networks:  nets,
+			Resources: resources,
+			Placement: placement,
+		},
+		Mode: swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{
+				Replicas: getMinReplicas(request),
+			},
+		},
+	}
+
+	if len(ports) > 0 {
+		spec.EndpointSpec = &swarm.EndpointSpec{
+			Ports: ports,
+		}
+	}
+
+	if request.ReadOnlyRootFilesystem {
+		spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
+			{
+				Type:   mount.TypeTmpfs,
+				Target: "/tmp",
+			},
+		}
+	}
+
+	if scratch != nil {
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, *scratch)
+	}
+
+	if shm != nil {
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, *shm)
+	}
+
+	spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, mounts...)
+
+	if err := checkMountTargets(spec.TaskTemplate.ContainerSpec.Mounts); err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	if err := checkMountCollisions(spec.TaskTemplate.ContainerSpec); err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	envProcess, envVars, err := resolveEnvProcess(request)
+	if err != nil {
+		nilSpec := swarm.ServiceSpec{}
+		return nilSpec, err
+	}
+
+	// TODO: request.EnvProcess should only be set if it's not nil, otherwise we override anything in the Docker image already
+	env := buildEnv(envProcess, envVars, useEnvPlaceholders(request))
+
+	if len(env) > 0 {
+		spec.TaskTemplate.ContainerSpec.Env = env
+	}
+
+	return spec, nil
+}
+
+// isHostNetworkMode returns true when the function asks for host networking
+func isHostNetworkMode(request *typesv1.FunctionDeployment) bool {
+	return request.Labels != nil && (*request.Labels)[NetworkModeLabel] == hostNetworkMode
+}
+
+// buildNetworks attaches the function to its overlay network, or to the host network when
+// NetworkModeLabel is "host". Host mode functions bypass the overlay, so they can not be
+// resolved by name from the gateway and must not be given a custom network or published ports.
+func buildNetworks(request *typesv1.FunctionDeployment) ([]swarm.NetworkAttachmentConfig, error) {
+	if request.Labels != nil {
+		if mode, exists := (*request.Labels)[NetworkModeLabel]; exists && mode != hostNetworkMode {
+			return nil, newFieldError(ErrCodeInvalidNetwork, NetworkModeLabel, mode, fmt.Sprintf("only %s is supported", hostNetworkMode))
+		}
+	}
+
+	if isHostNetworkMode(request) {
+		if len(request.Network) > 0 {
+			return nil, newFieldError(ErrCodeInvalidNetwork, "network", request.Network, fmt.Sprintf("a network can not be attached with host networking from label %s", NetworkModeLabel))
+		}
+
+		// the container shares the network of its node, there is no port of its own to publish
+		if ports := strings.TrimSpace((*request.Labels)[PortsLabel]); len(ports) > 0 {
+			return nil, newFieldError(ErrCodeInvalidNetwork, PortsLabel, ports, fmt.Sprintf("ports can not be published with host networking from label %s", NetworkModeLabel))
+		}
+
+		return []swarm.NetworkAttachmentConfig{
+			{
+				Target: hostNetworkMode,
+			},
+		}, nil
+	}
+
+	if request.Network == ingressNetwork {
+		return nil, newDeployError(ErrCodeInvalidNetwork, "network %s can not be attached explicitly, it is attached when ports are published with label %s", ingressNetwork, PortsLabel)
+	}
+
+	return []swarm.NetworkAttachmentConfig{
+		{
+			Target: request.Network,
+		},
+	}, nil
+}
+
+// getHostname returns the container hostname from HostnameLabel, an empty string leaves
+// the engine default in place
+func getHostname(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return "", nil
+	}
+
+	hostname, exists := (*request.Labels)[HostnameLabel]
+	if !exists {
+		return "", nil
+	}
+
+	valid := len(hostname) <= 253
+	for _, part := range strings.Split(hostname, ".") {
+		valid = valid && hostnameLabelExpression.MatchString(part)
+	}
+
+	if !valid {
+		return "", newDeployError(ErrCodeInvalidLabel, "label %s: %s is not a valid hostname", HostnameLabel, hostname)
+	}
+
+	return hostname, nil
+}
+
+// buildPrivileges parses the SELinux options in SecurityOptLabel, nil leaves the defaults of
+// the engine in place
+func buildPrivileges(request *typesv1.FunctionDeployment) (*swarm.Privileges, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[SecurityOptLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	selinux := swarm.SELinuxContext{}
+	for _, opt := range splitSecurityOpts(value) {
+		if len(opt) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid option %s, should be key=value", SecurityOptLabel, opt)
+		}
+
+		switch parts[0] {
+		case "label":
+		case "seccomp", "apparmor":
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: %s profiles are not supported by Swarm services", SecurityOptLabel, parts[0])
+		default:
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: unknown option %s", SecurityOptLabel, parts[0])
+		}
+
+		if parts[1] == "disable" {
+			selinux.Disable = true
+			continue
+		}
+
+		field := strings.SplitN(parts[1], ":", 2)
+		if len(field) != 2 || len(field[1]) == 0 {
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid SELinux option %s, should be user, role, type or level followed by :value", SecurityOptLabel, parts[1])
+		}
+
+		switch field[0] {
+		case "user":
+			selinux.User = field[1]
+		case "role":
+			selinux.Role = field[1]
+		case "type":
+			selinux.Type = field[1]
+		case "level":
+			selinux.Level = field[1]
+		default:
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid SELinux option %s, should be user, role, type or level followed by :value", SecurityOptLabel, parts[1])
+		}
+	}
+
+	if selinux == (swarm.SELinuxContext{}) {
+		return nil, nil
+	}
+
+	return &swarm.Privileges{
+		SELinuxContext: &selinux,
+	}, nil
+}
+
+// splitSecurityOpts splits the options of SecurityOptLabel on ",", except within an SELinux
+// level whose categories are also separated by ",", i.e. "label=level:s0:c100,c200"
+func splitSecurityOpts(value string) []string {
+	opts := []string{}
+	for _, opt := range strings.Split(value, ",") {
+		opt = strings.TrimSpace(opt)
+
+		last := len(opts) - 1
+		if last >= 0 && len(opt) > 0 && !strings.Contains(opt, "=") && strings.HasPrefix(opts[last], "label=level:") {
+			opts[last] += "," + opt
+			continue
+		}
+
+		opts = append(opts, opt)
+	}
+
+	return opts
+}
+
+// buildScratchMount returns the tmpfs mount for ScratchSizeLabel, nil when it is not set
+func buildScratchMount(request *typesv1.FunctionDeployment) (*mount.Mount, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	size, exists := (*request.Labels)[ScratchSizeLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	sizeBytes, err := units.RAMInBytes(size)
+	if err != nil || sizeBytes <= 0 {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid size %s, should be a positive size such as 512m", ScratchSizeLabel, size)
+	}
+
+	target := defaultScratchPath
+	if value, exists := (*request.Labels)[ScratchPathLabel]; exists {
+		target = value
+	}
+
+	if !path.IsAbs(target) || path.Clean(target) != target || target == "/" || target == "/tmp" {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid path %s, should be a clean absolute path other than / and /tmp", ScratchPathLabel, target)
+	}
+
+	if _, shm := (*request.Labels)[ShmSizeLabel]; shm && target == shmPath {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: %s clashes with the mount made for %s", ScratchPathLabel, target, ShmSizeLabel)
+	}
+
+	return &mount.Mount{
+		Type:   mount.TypeTmpfs,
+		Target: target,
+		TmpfsOptions: &mount.TmpfsOptions{
+			SizeBytes: sizeBytes,
+		},
+	}, nil
+}
+
+// buildShmMount returns the tmpfs mounted over /dev/shm for ShmSizeLabel, or nil when the
+// label is not set
+func buildShmMount(request *typesv1.FunctionDeployment) (*mount.Mount, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	size, exists := (*request.Labels)[ShmSizeLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	sizeBytes, err := units.RAMInBytes(size)
+	if err != nil || sizeBytes <= 0 {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid size %s, should be a positive size such as 1g", ShmSizeLabel, size)
+	}
+
+	return &mount.Mount{
+		Type:   mount.TypeTmpfs,
+		Target: shmPath,
+		TmpfsOptions: &mount.TmpfsOptions{
+			SizeBytes: sizeBytes,
+		},
+	}, nil
+}
+
+// tmpfsLabelTargets returns the targets of the tmpfs mounts made for ScratchSizeLabel and
+// ShmSizeLabel of a service, so they can be told apart from tmpfs mounts added outside of
+// faas-swarm
+func tmpfsLabelTargets(labels map[string]string) map[string]bool {
+	targets := map[string]bool{}
+	if _, exists := labels[ScratchSizeLabel]; exists {
+		target := defaultScratchPath
+		if value, exists := labels[ScratchPathLabel]; exists {
+			target = value
+		}
+		targets[target] = true
+	}
+
+	if _, exists := labels[ShmSizeLabel]; exists {
+		targets[shmPath] = true
+	}
+
+	return targets
+}
+
+// buildHealthcheck generates a healthcheck from HealthcheckHTTPPathLabel or disables the
+// image's healthcheck with HealthcheckDisableLabel, nil leaves the healthcheck of the image
+// in place
+func buildHealthcheck(request *typesv1.FunctionDeployment) (*container.HealthConfig, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	path, pathExists := (*request.Labels)[HealthcheckHTTPPathLabel]
+
+	if disable, exists := (*request.Labels)[HealthcheckDisableLabel]; exists {
+		disabled, err := strconv.ParseBool(disable)
+		if err != nil {
+			return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be true or false", HealthcheckDisableLabel, disable)
+		}
+
+		if disabled {
+			if pathExists {
+				return nil, newDeployError(ErrCodeInvalidLabel, "label %s can not be combined with %s", HealthcheckDisableLabel, HealthcheckHTTPPathLabel)
+			}
+
+			return &container.HealthConfig{
+				Test: []string{"NONE"},
+			}, nil
+		}
+	}
+
+	if !pathExists {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t\n") {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid path %s, should start with /", HealthcheckHTTPPathLabel, path)
+	}
+
+	return &container.HealthConfig{
+		Test: []string{"CMD", "curl", "-f", fmt.Sprintf("http://localhost:%d%s", healthcheckPort, path)},
+	}, nil
+}
+
+// buildPorts parses PortsLabel into the ports to publish. Ports default to the ingress
+// routing mesh, ports in host mode are bound on the node running the task so the same
+// published port and protocol can not be used by another port of the function.
+func buildPorts(request *typesv1.FunctionDeployment) ([]swarm.PortConfig, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[PortsLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	portOpts := new(opts.PortOpt)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if err := portOpts.Set(entry); err != nil {
+			return nil, newDeployError(ErrCodeInvalidPort, "label %s: invalid port %s: %s", PortsLabel, entry, err)
+		}
+	}
+
+	ports := portOpts.Value()
+	for i, port := range ports {
+		if port.PublishMode != swarm.PortConfigPublishModeHost || port.PublishedPort == 0 {
+			continue
+		}
+
+		for j, other := range ports {
+			if i != j && other.PublishedPort == port.PublishedPort && other.Protocol == port.Protocol {
+				return nil, newDeployError(ErrCodeInvalidPort, "label %s: host mode port %d/%s collides with another published port", PortsLabel, port.PublishedPort, port.Protocol)
+			}
+		}
+	}
+
+	return ports, nil
+}
+
+// buildConstraints returns a new slice with the placement constraints from the request, or
+// defaultConstraints when none were given, the linux-only default when those are nil. A copy is returned so that the spec
+// never shares a backing array with the request or the defaults.
+func buildConstraints(request *typesv1.FunctionDeployment, defaultConstraints []string) []string {
+	constraints := linuxOnlyConstraints
+	if defaultConstraints != nil {
+		constraints = defaultConstraints
+	}
+
+	if len(request.Constraints) > 0 {
+		constraints = request.Constraints
+	}
+
+	return append([]string{}, constraints...)
+}
+
+// buildPlacement returns the placement constraints and spread preferences for a function,
+// the constraints include those labelConstraints maps the labels of the function to
+func buildPlacement(request *typesv1.FunctionDeployment, labelConstraints map[string]string, defaultConstraints []string) (*swarm.Placement, error) {
+	preferences, err := buildPlacementPreferences(request)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeIDs, err := getPlacementNodeIDs(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Labels != nil {
+		if value, exists := (*request.Labels)[StickyPlacementLabel]; exists && value != "true" && value != "false" {
+			return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: invalid value %s, should be true or false", StickyPlacementLabel, value)
+		}
+	}
+
+	if _, err := getAntiAffinity(request); err != nil {
+		return nil, err
+	}
+
+	constraints := buildConstraints(request, defaultConstraints)
+	if err := validateConstraints(constraints); err != nil {
+		return nil, err
+	}
+
+	for _, nodeID := range nodeIDs {
+		constraints = append(constraints, "node.id == "+nodeID)
+	}
+
+	mapped, err := buildLabelConstraints(request, labelConstraints)
+	if err != nil {
+		return nil, err
+	}
+	constraints = append(constraints, mapped...)
+
+	return &swarm.Placement{
+		Constraints: constraints,
+		Preferences: preferences,
+	}, nil
+}
+
+// getPlacementNodeIDs returns the node IDs from PlacementNodeIDsLabel
+func getPlacementNodeIDs(request *typesv1.FunctionDeployment) ([]string, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[PlacementNodeIDsLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	var nodeIDs []string
+	for _, nodeID := range strings.Split(value, ",") {
+		nodeID = strings.TrimSpace(nodeID)
+		if len(nodeID) > 0 {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+
+	if len(nodeIDs) == 0 {
+		return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: no node id given", PlacementNodeIDsLabel)
+	}
+
+	if len(nodeIDs) > 1 {
+		return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: only one node id is supported as Swarm constraints can not be OR'd, use a node label constraint to target several nodes", PlacementNodeIDsLabel)
+	}
+
+	return nodeIDs, nil
+}
+
+// isStickyPlacement returns true when StickyPlacementLabel is "true" and the function is not
+// already pinned to a node by PlacementNodeIDsLabel
+func isStickyPlacement(request *typesv1.FunctionDeployment) bool {
+	if request.Labels == nil {
+		return false
+	}
+
+	_, pinned := (*request.Labels)[PlacementNodeIDsLabel]
+	return !pinned && (*request.Labels)[StickyPlacementLabel] == "true"
+}
+
+// findStickyNode returns the node a sticky function stays on, which is pinnedNode while it
+// is still in the swarm, otherwise the node of the oldest running task of the service. An
+// empty ID is returned when no task is running.
+func findStickyNode(c stickyClient, serviceID string, pinnedNode string) (string, error) {
+	if len(pinnedNode) > 0 {
+		nodeFilters := filters.NewArgs()
+		nodeFilters.Add("id", pinnedNode)
+
+		nodes, err := c.NodeList(context.Background(), types.NodeListOptions{Filters: nodeFilters})
+		if err != nil {
+			return "", err
+		}
+
+		for _, node := range nodes {
+			if node.ID == pinnedNode {
+				return pinnedNode, nil
+			}
+		}
+	}
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", serviceID)
+	taskFilter.Add("desired-state", "running")
+
+	tasks, err := c.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return "", err
+	}
+
+	if oldest := oldestRunningTask(tasks); oldest != nil {
+		return oldest.NodeID, nil
+	}
+
+	return "", nil
+}
+
+// stickyClient is the subset of Docker Client methods required to find the node of a
+// sticky function
+type stickyClient interface {
+	NodeLister
+	TaskLister
+}
+
+// pinToNode constrains the tasks of a service to a node and records the node in its labels
+func pinToNode(spec *swarm.ServiceSpec, nodeID string) {
+	if spec.TaskTemplate.Placement == nil {
+		spec.TaskTemplate.Placement = &swarm.Placement{}
+	}
+
+	spec.TaskTemplate.Placement.Constraints = append(spec.TaskTemplate.Placement.Constraints, "node.id == "+nodeID)
+	spec.Labels[stickyNodeLabel] = nodeID
+}
+
+// NodeLister is the subset of Docker Client methods required to validate node placement
+type NodeLister interface {
+	NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error)
+}
+
+// validatePlacementNodes checks that the nodes in PlacementNodeIDsLabel are in the swarm,
+// otherwise the tasks of the function would stay pending
+func validatePlacementNodes(c NodeLister, request *typesv1.FunctionDeployment) error {
+	nodeIDs, err := getPlacementNodeIDs(request)
+	if err != nil || len(nodeIDs) == 0 {
+		return err
+	}
+
+	nodeFilters := filters.NewArgs()
+	for _, nodeID := range nodeIDs {
+		nodeFilters.Add("id", nodeID)
+	}
+
+	nodes, err := c.NodeList(context.Background(), types.NodeListOptions{Filters: nodeFilters})
+	if err != nil {
+		return err
+	}
+
+	for _, nodeID := range nodeIDs {
+		found := false
+		for _, node := range nodes {
+			if node.ID == nodeID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return newDeployError(ErrCodeInvalidPlacement, "label %s: node %s not found", PlacementNodeIDsLabel, nodeID)
+		}
+	}
+
+	return nil
+}
+
+// labelConstraintValue is replaced by the value of the label in a label constraint template
+const labelConstraintValue = "{value}"
+
+// buildLabelConstraints returns the constraint for each label of the function which has a
+// template in labelConstraints, sorted by label. The label value is substituted for
+// labelConstraintValue, a value which would add another operator is rejected.
+func buildLabelConstraints(request *typesv1.FunctionDeployment, labelConstraints map[string]string) ([]string, error) {
+	if request.Labels == nil || len(labelConstraints) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(labelConstraints))
+	for key := range labelConstraints {
+		if _, exists := (*request.Labels)[key]; exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var constraints []string
+	for _, key := range keys {
+		value := (*request.Labels)[key]
+		if strings.ContainsAny(value, "=!") {
+			return nil, newFieldError(ErrCodeInvalidPlacement, "labels."+key, value, "can not contain = or ! as it sets a placement constraint")
+		}
+
+		constraints = append(constraints, strings.Replace(labelConstraints[key], labelConstraintValue, value, -1))
 	}
 
-	if request.ReadOnlyRootFilesystem {
-		spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
-			{
-				Type:   mount.TypeTmpfs,
-				Target: "/tmp",
-			},
+	return constraints, nil
+}
+
+// buildPlacementPreferences parses the comma-separated PlacementPreferenceLabel into spread
+// preferences. Swarm applies them in order, spreading over the first label and then over
+// the next within each of those groups.
+func buildPlacementPreferences(request *typesv1.FunctionDeployment) ([]swarm.PlacementPreference, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[PlacementPreferenceLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	var preferences []swarm.PlacementPreference
+	for _, descriptor := range strings.Split(value, ",") {
+		descriptor = strings.TrimSpace(descriptor)
+		if len(descriptor) == 0 {
+			continue
+		}
+
+		if !strings.HasPrefix(descriptor, "node.labels.") && !strings.HasPrefix(descriptor, "engine.labels.") {
+			return nil, newDeployError(ErrCodeInvalidPlacement, "label %s: invalid spread descriptor %s, must be prefixed by node.labels or engine.labels", PlacementPreferenceLabel, descriptor)
 		}
+
+		preferences = append(preferences, swarm.PlacementPreference{
+			Spread: &swarm.SpreadOver{
+				SpreadDescriptor: descriptor,
+			},
+		})
 	}
 
-	// TODO: request.EnvProcess should only be set if it's not nil, otherwise we override anything in the Docker image already
-	env := buildEnv(request.EnvProcess, request.EnvVars)
+	return preferences, nil
+}
 
-	if len(env) > 0 {
-		spec.TaskTemplate.ContainerSpec.Env = env
+// envProcessName is the environment variable the watchdog reads the function process from
+const envProcessName = "fprocess"
+
+// resolveEnvProcess returns the function process and the other environment variables of a
+// request. fprocess may be given in EnvVars instead of EnvProcess, or in both with the same
+// value, but conflicting values are rejected as only one would reach the watchdog.
+func resolveEnvProcess(request *typesv1.FunctionDeployment) (string, map[string]string, error) {
+	value, exists := request.EnvVars[envProcessName]
+	if !exists {
+		return request.EnvProcess, request.EnvVars, nil
 	}
 
-	return spec, nil
+	if len(request.EnvProcess) > 0 && request.EnvProcess != value {
+		return "", nil, newDeployError(ErrCodeInvalidEnv, "envVars %s: %q conflicts with envProcess %q, set only envProcess", envProcessName, value, request.EnvProcess)
+	}
+
+	envVars := make(map[string]string, len(request.EnvVars)-1)
+	for k, v := range request.EnvVars {
+		if k != envProcessName {
+			envVars[k] = v
+		}
+	}
+
+	return value, envVars, nil
 }
 
-func buildEnv(envProcess string, envVars map[string]string) []string {
+func buildEnv(envProcess string, envVars map[string]string, placeholders bool) []string {
 	var env []string
 	if len(envProcess) > 0 {
 		env = append(env, fmt.Sprintf("fprocess=%s", envProcess))
 	}
 
 	for k, v := range envVars {
+		if placeholders {
+			v = substituteEnvPlaceholders(v)
+		}
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 	return env
 }
 
-// BuildEncodedAuthConfig parses the image name for a repository, user name, and image name
-// If a repository is not included (ie: username/function-name), 'docker.io/' will be prepended
-func BuildEncodedAuthConfig(basicAuthB64 string, dockerImage string) (string, error) {
-	// use docker.io if no repository was included
-	if len(strings.Split(dockerImage, "/")) < 3 {
-		dockerImage = registry.DefaultNamespace + "/" + dockerImage
+// useEnvPlaceholders returns true when EnvPlaceholdersLabel is set to "true"
+func useEnvPlaceholders(request *typesv1.FunctionDeployment) bool {
+	if request.Labels == nil {
+		return false
 	}
 
-	distributionRef, err := reference.ParseNamed(dockerImage)
-	if err != nil {
-		return "", err
-	}
+	return (*request.Labels)[EnvPlaceholdersLabel] == "true"
+}
+
+// substituteEnvPlaceholders replaces the placeholders in envPlaceholders with their Swarm
+// templates, any other ${NAME} is left as it is
+func substituteEnvPlaceholders(value string) string {
+	return envPlaceholderExpression.ReplaceAllStringFunc(value, func(placeholder string) string {
+		name := envPlaceholderExpression.FindStringSubmatch(placeholder)[1]
+		if template, ok := envPlaceholders[name]; ok {
+			return template
+		}
+		return placeholder
+	})
+}
 
-	repoInfo, err := registry.ParseRepositoryInfo(distributionRef)
+// BuildEncodedAuthConfig parses the image name for a repository, user name, and image name
+// If a repository is not included (ie: username/function-name), 'docker.io/' will be prepended
+func BuildEncodedAuthConfig(basicAuthB64 string, dockerImage string) (string, error) {
+	repoInfo, err := parseRepositoryInfo(dockerImage)
 	if err != nil {
 		return "", err
 	}
@@ -240,15 +1606,96 @@ func BuildEncodedAuthConfig(basicAuthB64 string, dockerImage string) (string, er
 	return base64.URLEncoding.EncodeToString(buf), nil
 }
 
+// imageCheckStatus returns the HTTP status for an error from checkImageAllowed
+func imageCheckStatus(err error) int {
+	if errorCode(err, "") == ErrCodeImageNotAllowed {
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadRequest
+}
+
+// parseRepositoryInfo resolves the registry and repository of an image, 'docker.io/' is
+// prepended when the image does not name a registry
+func parseRepositoryInfo(dockerImage string) (*registry.RepositoryInfo, error) {
+	// use docker.io if no repository was included
+	if !hasRegistryHost(dockerImage) {
+		dockerImage = registry.DefaultNamespace + "/" + dockerImage
+	}
+
+	distributionRef, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.ParseRepositoryInfo(distributionRef)
+}
+
+// checkImageAllowed returns an error unless the registry and repository of an image start
+// with one of the allowed prefixes, such as "registry.local:5000" or "docker.io/functions".
+// Every image is allowed when the list is empty.
+func checkImageAllowed(image string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	repoInfo, err := parseRepositoryInfo(image)
+	if err != nil {
+		return newDeployError(ErrCodeInvalidRequest, "invalid image %s: %s", image, err)
+	}
+
+	name := repoInfo.Index.Name + "/" + reference.Path(repoInfo.Name)
+	for _, prefix := range allowed {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return nil
+		}
+	}
+
+	return newDeployError(ErrCodeImageNotAllowed, "image %s is not from an allowed registry, allowed: %s", image, strings.Join(allowed, ", "))
+}
+
+// hasRegistryHost returns true when the first part of an image name is a registry host
+// rather than a Docker Hub user, in the same way as the docker CLI
+func hasRegistryHost(image string) bool {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return false
+	}
+
+	host := image[:i]
+	return strings.ContainsAny(host, ".:") || host == "localhost"
+}
+
+// qualifyImage prepends defaultRegistry to an image which does not name a registry, the
+// image is returned as it is when defaultRegistry is empty
+func qualifyImage(image string, defaultRegistry string) string {
+	if len(defaultRegistry) == 0 || len(image) == 0 || hasRegistryHost(image) {
+		return image
+	}
+
+	return defaultRegistry + "/" + image
+}
+
+// errBasicAuthEncoding is returned when registry auth is neither standard nor URL-safe base64
+var errBasicAuthEncoding = errors.New("could not decode base64")
+
+// errBasicAuthSeparator is returned when decoded registry auth is not in user:password form
+var errBasicAuthSeparator = errors.New("missing colon separator between username and password")
+
+// userPasswordFromBasicAuth decodes user:password from standard or URL-safe base64
 func userPasswordFromBasicAuth(basicAuthB64 string) (string, string, error) {
 	c, err := base64.StdEncoding.DecodeString(basicAuthB64)
 	if err != nil {
-		return "", "", err
+		c, err = base64.URLEncoding.DecodeString(basicAuthB64)
+	}
+	if err != nil {
+		return "", "", errBasicAuthEncoding
 	}
+
 	cs := string(c)
 	s := strings.IndexByte(cs, ':')
 	if s < 0 {
-		return "", "", errors.New("Invalid basic auth")
+		return "", "", errBasicAuthSeparator
 	}
 	return cs[:s], cs[s+1:], nil
 }
@@ -257,7 +1704,27 @@ func parseMemory(value string) (int64, error) {
 	return units.RAMInBytes(value)
 }
 
+// parseCPU converts a CPU value to NanoCPUs. Plain integers are NanoCPUs, values with a
+// trailing "m" are millicores as used by Kubernetes and decimals are fractional cores.
 func parseCPU(value string) (int64, error) {
+	if strings.HasSuffix(value, "m") {
+		millicores, err := strconv.ParseInt(strings.TrimSuffix(value, "m"), 10, 64)
+		if err != nil || millicores < 0 {
+			return 0, fmt.Errorf("invalid millicores: %s", value)
+		}
+
+		return millicores * 1e6, nil
+	}
+
+	if strings.Contains(value, ".") {
+		cores, err := strconv.ParseFloat(value, 64)
+		if err != nil || cores < 0 {
+			return 0, fmt.Errorf("invalid cores: %s", value)
+		}
+
+		return int64(math.Round(cores * 1e9)), nil
+	}
+
 	v, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
 		return 0, err
@@ -266,73 +1733,88 @@ func parseCPU(value string) (int64, error) {
 	return v, nil
 }
 
-func buildResources(request *typesv1.FunctionDeployment) *swarm.ResourceRequirements {
+// buildResources converts the limits and requests of a function, limits which are not set
+// fall back to defaultLimits so that every function can be given a memory or CPU cap
+func buildResources(request *typesv1.FunctionDeployment, defaultLimits *typesv1.FunctionResources) (*swarm.ResourceRequirements, error) {
 	var resources *swarm.ResourceRequirements
 
-	if request.Requests != nil || request.Limits != nil {
+	limitValues := withDefaultLimits(request.Limits, defaultLimits)
 
-		resources = &swarm.ResourceRequirements{}
+	if request.Requests != nil || limitValues != nil {
 
-		if request.Limits != nil {
-			limits := &swarm.Resources{}
-			valueSet := false
-
-			if len(request.Limits.Memory) > 0 {
-				memoryBytes, err := parseMemory(request.Limits.Memory)
-				if err != nil {
-					log.Printf("Error parsing memory limit: %T", err)
-				} else {
-					limits.MemoryBytes = memoryBytes
-					valueSet = true
-				}
-			}
+		resources = &swarm.ResourceRequirements{}
 
-			if len(request.Limits.CPU) > 0 {
-				nanoCPUs, err := parseCPU(request.Limits.CPU)
-				if err != nil {
-					log.Printf("Error parsing cpu limit: %T", err)
-				} else {
-					limits.NanoCPUs = nanoCPUs
-					valueSet = true
-				}
-			}
+		limits, err := parseResources(limitValues, "limits")
+		if err != nil {
+			return nil, err
+		}
+		resources.Limits = limits
 
-			if valueSet {
-				resources.Limits = limits
-			}
+		reservations, err := parseResources(request.Requests, "requests")
+		if err != nil {
+			return nil, err
 		}
+		resources.Reservations = reservations
+	}
 
-		if request.Requests != nil {
-			reservations := &swarm.Resources{}
-			valueSet := false
+	return resources, nil
+}
 
-			if len(request.Requests.Memory) > 0 {
-				memoryBytes, err := parseMemory(request.Requests.Memory)
-				if err != nil {
-					log.Printf("Error parsing memory reservations: %T", err)
-				} else {
-					reservations.MemoryBytes = memoryBytes
-					valueSet = true
-				}
-			}
+// withDefaultLimits returns the limits of a function with any value not set taken from
+// defaults, the request is not modified
+func withDefaultLimits(limits *typesv1.FunctionResources, defaults *typesv1.FunctionResources) *typesv1.FunctionResources {
+	if defaults == nil {
+		return limits
+	}
 
-			if len(request.Requests.CPU) > 0 {
-				nanoCPUs, err := parseCPU(request.Requests.CPU)
-				if err != nil {
-					log.Printf("Error parsing cpu reservations: %T", err)
-				} else {
-					reservations.NanoCPUs = nanoCPUs
-					valueSet = true
-				}
-			}
+	merged := typesv1.FunctionResources{}
+	if limits != nil {
+		merged = *limits
+	}
 
-			if valueSet {
-				resources.Reservations = reservations
-			}
+	if len(merged.Memory) == 0 {
+		merged.Memory = defaults.Memory
+	}
+	if len(merged.CPU) == 0 {
+		merged.CPU = defaults.CPU
+	}
+
+	return &merged
+}
+
+// parseResources converts the memory and CPU values of a function's limits or requests,
+// it returns nil when neither value is set. Errors name the field within kind.
+func parseResources(values *typesv1.FunctionResources, kind string) (*swarm.Resources, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	resources := &swarm.Resources{}
+	valueSet := false
+
+	if len(values.Memory) > 0 {
+		memoryBytes, err := parseMemory(values.Memory)
+		if err != nil {
+			return nil, newFieldError(ErrCodeInvalidMemory, kind+".memory", values.Memory, "should be a size such as 128m or 1g")
+		}
+		resources.MemoryBytes = memoryBytes
+		valueSet = true
+	}
+
+	if len(values.CPU) > 0 {
+		nanoCPUs, err := parseCPU(values.CPU)
+		if err != nil {
+			return nil, newFieldError(ErrCodeInvalidCPU, kind+".cpu", values.CPU, "should be millicores such as 500m, cores such as 0.5 or nano CPUs")
 		}
+		resources.NanoCPUs = nanoCPUs
+		valueSet = true
+	}
 
+	if !valueSet {
+		return nil, nil
 	}
-	return resources
+
+	return resources, nil
 }
 
 func getMinReplicas(request *typesv1.FunctionDeployment) *uint64 {
@@ -350,28 +1832,345 @@ func getMinReplicas(request *typesv1.FunctionDeployment) *uint64 {
 	return &replicas
 }
 
-func buildLabels(request *typesv1.FunctionDeployment) (map[string]string, error) {
-	labels := map[string]string{
-		"com.openfaas.function": request.Service,
-		"function":              "true", // backwards-compatible
+// getPullPolicy returns the policy from PullPolicyLabel, PullPolicyIfNotPresent when not set
+func getPullPolicy(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return PullPolicyIfNotPresent, nil
+	}
+
+	policy, exists := (*request.Labels)[PullPolicyLabel]
+	if !exists {
+		return PullPolicyIfNotPresent, nil
+	}
+
+	switch policy {
+	case PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever:
+		return policy, nil
+	}
+
+	return "", newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be one of %s, %s or %s", PullPolicyLabel, policy, PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever)
+}
+
+// getMaxRestarts returns the restart attempts from RestartMaxAttemptsLabel, or the
+// provider default when the label is not set
+func getMaxRestarts(request *typesv1.FunctionDeployment, defaultMaxRestarts uint64) (uint64, error) {
+	if request.Labels == nil {
+		return defaultMaxRestarts, nil
+	}
+
+	val, exists := (*request.Labels)[RestartMaxAttemptsLabel]
+	if !exists {
+		return defaultMaxRestarts, nil
+	}
+
+	value, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be uint", RestartMaxAttemptsLabel, val)
+	}
+
+	if value > maxRestartAttempts {
+		return 0, newDeployError(ErrCodeInvalidLabel, "label %s: %d exceeds the maximum of %d", RestartMaxAttemptsLabel, value, maxRestartAttempts)
+	}
+
+	return value, nil
+}
+
+// getRestartCondition returns the condition from RestartConditionLabel, or the provider
+// default when the label is not set
+func getRestartCondition(request *typesv1.FunctionDeployment, defaultCondition swarm.RestartPolicyCondition) (swarm.RestartPolicyCondition, error) {
+	if len(defaultCondition) == 0 {
+		defaultCondition = swarm.RestartPolicyConditionAny
+	}
+
+	if request.Labels == nil {
+		return defaultCondition, nil
+	}
+
+	val, exists := (*request.Labels)[RestartConditionLabel]
+	if !exists {
+		return defaultCondition, nil
+	}
+
+	switch condition := swarm.RestartPolicyCondition(val); condition {
+	case swarm.RestartPolicyConditionAny, swarm.RestartPolicyConditionOnFailure, swarm.RestartPolicyConditionNone:
+		return condition, nil
+	}
+
+	return "", newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be one of %s, %s or %s", RestartConditionLabel, val, swarm.RestartPolicyConditionAny, swarm.RestartPolicyConditionOnFailure, swarm.RestartPolicyConditionNone)
+}
+
+// getRestartWindow returns the duration from RestartWindowLabel, or nil to count every restart
+// when the label is not set
+func getRestartWindow(request *typesv1.FunctionDeployment) (*time.Duration, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	val, exists := (*request.Labels)[RestartWindowLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	window, err := time.ParseDuration(val)
+	if err != nil || window <= 0 {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid duration %s, should be greater than 0 such as 10m or 1h", RestartWindowLabel, val)
+	}
+
+	return &window, nil
+}
+
+// getStopGracePeriod returns the duration from StopGracePeriodLabel, or nil to use the
+// Docker default of 10s when the label is not set
+func getStopGracePeriod(request *typesv1.FunctionDeployment) (*time.Duration, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	val, exists := (*request.Labels)[StopGracePeriodLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	period, err := time.ParseDuration(val)
+	if err != nil || period <= 0 {
+		return nil, newDeployError(ErrCodeInvalidLabel, "label %s: invalid duration %s, should be greater than 0 such as 30s or 2m", StopGracePeriodLabel, val)
+	}
+
+	return &period, nil
+}
+
+// getStopSignal returns the signal name from StopSignalLabel, such as "SIGTERM", or an empty
+// string to use the signal of the image when the label is not set
+func getStopSignal(request *typesv1.FunctionDeployment) (string, error) {
+	if request.Labels == nil {
+		return "", nil
+	}
+
+	val, exists := (*request.Labels)[StopSignalLabel]
+	if !exists {
+		return "", nil
+	}
+
+	if number, err := strconv.Atoi(val); err == nil {
+		if name, ok := stopSignals[number]; ok {
+			return name, nil
+		}
+	} else {
+		name := strings.ToUpper(strings.TrimSpace(val))
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+
+		for _, known := range stopSignals {
+			if name == known {
+				return name, nil
+			}
+		}
+	}
+
+	return "", newDeployError(ErrCodeInvalidLabel, "label %s: unknown signal %s, should be a name such as SIGTERM or a number such as 15", StopSignalLabel, val)
+}
+
+// getRestartDelay returns the restart delay of a function, raised to its stop grace period
+// when that is longer. A task which is killed at the end of its grace period exits with a
+// non-zero code, so with a shorter delay Swarm would restart it as a failure while the
+// work it was draining could still be finishing elsewhere.
+func getRestartDelay(defaultDelay time.Duration, stopGracePeriod *time.Duration) time.Duration {
+	if stopGracePeriod != nil && *stopGracePeriod > defaultDelay {
+		return *stopGracePeriod
+	}
+
+	return defaultDelay
+}
+
+// buildLabels merges the base labels of the provider, the labels generated by faas-swarm and
+// the labels and annotations of the request, in order of increasing precedence. The name of
+// the function is set in functionLabel, or DefaultFunctionLabel when it is empty. The legacy
+// function label is only set with DefaultFunctionLabel.
+func buildLabels(request *typesv1.FunctionDeployment, baseLabels map[string]string, functionLabel string, maxValueLength int) (map[string]string, error) {
+	functionLabel = functionLabelKey(functionLabel)
+
+	labels := map[string]string{}
+	for k, v := range baseLabels {
+		if !isReservedLabel(k) && k != functionLabel {
+			labels[k] = v
+		}
+	}
+
+	labels[functionLabel] = request.Service
+	if functionLabel == DefaultFunctionLabel {
+		labels[legacyFunctionLabel] = "true" // backwards-compatible
 	}
 
 	if request.Labels != nil {
 		for k, v := range *request.Labels {
+			if err := validateLabel("label", k, v, maxValueLength); err != nil {
+				return nil, err
+			}
 			labels[k] = v
 		}
+
+		if err := validateScaleLabels(*request.Labels); err != nil {
+			return nil, err
+		}
 	}
 
 	if request.Annotations != nil {
 		for k, v := range *request.Annotations {
+			if err := validateLabel("annotation", k, v, maxValueLength); err != nil {
+				return nil, err
+			}
+
 			key := fmt.Sprintf("%s%s", annotationLabelPrefix, k)
 			if _, ok := labels[key]; !ok {
 				labels[key] = v
 			} else {
-				return nil, errors.New(fmt.Sprintf("Keys %s can not be used as a labels as is clashes with annotations", k))
+				return nil, newDeployError(ErrCodeAnnotationClash, "Keys %s can not be used as a labels as is clashes with annotations", k)
 			}
 		}
 	}
 
+	if len(request.Namespace) > 0 {
+		labels[NamespaceLabel] = request.Namespace
+	}
+	if namespace, exists := labels[NamespaceLabel]; exists {
+		if err := validateNamespace(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateTimeoutAnnotations(labels); err != nil {
+		return nil, err
+	}
+
 	return labels, nil
 }
+
+// splitTaskLabels returns the labels for the service and for its containers, the labels
+// with TaskLabelPrefix are only added to the container labels without the prefix
+func splitTaskLabels(labels map[string]string) (map[string]string, map[string]string, error) {
+	serviceLabels := map[string]string{}
+	containerLabels := map[string]string{}
+	taskLabels := map[string]string{}
+
+	for k, v := range labels {
+		if strings.HasPrefix(k, TaskLabelPrefix) {
+			key := strings.TrimPrefix(k, TaskLabelPrefix)
+			if len(strings.TrimSpace(key)) == 0 {
+				return nil, nil, newDeployError(ErrCodeInvalidLabel, "label %s: task label key can not be empty", k)
+			}
+			taskLabels[key] = v
+			continue
+		}
+
+		serviceLabels[k] = v
+		containerLabels[k] = v
+	}
+
+	for k, v := range taskLabels {
+		if _, exists := containerLabels[k]; exists {
+			return nil, nil, newDeployError(ErrCodeInvalidLabel, "label %s%s: clashes with the label %s", TaskLabelPrefix, k, k)
+		}
+		containerLabels[k] = v
+	}
+
+	return serviceLabels, containerLabels, nil
+}
+
+// isReservedLabel returns true for the labels owned by OpenFaaS, which base labels can not set
+func isReservedLabel(key string) bool {
+	return key == "function" || strings.HasPrefix(key, reservedLabelPrefix)
+}
+
+// validateScaleLabels checks the autoscaling hints read by the gateway, which would
+// otherwise ignore an invalid value
+func validateScaleLabels(labels map[string]string) error {
+	if target, exists := labels[ScaleTargetLabel]; exists {
+		value, err := strconv.ParseUint(target, 10, 64)
+		if err != nil || value == 0 {
+			return newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be a positive integer", ScaleTargetLabel, target)
+		}
+	}
+
+	if scaleType, exists := labels[ScaleTypeLabel]; exists {
+		switch scaleType {
+		case ScaleTypeRPS, ScaleTypeCPU, ScaleTypeCapacity:
+		default:
+			return newDeployError(ErrCodeInvalidLabel, "label %s: invalid value %s, should be one of %s, %s or %s", ScaleTypeLabel, scaleType, ScaleTypeRPS, ScaleTypeCPU, ScaleTypeCapacity)
+		}
+	}
+
+	minScale, minErr := strconv.ParseUint(labels[MinScaleLabel], 10, 64)
+	maxScale, maxErr := strconv.ParseUint(labels[MaxScaleLabel], 10, 64)
+	if minErr == nil && maxErr == nil && minScale > maxScale {
+		return newDeployError(ErrCodeInvalidLabel, "label %s: %d is greater than %s: %d", MinScaleLabel, minScale, MaxScaleLabel, maxScale)
+	}
+
+	return nil
+}
+
+// timeoutAnnotations are the annotations the gateway reads the timeouts of a function from
+var timeoutAnnotations = []string{"read_timeout", "write_timeout", "exec_timeout"}
+
+// validateTimeoutAnnotations checks the timeout annotations read by the gateway, which would
+// otherwise ignore an invalid value. The gateway accepts a duration such as "30s", or a
+// number of seconds.
+func validateTimeoutAnnotations(labels map[string]string) error {
+	for _, name := range timeoutAnnotations {
+		val, exists := labels[annotationLabelPrefix+name]
+		if !exists {
+			continue
+		}
+
+		if seconds, err := strconv.ParseUint(val, 10, 64); err == nil && seconds > 0 {
+			continue
+		}
+
+		if timeout, err := time.ParseDuration(val); err == nil && timeout > 0 {
+			continue
+		}
+
+		return newDeployError(ErrCodeInvalidLabel, "annotation %s: invalid duration %s, should be greater than 0 such as 30s or 2m", name, val)
+	}
+
+	return nil
+}
+
+// requireLabels checks that each of the required labels is set on the request
+func requireLabels(request *typesv1.FunctionDeployment, required []string) error {
+	missing := []string{}
+	for _, key := range required {
+		if request.Labels == nil {
+			missing = append(missing, key)
+			continue
+		}
+
+		if _, exists := (*request.Labels)[key]; !exists {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		return newDeployError(ErrCodeMissingLabel, "required labels are missing: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// validateLabel checks that a user-supplied label or annotation has a key and a non-empty
+// value within maxValueLength bytes, Swarm otherwise rejects it with an opaque error
+func validateLabel(kind string, key string, value string, maxValueLength int) error {
+	if len(strings.TrimSpace(key)) == 0 {
+		return newDeployError(ErrCodeInvalidLabel, "%s key can not be empty", kind)
+	}
+
+	if len(value) == 0 {
+		return newDeployError(ErrCodeInvalidLabel, "%s %s: value can not be empty", kind, key)
+	}
+
+	if maxValueLength > 0 && len(value) > maxValueLength {
+		return newDeployError(ErrCodeInvalidLabel, "%s %s: value of %d bytes exceeds the maximum of %d bytes", kind, key, len(value), maxValueLength)
+	}
+
+	return nil
+}
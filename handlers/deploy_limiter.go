@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+// DeployLimiter limits how many service creates and updates are sent to Swarm at the same
+// time across all requests, so a bulk deploy does not overload the manager. Requests over
+// the limit wait in a queue, and are rejected once the queue is full. A nil DeployLimiter
+// does not limit anything.
+type DeployLimiter struct {
+	slots   chan struct{}
+	waiting chan struct{}
+}
+
+// NewDeployLimiter returns a DeployLimiter running at most concurrency operations with up to
+// queueLength more waiting, or nil when concurrency is not positive
+func NewDeployLimiter(concurrency int, queueLength int) *DeployLimiter {
+	if concurrency <= 0 {
+		return nil
+	}
+
+	if queueLength < 0 {
+		queueLength = 0
+	}
+
+	return &DeployLimiter{
+		slots:   make(chan struct{}, concurrency),
+		waiting: make(chan struct{}, queueLength),
+	}
+}
+
+// Acquire waits for a slot until ctx is done, it returns a DeployError with
+// ErrCodeDeployThrottled without waiting when the queue is full. Release must be called
+// once the operation has finished when no error is returned.
+func (l *DeployLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case l.waiting <- struct{}{}:
+	default:
+		return newDeployError(ErrCodeDeployThrottled, "too many deployments in progress, try again later")
+	}
+	defer func() { <-l.waiting }()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by Acquire
+func (l *DeployLimiter) Release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+}
+
+// throttleStatus returns the HTTP status for an error from DeployLimiter.Acquire
+func throttleStatus(err error) int {
+	if errorCode(err, "") == ErrCodeDeployThrottled {
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// gatedDeployClient holds each ServiceCreate until release is closed and records the most
+// creates in progress at once
+type gatedDeployClient struct {
+	*fakeDeployClient
+
+	release chan struct{}
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *gatedDeployClient) ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	<-f.release
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	return f.fakeDeployClient.ServiceCreate(ctx, service, options)
+}
+
+func Test_DeployLimiter_LimitsConcurrentCreates(t *testing.T) {
+	c := &gatedDeployClient{
+		fakeDeployClient: newFakeDeployClient(),
+		release:          make(chan struct{}),
+	}
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		Limiter:             NewDeployLimiter(2, 10),
+	}
+
+	statuses := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			request := batchRequest(fmt.Sprintf("fn%d", i))
+			status, _, _ := deployFunction(c, config, &request, nil)
+			statuses <- status
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(c.release)
+
+	for i := 0; i < 8; i++ {
+		if status := <-statuses; status != http.StatusAccepted {
+			t.Errorf("want: %d got: %d", http.StatusAccepted, status)
+		}
+	}
+
+	if c.maxInFlight != 2 {
+		t.Errorf("want: at most %d creates in progress got: %d", 2, c.maxInFlight)
+	}
+}
+
+func Test_DeployLimiter_QueueFull(t *testing.T) {
+	limiter := NewDeployLimiter(1, 1)
+
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		queued <- limiter.Acquire(context.Background())
+	}()
+
+	for len(limiter.waiting) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := limiter.Acquire(context.Background()); errorCode(err, "") != ErrCodeDeployThrottled {
+		t.Errorf("want: error code %s got: %v", ErrCodeDeployThrottled, err)
+	}
+
+	limiter.Release()
+	if err := <-queued; err != nil {
+		t.Errorf("want: queued request to get the slot got: %v", err)
+	}
+}
+
+func Test_DeployHandler_Throttled(t *testing.T) {
+	c := newFakeDeployClient()
+	limiter := NewDeployLimiter(1, 0)
+	limiter.Acquire(context.Background())
+
+	body, _ := json.Marshal(batchRequest("figlet"))
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, Limiter: limiter}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want: %d got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+	if deployErr.Code != ErrCodeDeployThrottled {
+		t.Errorf("want: code %s got: %s", ErrCodeDeployThrottled, deployErr.Code)
+	}
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+}
+
+func Test_DeployLimiter_Nil(t *testing.T) {
+	var limiter *DeployLimiter
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Errorf("want: no error got: %v", err)
+	}
+	limiter.Release()
+
+	if NewDeployLimiter(0, 10) != nil {
+		t.Errorf("want: no limiter when concurrency is 0")
+	}
+}
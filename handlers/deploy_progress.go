@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// deployProgressContentType is the Accept header value which streams the progress of a deploy
+const deployProgressContentType = "text/event-stream"
+
+const (
+	// DeployPhaseWarning reports a problem with a function which is deployed anyway
+	DeployPhaseWarning = "warning"
+
+	// DeployPhaseWaiting is reported while the deploy waits for the function named by
+	// DependsOnLabel to have a running replica
+	DeployPhaseWaiting = "waiting for dependency"
+
+	// DeployPhasePulling is reported while Swarm resolves the image in the registry, the
+	// image itself is pulled by each node when its task starts
+	DeployPhasePulling = "pulling"
+
+	// DeployPhaseCreating is reported before the service is created
+	DeployPhaseCreating = "creating service"
+
+	// DeployPhaseAccepted is reported once Swarm has accepted the service
+	DeployPhaseAccepted = "accepted"
+
+	// DeployPhasePrePulling is reported while the image of a function with PrePullLabel is
+	// pulled on every eligible node
+	DeployPhasePrePulling = "pre-pulling"
+
+	// DeployPhaseTask reports each new state of the first task of the service
+	DeployPhaseTask = "task"
+)
+
+// deployProgressPolls is how many times the task state is read after a service is accepted
+const deployProgressPolls = 5
+
+// deployProgressPollInterval is the delay between reading the task state
+const deployProgressPollInterval = time.Second
+
+// DeployProgress is an event in the progress stream of a deploy
+type DeployProgress struct {
+	Phase   string `json:"phase"`
+	Message string `json:"message,omitempty"`
+}
+
+// deployProgress writes DeployProgress server-sent events, the response is only started by
+// the first event so that errors found before can still be returned with their status code.
+// All methods are no-ops on a nil deployProgress.
+type deployProgress struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+
+	// ctx is the context of the request, watchDeployTasks stops when it is done
+	ctx context.Context
+}
+
+// newDeployProgress returns a deployProgress when the client accepts an event stream,
+// otherwise nil
+func newDeployProgress(w http.ResponseWriter, r *http.Request) *deployProgress {
+	if !strings.Contains(r.Header.Get("Accept"), deployProgressContentType) {
+		return nil
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("DeployHandler: response is not a Flusher, required for streaming progress")
+		return nil
+	}
+
+	return &deployProgress{w: w, flusher: flusher, ctx: r.Context()}
+}
+
+// Started returns true once the event stream has been started
+func (p *deployProgress) Started() bool {
+	return p != nil && p.started
+}
+
+// Report writes a progress event
+func (p *deployProgress) Report(phase string, format string, a ...interface{}) {
+	p.write("progress", DeployProgress{Phase: phase, Message: fmt.Sprintf(format, a...)})
+}
+
+// Error writes the error which ended the deploy
+func (p *deployProgress) Error(err error) {
+	p.write("error", toDeployError(err, ErrCodeDeployFailed))
+}
+
+func (p *deployProgress) write(event string, body interface{}) {
+	if p == nil {
+		return
+	}
+
+	if !p.started {
+		p.w.Header().Set("Content-Type", deployProgressContentType)
+		p.w.Header().Set("Cache-Control", "no-cache")
+		p.w.WriteHeader(http.StatusOK)
+		p.started = true
+	}
+
+	data, _ := json.Marshal(body)
+	fmt.Fprintf(p.w, "event: %s\ndata: %s\n\n", event, data)
+	p.flusher.Flush()
+}
+
+// watchDeployTasks reports the state of the first task of a new service until it is
+// running or has stopped, deployProgressPolls have been made or the request is done, such as
+// when the provider drains
+func watchDeployTasks(c TaskLister, serviceID string, progress *deployProgress) {
+	if progress == nil {
+		return
+	}
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", serviceID)
+
+	var lastState swarm.TaskState
+	for i := 0; i < deployProgressPolls; i++ {
+		if i > 0 {
+			select {
+			case <-progress.ctx.Done():
+				return
+			case <-time.After(deployProgressPollInterval):
+			}
+		}
+
+		tasks, err := c.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilter})
+		if err != nil {
+			log.Printf("Error listing tasks of %s: %s\n", serviceID, err)
+			return
+		}
+
+		if len(tasks) == 0 {
+			continue
+		}
+
+		task := tasks[0]
+		if task.Status.State != lastState {
+			lastState = task.Status.State
+			if len(task.Status.Err) > 0 {
+				progress.Report(DeployPhaseTask, "%s: %s", lastState, task.Status.Err)
+			} else {
+				progress.Report(DeployPhaseTask, "%s", lastState)
+			}
+		}
+
+		switch lastState {
+		case swarm.TaskStateRunning, swarm.TaskStateComplete, swarm.TaskStateFailed, swarm.TaskStateRejected, swarm.TaskStateShutdown:
+			return
+		}
+	}
+}
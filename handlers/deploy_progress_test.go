@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// readProgressEvents parses the server-sent events of a deploy progress stream
+func readProgressEvents(t *testing.T, body string) ([]string, []DeployProgress) {
+	events := []string{}
+	progress := []DeployProgress{}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			p := DeployProgress{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &p); err != nil {
+				t.Fatalf("unexpected event data %q: %s", line, err)
+			}
+			progress = append(progress, p)
+		}
+	}
+
+	return events, progress
+}
+
+func doStreamedDeploy(c DeployClient, request CreateFunctionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	req.Header.Set("Accept", "text/event-stream")
+	rr := httptest.NewRecorder()
+
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_DeployHandler_StreamsProgress(t *testing.T) {
+	c := newFakeDeployClient()
+	c.tasks = []swarm.Task{runningTask("figlet-1")}
+
+	// the image is only resolved, and reported as pulling, with the always pull policy
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{PullPolicyLabel: PullPolicyAlways}
+	rr := doStreamedDeploy(c, request)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("want: Content-Type %s got: %s", "text/event-stream", contentType)
+	}
+
+	_, progress := readProgressEvents(t, rr.Body.String())
+
+	phases := []string{}
+	for _, p := range progress {
+		phases = append(phases, p.Phase)
+	}
+
+	want := []string{DeployPhasePulling, DeployPhaseCreating, DeployPhaseAccepted, DeployPhaseTask}
+	if !reflect.DeepEqual(phases, want) {
+		t.Errorf("want: %v got: %v", want, phases)
+	}
+
+	if last := progress[len(progress)-1]; last.Message != string(swarm.TaskStateRunning) {
+		t.Errorf("want: task message %s got: %s", swarm.TaskStateRunning, last.Message)
+	}
+}
+
+func Test_DeployHandler_StreamedCreateError(t *testing.T) {
+	c := newFakeDeployClient("figlet")
+
+	rr := doStreamedDeploy(c, batchRequest("figlet"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+
+	events, _ := readProgressEvents(t, rr.Body.String())
+	if len(events) == 0 || events[len(events)-1] != "error" {
+		t.Errorf("want: stream ending with an error event got: %v", events)
+	}
+}
+
+func Test_DeployHandler_StreamedValidationError(t *testing.T) {
+	c := newFakeDeployClient()
+
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{HostnameLabel: "-figlet"}
+
+	rr := doStreamedDeploy(c, request)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+}
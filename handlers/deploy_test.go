@@ -1,8 +1,23 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	typesv1 "github.com/openfaas/faas-provider/types"
 
 	"testing"
@@ -10,7 +25,7 @@ import (
 
 func Test_BuildLabels_Defaults(t *testing.T) {
 	request := &typesv1.FunctionDeployment{}
-	val, err := buildLabels(request)
+	val, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
 
 	if err != nil {
 		t.Fatalf("want: no error got: %v", err)
@@ -29,13 +44,30 @@ func Test_BuildLabels_Defaults(t *testing.T) {
 	}
 }
 
+func Test_BuildLabels_Namespace(t *testing.T) {
+	request := &typesv1.FunctionDeployment{Service: "figlet", Namespace: "staging"}
+	labels, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if got := labels[NamespaceLabel]; got != "staging" {
+		t.Errorf("want: label %s=%s got: %q", NamespaceLabel, "staging", got)
+	}
+
+	request.Namespace = "Staging_1"
+	if _, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength); errorCode(err, "") != ErrCodeInvalidRequest {
+		t.Errorf("want: error code %s got: %v", ErrCodeInvalidRequest, err)
+	}
+}
+
 func Test_BuildLabels_WithAnnotations(t *testing.T) {
 	request := &typesv1.FunctionDeployment{
 		Labels:      &map[string]string{"function_name": "echo"},
 		Annotations: &map[string]string{"current-time": "Wed 25 Jul 06:41:43 BST 2018"},
 	}
 
-	val, err := buildLabels(request)
+	val, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
 
 	if err != nil {
 		t.Fatalf("want: no error got: %v", err)
@@ -55,7 +87,7 @@ func Test_BuildLabels_NoAnnotations(t *testing.T) {
 		Labels: &map[string]string{"function_name": "echo"},
 	}
 
-	val, err := buildLabels(request)
+	val, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
 
 	if err != nil {
 		t.Fatalf("want: no error got: %v", err)
@@ -79,9 +111,2139 @@ func Test_BuildLabels_KeyClash(t *testing.T) {
 		Annotations: &map[string]string{"current-time": "Wed 25 Jul 06:41:43 BST 2018"},
 	}
 
-	_, err := buildLabels(request)
+	_, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+
+	if code := errorCode(err, ""); code != ErrCodeAnnotationClash {
+		t.Errorf("want: error code %s got: %s", ErrCodeAnnotationClash, code)
+	}
+}
+
+func Test_BuildLabels_EmptyKey(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{"": "echo"},
+	}
+
+	_, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+}
+
+func Test_BuildLabels_EmptyValue(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Annotations: &map[string]string{"topic": ""},
+	}
+
+	_, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+
+	if !strings.Contains(err.Error(), "topic") {
+		t.Errorf("want: error naming key '%s' got: %s", "topic", err.Error())
+	}
+}
+
+func Test_BuildLabels_OversizedValue(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{"function_name": strings.Repeat("a", 65)},
+	}
+
+	_, err := buildLabels(request, nil, DefaultFunctionLabel, 64)
+
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+
+	if !strings.Contains(err.Error(), "function_name") {
+		t.Errorf("want: error naming key '%s' got: %s", "function_name", err.Error())
+	}
+
+	if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+	}
+}
+
+func Test_BuildLabels_ValueAtMaxLength(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{"function_name": strings.Repeat("a", 64)},
+	}
+
+	_, err := buildLabels(request, nil, DefaultFunctionLabel, 64)
+
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+}
+
+func Test_BuildPlacementPreferences_Ordered(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{
+			PlacementPreferenceLabel: "engine.labels.lifecycle, node.labels.zone",
+		},
+	}
+
+	preferences, err := buildPlacementPreferences(request)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []string{"engine.labels.lifecycle", "node.labels.zone"}
+	if len(preferences) != len(want) {
+		t.Fatalf("want: %d preferences got: %d", len(want), len(preferences))
+	}
+
+	for i, descriptor := range want {
+		if got := preferences[i].Spread.SpreadDescriptor; got != descriptor {
+			t.Errorf("want: preference %d to be '%s' got: '%s'", i, descriptor, got)
+		}
+	}
+}
+
+func Test_BuildPlacementPreferences_NoLabel(t *testing.T) {
+	request := &typesv1.FunctionDeployment{}
+
+	preferences, err := buildPlacementPreferences(request)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if len(preferences) != 0 {
+		t.Errorf("want: %d preferences got: %d", 0, len(preferences))
+	}
+}
+
+func Test_BuildPlacementPreferences_InvalidDescriptor(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{
+			PlacementPreferenceLabel: "lifecycle",
+		},
+	}
 
+	_, err := buildPlacementPreferences(request)
 	if err == nil {
 		t.Fatal("want: an error got: nil")
 	}
 }
+
+func Test_GetMaxRestarts(t *testing.T) {
+	defaultMaxRestarts := uint64(5)
+
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		want    uint64
+		wantErr bool
+	}{
+		{"no labels uses default", nil, defaultMaxRestarts, false},
+		{"label absent uses default", &map[string]string{"function_name": "echo"}, defaultMaxRestarts, false},
+		{"override", &map[string]string{RestartMaxAttemptsLabel: "10"}, 10, false},
+		{"zero", &map[string]string{RestartMaxAttemptsLabel: "0"}, 0, false},
+		{"negative is invalid", &map[string]string{RestartMaxAttemptsLabel: "-1"}, 0, true},
+		{"text is invalid", &map[string]string{RestartMaxAttemptsLabel: "many"}, 0, true},
+		{"above cap is invalid", &map[string]string{RestartMaxAttemptsLabel: "101"}, 0, true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{Labels: s.labels}
+
+			got, err := getMaxRestarts(request, defaultMaxRestarts)
+			if s.wantErr {
+				if err == nil {
+					t.Fatal("want: an error got: nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got != s.want {
+				t.Errorf("want: %d got: %d", s.want, got)
+			}
+		})
+	}
+}
+
+// Test_MakeSpec_StopGracePeriod documents that the restart delay is never shorter than the
+// stop grace period, so a task killed at the end of a long grace period is not restarted
+// straight away as a failure
+func Test_MakeSpec_StopGracePeriod(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		labels    *map[string]string
+		wantGrace *time.Duration
+		wantDelay time.Duration
+		wantErr   bool
+	}{
+		{name: "no label", wantDelay: 5 * time.Second},
+		{name: "shorter than delay", labels: &map[string]string{StopGracePeriodLabel: "2s"}, wantGrace: durationPtr(2 * time.Second), wantDelay: 5 * time.Second},
+		{name: "longer than delay", labels: &map[string]string{StopGracePeriodLabel: "2m"}, wantGrace: durationPtr(2 * time.Minute), wantDelay: 2 * time.Minute},
+		{name: "invalid duration", labels: &map[string]string{StopGracePeriodLabel: "two minutes"}, wantErr: true},
+		{name: "zero", labels: &map[string]string{StopGracePeriodLabel: "0s"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "batch",
+				Image:   "functions/batch:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{RestartDelay: 5 * time.Second}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			grace := spec.TaskTemplate.ContainerSpec.StopGracePeriod
+			if (s.wantGrace == nil) != (grace == nil) || (s.wantGrace != nil && *s.wantGrace != *grace) {
+				t.Errorf("want: stop grace period %v got: %v", s.wantGrace, grace)
+			}
+
+			if got := *spec.TaskTemplate.RestartPolicy.Delay; got != s.wantDelay {
+				t.Errorf("want: restart delay %s got: %s", s.wantDelay, got)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_DefaultConstraints(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		defaults    []string
+		constraints []string
+		want        []string
+	}{
+		{name: "not configured", want: linuxOnlyConstraints},
+		{name: "configured", defaults: []string{"node.platform.os == windows", "node.labels.tier == functions"}, want: []string{"node.platform.os == windows", "node.labels.tier == functions"}},
+		{name: "none", defaults: []string{}, want: []string{}},
+		{name: "request constraints", defaults: []string{"node.platform.os == windows"}, constraints: []string{"node.role == worker"}, want: []string{"node.role == worker"}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service:     "figlet",
+				Image:       "functions/figlet:0.1",
+				Constraints: s.constraints,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{DefaultConstraints: s.defaults}, nil)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if constraints := spec.TaskTemplate.Placement.Constraints; !reflect.DeepEqual(constraints, s.want) {
+				t.Errorf("want: constraints %v got: %v", s.want, constraints)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_RestartWindow(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		want    *time.Duration
+		wantErr bool
+	}{
+		{name: "no label"},
+		{name: "window", labels: &map[string]string{RestartWindowLabel: "1h"}, want: durationPtr(time.Hour)},
+		{name: "invalid duration", labels: &map[string]string{RestartWindowLabel: "an hour"}, wantErr: true},
+		{name: "negative", labels: &map[string]string{RestartWindowLabel: "-10m"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "batch",
+				Image:   "functions/batch:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			window := spec.TaskTemplate.RestartPolicy.Window
+			if (s.want == nil) != (window == nil) || (s.want != nil && *s.want != *window) {
+				t.Errorf("want: restart window %v got: %v", s.want, window)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_StopSignal(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "no label", want: ""},
+		{name: "name", labels: &map[string]string{StopSignalLabel: "SIGINT"}, want: "SIGINT"},
+		{name: "name without prefix", labels: &map[string]string{StopSignalLabel: "term"}, want: "SIGTERM"},
+		{name: "number", labels: &map[string]string{StopSignalLabel: "15"}, want: "SIGTERM"},
+		{name: "unknown name", labels: &map[string]string{StopSignalLabel: "SIGFOO"}, wantErr: true},
+		{name: "unknown number", labels: &map[string]string{StopSignalLabel: "64"}, wantErr: true},
+		{name: "zero", labels: &map[string]string{StopSignalLabel: "0"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "batch",
+				Image:   "functions/batch:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got := spec.TaskTemplate.ContainerSpec.StopSignal; got != s.want {
+				t.Errorf("want: stop signal %q got: %q", s.want, got)
+			}
+		})
+	}
+}
+
+func durationPtr(value time.Duration) *time.Duration {
+	return &value
+}
+
+func Test_GetRestartCondition(t *testing.T) {
+	scenarios := []struct {
+		name             string
+		defaultCondition swarm.RestartPolicyCondition
+		labels           *map[string]string
+		want             swarm.RestartPolicyCondition
+		wantErr          bool
+	}{
+		{name: "no default is any", defaultCondition: "", want: swarm.RestartPolicyConditionAny},
+		{name: "default any", defaultCondition: swarm.RestartPolicyConditionAny, want: swarm.RestartPolicyConditionAny},
+		{name: "default on-failure", defaultCondition: swarm.RestartPolicyConditionOnFailure, want: swarm.RestartPolicyConditionOnFailure},
+		{name: "default none", defaultCondition: swarm.RestartPolicyConditionNone, want: swarm.RestartPolicyConditionNone},
+		{name: "label overrides default", defaultCondition: swarm.RestartPolicyConditionOnFailure, labels: &map[string]string{RestartConditionLabel: "any"}, want: swarm.RestartPolicyConditionAny},
+		{name: "invalid label", defaultCondition: swarm.RestartPolicyConditionAny, labels: &map[string]string{RestartConditionLabel: "always"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{RestartCondition: s.defaultCondition}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got := spec.TaskTemplate.RestartPolicy.Condition; got != s.want {
+				t.Errorf("want: %s got: %s", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_ErrorCodes(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		request typesv1.FunctionDeployment
+		want    string
+	}{
+		{
+			"bad memory",
+			typesv1.FunctionDeployment{Limits: &typesv1.FunctionResources{Memory: "lots"}},
+			ErrCodeInvalidMemory,
+		},
+		{
+			"bad cpu",
+			typesv1.FunctionDeployment{Requests: &typesv1.FunctionResources{CPU: "half"}},
+			ErrCodeInvalidCPU,
+		},
+		{
+			"clashing annotation",
+			typesv1.FunctionDeployment{
+				Labels:      &map[string]string{annotationLabelPrefix + "topic": "a"},
+				Annotations: &map[string]string{"topic": "b"},
+			},
+			ErrCodeAnnotationClash,
+		},
+		{
+			"bad placement preference",
+			typesv1.FunctionDeployment{Labels: &map[string]string{PlacementPreferenceLabel: "zone"}},
+			ErrCodeInvalidPlacement,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := makeSpec(&s.request, DeployConfig{}, nil)
+			if err == nil {
+				t.Fatal("want: an error got: nil")
+			}
+
+			if code := errorCode(err, ""); code != s.want {
+				t.Errorf("want: error code %s got: %s", s.want, code)
+			}
+		})
+	}
+}
+
+func Test_WriteDeployError_Envelope(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	writeDeployError(rr, http.StatusBadRequest, newDeployError(ErrCodeInvalidMemory, "invalid memory limit: lots"), ErrCodeInvalidRequest)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: status %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("want: content type '%s' got: '%s'", "application/json", contentType)
+	}
+
+	envelope := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("want: JSON body got: %s", rr.Body.String())
+	}
+
+	if envelope.Code != ErrCodeInvalidMemory {
+		t.Errorf("want: code %s got: %s", ErrCodeInvalidMemory, envelope.Code)
+	}
+}
+
+func Test_DeployHandler_ResourceFieldErrors(t *testing.T) {
+	scenarios := []struct {
+		field     string
+		resources func(request *CreateFunctionRequest)
+		wantCode  string
+		wantValue string
+	}{
+		{"limits.memory", func(r *CreateFunctionRequest) { r.Limits = &typesv1.FunctionResources{Memory: "lots"} }, ErrCodeInvalidMemory, "lots"},
+		{"limits.cpu", func(r *CreateFunctionRequest) { r.Limits = &typesv1.FunctionResources{CPU: "half"} }, ErrCodeInvalidCPU, "half"},
+		{"requests.memory", func(r *CreateFunctionRequest) { r.Requests = &typesv1.FunctionResources{Memory: "12XB"} }, ErrCodeInvalidMemory, "12XB"},
+		{"requests.cpu", func(r *CreateFunctionRequest) { r.Requests = &typesv1.FunctionResources{CPU: "-1m"} }, ErrCodeInvalidCPU, "-1m"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.field, func(t *testing.T) {
+			request := batchRequest("figlet")
+			s.resources(&request)
+
+			body, _ := json.Marshal(request)
+			req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(newFakeDeployClient(), DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+			}
+
+			envelope := DeployError{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("want: JSON body got: %s", rr.Body.String())
+			}
+
+			if envelope.Code != s.wantCode {
+				t.Errorf("want: code %s got: %s", s.wantCode, envelope.Code)
+			}
+			if envelope.Field != s.field {
+				t.Errorf("want: field %s got: %s", s.field, envelope.Field)
+			}
+			if envelope.Value != s.wantValue {
+				t.Errorf("want: value %s got: %s", s.wantValue, envelope.Value)
+			}
+			if !strings.Contains(envelope.Message, s.field) || !strings.Contains(envelope.Message, s.wantValue) {
+				t.Errorf("want: message naming %s and %s got: %s", s.field, s.wantValue, envelope.Message)
+			}
+		})
+	}
+}
+
+func Test_WriteDeployError_FallbackCode(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	writeDeployError(rr, http.StatusBadRequest, fmt.Errorf("daemon unavailable"), ErrCodeDeployFailed)
+
+	envelope := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("want: JSON body got: %s", rr.Body.String())
+	}
+
+	if envelope.Code != ErrCodeDeployFailed {
+		t.Errorf("want: code %s got: %s", ErrCodeDeployFailed, envelope.Code)
+	}
+
+	if envelope.Message != "daemon unavailable" {
+		t.Errorf("want: message '%s' got: '%s'", "daemon unavailable", envelope.Message)
+	}
+}
+
+type fakeNetworkLister struct {
+	networks []types.NetworkResource
+
+	// listErr is returned by NetworkList
+	listErr error
+}
+
+func (f fakeNetworkLister) NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	for _, network := range f.networks {
+		if network.Name == networkID || network.ID == networkID {
+			return network, nil
+		}
+	}
+
+	return types.NetworkResource{}, fakeNotFoundError{name: networkID}
+}
+
+func (f fakeNetworkLister) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+
+	matched := []types.NetworkResource{}
+	for _, network := range f.networks {
+		for k, v := range network.Labels {
+			if options.Filters.ExactMatch("label", k+"="+v) {
+				matched = append(matched, network)
+				break
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+func Test_LookupNetwork_LabelSelector(t *testing.T) {
+	lister := fakeNetworkLister{
+		networks: []types.NetworkResource{
+			{Name: "func_functions", Labels: map[string]string{"openfaas": "true"}},
+			{Name: "payments_functions", Labels: map[string]string{"team": "payments"}},
+		},
+	}
+
+	scenarios := []struct {
+		name     string
+		selector string
+		want     string
+	}{
+		{"default selector", "", "func_functions"},
+		{"custom selector", "team=payments", "payments_functions"},
+		{"no match", "team=billing", ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := lookupNetwork(lister, s.selector)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got != s.want {
+				t.Errorf("want: network '%s' got: '%s'", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_DefaultNetwork_ListError(t *testing.T) {
+	c := newFakeDeployClient()
+	c.listErr = fmt.Errorf("Cannot connect to the Docker daemon")
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}
+
+	request := batchRequest("figlet")
+	if status, _, err := deployFunction(c, config, &request, nil); status != http.StatusInternalServerError {
+		t.Errorf("want: deploy status %d got: %d %v", http.StatusInternalServerError, status, err)
+	}
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+
+	c.specs = append(c.specs, existingServiceSpec(nil))
+	if rr := doUpdate(c, config, request); rr.Code != http.StatusInternalServerError {
+		t.Errorf("want: update status %d got: %d %s", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+}
+
+func Test_DeployFunction_TeamNetworks(t *testing.T) {
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		TeamNetworks:        map[string]string{"payments": "payments_net"},
+	}
+
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		network string
+		want    string
+	}{
+		{name: "mapped team", labels: &map[string]string{TeamLabel: "payments"}, want: "payments_net"},
+		{name: "unmapped team", labels: &map[string]string{TeamLabel: "search"}, want: "func_functions"},
+		{name: "no team", want: "func_functions"},
+		{name: "explicit network", labels: &map[string]string{TeamLabel: "payments"}, network: "shared_net", want: "shared_net"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			request := batchRequest("figlet")
+			request.Labels = s.labels
+			request.Network = s.network
+
+			if status, _, err := deployFunction(c, config, &request, nil); status != http.StatusAccepted {
+				t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+			}
+
+			if got := c.specs[0].TaskTemplate.Networks[0].Target; got != s.want {
+				t.Errorf("want: network %s got: %s", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_HostNetworkMode(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Labels:  &map[string]string{NetworkModeLabel: "host"},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	networks := spec.TaskTemplate.Networks
+	if len(networks) != 1 || networks[0].Target != "host" {
+		t.Errorf("want: only the host network got: %v", networks)
+	}
+}
+
+func Test_MakeSpec_HostNetworkModeWithCustomNetwork(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Network: "func_functions",
+		Labels:  &map[string]string{NetworkModeLabel: "host"},
+	}
+
+	_, err := makeSpec(request, DeployConfig{}, nil)
+	if code := errorCode(err, ""); code != ErrCodeInvalidNetwork {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidNetwork, code)
+	}
+}
+
+func Test_MakeSpec_UnsupportedNetworkMode(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Labels:  &map[string]string{NetworkModeLabel: "bridge"},
+	}
+
+	_, err := makeSpec(request, DeployConfig{}, nil)
+	if code := errorCode(err, ""); code != ErrCodeInvalidNetwork {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidNetwork, code)
+	}
+}
+
+func Test_BuildPorts_PublishModes(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		label string
+		want  []swarm.PortConfig
+	}{
+		{
+			name:  "short syntax defaults to ingress",
+			label: "8080:80",
+			want: []swarm.PortConfig{
+				{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 80, PublishedPort: 8080, PublishMode: swarm.PortConfigPublishModeIngress},
+			},
+		},
+		{
+			name:  "explicit ingress mode",
+			label: "published=8080,target=80,mode=ingress",
+			want: []swarm.PortConfig{
+				{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 80, PublishedPort: 8080, PublishMode: swarm.PortConfigPublishModeIngress},
+			},
+		},
+		{
+			name:  "host mode and ingress mode",
+			label: "published=8080,target=80,mode=host; published=9090,target=90,protocol=udp",
+			want: []swarm.PortConfig{
+				{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 80, PublishedPort: 8080, PublishMode: swarm.PortConfigPublishModeHost},
+				{Protocol: swarm.PortConfigProtocolUDP, TargetPort: 90, PublishedPort: 9090, PublishMode: swarm.PortConfigPublishModeIngress},
+			},
+		},
+		{
+			name:  "host mode ports on different protocols",
+			label: "published=53,target=53,mode=host;published=53,target=53,mode=host,protocol=udp",
+			want: []swarm.PortConfig{
+				{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 53, PublishedPort: 53, PublishMode: swarm.PortConfigPublishModeHost},
+				{Protocol: swarm.PortConfigProtocolUDP, TargetPort: 53, PublishedPort: 53, PublishMode: swarm.PortConfigPublishModeHost},
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &map[string]string{PortsLabel: s.label},
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if spec.EndpointSpec == nil || !reflect.DeepEqual(spec.EndpointSpec.Ports, s.want) {
+				t.Errorf("want: %v got: %v", s.want, spec.EndpointSpec)
+			}
+		})
+	}
+}
+
+func Test_BuildPorts_Rejected(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		label string
+	}{
+		{name: "unknown publish mode", label: "published=8080,target=80,mode=mesh"},
+		{name: "missing target", label: "published=8080,mode=host"},
+		{name: "host mode collision", label: "published=8080,target=80,mode=host;published=8080,target=81,mode=host"},
+		{name: "host mode collides with ingress", label: "published=8080,target=80,mode=host;8080:81"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &map[string]string{PortsLabel: s.label},
+			}
+
+			_, err := makeSpec(request, DeployConfig{}, nil)
+			if code := errorCode(err, ""); code != ErrCodeInvalidPort {
+				t.Errorf("want: error code %s got: %s", ErrCodeInvalidPort, code)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_NoPorts(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if spec.EndpointSpec != nil {
+		t.Errorf("want: no endpoint spec got: %v", spec.EndpointSpec)
+	}
+}
+
+func Test_DeployHandler_TimeoutReturnsGatewayTimeout(t *testing.T) {
+	c := newFakeDeployClient()
+	c.blockCreate = true
+
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		DeployTimeout:       time.Millisecond * 10,
+	}
+
+	body, _ := json.Marshal(batchRequest("figlet"))
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		DeployHandler(c, config).ServeHTTP(rr, req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("want: handler to return after the deploy timeout got: still blocked")
+	}
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("want: %d got: %d", http.StatusGatewayTimeout, rr.Code)
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+	if deployErr.Code != ErrCodeDeployTimeout {
+		t.Errorf("want: %s got: %s", ErrCodeDeployTimeout, deployErr.Code)
+	}
+}
+
+func Test_GetPullPolicy(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "no labels", labels: nil, want: PullPolicyIfNotPresent},
+		{name: "label not set", labels: &map[string]string{}, want: PullPolicyIfNotPresent},
+		{name: "always", labels: &map[string]string{PullPolicyLabel: "always"}, want: PullPolicyAlways},
+		{name: "if-not-present", labels: &map[string]string{PullPolicyLabel: "if-not-present"}, want: PullPolicyIfNotPresent},
+		{name: "never", labels: &map[string]string{PullPolicyLabel: "never"}, want: PullPolicyNever},
+		{name: "unknown policy", labels: &map[string]string{PullPolicyLabel: "sometimes"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			policy, err := getPullPolicy(&typesv1.FunctionDeployment{Labels: s.labels})
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if policy != s.want {
+				t.Errorf("want: %s got: %s", s.want, policy)
+			}
+		})
+	}
+}
+
+func Test_DeployHandler_PullPolicyQueriesRegistry(t *testing.T) {
+	scenarios := []struct {
+		policy string
+		want   bool
+	}{
+		{policy: PullPolicyAlways, want: true},
+		{policy: PullPolicyIfNotPresent, want: false},
+		{policy: PullPolicyNever, want: false},
+		{policy: "", want: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.policy, func(t *testing.T) {
+			c := newFakeDeployClient()
+
+			request := batchRequest("figlet")
+			if len(s.policy) > 0 {
+				request.Labels = &map[string]string{PullPolicyLabel: s.policy}
+			}
+			body, _ := json.Marshal(request)
+
+			req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+			}
+			if len(c.options) != 1 || c.options[0].QueryRegistry != s.want {
+				t.Errorf("want: QueryRegistry %v got: %v", s.want, c.options)
+			}
+		})
+	}
+}
+
+// Test_DeployFunction_PinsManifestListDigest deploys through the Docker client, which pins
+// the image digest with PullPolicyAlways. A multi-arch tag must be pinned to the digest of its manifest list, with
+// every platform of the list, so each node still pulls the image built for its architecture.
+func Test_DeployFunction_PinsManifestListDigest(t *testing.T) {
+	const listDigest = "sha256:b4b8a3b5bd3e8c9f4b8cd73b8e8b3bba4c3b1a0a6c85b1e6d0e3c2ca5e2f6a71"
+
+	created := make(chan swarm.ServiceSpec, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/distribution/functions/figlet:latest/json"):
+			body, _ := json.Marshal(registrytypes.DistributionInspect{
+				Descriptor: ocispec.Descriptor{
+					MediaType: ocispec.MediaTypeImageIndex,
+					Digest:    listDigest,
+				},
+				Platforms: []ocispec.Platform{
+					{OS: "linux", Architecture: "amd64"},
+					{OS: "linux", Architecture: "arm64"},
+				},
+			})
+			w.Write(body)
+		case strings.HasSuffix(r.URL.Path, "/services/create"):
+			spec := swarm.ServiceSpec{}
+			json.NewDecoder(r.Body).Decode(&spec)
+			created <- spec
+			w.Write([]byte(`{"ID":"figlet"}`))
+		default:
+			t.Errorf("unexpected request to the Docker API: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.37"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := batchRequest("figlet")
+	request.Image = "functions/figlet:latest"
+	request.Network = "func_functions"
+	request.Labels = &map[string]string{PullPolicyLabel: PullPolicyAlways}
+
+	status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+	}
+
+	spec := <-created
+	if want := "functions/figlet:latest@" + listDigest; spec.TaskTemplate.ContainerSpec.Image != want {
+		t.Errorf("want: image %s got: %s", want, spec.TaskTemplate.ContainerSpec.Image)
+	}
+
+	wantPlatforms := []swarm.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}
+	if !reflect.DeepEqual(spec.TaskTemplate.Placement.Platforms, wantPlatforms) {
+		t.Errorf("want: platforms %v got: %v", wantPlatforms, spec.TaskTemplate.Placement.Platforms)
+	}
+}
+
+func Test_DeployHandler_NoNetwork(t *testing.T) {
+	c := newFakeDeployClient()
+	c.networks = nil
+
+	body, _ := json.Marshal(batchRequest("figlet"))
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+	if deployErr.Code != ErrCodeInvalidNetwork {
+		t.Errorf("want: %s got: %s", ErrCodeInvalidNetwork, deployErr.Code)
+	}
+	if !strings.Contains(deployErr.Message, "no openfaas network found") {
+		t.Errorf("want: message about the missing network got: %s", deployErr.Message)
+	}
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+}
+
+func Test_MakeSpec_RequiredCostLabels(t *testing.T) {
+	required := []string{"com.openfaas.cost.team", "com.openfaas.cost.project"}
+
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		wantErr bool
+	}{
+		{
+			name: "required labels present",
+			labels: &map[string]string{
+				"com.openfaas.cost.team":    "payments",
+				"com.openfaas.cost.project": "checkout",
+			},
+		},
+		{
+			name:    "one required label missing",
+			labels:  &map[string]string{"com.openfaas.cost.team": "payments"},
+			wantErr: true,
+		},
+		{
+			name:    "no labels",
+			labels:  nil,
+			wantErr: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{RequiredLabels: required}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeMissingLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeMissingLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			for _, key := range required {
+				if spec.Annotations.Labels[key] != (*s.labels)[key] {
+					t.Errorf("want: label %s=%s got: %s", key, (*s.labels)[key], spec.Annotations.Labels[key])
+				}
+			}
+		})
+	}
+}
+
+func Test_BuildLabels_ScaleLabels(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{name: "rps", labels: map[string]string{ScaleTypeLabel: "rps", ScaleTargetLabel: "50"}},
+		{name: "cpu", labels: map[string]string{ScaleTypeLabel: "cpu", ScaleTargetLabel: "80"}},
+		{name: "capacity", labels: map[string]string{ScaleTypeLabel: "capacity"}},
+		{name: "unknown scale type", labels: map[string]string{ScaleTypeLabel: "memory"}, wantErr: true},
+		{name: "scale type is case sensitive", labels: map[string]string{ScaleTypeLabel: "RPS"}, wantErr: true},
+		{name: "non-numeric target", labels: map[string]string{ScaleTargetLabel: "lots"}, wantErr: true},
+		{name: "zero target", labels: map[string]string{ScaleTargetLabel: "0"}, wantErr: true},
+		{name: "min below max", labels: map[string]string{MinScaleLabel: "2", MaxScaleLabel: "5"}},
+		{name: "min equal to max", labels: map[string]string{MinScaleLabel: "3", MaxScaleLabel: "3"}},
+		{name: "min above max", labels: map[string]string{MinScaleLabel: "5", MaxScaleLabel: "3"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Labels:  &s.labels,
+			}
+
+			labels, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			for k, v := range s.labels {
+				if labels[k] != v {
+					t.Errorf("want: label %s=%s passed through got: %s", k, v, labels[k])
+				}
+			}
+		})
+	}
+}
+
+func Test_BuildLabels_TimeoutAnnotations(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "durations", annotations: map[string]string{"read_timeout": "30s", "write_timeout": "1m30s", "exec_timeout": "2m"}},
+		{name: "seconds", annotations: map[string]string{"exec_timeout": "60"}},
+		{name: "other annotations ignored", annotations: map[string]string{"topic": "orders"}},
+		{name: "invalid read_timeout", annotations: map[string]string{"read_timeout": "thirty seconds"}, wantErr: true},
+		{name: "invalid write_timeout", annotations: map[string]string{"write_timeout": "10x"}, wantErr: true},
+		{name: "zero exec_timeout", annotations: map[string]string{"exec_timeout": "0s"}, wantErr: true},
+		{name: "negative exec_timeout", annotations: map[string]string{"exec_timeout": "-5s"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service:     "figlet",
+				Annotations: &s.annotations,
+			}
+
+			labels, err := buildLabels(request, nil, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			for k, v := range s.annotations {
+				if labels[annotationLabelPrefix+k] != v {
+					t.Errorf("want: annotation %s=%s passed through got: %s", k, v, labels[annotationLabelPrefix+k])
+				}
+			}
+		})
+	}
+}
+
+func Test_DeployHandler_InvalidTimeoutAnnotation(t *testing.T) {
+	c := newFakeDeployClient()
+
+	request := batchRequest("figlet")
+	request.Annotations = &map[string]string{"exec_timeout": "soon"}
+
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+}
+
+func Test_DeployHandler_InvertedScaleBounds(t *testing.T) {
+	c := newFakeDeployClient()
+
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{MinScaleLabel: "5", MaxScaleLabel: "3"}
+
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+	if !strings.Contains(deployErr.Message, "5") || !strings.Contains(deployErr.Message, "3") {
+		t.Errorf("want: message naming both bounds got: %s", deployErr.Message)
+	}
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+}
+
+func Test_BuildPlacement_NodeID(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Constraints: []string{"node.role == worker"},
+		Labels:      &map[string]string{PlacementNodeIDsLabel: " 7vbo5rpyhe1kmtq2ogdcyqg7h "},
+	}
+
+	placement, err := buildPlacement(request, nil, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []string{"node.role == worker", "node.id == 7vbo5rpyhe1kmtq2ogdcyqg7h"}
+	if !reflect.DeepEqual(placement.Constraints, want) {
+		t.Errorf("want: %v got: %v", want, placement.Constraints)
+	}
+	if len(request.Constraints) != 1 {
+		t.Errorf("want: request constraints unchanged got: %v", request.Constraints)
+	}
+}
+
+func Test_BuildPlacement_LabelConstraints(t *testing.T) {
+	labelConstraints := map[string]string{
+		"gpu":  "node.labels.gpu=={value}",
+		"zone": "node.labels.zone == {value}",
+		"edge": "node.role != manager",
+	}
+
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "mapped labels",
+			labels: map[string]string{"gpu": "true", "zone": "eu-west-1a", "team": "ml"},
+			want:   []string{"node.platform.os == linux", "node.labels.gpu==true", "node.labels.zone == eu-west-1a"},
+		},
+		{
+			name:   "template without a value",
+			labels: map[string]string{"edge": "yes"},
+			want:   []string{"node.platform.os == linux", "node.role != manager"},
+		},
+		{
+			name:   "no mapped labels",
+			labels: map[string]string{"team": "ml"},
+			want:   []string{"node.platform.os == linux"},
+		},
+		{
+			name:    "value adding an operator",
+			labels:  map[string]string{"gpu": "true!=false"},
+			wantErr: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{Labels: &s.labels}
+
+			placement, err := buildPlacement(request, labelConstraints, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidPlacement {
+					t.Errorf("want: error code %s got: %v", ErrCodeInvalidPlacement, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(placement.Constraints, s.want) {
+				t.Errorf("want: %v got: %v", s.want, placement.Constraints)
+			}
+		})
+	}
+}
+
+func Test_BuildPlacement_NodeIDsRejected(t *testing.T) {
+	scenarios := []struct {
+		name  string
+		value string
+	}{
+		{name: "several node ids", value: "node-a,node-b"},
+		{name: "empty", value: " , "},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Labels: &map[string]string{PlacementNodeIDsLabel: s.value},
+			}
+
+			_, err := buildPlacement(request, nil, nil)
+			if code := errorCode(err, ""); code != ErrCodeInvalidPlacement {
+				t.Errorf("want: error code %s got: %s", ErrCodeInvalidPlacement, code)
+			}
+		})
+	}
+}
+
+func Test_ValidatePlacementNodes(t *testing.T) {
+	c := newFakeDeployClient()
+	c.nodes = []swarm.Node{{ID: "node-a"}}
+
+	found := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{PlacementNodeIDsLabel: "node-a"},
+	}
+	if err := validatePlacementNodes(c, found); err != nil {
+		t.Errorf("want: no error got: %v", err)
+	}
+
+	missing := &typesv1.FunctionDeployment{
+		Labels: &map[string]string{PlacementNodeIDsLabel: "node-b"},
+	}
+	if code := errorCode(validatePlacementNodes(c, missing), ""); code != ErrCodeInvalidPlacement {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidPlacement, code)
+	}
+}
+
+func Test_MakeSpec_HealthcheckHTTPPath(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Labels:  &map[string]string{HealthcheckHTTPPathLabel: "/_/ready"},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []string{"CMD", "curl", "-f", "http://localhost:8080/_/ready"}
+	healthcheck := spec.TaskTemplate.ContainerSpec.Healthcheck
+	if healthcheck == nil || !reflect.DeepEqual(healthcheck.Test, want) {
+		t.Errorf("want: %v got: %v", want, healthcheck)
+	}
+}
+
+func Test_MakeSpec_HealthcheckHTTPPathRejected(t *testing.T) {
+	for _, path := range []string{"_/ready", "", "/ready now"} {
+		t.Run(path, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &map[string]string{HealthcheckHTTPPathLabel: path},
+			}
+
+			_, err := makeSpec(request, DeployConfig{}, nil)
+			if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+				t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+			}
+		})
+	}
+}
+
+func Test_BuildLabels_BaseLabelsPrecedence(t *testing.T) {
+	baseLabels := map[string]string{
+		"logging":                "enabled",
+		"monitoring":             "prometheus",
+		"com.openfaas.function":  "base",
+		"com.openfaas.scale.min": "5",
+		"function":               "false",
+	}
+
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Labels: &map[string]string{
+			"monitoring": "datadog",
+		},
+	}
+
+	labels, err := buildLabels(request, baseLabels, DefaultFunctionLabel, DefaultMaxLabelValueLength)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := map[string]string{
+		"logging":               "enabled",
+		"monitoring":            "datadog",
+		"com.openfaas.function": "figlet",
+		"function":              "true",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("want: %v got: %v", want, labels)
+	}
+}
+
+func Test_MakeSpec_Hostname(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  *map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "not set", labels: nil, want: ""},
+		{name: "simple", labels: &map[string]string{HostnameLabel: "figlet"}, want: "figlet"},
+		{name: "fully qualified", labels: &map[string]string{HostnameLabel: "figlet-1.functions.local"}, want: "figlet-1.functions.local"},
+		{name: "underscore", labels: &map[string]string{HostnameLabel: "fig_let"}, wantErr: true},
+		{name: "leading hyphen", labels: &map[string]string{HostnameLabel: "-figlet"}, wantErr: true},
+		{name: "empty part", labels: &map[string]string{HostnameLabel: "figlet..local"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if got := spec.TaskTemplate.ContainerSpec.Hostname; got != s.want {
+				t.Errorf("want: hostname %q got: %q", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_UserPasswordFromBasicAuth(t *testing.T) {
+	scenarios := []struct {
+		name         string
+		basicAuth    string
+		wantUser     string
+		wantPassword string
+		wantErr      error
+	}{
+		{
+			name:         "standard base64",
+			basicAuth:    base64.StdEncoding.EncodeToString([]byte("user:p>?~")),
+			wantUser:     "user",
+			wantPassword: "p>?~",
+		},
+		{
+			name:         "url-safe base64",
+			basicAuth:    base64.URLEncoding.EncodeToString([]byte("user:p>?~")),
+			wantUser:     "user",
+			wantPassword: "p>?~",
+		},
+		{
+			name:      "not base64",
+			basicAuth: "user:password",
+			wantErr:   errBasicAuthEncoding,
+		},
+		{
+			name:      "missing colon",
+			basicAuth: base64.StdEncoding.EncodeToString([]byte("userpassword")),
+			wantErr:   errBasicAuthSeparator,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			user, password, err := userPasswordFromBasicAuth(s.basicAuth)
+			if err != s.wantErr {
+				t.Fatalf("want: error %v got: %v", s.wantErr, err)
+			}
+
+			if user != s.wantUser || password != s.wantPassword {
+				t.Errorf("want: %s:%s got: %s:%s", s.wantUser, s.wantPassword, user, password)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_PublishedPortsUseIngress(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Network: "func_functions",
+		Labels:  &map[string]string{PortsLabel: "8080:8080"},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	// Swarm attaches the ingress network for ports published in ingress mode
+	if spec.EndpointSpec == nil || len(spec.EndpointSpec.Ports) != 1 || spec.EndpointSpec.Ports[0].PublishMode != swarm.PortConfigPublishModeIngress {
+		t.Errorf("want: one ingress mode port got: %v", spec.EndpointSpec)
+	}
+
+	networks := spec.TaskTemplate.Networks
+	if len(networks) != 1 || networks[0].Target != "func_functions" {
+		t.Errorf("want: only the function network attached got: %v", networks)
+	}
+}
+
+func Test_MakeSpec_ExplicitIngressRejected(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Network: "ingress",
+		Labels:  &map[string]string{PortsLabel: "8080:8080"},
+	}
+
+	_, err := makeSpec(request, DeployConfig{}, nil)
+	if code := errorCode(err, ""); code != ErrCodeInvalidNetwork {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidNetwork, code)
+	}
+}
+
+func Test_MakeSpec_HealthcheckDisable(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "disabled",
+			labels: map[string]string{HealthcheckDisableLabel: "true"},
+			want:   []string{"NONE"},
+		},
+		{
+			name:   "not disabled",
+			labels: map[string]string{HealthcheckDisableLabel: "false"},
+			want:   nil,
+		},
+		{
+			name:    "invalid value",
+			labels:  map[string]string{HealthcheckDisableLabel: "yes please"},
+			wantErr: true,
+		},
+		{
+			name:    "combined with http path",
+			labels:  map[string]string{HealthcheckDisableLabel: "true", HealthcheckHTTPPathLabel: "/_/ready"},
+			wantErr: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			healthcheck := spec.TaskTemplate.ContainerSpec.Healthcheck
+			if s.want == nil {
+				if healthcheck != nil {
+					t.Errorf("want: image healthcheck left in place got: %v", healthcheck)
+				}
+				return
+			}
+
+			if healthcheck == nil || !reflect.DeepEqual(healthcheck.Test, s.want) {
+				t.Errorf("want: %v got: %v", s.want, healthcheck)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_SecurityOpt(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    *swarm.SELinuxContext
+		wantErr bool
+	}{
+		{name: "level with categories", value: "label=level:s0:c100,c200", want: &swarm.SELinuxContext{Level: "s0:c100,c200"}},
+		{name: "level with categories before type", value: "label=level:s0:c100, c200,label=type:svirt_apache_t", want: &swarm.SELinuxContext{Level: "s0:c100,c200", Type: "svirt_apache_t"}},
+		{name: "separated value after type", value: "label=type:svirt_apache_t,c200", wantErr: true},
+		{name: "type", value: "label=type:svirt_apache_t", want: &swarm.SELinuxContext{Type: "svirt_apache_t"}},
+		{name: "user role and level", value: "label=user:system_u,label=role:system_r,label=level:s0", want: &swarm.SELinuxContext{User: "system_u", Role: "system_r", Level: "s0"}},
+		{name: "disable", value: "label=disable", want: &swarm.SELinuxContext{Disable: true}},
+		{name: "seccomp", value: "seccomp=profile.json", wantErr: true},
+		{name: "apparmor", value: "apparmor=myprofile", wantErr: true},
+		{name: "unknown option", value: "no-new-privileges=true", wantErr: true},
+		{name: "unknown SELinux field", value: "label=range:s0", wantErr: true},
+		{name: "missing value", value: "label", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &map[string]string{SecurityOptLabel: s.value},
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			privileges := spec.TaskTemplate.ContainerSpec.Privileges
+			if privileges == nil || !reflect.DeepEqual(privileges.SELinuxContext, s.want) {
+				t.Errorf("want: %+v got: %+v", s.want, privileges)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_NoSecurityOpt(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if privileges := spec.TaskTemplate.ContainerSpec.Privileges; privileges != nil {
+		t.Errorf("want: no privileges got: %+v", privileges)
+	}
+}
+
+func Test_BuildEnv_Placeholders(t *testing.T) {
+	scenarios := []struct {
+		value string
+		want  string
+	}{
+		{value: "${NODE_HOSTNAME}", want: "{{.Node.Hostname}}"},
+		{value: "${NODE_ID}", want: "{{.Node.ID}}"},
+		{value: "${SERVICE_NAME}", want: "{{.Service.Name}}"},
+		{value: "${SERVICE_ID}", want: "{{.Service.ID}}"},
+		{value: "${TASK_NAME}", want: "{{.Task.Name}}"},
+		{value: "${TASK_SLOT}", want: "{{.Task.Slot}}"},
+		{value: "http://${SERVICE_NAME}:8080/${TASK_SLOT}", want: "http://{{.Service.Name}}:8080/{{.Task.Slot}}"},
+		{value: "${HOME}", want: "${HOME}"},
+		{value: "$SERVICE_NAME", want: "$SERVICE_NAME"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.value, func(t *testing.T) {
+			env := buildEnv("", map[string]string{"value": s.value}, true)
+
+			if want := []string{"value=" + s.want}; !reflect.DeepEqual(env, want) {
+				t.Errorf("want: %v got: %v", want, env)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_EnvPlaceholdersLabel(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		labels *map[string]string
+		want   string
+	}{
+		{name: "not set", labels: nil, want: "name=${SERVICE_NAME}"},
+		{name: "false", labels: &map[string]string{EnvPlaceholdersLabel: "false"}, want: "name=${SERVICE_NAME}"},
+		{name: "true", labels: &map[string]string{EnvPlaceholdersLabel: "true"}, want: "name={{.Service.Name}}"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  s.labels,
+				EnvVars: map[string]string{"name": "${SERVICE_NAME}"},
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if want := []string{s.want}; !reflect.DeepEqual(spec.TaskTemplate.ContainerSpec.Env, want) {
+				t.Errorf("want: %v got: %v", want, spec.TaskTemplate.ContainerSpec.Env)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_TaskLabels(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Labels: &map[string]string{
+			"team":                           "tools",
+			TaskLabelPrefix + "logging":      "splunk",
+			TaskLabelPrefix + "log.sampling": "0.1",
+		},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	wantService := map[string]string{
+		"com.openfaas.function": "figlet",
+		"function":              "true",
+		"team":                  "tools",
+	}
+	if !reflect.DeepEqual(spec.Annotations.Labels, wantService) {
+		t.Errorf("want service labels: %v got: %v", wantService, spec.Annotations.Labels)
+	}
+
+	wantContainer := map[string]string{
+		"com.openfaas.function": "figlet",
+		"function":              "true",
+		"team":                  "tools",
+		"logging":               "splunk",
+		"log.sampling":          "0.1",
+	}
+	if !reflect.DeepEqual(spec.TaskTemplate.ContainerSpec.Labels, wantContainer) {
+		t.Errorf("want container labels: %v got: %v", wantContainer, spec.TaskTemplate.ContainerSpec.Labels)
+	}
+}
+
+func Test_MakeSpec_TaskLabelsRejected(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{name: "empty key", labels: map[string]string{TaskLabelPrefix: "splunk"}},
+		{name: "clashes with service label", labels: map[string]string{"team": "tools", TaskLabelPrefix + "team": "search"}},
+		{name: "clashes with function label", labels: map[string]string{TaskLabelPrefix + "function": "false"}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:latest",
+				Labels:  &s.labels,
+			}
+
+			_, err := makeSpec(request, DeployConfig{}, nil)
+			if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+				t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_ScratchMount(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service:                "figlet",
+		Image:                  "functions/figlet:latest",
+		ReadOnlyRootFilesystem: true,
+		Labels:                 &map[string]string{ScratchSizeLabel: "512m", ScratchPathLabel: "/var/scratch"},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeTmpfs, Target: "/tmp"},
+		{Type: mount.TypeTmpfs, Target: "/var/scratch", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 512 * 1024 * 1024}},
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
+
+func Test_MakeSpec_ShmSize(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		want    []mount.Mount
+		wantErr bool
+	}{
+		{
+			name:   "size",
+			labels: map[string]string{ShmSizeLabel: "1g"},
+			want:   []mount.Mount{{Type: mount.TypeTmpfs, Target: "/dev/shm", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1024 * 1024 * 1024}}},
+		},
+		{name: "not a size", labels: map[string]string{ShmSizeLabel: "large"}, wantErr: true},
+		{name: "zero", labels: map[string]string{ShmSizeLabel: "0"}, wantErr: true},
+		{name: "scratch over /dev/shm", labels: map[string]string{ShmSizeLabel: "1g", ScratchSizeLabel: "512m", ScratchPathLabel: "/dev/shm"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "inference",
+				Image:   "functions/inference:latest",
+				Labels:  &s.labels,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got := spec.TaskTemplate.ContainerSpec.Mounts; !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %+v got: %+v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_UpdateSpec_ShmSizeRemoved(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "inference",
+		Image:   "functions/inference:latest",
+		Labels:  &map[string]string{ShmSizeLabel: "1g"},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	request.Labels = &map[string]string{ShmSizeLabel: "2g"}
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; len(got) != 1 || got[0].TmpfsOptions.SizeBytes != 2*1024*1024*1024 {
+		t.Errorf("want: one /dev/shm mount of 2g got: %+v", got)
+	}
+
+	request.Labels = nil
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; len(got) != 0 {
+		t.Errorf("want: no mounts got: %+v", got)
+	}
+}
+
+func Test_BuildScratchMount(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		labels     *map[string]string
+		wantTarget string
+		wantErr    bool
+	}{
+		{name: "not set", labels: nil, wantTarget: ""},
+		{name: "default path", labels: &map[string]string{ScratchSizeLabel: "1g"}, wantTarget: "/scratch"},
+		{name: "custom path", labels: &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: "/data/cache"}, wantTarget: "/data/cache"},
+		{name: "invalid size", labels: &map[string]string{ScratchSizeLabel: "lots"}, wantErr: true},
+		{name: "zero size", labels: &map[string]string{ScratchSizeLabel: "0"}, wantErr: true},
+		{name: "relative path", labels: &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: "scratch"}, wantErr: true},
+		{name: "unclean path", labels: &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: "/data/../etc"}, wantErr: true},
+		{name: "root", labels: &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: "/"}, wantErr: true},
+		{name: "tmp", labels: &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: "/tmp"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			scratch, err := buildScratchMount(&typesv1.FunctionDeployment{Labels: s.labels})
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidLabel {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidLabel, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			target := ""
+			if scratch != nil {
+				target = scratch.Target
+			}
+			if target != s.wantTarget {
+				t.Errorf("want: target %q got: %q", s.wantTarget, target)
+			}
+		})
+	}
+}
+
+func Test_QualifyImage(t *testing.T) {
+	cases := []struct {
+		name            string
+		image           string
+		defaultRegistry string
+		want            string
+	}{
+		{"no default registry", "functions/figlet:latest", "", "functions/figlet:latest"},
+		{"user image", "functions/figlet:latest", "registry.local:5000", "registry.local:5000/functions/figlet:latest"},
+		{"official image", "alpine:3.8", "registry.local:5000", "registry.local:5000/alpine:3.8"},
+		{"explicit registry", "quay.io/functions/figlet", "registry.local:5000", "quay.io/functions/figlet"},
+		{"explicit docker hub", "docker.io/functions/figlet", "registry.local:5000", "docker.io/functions/figlet"},
+		{"registry with port", "other:5000/figlet", "registry.local:5000", "other:5000/figlet"},
+		{"localhost", "localhost/figlet", "registry.local:5000", "localhost/figlet"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := qualifyImage(c.image, c.defaultRegistry); got != c.want {
+				t.Errorf("want: %s got: %s", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_DeployFunction_DefaultRegistry(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		DefaultRegistry:     "registry.local:5000",
+	}
+
+	request := batchRequest("figlet")
+	request.Image = "functions/figlet:latest"
+	request.RegistryAuth = base64.StdEncoding.EncodeToString([]byte("user:password"))
+
+	if _, _, err := deployFunction(c, config, &request, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "registry.local:5000/functions/figlet:latest"
+	if got := c.specs[0].TaskTemplate.ContainerSpec.Image; got != want {
+		t.Errorf("want: image %s got: %s", want, got)
+	}
+
+	auth := types.AuthConfig{}
+	authJSON, _ := base64.URLEncoding.DecodeString(c.options[0].EncodedRegistryAuth)
+	if err := json.Unmarshal(authJSON, &auth); err != nil {
+		t.Fatalf("unexpected registry auth %q: %s", c.options[0].EncodedRegistryAuth, err)
+	}
+	if auth.ServerAddress != "registry.local:5000" {
+		t.Errorf("want: server address %s got: %s", "registry.local:5000", auth.ServerAddress)
+	}
+}
+
+func Test_CheckImageAllowed(t *testing.T) {
+	allowed := []string{"registry.local:5000", "docker.io/functions"}
+
+	cases := []struct {
+		name    string
+		image   string
+		allowed []string
+		wantErr string
+	}{
+		{name: "no allow-list", image: "evil/miner:latest", allowed: nil},
+		{name: "allowed registry", image: "registry.local:5000/team/figlet:0.1", allowed: allowed},
+		{name: "allowed docker hub user", image: "functions/figlet:latest", allowed: allowed},
+		{name: "allowed docker hub user with registry", image: "docker.io/functions/figlet", allowed: allowed},
+		{name: "disallowed docker hub user", image: "evil/miner:latest", allowed: allowed, wantErr: ErrCodeImageNotAllowed},
+		{name: "disallowed official image", image: "alpine:3.8", allowed: allowed, wantErr: ErrCodeImageNotAllowed},
+		{name: "disallowed registry", image: "quay.io/functions/figlet", allowed: allowed, wantErr: ErrCodeImageNotAllowed},
+		{name: "prefix is not a path segment", image: "docker.io/functions-evil/figlet", allowed: allowed, wantErr: ErrCodeImageNotAllowed},
+		{name: "host is not a prefix of another host", image: "registry.local:50001/figlet", allowed: allowed, wantErr: ErrCodeImageNotAllowed},
+		{name: "invalid image", image: "Functions/FIGLET", allowed: allowed, wantErr: ErrCodeInvalidRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkImageAllowed(c.image, c.allowed)
+			if got := errorCode(err, ""); got != c.wantErr {
+				t.Errorf("want: error code %q got: %q %v", c.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func Test_DeployHandler_ImageNotAllowed(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		AllowedRegistries:   []string{"registry.local:5000"},
+	}
+
+	for _, s := range []struct {
+		image string
+		want  int
+	}{
+		{image: "functions/figlet:latest", want: http.StatusForbidden},
+		{image: "registry.local:5000/functions/figlet:latest", want: http.StatusAccepted},
+	} {
+		request := batchRequest("figlet")
+		request.Image = s.image
+
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		DeployHandler(c, config).ServeHTTP(rr, req)
+
+		if rr.Code != s.want {
+			t.Errorf("want: %d for %s got: %d %s", s.want, s.image, rr.Code, rr.Body.String())
+		}
+	}
+
+	if len(c.created) != 1 {
+		t.Errorf("want: only the allowed image deployed got: %v", c.created)
+	}
+}
+
+func Test_FindStickyNode(t *testing.T) {
+	now := time.Now()
+	taskOn := func(nodeID string, state swarm.TaskState, started time.Time) swarm.Task {
+		return swarm.Task{NodeID: nodeID, Status: swarm.TaskStatus{State: state, Timestamp: started}}
+	}
+
+	tasks := []swarm.Task{
+		taskOn("node-b", swarm.TaskStateRunning, now.Add(-time.Minute)),
+		taskOn("node-a", swarm.TaskStateRunning, now.Add(-time.Hour)),
+		taskOn("node-c", swarm.TaskStateShutdown, now.Add(-2*time.Hour)),
+	}
+
+	scenarios := []struct {
+		name       string
+		pinnedNode string
+		nodes      []swarm.Node
+		tasks      []swarm.Task
+		want       string
+	}{
+		{name: "oldest running task", tasks: tasks, want: "node-a"},
+		{name: "pinned node in swarm", pinnedNode: "node-b", nodes: []swarm.Node{{ID: "node-b"}}, tasks: tasks, want: "node-b"},
+		{name: "pinned node left swarm", pinnedNode: "node-d", nodes: []swarm.Node{}, tasks: tasks, want: "node-a"},
+		{name: "no running tasks", tasks: []swarm.Task{taskOn("node-c", swarm.TaskStatePending, now)}, want: ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.nodes = s.nodes
+			c.tasks = s.tasks
+
+			got, err := findStickyNode(c, "svc-figlet", s.pinnedNode)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got != s.want {
+				t.Errorf("want: node %q got: %q", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_PinToNode(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:latest",
+		Labels:  &map[string]string{StickyPlacementLabel: "true"},
+		Constraints: []string{
+			"node.platform.os == linux",
+		},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if !isStickyPlacement(request) {
+		t.Fatalf("want: sticky placement")
+	}
+
+	pinToNode(&spec, "node-a")
+
+	want := []string{"node.platform.os == linux", "node.id == node-a"}
+	if got := spec.TaskTemplate.Placement.Constraints; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: constraints %v got: %v", want, got)
+	}
+	if got := spec.Labels[stickyNodeLabel]; got != "node-a" {
+		t.Errorf("want: label %s=%s got: %q", stickyNodeLabel, "node-a", got)
+	}
+}
+
+func Test_StickyPlacementLabel(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		labels     map[string]string
+		wantSticky bool
+		wantErr    bool
+	}{
+		{name: "sticky", labels: map[string]string{StickyPlacementLabel: "true"}, wantSticky: true},
+		{name: "not sticky", labels: map[string]string{StickyPlacementLabel: "false"}},
+		{name: "pinned by node id", labels: map[string]string{StickyPlacementLabel: "true", PlacementNodeIDsLabel: "node-a"}},
+		{name: "invalid value", labels: map[string]string{StickyPlacementLabel: "yes"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{Labels: &s.labels}
+
+			_, err := buildPlacement(request, nil, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidPlacement {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidPlacement, code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if got := isStickyPlacement(request); got != s.wantSticky {
+				t.Errorf("want: sticky %v got: %v", s.wantSticky, got)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_EnvProcessInEnvVars(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		envProcess string
+		envVars    map[string]string
+		want       string
+		wantErr    bool
+	}{
+		{name: "envProcess only", envProcess: "cat", envVars: map[string]string{"mode": "http"}, want: "cat"},
+		{name: "envVars only", envVars: map[string]string{"fprocess": "cat", "mode": "http"}, want: "cat"},
+		{name: "same value in both", envProcess: "cat", envVars: map[string]string{"fprocess": "cat"}, want: "cat"},
+		{name: "conflicting values", envProcess: "cat", envVars: map[string]string{"fprocess": "sha512sum"}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service:    "figlet",
+				Image:      "functions/figlet:latest",
+				EnvProcess: s.envProcess,
+				EnvVars:    s.envVars,
+			}
+
+			spec, err := makeSpec(request, DeployConfig{}, nil)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidEnv {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidEnv, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			fprocess := []string{}
+			for _, entry := range spec.TaskTemplate.ContainerSpec.Env {
+				if strings.HasPrefix(entry, "fprocess=") {
+					fprocess = append(fprocess, entry)
+				}
+			}
+
+			if want := []string{"fprocess=" + s.want}; !reflect.DeepEqual(fprocess, want) {
+				t.Errorf("want: %v got: %v", want, fprocess)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DeployTimingSecrets is the time spent resolving the secrets of a function and creating
+	// its inline secrets
+	DeployTimingSecrets = "secrets"
+
+	// DeployTimingNetwork is the time spent finding the network of a function and checking it
+	DeployTimingNetwork = "network"
+
+	// DeployTimingSpec is the time spent building the service spec
+	DeployTimingSpec = "spec"
+
+	// DeployTimingCreate is the time spent waiting for Swarm to create the service
+	DeployTimingCreate = "create"
+)
+
+// deployTiming is how long one phase of a deploy took
+type deployTiming struct {
+	phase    string
+	duration time.Duration
+}
+
+// deployTimings records how long each phase of a single deploy takes. All methods are no-ops
+// on a nil deployTimings.
+type deployTimings struct {
+	timings []deployTiming
+}
+
+// Start begins timing phase, the returned func records the time taken when it is called
+func (t *deployTimings) Start(phase string) func() {
+	if t == nil {
+		return func() {}
+	}
+
+	began := time.Now()
+	return func() {
+		t.timings = append(t.timings, deployTiming{phase: phase, duration: time.Since(began)})
+	}
+}
+
+// String lists each phase timed with its duration, i.e. "secrets=2ms network=5ms"
+func (t *deployTimings) String() string {
+	if t == nil {
+		return ""
+	}
+
+	parts := []string{}
+	for _, timing := range t.timings {
+		parts = append(parts, fmt.Sprintf("%s=%s", timing.phase, timing.duration))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// DeployPhaseStats are the timings of one deploy phase across every deploy
+type DeployPhaseStats struct {
+	Count        uint64  `json:"count"`
+	TotalSeconds float64 `json:"totalSeconds"`
+	MaxSeconds   float64 `json:"maxSeconds"`
+}
+
+// DeployMetrics accumulates the phase timings of every deploy, so operators can find the
+// slow phase during bulk deploys. A nil DeployMetrics records nothing.
+type DeployMetrics struct {
+	mu     sync.Mutex
+	phases map[string]DeployPhaseStats
+}
+
+// NewDeployMetrics returns an empty DeployMetrics
+func NewDeployMetrics() *DeployMetrics {
+	return &DeployMetrics{phases: map[string]DeployPhaseStats{}}
+}
+
+// Record adds the phase timings of a deploy
+func (m *DeployMetrics) Record(timings *deployTimings) {
+	if m == nil || timings == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, timing := range timings.timings {
+		stats := m.phases[timing.phase]
+		seconds := timing.duration.Seconds()
+
+		stats.Count++
+		stats.TotalSeconds += seconds
+		if seconds > stats.MaxSeconds {
+			stats.MaxSeconds = seconds
+		}
+
+		m.phases[timing.phase] = stats
+	}
+}
+
+// Phases returns the timings of each phase recorded so far
+func (m *DeployMetrics) Phases() map[string]DeployPhaseStats {
+	phases := map[string]DeployPhaseStats{}
+	if m == nil {
+		return phases
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for phase, stats := range m.phases {
+		phases[phase] = stats
+	}
+
+	return phases
+}
+
+// MakeDeployMetricsHandler returns the DeployPhaseStats of each deploy phase keyed by phase
+func MakeDeployMetricsHandler(m *DeployMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal(m.Phases())
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// logDeployTimings logs the phase timings of a deploy and adds them to metrics
+func logDeployTimings(service string, timings *deployTimings, metrics *DeployMetrics) {
+	if timings == nil || len(timings.timings) == 0 {
+		return
+	}
+
+	log.Printf("Deploy timings for %s: %s\n", service, timings)
+	metrics.Record(timings)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_DeployFunction_RecordsPhaseTimings(t *testing.T) {
+	c := newFakeDeployClient()
+	metrics := NewDeployMetrics()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, Metrics: metrics}
+
+	for _, name := range []string{"figlet", "nodeinfo"} {
+		request := batchRequest(name)
+		if status, _, err := deployFunction(c, config, &request, nil); status != http.StatusAccepted {
+			t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+		}
+	}
+
+	phases := metrics.Phases()
+	for _, phase := range []string{DeployTimingSecrets, DeployTimingNetwork, DeployTimingSpec, DeployTimingCreate} {
+		stats, exists := phases[phase]
+		if !exists {
+			t.Errorf("want: timings for %s got: none", phase)
+			continue
+		}
+
+		if stats.Count != 2 {
+			t.Errorf("want: %s timed 2 times got: %d", phase, stats.Count)
+		}
+
+		if stats.MaxSeconds > stats.TotalSeconds {
+			t.Errorf("want: %s max no more than total got: max %f total %f", phase, stats.MaxSeconds, stats.TotalSeconds)
+		}
+	}
+}
+
+func Test_DeployFunction_RejectedBeforeCreateIsNotTimedAsCreate(t *testing.T) {
+	c := newFakeDeployClient()
+	metrics := NewDeployMetrics()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, Metrics: metrics}
+
+	request := batchRequest("figlet")
+	request.Secrets = []string{"missing"}
+	if status, _, _ := deployFunction(c, config, &request, nil); status != http.StatusBadRequest {
+		t.Fatalf("want: %d got: %d", http.StatusBadRequest, status)
+	}
+
+	if _, exists := metrics.Phases()[DeployTimingCreate]; exists {
+		t.Errorf("want: no %s timing for a rejected deploy", DeployTimingCreate)
+	}
+}
+
+func Test_DeployMetricsHandler(t *testing.T) {
+	metrics := NewDeployMetrics()
+	metrics.Record(&deployTimings{timings: []deployTiming{{phase: DeployTimingCreate, duration: 1500 * time.Millisecond}}})
+
+	rr := httptest.NewRecorder()
+	MakeDeployMetricsHandler(metrics).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/system/metrics/deploy", nil))
+
+	phases := map[string]DeployPhaseStats{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &phases); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	want := DeployPhaseStats{Count: 1, TotalSeconds: 1.5, MaxSeconds: 1.5}
+	if got := phases[DeployTimingCreate]; got != want {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
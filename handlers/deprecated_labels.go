@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// renameDeprecatedLabels copies the value of each deprecated label of a request, a key of
+// deprecated, to the label which replaces it, unless that is also set. The deprecated label
+// is kept so anything still reading it works. A warning is returned for each deprecated
+// label, sorted by key.
+func renameDeprecatedLabels(request *typesv1.FunctionDeployment, deprecated map[string]string) []string {
+	if request.Labels == nil || len(deprecated) == 0 {
+		return nil
+	}
+
+	labels := *request.Labels
+
+	var warnings []string
+	for oldKey, newKey := range deprecated {
+		value, exists := labels[oldKey]
+		if !exists {
+			continue
+		}
+
+		if _, replaced := labels[newKey]; replaced {
+			warnings = append(warnings, fmt.Sprintf("label %s is deprecated and ignored as %s is also set", oldKey, newKey))
+			continue
+		}
+
+		labels[newKey] = value
+		warnings = append(warnings, fmt.Sprintf("label %s is deprecated, use %s", oldKey, newKey))
+	}
+
+	sort.Strings(warnings)
+
+	return warnings
+}
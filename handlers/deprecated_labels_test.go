@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_DeployHandler_DeprecatedLabel(t *testing.T) {
+	scenarios := []struct {
+		name         string
+		labels       map[string]string
+		wantReplicas uint64
+		wantWarning  string
+	}{
+		{
+			name:         "deprecated label honoured",
+			labels:       map[string]string{"com.openfaas.scale.min.legacy": "3"},
+			wantReplicas: 3,
+			wantWarning:  "label com.openfaas.scale.min.legacy is deprecated, use " + MinScaleLabel,
+		},
+		{
+			name:         "replacement label wins",
+			labels:       map[string]string{"com.openfaas.scale.min.legacy": "3", MinScaleLabel: "2"},
+			wantReplicas: 2,
+			wantWarning:  "label com.openfaas.scale.min.legacy is deprecated and ignored as " + MinScaleLabel + " is also set",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			config := DeployConfig{
+				MaxLabelValueLength: DefaultMaxLabelValueLength,
+				DeprecatedLabels:    map[string]string{"com.openfaas.scale.min.legacy": MinScaleLabel},
+			}
+
+			request := batchRequest("figlet")
+			request.Labels = &s.labels
+
+			body, _ := json.Marshal(request)
+			req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(c, config).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+			}
+
+			if warning := rr.Header().Get("Warning"); !strings.Contains(warning, s.wantWarning) {
+				t.Errorf("want: warning %q got: %q", s.wantWarning, warning)
+			}
+
+			if len(c.specs) != 1 {
+				t.Fatalf("want: 1 service created got: %d", len(c.specs))
+			}
+			if replicas := *c.specs[0].Mode.Replicated.Replicas; replicas != s.wantReplicas {
+				t.Errorf("want: %d replicas got: %d", s.wantReplicas, replicas)
+			}
+		})
+	}
+}
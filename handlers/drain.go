@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Drainer tracks the requests in progress so the provider can shut down without cutting a
+// deploy or update half way. Once draining, new requests are rejected with 503, streaming
+// requests such as followed logs and server-sent events are cancelled, and the other requests
+// may complete.
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{}
+
+	streams       context.Context
+	cancelStreams context.CancelFunc
+}
+
+// NewDrainer returns a Drainer accepting requests
+func NewDrainer() *Drainer {
+	streams, cancelStreams := context.WithCancel(context.Background())
+
+	return &Drainer{
+		idle:          make(chan struct{}),
+		streams:       streams,
+		cancelStreams: cancelStreams,
+	}
+}
+
+// Middleware tracks each request to next until it returns
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.start() {
+			w.Header().Set("Connection", "close")
+			writeText(w, http.StatusServiceUnavailable, "The provider is shutting down.")
+			return
+		}
+		defer d.done()
+
+		if isStreamingRequest(r) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			go func() {
+				select {
+				case <-d.streams.Done():
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			r = r.WithContext(ctx)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Drain stops accepting requests, cancels the streaming requests and waits up to timeout for
+// the others to complete. An error is returned when some are still in progress.
+func (d *Drainer) Drain(timeout time.Duration) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.active == 0 {
+			close(d.idle)
+		}
+	}
+	d.mu.Unlock()
+
+	d.cancelStreams()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return fmt.Errorf("%d requests still in progress after %s", d.active, timeout)
+}
+
+// start counts a new request, it returns false when draining
+func (d *Drainer) start() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return false
+	}
+
+	d.active++
+	return true
+}
+
+// done counts a completed request
+func (d *Drainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// isStreamingRequest returns true for requests which stream until the client goes away,
+// such as followed logs, the events of a function and any request accepting server-sent
+// events, which includes the progress of a deploy
+func isStreamingRequest(r *http.Request) bool {
+	if r.URL.Query().Get("follow") == "true" {
+		return true
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/system/function/") && strings.HasSuffix(r.URL.Path, "/events") {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), deployProgressContentType)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/gorilla/mux"
+)
+
+func Test_Drainer_WaitsForRequests(t *testing.T) {
+	drainer := NewDrainer()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+	<-started
+
+	drained := make(chan error)
+	go func() {
+		drained <- drainer.Drain(time.Second)
+	}()
+
+	for !isDraining(drainer) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want: new request rejected with %d got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("want: no error got: %v", err)
+	}
+}
+
+func Test_Drainer_Timeout(t *testing.T) {
+	drainer := NewDrainer()
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+	<-started
+
+	if err := drainer.Drain(10 * time.Millisecond); err == nil {
+		t.Errorf("want: error for the request still in progress got: nil")
+	}
+}
+
+func Test_Drainer_CancelsStreams(t *testing.T) {
+	drainer := NewDrainer()
+
+	started := make(chan struct{})
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/system/logs?name=figlet&follow=true", nil))
+	<-started
+
+	if err := drainer.Drain(time.Second); err != nil {
+		t.Errorf("want: followed logs cancelled got: %v", err)
+	}
+}
+
+func Test_Drainer_CancelsEventsStream(t *testing.T) {
+	drainer := NewDrainer()
+	c := &fakeEventsClient{
+		serviceMessages:   make(chan events.Message),
+		containerMessages: make(chan events.Message),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/events", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+
+	done := make(chan struct{})
+	go func() {
+		drainer.Middleware(MakeEventsHandler(c, DefaultFunctionLabel)).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	// the handler is streaming once it waits for the first event
+	c.serviceMessages <- events.Message{Type: events.ServiceEventType, Action: "update"}
+
+	if err := drainer.Drain(time.Second); err != nil {
+		t.Errorf("want: events stream cancelled got: %v", err)
+	}
+	<-done
+}
+
+func Test_IsStreamingRequest(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		url    string
+		accept string
+		want   bool
+	}{
+		{name: "followed logs", url: "/system/logs?name=figlet&follow=true", want: true},
+		{name: "logs", url: "/system/logs?name=figlet"},
+		{name: "function events", url: "/system/function/figlet/events", want: true},
+		{name: "deploy progress", url: "/system/functions", accept: "text/event-stream", want: true},
+		{name: "deploy", url: "/system/functions", accept: "application/json"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, s.url, nil)
+			if len(s.accept) > 0 {
+				req.Header.Set("Accept", s.accept)
+			}
+
+			if got := isStreamingRequest(req); got != s.want {
+				t.Errorf("want: %t got: %t", s.want, got)
+			}
+		})
+	}
+}
+
+func isDraining(d *Drainer) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining
+}
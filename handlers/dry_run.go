@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// DryRunResult is returned by DeployHandler for ?dryRun=true, nothing is created
+type DryRunResult struct {
+	// Service is the name of the function
+	Service string `json:"service"`
+
+	// Exists is set when a function of the same name is already deployed
+	Exists bool `json:"exists"`
+
+	// Changes lists the differences between the deployed function and the request, sorted by
+	// field. It is only set when the function exists.
+	Changes []SpecChange `json:"changes,omitempty"`
+
+	// Warnings are the warnings the deploy would return
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SpecChange is a field which differs between the deployed and proposed spec of a function.
+// From is empty for an added field and To is empty for a removed one.
+type SpecChange struct {
+	// Field is "image", "env.NAME", "labels.KEY", "limits.memory", "limits.cpu",
+	// "requests.memory" or "requests.cpu"
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// diffIgnoredLabels change on every deploy, so are left out of a diff
+var diffIgnoredLabels = map[string]bool{
+	"com.openfaas.uid": true,
+	previousImageLabel: true,
+}
+
+// isDryRun reports whether a deploy request asks for a dry run with ?dryRun=true
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	return dryRun
+}
+
+// dryRunDeploy validates a request as deployFunction does and writes a DryRunResult without
+// creating anything. When a service of the same name exists, the result lists how its spec
+// differs from the one the request would create.
+func dryRunDeploy(w http.ResponseWriter, c DeployClient, config DeployConfig, request *CreateFunctionRequest) {
+	plan, status, err := planDeploy(c, config, request, nil, nil, true)
+	if err != nil {
+		writeDeployError(w, status, err, ErrCodeDeployFailed)
+		return
+	}
+
+	result := DryRunResult{
+		Service:  request.Service,
+		Warnings: plan.warnings,
+	}
+
+	service, _, err := c.ServiceInspectWithRaw(context.Background(), request.Service, types.ServiceInspectOptions{})
+	if err == nil {
+		result.Exists = true
+		result.Changes = diffSpecs(service.Spec, plan.spec)
+	} else if !client.IsErrNotFound(err) {
+		log.Printf("Error inspecting service %s for a dry run: %s\n", request.Service, err)
+		writeDeployError(w, http.StatusInternalServerError, err, ErrCodeDeployFailed)
+		return
+	}
+
+	body, _ := json.Marshal(result)
+	writeJSON(w, http.StatusOK, body)
+}
+
+// diffSpecs compares the image, environment, labels and resources of two service specs
+func diffSpecs(live swarm.ServiceSpec, proposed swarm.ServiceSpec) []SpecChange {
+	changes := []SpecChange{}
+
+	liveImage := specImage(live)
+	if !strings.Contains(specImage(proposed), "@") {
+		// Swarm pins the image to the digest it resolved when the service was created
+		liveImage = strings.SplitN(liveImage, "@", 2)[0]
+	}
+	changes = appendChange(changes, "image", liveImage, specImage(proposed))
+
+	changes = diffMaps(changes, "env.", specEnv(live), specEnv(proposed))
+	changes = diffMaps(changes, "labels.", specLabels(live), specLabels(proposed))
+	changes = diffMaps(changes, "", specResources(live), specResources(proposed))
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+func appendChange(changes []SpecChange, field string, from string, to string) []SpecChange {
+	if from == to {
+		return changes
+	}
+
+	return append(changes, SpecChange{Field: field, From: from, To: to})
+}
+
+func diffMaps(changes []SpecChange, prefix string, live map[string]string, proposed map[string]string) []SpecChange {
+	for key, from := range live {
+		changes = appendChange(changes, prefix+key, from, proposed[key])
+	}
+
+	for key, to := range proposed {
+		if _, exists := live[key]; !exists {
+			changes = appendChange(changes, prefix+key, "", to)
+		}
+	}
+
+	return changes
+}
+
+func specImage(spec swarm.ServiceSpec) string {
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return ""
+	}
+
+	return spec.TaskTemplate.ContainerSpec.Image
+}
+
+func specEnv(spec swarm.ServiceSpec) map[string]string {
+	env := map[string]string{}
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return env
+	}
+
+	for _, entry := range spec.TaskTemplate.ContainerSpec.Env {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		} else {
+			env[parts[0]] = ""
+		}
+	}
+
+	return env
+}
+
+// specLabels returns the service labels along with the task labels, which are given with
+// TaskLabelPrefix as they are in a request. The service labels are copied to the containers,
+// so only the container labels which are not also service labels are task labels.
+func specLabels(spec swarm.ServiceSpec) map[string]string {
+	labels := map[string]string{}
+	for key, value := range spec.Annotations.Labels {
+		if !diffIgnoredLabels[key] {
+			labels[key] = value
+		}
+	}
+
+	if spec.TaskTemplate.ContainerSpec != nil {
+		for key, value := range spec.TaskTemplate.ContainerSpec.Labels {
+			if _, isServiceLabel := spec.Annotations.Labels[key]; isServiceLabel || diffIgnoredLabels[key] {
+				continue
+			}
+			labels[TaskLabelPrefix+key] = value
+		}
+	}
+
+	return labels
+}
+
+func specResources(spec swarm.ServiceSpec) map[string]string {
+	resources := map[string]string{}
+	if spec.TaskTemplate.Resources == nil {
+		return resources
+	}
+
+	addResources := func(prefix string, r *swarm.Resources) {
+		if r == nil {
+			return
+		}
+		if r.MemoryBytes > 0 {
+			resources[prefix+"memory"] = units.BytesSize(float64(r.MemoryBytes))
+		}
+		if r.NanoCPUs > 0 {
+			resources[prefix+"cpu"] = fmt.Sprintf("%g", float64(r.NanoCPUs)/1e9)
+		}
+	}
+
+	addResources("limits.", spec.TaskTemplate.Resources.Limits)
+	addResources("requests.", spec.TaskTemplate.Resources.Reservations)
+
+	return resources
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func Test_DiffSpecs_ImageAndEnv(t *testing.T) {
+	live := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   "figlet",
+			Labels: map[string]string{"com.openfaas.uid": "1", "team": "a"},
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{
+				Image: "functions/figlet:0.1@sha256:abc",
+				Env:   []string{"mode=slow", "debug=true"},
+			},
+		},
+	}
+	proposed := swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name:   "figlet",
+			Labels: map[string]string{"com.openfaas.uid": "2", "team": "a"},
+		},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{
+				Image: "functions/figlet:0.2",
+				Env:   []string{"mode=fast", "write_timeout=10s"},
+			},
+		},
+	}
+
+	want := []SpecChange{
+		{Field: "env.debug", From: "true"},
+		{Field: "env.mode", From: "slow", To: "fast"},
+		{Field: "env.write_timeout", To: "10s"},
+		{Field: "image", From: "functions/figlet:0.1", To: "functions/figlet:0.2"},
+	}
+	if got := diffSpecs(live, proposed); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+
+	if got := diffSpecs(live, live); len(got) != 0 {
+		t.Errorf("want: no changes for the same spec got: %+v", got)
+	}
+}
+
+func Test_DeployHandler_DryRun(t *testing.T) {
+	c := newFakeDeployClient()
+	existing := batchRequest("figlet")
+	existing.EnvVars = map[string]string{"mode": "slow"}
+	if status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &existing, nil); err != nil {
+		t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+	}
+
+	scenarios := []struct {
+		name        string
+		service     string
+		wantExists  bool
+		wantChanges []SpecChange
+	}{
+		{
+			name:       "existing function",
+			service:    "figlet",
+			wantExists: true,
+			wantChanges: []SpecChange{
+				{Field: "env.mode", From: "slow", To: "fast"},
+				{Field: "image", From: "functions/alpine:latest", To: "functions/figlet:0.2"},
+			},
+		},
+		{name: "new function", service: "nodeinfo"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := batchRequest(s.service)
+			request.Image = "functions/figlet:0.2"
+			request.EnvVars = map[string]string{"mode": "fast"}
+			body, _ := json.Marshal(request)
+
+			req := httptest.NewRequest(http.MethodPost, "/system/functions?dryRun=true", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			result := DryRunResult{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatalf("want: JSON result got: %q", rr.Body.String())
+			}
+			if result.Exists != s.wantExists {
+				t.Errorf("want: exists %t got: %t", s.wantExists, result.Exists)
+			}
+			if !reflect.DeepEqual(result.Changes, s.wantChanges) {
+				t.Errorf("want: %+v got: %+v", s.wantChanges, result.Changes)
+			}
+			if len(c.created) != 1 {
+				t.Errorf("want: only the existing service created got: %v", c.created)
+			}
+		})
+	}
+}
+
+func Test_DiffSpecs_Labels(t *testing.T) {
+	labelledSpec := func(labels map[string]string) swarm.ServiceSpec {
+		serviceLabels, containerLabels, err := splitTaskLabels(labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Name: "figlet", Labels: serviceLabels},
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{Image: "functions/figlet:0.1", Labels: containerLabels},
+			},
+		}
+	}
+
+	live := labelledSpec(map[string]string{
+		"com.openfaas.uid":          "1",
+		previousImageLabel:          "functions/figlet:0.0",
+		"team":                      "a",
+		TaskLabelPrefix + "logging": "splunk",
+	})
+	proposed := labelledSpec(map[string]string{
+		"com.openfaas.uid":          "2",
+		previousImageLabel:          "functions/figlet:0.1",
+		"team":                      "b",
+		"tier":                      "web",
+		TaskLabelPrefix + "logging": "fluentd",
+	})
+
+	want := []SpecChange{
+		{Field: "labels." + TaskLabelPrefix + "logging", From: "splunk", To: "fluentd"},
+		{Field: "labels.team", From: "a", To: "b"},
+		{Field: "labels.tier", To: "web"},
+	}
+	if got := diffSpecs(live, proposed); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
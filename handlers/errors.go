@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Error codes returned when a deployment is rejected. The codes are stable so that clients
+// can decide whether to retry or to surface the problem to the user.
+const (
+	// ErrCodeInvalidRequest the request body could not be parsed
+	ErrCodeInvalidRequest = "invalid_request"
+	// ErrCodeInvalidRegistryAuth the registry auth could not be decoded
+	ErrCodeInvalidRegistryAuth = "invalid_registry_auth"
+	// ErrCodeSecretNotFound a referenced secret does not exist
+	ErrCodeSecretNotFound = "secret_not_found"
+	// ErrCodeInvalidSecret a secret was duplicated or is not allowed
+	ErrCodeInvalidSecret = "invalid_secret"
+	// ErrCodeInvalidLabel a label or annotation is empty, oversized or has a bad value
+	ErrCodeInvalidLabel = "invalid_label"
+	// ErrCodeMissingLabel a label required by the provider was not given
+	ErrCodeMissingLabel = "missing_label"
+	// ErrCodeAnnotationClash a label clashes with the label generated for an annotation
+	ErrCodeAnnotationClash = "annotation_clash"
+	// ErrCodeInvalidMemory a memory limit or request could not be parsed
+	ErrCodeInvalidMemory = "invalid_memory"
+	// ErrCodeInvalidCPU a CPU limit or request could not be parsed
+	ErrCodeInvalidCPU = "invalid_cpu"
+	// ErrCodeInvalidEnv an environment variable conflicts with another setting
+	ErrCodeInvalidEnv = "invalid_env"
+	// ErrCodeInvalidPlacement a placement constraint or preference is malformed
+	ErrCodeInvalidPlacement = "invalid_placement"
+	// ErrCodeInvalidNetwork the network or network mode can not be used
+	ErrCodeInvalidNetwork = "invalid_network"
+	// ErrCodeInvalidPort a published port is malformed or collides with another port
+	ErrCodeInvalidPort = "invalid_port"
+	// ErrCodeInvalidMount a bind or volume mount is malformed or collides with another mount
+	ErrCodeInvalidMount = "invalid_mount"
+	// ErrCodeImageNotAllowed the image is not from one of the allowed registries
+	ErrCodeImageNotAllowed = "image_not_allowed"
+	// ErrCodeImageNotFound the image could not be resolved in its registry
+	ErrCodeImageNotFound = "image_not_found"
+	// ErrCodeRegistryAuth the registry of the image refused the registry auth
+	ErrCodeRegistryAuth = "registry_auth_failed"
+	// ErrCodeRegistryUnavailable the registry of the image could not be reached or timed out
+	ErrCodeRegistryUnavailable = "registry_unavailable"
+	// ErrCodeNoPreviousVersion the function has not been updated so can not be rolled back
+	ErrCodeNoPreviousVersion = "no_previous_version"
+	// ErrCodeQuotaExceeded the replicas of a namespace would exceed its quota
+	ErrCodeQuotaExceeded = "quota_exceeded"
+	// ErrCodeFunctionLimit the maximum number of functions are already deployed
+	ErrCodeFunctionLimit = "function_limit"
+	// ErrCodeDependencyNotReady the function a function depends on has no running replicas
+	ErrCodeDependencyNotReady = "dependency_not_ready"
+	// ErrCodeDeployFailed Swarm rejected or failed to apply the service spec
+	ErrCodeDeployFailed = "deploy_failed"
+	// ErrCodeDeployThrottled too many deployments are in progress or queued
+	ErrCodeDeployThrottled = "deploy_throttled"
+	// ErrCodeDeployInProgress a deployment with the same Idempotency-Key is still in progress
+	ErrCodeDeployInProgress = "deploy_in_progress"
+	// ErrCodeDeployTimeout Swarm did not create the service within the deploy timeout
+	ErrCodeDeployTimeout = "deploy_timeout"
+)
+
+// DeployError is a deployment failure with a machine-readable code, it is written
+// to the client as the JSON error envelope
+type DeployError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Field is the request field with a bad value, such as "limits.memory", when the
+	// error is about a single field
+	Field string `json:"field,omitempty"`
+
+	// Value is the bad value of Field
+	Value string `json:"value,omitempty"`
+
+	// Errors lists each problem when a request has more than one, see validateRequest
+	Errors []*DeployError `json:"errors,omitempty"`
+}
+
+func (e *DeployError) Error() string {
+	return e.Message
+}
+
+func newDeployError(code string, format string, args ...interface{}) *DeployError {
+	return &DeployError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// newFieldError returns a DeployError for a bad value of a request field, reason explains
+// the values which are accepted
+func newFieldError(code string, field string, value string, reason string) *DeployError {
+	return &DeployError{
+		Code:    code,
+		Message: fmt.Sprintf("invalid value for %s: %s, %s", field, value, reason),
+		Field:   field,
+		Value:   value,
+	}
+}
+
+// errorCode returns the code of a DeployError, or fallbackCode for any other error
+func errorCode(err error, fallbackCode string) string {
+	if deployErr, ok := err.(*DeployError); ok {
+		return deployErr.Code
+	}
+
+	return fallbackCode
+}
+
+// toDeployError returns err if it is a DeployError, otherwise it wraps err with fallbackCode
+func toDeployError(err error, fallbackCode string) *DeployError {
+	if deployErr, ok := err.(*DeployError); ok {
+		return deployErr
+	}
+
+	return &DeployError{
+		Code:    fallbackCode,
+		Message: err.Error(),
+	}
+}
+
+// writeDeployError writes err as a JSON error envelope, errors which are not a
+// DeployError are reported with fallbackCode
+func writeDeployError(w http.ResponseWriter, statusCode int, err error, fallbackCode string) {
+	body, _ := json.Marshal(toDeployError(err, fallbackCode))
+
+	writeJSON(w, statusCode, body)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// EventsClient is the subset of Docker Client methods required to stream the events of a function
+type EventsClient interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+}
+
+// FunctionEvent is a lifecycle event of a function's service or one of its task containers
+type FunctionEvent struct {
+	// Type is either "service" or "container"
+	Type string `json:"type"`
+
+	// Action is the Docker event action, i.e. create, start, die or update
+	Action string `json:"action"`
+
+	// ID is the ID of the service or container which generated the event
+	ID string `json:"id"`
+
+	// TaskID is the Swarm task which the container belongs to
+	TaskID string `json:"taskId,omitempty"`
+
+	// ExitCode is set for containers which have died
+	ExitCode string `json:"exitCode,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MakeEventsHandler streams the service and task container events of a function as
+// server-sent events until the client disconnects.
+// Container events are reported by the Docker daemon the provider is connected to, so
+// tasks scheduled onto other nodes only appear through service events. Services without
+// functionLabel are reported as not found.
+func MakeEventsHandler(c EventsClient, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Println("EventsHandler: response is not a Flusher, required for streaming response")
+			http.NotFound(w, r)
+			return
+		}
+
+		service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+				return
+			}
+
+			log.Printf("EventsHandler: error inspecting service %s: %s\n", functionName, err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !isFunctionService(service, functionLabel) {
+			writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		serviceFilter := filters.NewArgs()
+		serviceFilter.Add("type", events.ServiceEventType)
+		serviceFilter.Add("service", service.ID)
+		serviceMessages, serviceErrs := c.Events(ctx, types.EventsOptions{Filters: serviceFilter})
+
+		containerFilter := filters.NewArgs()
+		containerFilter.Add("type", events.ContainerEventType)
+		containerFilter.Add("label", "com.docker.swarm.service.id="+service.ID)
+		containerMessages, containerErrs := c.Events(ctx, types.EventsOptions{Filters: containerFilter})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			var msg events.Message
+
+			select {
+			case <-ctx.Done():
+				return
+			case msg = <-serviceMessages:
+			case msg = <-containerMessages:
+			case err := <-serviceErrs:
+				log.Printf("EventsHandler: service events for %s stopped: %s\n", functionName, err)
+				return
+			case err := <-containerErrs:
+				log.Printf("EventsHandler: container events for %s stopped: %s\n", functionName, err)
+				return
+			}
+
+			if err := writeFunctionEvent(w, toFunctionEvent(msg)); err != nil {
+				log.Printf("EventsHandler: error writing event for %s: %s\n", functionName, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func toFunctionEvent(msg events.Message) FunctionEvent {
+	return FunctionEvent{
+		Type:      msg.Type,
+		Action:    msg.Action,
+		ID:        msg.Actor.ID,
+		TaskID:    msg.Actor.Attributes["com.docker.swarm.task.id"],
+		ExitCode:  msg.Actor.Attributes["exitCode"],
+		Timestamp: time.Unix(0, msg.TimeNano).UTC(),
+	}
+}
+
+// writeFunctionEvent writes the event in the server-sent events format, using the action
+// as the event name
+func writeFunctionEvent(w http.ResponseWriter, event FunctionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Action, data)
+	return err
+}
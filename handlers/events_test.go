@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakeEventsClient struct {
+	// notFunction makes ServiceInspectWithRaw return a service without the function label
+	notFunction bool
+
+	serviceMessages   chan events.Message
+	containerMessages chan events.Message
+	containerFilters  []string
+}
+
+func (f *fakeEventsClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	if f.notFunction {
+		return swarm.Service{ID: "svc-" + serviceID}, []byte{}, nil
+	}
+
+	return labelledFunction(serviceID, nil), []byte{}, nil
+}
+
+func (f *fakeEventsClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	if options.Filters.ExactMatch("type", events.ServiceEventType) {
+		return f.serviceMessages, make(chan error)
+	}
+
+	f.containerFilters = options.Filters.Get("label")
+	return f.containerMessages, make(chan error)
+}
+
+func Test_EventsHandler_StreamsEventsUntilDisconnect(t *testing.T) {
+	c := &fakeEventsClient{
+		serviceMessages:   make(chan events.Message),
+		containerMessages: make(chan events.Message),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/events", nil).WithContext(ctx)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		MakeEventsHandler(c, DefaultFunctionLabel)(rr, req)
+		close(done)
+	}()
+
+	c.serviceMessages <- events.Message{
+		Type:   events.ServiceEventType,
+		Action: "update",
+		Actor:  events.Actor{ID: "svc-figlet"},
+	}
+	c.containerMessages <- events.Message{
+		Type:   events.ContainerEventType,
+		Action: "die",
+		Actor: events.Actor{
+			ID: "container-1",
+			Attributes: map[string]string{
+				"com.docker.swarm.task.id": "task-1",
+				"exitCode":                 "137",
+			},
+		},
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("want: handler to return after client disconnect")
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("want: content type '%s' got: '%s'", "text/event-stream", contentType)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{"event: update\n", "event: die\n", `"taskId":"task-1"`, `"exitCode":"137"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: body to contain '%s' got: %s", want, body)
+		}
+	}
+
+	if len(c.containerFilters) != 1 || c.containerFilters[0] != "com.docker.swarm.service.id=svc-figlet" {
+		t.Errorf("want: container events filtered by service ID got: %v", c.containerFilters)
+	}
+}
+
+func Test_EventsHandler_NotAFunction(t *testing.T) {
+	c := &fakeEventsClient{notFunction: true}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/nginx/events", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "nginx"})
+	rr := httptest.NewRecorder()
+
+	MakeEventsHandler(c, DefaultFunctionLabel)(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// ServiceInspector is the subset of Docker Client methods required to look up a single service
+type ServiceInspector interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+}
+
+// MakeFunctionExistsHandler answers HEAD requests for a function with 200 when it exists
+// and 404 when it does not, without a body. Services without functionLabel are not functions.
+func MakeFunctionExistsHandler(c ServiceInspector, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			log.Printf("FunctionExistsHandler: error inspecting service %s: %s\n", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// services which were not deployed as functions are not reported
+		if !isFunctionService(service, functionLabel) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakeNotFoundError struct {
+	name string
+}
+
+func (e fakeNotFoundError) Error() string {
+	return fmt.Sprintf("service %s not found", e.name)
+}
+
+func (e fakeNotFoundError) NotFound() bool {
+	return true
+}
+
+type fakeServiceInspector struct {
+	services map[string]swarm.Service
+}
+
+func (f fakeServiceInspector) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	service, exists := f.services[serviceID]
+	if !exists {
+		return swarm.Service{}, nil, fakeNotFoundError{name: serviceID}
+	}
+
+	return service, []byte{}, nil
+}
+
+func Test_FunctionExistsHandler(t *testing.T) {
+	c := fakeServiceInspector{
+		services: map[string]swarm.Service{
+			"figlet":   labelledFunction("figlet", nil),
+			"registry": {Spec: swarm.ServiceSpec{TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{}}}},
+		},
+	}
+
+	scenarios := []struct {
+		name string
+		want int
+	}{
+		{name: "figlet", want: http.StatusOK},
+		{name: "missing", want: http.StatusNotFound},
+		{name: "registry", want: http.StatusNotFound},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodHead, "/system/function/"+s.name, nil)
+			req = mux.SetURLVars(req, map[string]string{"name": s.name})
+			rr := httptest.NewRecorder()
+
+			MakeFunctionExistsHandler(c, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+			if rr.Code != s.want {
+				t.Errorf("want: %d got: %d", s.want, rr.Code)
+			}
+			if rr.Body.Len() != 0 {
+				t.Errorf("want: no body got: %q", rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// exportContentType is the content type of an exported stack snippet
+const exportContentType = "application/x-yaml"
+
+// MakeExportHandler returns a function as a stack file snippet in the functions: format of
+// faas-cli, rebuilt from the live service so it can be redeployed. Secrets are referenced by
+// the name they are mounted as, their values are never exported. When redactEnvVars is set
+// the values of environment variables are hidden as in ReplicaReader.
+func MakeExportHandler(c ServiceInspector, redactEnvVars bool, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+				return
+			}
+
+			log.Printf("ExportHandler: error inspecting service %s: %s\n", functionName, err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !isFunctionService(service, functionLabel) {
+			writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+			return
+		}
+
+		w.Header().Set("Content-Type", exportContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(exportStack(service, redactEnvVars, functionLabel))
+	}
+}
+
+// exportStack writes the stack snippet of a function service. The labels set by faas-swarm
+// itself are left out as they are added again on deploy.
+func exportStack(service swarm.Service, redactEnvVars bool, functionLabel string) []byte {
+	containerSpec := service.Spec.TaskTemplate.ContainerSpec
+
+	labels, annotations := buildLabelsAndAnnotations(service.Spec.Labels)
+	for _, key := range []string{functionLabelKey(functionLabel), legacyFunctionLabel, NamespaceLabel, stickyNodeLabel} {
+		delete(labels, key)
+	}
+	for k, v := range containerSpec.Labels {
+		if _, exists := service.Spec.Labels[k]; !exists {
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[TaskLabelPrefix+k] = v
+		}
+	}
+
+	envProcess, envVars := parseEnv(containerSpec.Env)
+	if redactEnvVars {
+		for k := range envVars {
+			envVars[k] = redactedValue
+		}
+	}
+
+	var secrets []string
+	for _, secret := range containerSpec.Secrets {
+		if secret.File != nil {
+			secrets = append(secrets, path.Base(secret.File.Name))
+		}
+	}
+	sort.Strings(secrets)
+
+	var constraints []string
+	if placement := service.Spec.TaskTemplate.Placement; placement != nil {
+		for _, constraint := range placement.Constraints {
+			if !isLinuxOnlyConstraint(constraint) {
+				constraints = append(constraints, constraint)
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("functions:\n")
+	fmt.Fprintf(buf, "  %s:\n", yamlString(service.Spec.Name))
+	writeYAMLValue(buf, "image", containerSpec.Image)
+	writeYAMLValue(buf, "namespace", service.Spec.Labels[NamespaceLabel])
+	writeYAMLValue(buf, "fprocess", envProcess)
+	writeYAMLMap(buf, "environment", envVars)
+	writeYAMLMap(buf, "labels", labels)
+	writeYAMLMap(buf, "annotations", annotations)
+	writeYAMLList(buf, "secrets", secrets)
+	writeYAMLList(buf, "constraints", constraints)
+
+	if resources := service.Spec.TaskTemplate.Resources; resources != nil {
+		writeYAMLMap(buf, "limits", exportResources(resources.Limits))
+		writeYAMLMap(buf, "requests", exportResources(resources.Reservations))
+	}
+
+	if containerSpec.ReadOnly {
+		buf.WriteString("    readonly_root_filesystem: true\n")
+	}
+
+	return buf.Bytes()
+}
+
+// isLinuxOnlyConstraint returns true for the constraint added to every function by faas-swarm
+func isLinuxOnlyConstraint(constraint string) bool {
+	for _, linuxOnly := range linuxOnlyConstraints {
+		if constraint == linuxOnly {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportResources formats resources in the units accepted on deploy, memory in the largest
+// unit it is a whole number of and CPU in millicores, or nano CPUs when not a whole number
+func exportResources(resources *swarm.Resources) map[string]string {
+	if resources == nil {
+		return nil
+	}
+
+	values := map[string]string{}
+
+	if memory := resources.MemoryBytes; memory > 0 {
+		values["memory"] = strconv.FormatInt(memory, 10)
+		for _, unit := range []struct {
+			suffix string
+			bytes  int64
+		}{{"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}} {
+			if memory%unit.bytes == 0 {
+				values["memory"] = strconv.FormatInt(memory/unit.bytes, 10) + unit.suffix
+				break
+			}
+		}
+	}
+
+	if cpu := resources.NanoCPUs; cpu > 0 {
+		values["cpu"] = strconv.FormatInt(cpu, 10)
+		if cpu%1e6 == 0 {
+			values["cpu"] = strconv.FormatInt(cpu/1e6, 10) + "m"
+		}
+	}
+
+	return values
+}
+
+// writeYAMLValue writes a field of the function, nothing is written for an empty value
+func writeYAMLValue(buf *bytes.Buffer, key string, value string) {
+	if len(value) == 0 {
+		return
+	}
+
+	fmt.Fprintf(buf, "    %s: %s\n", key, yamlString(value))
+}
+
+// writeYAMLMap writes a field of the function with the entries sorted by key
+func writeYAMLMap(buf *bytes.Buffer, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(buf, "    %s:\n", key)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "      %s: %s\n", yamlString(k), yamlString(values[k]))
+	}
+}
+
+// writeYAMLList writes a field of the function as a block sequence
+func writeYAMLList(buf *bytes.Buffer, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	fmt.Fprintf(buf, "    %s:\n", key)
+	for _, value := range values {
+		fmt.Fprintf(buf, "      - %s\n", yamlString(value))
+	}
+}
+
+// yamlString quotes a scalar, the escapes of a Go quoted string are all valid in a YAML
+// double-quoted scalar so the value is never read as a number, boolean or null
+func yamlString(value string) string {
+	return strconv.Quote(value)
+}
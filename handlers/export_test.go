@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+func Test_ExportHandler(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service:                "figlet",
+		Namespace:              "staging",
+		Image:                  "functions/figlet:0.13.0",
+		EnvProcess:             "figlet",
+		EnvVars:                map[string]string{"write_debug": "true"},
+		Labels:                 &map[string]string{MinScaleLabel: "2", TaskLabelPrefix + "team": "tools"},
+		Annotations:            &map[string]string{"topic": "cron"},
+		Constraints:            []string{"node.role == worker"},
+		Limits:                 &typesv1.FunctionResources{Memory: "128m", CPU: "500m"},
+		Requests:               &typesv1.FunctionResources{Memory: "1g"},
+		ReadOnlyRootFilesystem: true,
+	}
+	secrets := []*swarm.SecretReference{
+		{SecretName: "api-key", File: &swarm.SecretReferenceFileTarget{Name: "/var/openfaas/secrets/api-key"}},
+	}
+
+	spec, err := makeSpec(request, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, secrets)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	c := fakeServiceInspector{
+		services: map[string]swarm.Service{"figlet": {ID: "svc-figlet", Spec: spec}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/export", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+	MakeExportHandler(c, false, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	want := `functions:
+  "figlet":
+    image: "functions/figlet:0.13.0"
+    namespace: "staging"
+    fprocess: "figlet"
+    environment:
+      "write_debug": "true"
+    labels:
+      "com.openfaas.scale.min": "2"
+      "com.openfaas.task_label.team": "tools"
+    annotations:
+      "topic": "cron"
+    secrets:
+      - "api-key"
+    constraints:
+      - "node.role == worker"
+    limits:
+      "cpu": "500m"
+      "memory": "128m"
+    requests:
+      "memory": "1g"
+    readonly_root_filesystem: true
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_ExportHandler_NotFound(t *testing.T) {
+	c := fakeServiceInspector{services: map[string]swarm.Service{}}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/export", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+	MakeExportHandler(c, false, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
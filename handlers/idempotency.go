@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header used to make deploy retries safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyCache remembers the idempotency keys of successful deployments for a
+// limited time, so that a retried request can be answered without creating the
+// service again. A key is reserved while its deployment is in progress so that
+// concurrent retries can not create the service twice.
+type idempotencyCache struct {
+	ttl      time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	entries  map[string]time.Time
+	reserved map[string]bool
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]time.Time),
+		reserved: make(map[string]bool),
+	}
+}
+
+// idempotencyKey scopes a client supplied key to the function it was sent for
+func idempotencyKey(key string, service string) string {
+	return service + "/" + key
+}
+
+// Seen returns true if key was added within the TTL
+func (c *idempotencyCache) Seen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+
+	if c.now().After(expires) {
+		delete(c.entries, key)
+		return false
+	}
+
+	return true
+}
+
+// Reserve claims key for a deployment which is about to start, it returns false when key
+// was already added within the TTL or is reserved by a deployment still in progress
+func (c *idempotencyCache) Reserve(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reserved[key] {
+		return false
+	}
+
+	if expires, exists := c.entries[key]; exists && !c.now().After(expires) {
+		return false
+	}
+
+	c.reserved[key] = true
+	return true
+}
+
+// Release drops the reservation of key after its deployment failed, so it can be retried
+func (c *idempotencyCache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.reserved, key)
+}
+
+// Add records key, releasing its reservation, and evicts any expired entries
+func (c *idempotencyCache) Add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.reserved, key)
+
+	now := c.now()
+	for k, expires := range c.entries {
+		if now.After(expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = now.Add(c.ttl)
+}
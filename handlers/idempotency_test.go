@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_IdempotencyCache_RepeatedKey(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute)
+	key := idempotencyKey("a1b2", "figlet")
+
+	if cache.Seen(key) {
+		t.Fatalf("want: key '%s' not seen before it is added", key)
+	}
+
+	cache.Add(key)
+
+	if !cache.Seen(key) {
+		t.Errorf("want: key '%s' seen after it is added", key)
+	}
+}
+
+func Test_IdempotencyCache_DistinctKeys(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute)
+	cache.Add(idempotencyKey("a1b2", "figlet"))
+
+	if cache.Seen(idempotencyKey("c3d4", "figlet")) {
+		t.Errorf("want: a distinct key not to be seen")
+	}
+
+	if cache.Seen(idempotencyKey("a1b2", "echo")) {
+		t.Errorf("want: the same key for a different function not to be seen")
+	}
+}
+
+func Test_IdempotencyCache_Expires(t *testing.T) {
+	now := time.Now()
+	cache := newIdempotencyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	key := idempotencyKey("a1b2", "figlet")
+	cache.Add(key)
+
+	now = now.Add(time.Minute * 2)
+
+	if cache.Seen(key) {
+		t.Errorf("want: key '%s' not seen after the TTL", key)
+	}
+}
+
+func Test_IdempotencyCache_Reserve(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute)
+	key := idempotencyKey("a1b2", "figlet")
+
+	if !cache.Reserve(key) {
+		t.Fatalf("want: key '%s' reserved", key)
+	}
+
+	if cache.Reserve(key) {
+		t.Errorf("want: key '%s' not reserved twice", key)
+	}
+
+	cache.Release(key)
+
+	if !cache.Reserve(key) {
+		t.Fatalf("want: key '%s' reserved again after it is released", key)
+	}
+
+	cache.Add(key)
+
+	if cache.Reserve(key) {
+		t.Errorf("want: key '%s' not reserved after it is added", key)
+	}
+}
+
+// waitForCreate blocks until a ServiceCreate of c is in progress
+func waitForCreate(c *gatedDeployClient) {
+	for {
+		c.mu.Lock()
+		inFlight := c.inFlight
+		c.mu.Unlock()
+
+		if inFlight > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func doIdempotentDeploy(handler http.Handler, name string, key string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(batchRequest(name))
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_DeployHandler_ConcurrentIdempotencyKey(t *testing.T) {
+	c := &gatedDeployClient{
+		fakeDeployClient: newFakeDeployClient(),
+		release:          make(chan struct{}),
+	}
+
+	handler := DeployHandler(c, DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		IdempotencyTTL:      time.Minute,
+	})
+
+	first := make(chan int)
+	go func() {
+		first <- doIdempotentDeploy(handler, "figlet", "a1b2").Code
+	}()
+
+	waitForCreate(c)
+
+	retry := doIdempotentDeploy(handler, "figlet", "a1b2")
+	if retry.Code != http.StatusConflict {
+		t.Errorf("want: %d for a retry while the deployment is in progress got: %d", http.StatusConflict, retry.Code)
+	}
+
+	envelope := DeployError{}
+	if err := json.Unmarshal(retry.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("want: JSON body got: %s", retry.Body.String())
+	}
+	if envelope.Code != ErrCodeDeployInProgress {
+		t.Errorf("want: code %s got: %s", ErrCodeDeployInProgress, envelope.Code)
+	}
+
+	close(c.release)
+
+	if code := <-first; code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d", http.StatusAccepted, code)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := doIdempotentDeploy(handler, "figlet", "a1b2"); rr.Code != http.StatusAccepted {
+				t.Errorf("want: %d for a retry after the deployment got: %d", http.StatusAccepted, rr.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(c.created) != 1 {
+		t.Errorf("want: 1 service created got: %v", c.created)
+	}
+}
+
+func Test_DeployHandler_IdempotencyKeyReleasedOnFailure(t *testing.T) {
+	c := newFakeDeployClient("figlet")
+	handler := DeployHandler(c, DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		IdempotencyTTL:      time.Minute,
+	})
+
+	if rr := doIdempotentDeploy(handler, "figlet", "a1b2"); rr.Code == http.StatusAccepted {
+		t.Fatalf("want: the rejected deployment to fail got: %d", rr.Code)
+	}
+
+	delete(c.rejected, "figlet")
+
+	if rr := doIdempotentDeploy(handler, "figlet", "a1b2"); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d for a retry after the failure got: %d", http.StatusAccepted, rr.Code)
+	}
+
+	if len(c.created) != 1 {
+		t.Errorf("want: the retry to create the service got: %v", c.created)
+	}
+}
@@ -36,8 +36,6 @@ func MakeInfoHandler(version, sha string) http.HandlerFunc {
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(jsonOut)
+		writeJSON(w, http.StatusOK, jsonOut)
 	}
 }
@@ -4,14 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	dockerlogs "github.com/docker/cli/service/logs"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
 
 	"github.com/openfaas/faas-provider/logs"
 )
@@ -19,8 +28,19 @@ import (
 const (
 	// log line prefix length frm github.com/moby/moby/pkg/stdcopy/stdcopy.go
 	stdWriterPrefixLen = 8
+
+	// DefaultLogBufferLines is how many log messages are held for a client which is slower
+	// than the function writing them
+	DefaultLogBufferLines = 256
+
+	// logSlowClientTimeout is how long a client can leave the log buffer full before its
+	// connection is dropped
+	logSlowClientTimeout = time.Second * 10
 )
 
+// errSlowLogClient is returned when a client does not keep up with the function logs
+var errSlowLogClient = errors.New("client is not keeping up with the log stream")
+
 // LogRequester implements the Requester interface for Swarm
 type LogRequester struct {
 	client ServiceLogger
@@ -28,7 +48,20 @@ type LogRequester struct {
 
 // ServiceLogger is the subset of Docker Client methods required for querying function logs
 type ServiceLogger interface {
+	ServiceInspector
 	ServiceLogs(ctx context.Context, serviceID string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	TaskInspectWithRaw(ctx context.Context, taskID string) (swarm.Task, []byte, error)
+	TaskLogs(ctx context.Context, taskID string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+}
+
+// taskNotFoundError is returned when the task of a log request is not a task of the function
+type taskNotFoundError struct {
+	task     string
+	function string
+}
+
+func (e taskNotFoundError) Error() string {
+	return fmt.Sprintf("task %s is not a task of %s", e.task, e.function)
 }
 
 // NewLogRequester returns a Requestor instance that can be used in the function logs endpoint
@@ -55,7 +88,13 @@ func (l LogRequester) Query(ctx context.Context, r logs.Request) (<-chan logs.Me
 		options.Tail = strconv.Itoa(r.Tail)
 	}
 
-	logStream, err := l.client.ServiceLogs(ctx, r.Name, options)
+	var logStream io.ReadCloser
+	var err error
+	if len(r.Instance) > 0 {
+		logStream, err = l.queryTask(ctx, r.Name, r.Instance, options)
+	} else {
+		logStream, err = l.client.ServiceLogs(ctx, r.Name, options)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +106,378 @@ func (l LogRequester) Query(ctx context.Context, r logs.Request) (<-chan logs.Me
 	return msgStream, nil
 }
 
+// queryTask returns the logs of one task, which must be a task of the function's service
+func (l LogRequester) queryTask(ctx context.Context, function string, taskID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	task, _, err := l.client.TaskInspectWithRaw(ctx, taskID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, taskNotFoundError{task: taskID, function: function}
+		}
+		return nil, err
+	}
+
+	service, _, err := l.client.ServiceInspectWithRaw(ctx, function, types.ServiceInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if task.ServiceID != service.ID {
+		return nil, taskNotFoundError{task: taskID, function: function}
+	}
+
+	return l.client.TaskLogs(ctx, task.ID, options)
+}
+
+// MakeLogHandler returns the function logs handler. Log lines can be filtered with a regular
+// expression in the "filter" query parameter, only the lines which match are returned. The
+// "task" query parameter selects the logs of one task of the function, rather than all of them.
+//
+// At most bufferLines messages are held for a client, when a client does not read the next
+// line within logSlowClientTimeout of the buffer filling up its connection is dropped. A
+// client which stops reading altogether is dropped once a write to it does not complete
+// within logSlowClientTimeout.
+//
+// While following logs an empty line is written after each heartbeat interval without a
+// message, so proxies do not close an idle stream. Zero disables the heartbeat.
+func MakeLogHandler(requester logs.Requester, timeout time.Duration, bufferLines int, heartbeat time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Println("LogHandler: response is not a Flusher, required for streaming response")
+			http.NotFound(w, r)
+			return
+		}
+
+		logRequest, err := parseLogRequest(r)
+		if err != nil {
+			log.Printf("LogHandler: could not parse request %s\n", err)
+			writeText(w, http.StatusUnprocessableEntity, "could not parse the log request")
+			return
+		}
+
+		if filter := r.URL.Query().Get("filter"); len(filter) > 0 {
+			expression, err := regexp.Compile(filter)
+			if err != nil {
+				writeText(w, http.StatusBadRequest, fmt.Sprintf("Invalid log filter: %s", err))
+				return
+			}
+
+			requester = filteredLogRequester{requester: requester, filter: expression}
+		}
+
+		ctx, cancelQuery := context.WithTimeout(r.Context(), timeout)
+		defer cancelQuery()
+
+		messages, err := requester.Query(ctx, logRequest)
+		if _, ok := err.(taskNotFoundError); ok {
+			writeText(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("LogHandler: function log request failed: %s\n", err)
+			writeText(w, http.StatusInternalServerError, "function log request failed")
+			return
+		}
+
+		// a client which stops reading blocks a write to its connection, a hijacked connection
+		// is given a deadline for each write so that the client is dropped instead
+		var stream io.Writer = w
+		hijacked, err := hijackLogStream(w, logSlowClientTimeout)
+		if err == nil {
+			defer hijacked.Close()
+			stream, flusher = hijacked, hijacked
+		} else {
+			w.Header().Set("Connection", "Keep-Alive")
+			w.Header().Set("Transfer-Encoding", "chunked")
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+		}
+
+		if !logRequest.Follow {
+			heartbeat = 0
+		}
+
+		if err := streamLogs(ctx, stream, flusher, messages, bufferLines, logSlowClientTimeout, heartbeat); err != nil {
+			log.Printf("LogHandler: dropping connection for %s logs: %s\n", logRequest.Name, err)
+			cancelQuery()
+			if hijacked != nil {
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// streamLogs writes each message as a line of JSON and flushes it to the client. Messages
+// are buffered up to bufferLines, errSlowLogClient is returned when the buffer stays full
+// for longer than slowClientTimeout. An empty line is written for each heartbeat interval
+// in which no message was written, when heartbeat is not zero.
+func streamLogs(ctx context.Context, w io.Writer, flusher http.Flusher, messages <-chan logs.Message, bufferLines int, slowClientTimeout time.Duration, heartbeat time.Duration) error {
+	if bufferLines <= 0 {
+		bufferLines = DefaultLogBufferLines
+	}
+
+	buffered := make(chan logs.Message, bufferLines)
+	slow := make(chan struct{})
+
+	go func() {
+		defer close(buffered)
+
+		for msg := range messages {
+			select {
+			case buffered <- msg:
+				continue
+			default:
+			}
+
+			timer := time.NewTimer(slowClientTimeout)
+			select {
+			case buffered <- msg:
+				timer.Stop()
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+				close(slow)
+
+				// drain the requester so that it can stop once the query is cancelled
+				for range messages {
+				}
+				return
+			}
+		}
+	}()
+
+	var heartbeats <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+
+	encoder := json.NewEncoder(w)
+	written := false
+
+stream:
+	for {
+		select {
+		case msg, ok := <-buffered:
+			if !ok {
+				break stream
+			}
+
+			// the client may have fallen behind while the last message was written
+			select {
+			case <-slow:
+				return errSlowLogClient
+			default:
+			}
+
+			if err := encoder.Encode(msg); err != nil {
+				return err
+			}
+			flusher.Flush()
+			written = true
+
+		case <-heartbeats:
+			if written {
+				written = false
+				continue
+			}
+
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+
+	select {
+	case <-slow:
+		return errSlowLogClient
+	default:
+		return nil
+	}
+}
+
+// deadlineLogWriter writes the chunked log stream to the hijacked connection of a client,
+// each write must complete within timeout so a client which stops reading can not block
+// the stream
+type deadlineLogWriter struct {
+	conn    net.Conn
+	buf     *bufio.Writer
+	chunked io.WriteCloser
+	timeout time.Duration
+	err     error
+}
+
+// hijackLogStream takes over the connection of w and writes the headers of the log stream,
+// http.ErrNotSupported is returned when the connection can not be hijacked, such as for
+// HTTP/2
+func hijackLogStream(w http.ResponseWriter, timeout time.Duration) (*deadlineLogWriter, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	header := w.Header()
+	header.Set("Connection", "close")
+	header.Set("Transfer-Encoding", "chunked")
+	header.Set("Content-Type", "application/x-ndjson")
+
+	rw.Writer.WriteString("HTTP/1.1 200 OK\r\n")
+	header.Write(rw.Writer)
+	rw.Writer.WriteString("\r\n")
+
+	d := newDeadlineLogWriter(conn, rw.Writer, timeout)
+	d.Flush()
+
+	return d, d.err
+}
+
+// newDeadlineLogWriter returns a deadlineLogWriter writing the chunks of the stream to buf,
+// which is flushed to conn
+func newDeadlineLogWriter(conn net.Conn, buf *bufio.Writer, timeout time.Duration) *deadlineLogWriter {
+	return &deadlineLogWriter{
+		conn:    conn,
+		buf:     buf,
+		chunked: httputil.NewChunkedWriter(buf),
+		timeout: timeout,
+	}
+}
+
+// Write sends p to the client as a chunk of the response, errSlowLogClient is returned when
+// the client has not read enough of the stream to take p within the timeout. Each write is
+// flushed, so a heartbeat to a stalled client fails straight away rather than on the next
+// write.
+func (d *deadlineLogWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+
+	n, err := d.chunked.Write(p)
+	d.fail(err)
+	d.Flush()
+
+	return n, d.err
+}
+
+// Flush sends the buffered chunks to the client
+func (d *deadlineLogWriter) Flush() {
+	if d.err != nil {
+		return
+	}
+
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	d.fail(d.buf.Flush())
+}
+
+// Close ends the response when every write succeeded and closes the connection, so that a
+// stream which failed is seen as incomplete by the client
+func (d *deadlineLogWriter) Close() error {
+	if d.err == nil {
+		d.fail(d.chunked.Close())
+		if d.err == nil {
+			d.buf.WriteString("\r\n")
+			d.Flush()
+		}
+	}
+
+	return d.conn.Close()
+}
+
+// fail records the first error writing to the client
+func (d *deadlineLogWriter) fail(err error) {
+	if err == nil || d.err != nil {
+		return
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		err = errSlowLogClient
+	}
+	d.err = err
+}
+
+// parseLogRequest reads the log request from the query string in the same way as the
+// faas-provider log handler
+func parseLogRequest(r *http.Request) (logs.Request, error) {
+	query := r.URL.Query()
+	logRequest := logs.Request{
+		Name:     query.Get("name"),
+		Instance: query.Get("instance"),
+	}
+
+	if task := query.Get("task"); len(task) > 0 {
+		logRequest.Instance = task
+	}
+
+	if tail := query.Get("tail"); len(tail) > 0 {
+		value, err := strconv.Atoi(tail)
+		if err != nil {
+			return logRequest, err
+		}
+		logRequest.Tail = value
+	}
+
+	// an invalid value is treated as false
+	logRequest.Follow, _ = strconv.ParseBool(query.Get("follow"))
+
+	if since := query.Get("since"); len(since) > 0 {
+		value, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return logRequest, err
+		}
+		logRequest.Since = &value
+	}
+
+	return logRequest, nil
+}
+
+// filteredLogRequester drops the log messages of a Requester which do not match filter
+type filteredLogRequester struct {
+	requester logs.Requester
+	filter    *regexp.Regexp
+}
+
+// Query implements the Requester interface
+func (f filteredLogRequester) Query(ctx context.Context, r logs.Request) (<-chan logs.Message, error) {
+	messages, err := f.requester.Query(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(chan logs.Message)
+
+	go func() {
+		defer close(filtered)
+
+		for msg := range messages {
+			if !f.filter.MatchString(msg.Text) {
+				continue
+			}
+
+			select {
+			case filtered <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return filtered, nil
+}
+
 // parseLogStream reads log lines from the logStream, parses them into Message objects, and sends
 // them on the msgStream channel.  Raw log lines look like 'timestamp serviceDetails rawMessage`, e.g.
 // 2019-02-09T02:34:38.914788800Z com.docker.swarm.node.id=lfplf8vfa6j2fp4xkygcze8i4,com.docker.swarm.service.id=wy8sr6u3lqx11a34t96qlbyff,com.docker.swarm.task.id=zzvbv53tdyebuhh9rquadwuud 2019/02/09 02:34:38 Error reading stdout: EOF
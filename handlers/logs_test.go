@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/openfaas/faas-provider/logs"
+)
+
+type fakeLogRequester struct {
+	lines []string
+}
+
+func (f fakeLogRequester) Query(ctx context.Context, r logs.Request) (<-chan logs.Message, error) {
+	messages := make(chan logs.Message, len(f.lines))
+	for _, line := range f.lines {
+		messages <- logs.Message{Name: r.Name, Text: line}
+	}
+	close(messages)
+
+	return messages, nil
+}
+
+func readLogLines(t *testing.T, filter string) (int, []string) {
+	requester := fakeLogRequester{
+		lines: []string{
+			"Forking fprocess.",
+			"error: unable to reach database",
+			"Wrote 42 Bytes - Duration: 0.01s",
+			"error: timed out",
+		},
+	}
+
+	server := httptest.NewServer(MakeLogHandler(requester, time.Second, DefaultLogBufferLines, 0))
+	defer server.Close()
+
+	query := url.Values{}
+	query.Set("name", "figlet")
+	if len(filter) > 0 {
+		query.Set("filter", filter)
+	}
+
+	res, err := http.Get(server.URL + "/system/logs?" + query.Encode())
+	if err != nil {
+		t.Fatalf("unexpected error requesting logs: %s", err)
+	}
+	defer res.Body.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		msg := logs.Message{}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		lines = append(lines, msg.Text)
+	}
+
+	return res.StatusCode, lines
+}
+
+func Test_LogHandler_Filter(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{
+			name:   "no filter",
+			filter: "",
+			want: []string{
+				"Forking fprocess.",
+				"error: unable to reach database",
+				"Wrote 42 Bytes - Duration: 0.01s",
+				"error: timed out",
+			},
+		},
+		{
+			name:   "matching lines",
+			filter: "^error:",
+			want:   []string{"error: unable to reach database", "error: timed out"},
+		},
+		{
+			name:   "no matching lines",
+			filter: "panic",
+			want:   []string{},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			status, lines := readLogLines(t, s.filter)
+
+			if status != http.StatusOK {
+				t.Fatalf("want: %d got: %d", http.StatusOK, status)
+			}
+			if !reflect.DeepEqual(lines, s.want) {
+				t.Errorf("want: %v got: %v", s.want, lines)
+			}
+		})
+	}
+}
+
+func Test_LogHandler_InvalidFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/system/logs?name=figlet&filter=%28unclosed", nil)
+	rr := httptest.NewRecorder()
+
+	MakeLogHandler(fakeLogRequester{}, time.Second, DefaultLogBufferLines, 0).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// slowResponseWriter takes delay to write each line after the first
+type slowResponseWriter struct {
+	httptest.ResponseRecorder
+
+	delay time.Duration
+	lines int
+}
+
+func (s *slowResponseWriter) Write(b []byte) (int, error) {
+	if s.lines > 0 {
+		time.Sleep(s.delay)
+	}
+	s.lines++
+	return len(b), nil
+}
+
+func Test_StreamLogs_SlowClient(t *testing.T) {
+	messages := make(chan logs.Message)
+	go func() {
+		defer close(messages)
+		for i := 0; i < 100; i++ {
+			messages <- logs.Message{Name: "figlet", Text: fmt.Sprintf("line %d", i)}
+		}
+	}()
+
+	w := &slowResponseWriter{delay: time.Millisecond * 100}
+
+	err := streamLogs(context.Background(), w, w, messages, 10, time.Millisecond*20, 0)
+	if err != errSlowLogClient {
+		t.Errorf("want: %v got: %v", errSlowLogClient, err)
+	}
+
+	if w.lines >= 100 {
+		t.Errorf("want: slow client dropped before all lines were written got: %d lines", w.lines)
+	}
+}
+
+func Test_StreamLogs_ClientKeepsUp(t *testing.T) {
+	messages := make(chan logs.Message)
+	go func() {
+		defer close(messages)
+		for i := 0; i < 100; i++ {
+			messages <- logs.Message{Name: "figlet", Text: fmt.Sprintf("line %d", i)}
+		}
+	}()
+
+	w := &slowResponseWriter{}
+
+	if err := streamLogs(context.Background(), w, w, messages, 10, time.Second, 0); err != nil {
+		t.Errorf("want: no error got: %v", err)
+	}
+
+	if w.lines != 100 {
+		t.Errorf("want: %d lines got: %d", 100, w.lines)
+	}
+}
+
+func Test_StreamLogs_HeartbeatWhileIdle(t *testing.T) {
+	messages := make(chan logs.Message)
+	go func() {
+		defer close(messages)
+		messages <- logs.Message{Name: "figlet", Text: "Forking fprocess."}
+		time.Sleep(time.Millisecond * 100)
+		messages <- logs.Message{Name: "figlet", Text: "Wrote 42 Bytes"}
+	}()
+
+	w := httptest.NewRecorder()
+	if err := streamLogs(context.Background(), w, w, messages, 10, time.Second, time.Millisecond*10); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("want: both log lines got: %q", w.Body.String())
+	}
+
+	heartbeats := 0
+	for _, line := range lines[1 : len(lines)-1] {
+		if len(line) > 0 {
+			t.Errorf("want: only empty lines between the messages got: %q", line)
+		}
+		heartbeats++
+	}
+
+	if heartbeats < 3 {
+		t.Errorf("want: at least %d heartbeats while idle got: %d", 3, heartbeats)
+	}
+	if !strings.Contains(lines[len(lines)-1], "Wrote 42 Bytes") {
+		t.Errorf("want: last line to be the second message got: %q", lines[len(lines)-1])
+	}
+}
+
+// fakeServiceLogger returns one log line naming the task for each task of the service from
+// ServiceLogs, and for the one task from TaskLogs
+type fakeServiceLogger struct {
+	fakeServiceInspector
+
+	tasks map[string]swarm.Task
+
+	queried string
+}
+
+func (f *fakeServiceLogger) logStream(taskIDs ...string) io.ReadCloser {
+	lines := ""
+	for _, id := range taskIDs {
+		lines += fmt.Sprintf("\x01\x00\x00\x00\x00\x00\x00\x00%s com.docker.swarm.task.id=%s hello from %s\n", "2019-02-09T02:34:38.914788800Z", id, id)
+	}
+
+	return ioutil.NopCloser(strings.NewReader(lines))
+}
+
+func (f *fakeServiceLogger) ServiceLogs(ctx context.Context, serviceID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.queried = "service " + serviceID
+
+	taskIDs := []string{}
+	for id, task := range f.tasks {
+		if task.ServiceID == f.services[serviceID].ID {
+			taskIDs = append(taskIDs, id)
+		}
+	}
+
+	return f.logStream(taskIDs...), nil
+}
+
+func (f *fakeServiceLogger) TaskInspectWithRaw(ctx context.Context, taskID string) (swarm.Task, []byte, error) {
+	task, exists := f.tasks[taskID]
+	if !exists {
+		return swarm.Task{}, nil, fakeNotFoundError{name: taskID}
+	}
+
+	return task, nil, nil
+}
+
+func (f *fakeServiceLogger) TaskLogs(ctx context.Context, taskID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.queried = "task " + taskID
+
+	return f.logStream(taskID), nil
+}
+
+func Test_LogRequester_Task(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		task        string
+		wantQueried string
+		wantErr     bool
+		wantLines   []string
+	}{
+		{name: "every task", wantQueried: "service figlet", wantLines: []string{"hello from task1", "hello from task2"}},
+		{name: "one task", task: "task2", wantQueried: "task task2", wantLines: []string{"hello from task2"}},
+		{name: "task of another function", task: "task3", wantErr: true},
+		{name: "missing task", task: "task4", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := &fakeServiceLogger{
+				fakeServiceInspector: fakeServiceInspector{services: map[string]swarm.Service{
+					"figlet": {ID: "svc-figlet"},
+				}},
+				tasks: map[string]swarm.Task{
+					"task1": {ID: "task1", ServiceID: "svc-figlet"},
+					"task2": {ID: "task2", ServiceID: "svc-figlet"},
+					"task3": {ID: "task3", ServiceID: "svc-nodeinfo"},
+				},
+			}
+
+			messages, err := NewLogRequester(c).Query(context.Background(), logs.Request{Name: "figlet", Instance: s.task})
+			if s.wantErr {
+				if _, ok := err.(taskNotFoundError); !ok {
+					t.Fatalf("want: taskNotFoundError got: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			lines := []string{}
+			for msg := range messages {
+				lines = append(lines, msg.Text)
+			}
+			sort.Strings(lines)
+
+			if c.queried != s.wantQueried {
+				t.Errorf("want: logs of %s got: %s", s.wantQueried, c.queried)
+			}
+
+			if !reflect.DeepEqual(lines, s.wantLines) {
+				t.Errorf("want: lines %v got: %v", s.wantLines, lines)
+			}
+		})
+	}
+}
+
+func Test_ParseLogRequest_Task(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/system/logs?name=figlet&task=task2", nil)
+
+	logRequest, err := parseLogRequest(req)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if logRequest.Instance != "task2" {
+		t.Errorf("want: instance %s got: %s", "task2", logRequest.Instance)
+	}
+}
+
+func Test_HijackLogStream_StalledClient(t *testing.T) {
+	streamed := make(chan error, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := hijackLogStream(w, time.Millisecond*50)
+		if err != nil {
+			streamed <- err
+			return
+		}
+		defer stream.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// the function writes far more than the socket buffers can hold
+		messages := make(chan logs.Message)
+		go func() {
+			defer close(messages)
+			text := strings.Repeat("x", 64*1024)
+			for {
+				select {
+				case messages <- logs.Message{Name: "figlet", Text: text}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		streamed <- streamLogs(ctx, stream, stream, messages, 10, time.Second*5, 0)
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	defer conn.Close()
+
+	// request the logs and never read the response
+	fmt.Fprintf(conn, "GET /system/logs?name=figlet&follow=true HTTP/1.1\r\nHost: %s\r\n\r\n", server.Listener.Addr())
+
+	select {
+	case err := <-streamed:
+		if err != errSlowLogClient {
+			t.Errorf("want: %v got: %v", errSlowLogClient, err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("want: the stalled client dropped before the slow client timeout")
+	}
+}
+
+func Test_HijackLogStream_ChunkedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := hijackLogStream(w, time.Second)
+		if err != nil {
+			t.Errorf("unexpected error hijacking: %s", err)
+			return
+		}
+		defer stream.Close()
+
+		fmt.Fprintln(stream, `{"text":"Forking fprocess."}`)
+		stream.Flush()
+	}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error requesting logs: %s", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("want: a complete response got: %s", err)
+	}
+
+	if res.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("want: %s got: %s", "application/x-ndjson", res.Header.Get("Content-Type"))
+	}
+
+	want := "{\"text\":\"Forking fprocess.\"}\n"
+	if string(body) != want {
+		t.Errorf("want: %q got: %q", want, string(body))
+	}
+}
+
+func Test_StreamLogs_HeartbeatToStalledClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	// nothing reads from client, so the first heartbeat can not be written
+	stream := newDeadlineLogWriter(server, bufio.NewWriter(server), time.Millisecond*20)
+	defer stream.Close()
+
+	messages := make(chan logs.Message)
+	defer close(messages)
+
+	start := time.Now()
+	err := streamLogs(context.Background(), stream, stream, messages, 10, time.Second, time.Millisecond*300)
+	if err != errSlowLogClient {
+		t.Fatalf("want: %v got: %v", errSlowLogClient, err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Millisecond*600 {
+		t.Errorf("want: the stalled client dropped on the first heartbeat got: %s", elapsed)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenancePath is the admin endpoint which reads and sets maintenance mode
+const MaintenancePath = "/system/maintenance"
+
+// MaintenanceStatus is the state of maintenance mode, it is read and written as JSON on
+// MaintenancePath
+type MaintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+
+	// Reason is given to clients whose changes are rejected
+	Reason string `json:"reason,omitempty"`
+
+	// Since is when maintenance mode was turned on
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// Maintenance puts the provider in read-only mode while the cluster is being maintained.
+// Requests which change the functions or secrets, such as deploy, update, scale and delete,
+// are rejected with 503 while reads, logs and function invocations keep working.
+type Maintenance struct {
+	mu     sync.RWMutex
+	status MaintenanceStatus
+}
+
+// NewMaintenance returns a Maintenance which is not enabled
+func NewMaintenance() *Maintenance {
+	return &Maintenance{}
+}
+
+// Set turns maintenance mode on or off, reason is only kept while it is on
+func (m *Maintenance) Set(enabled bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		m.status = MaintenanceStatus{}
+		return
+	}
+
+	since := m.status.Since
+	if since == nil {
+		now := time.Now().UTC()
+		since = &now
+	}
+
+	m.status = MaintenanceStatus{
+		Enabled: true,
+		Reason:  reason,
+		Since:   since,
+	}
+}
+
+// Status returns the current state of maintenance mode
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status
+}
+
+// Middleware rejects the write requests to the provider API while maintenance mode is on
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := m.Status()
+		if status.Enabled && isWriteRequest(r) {
+			message := "The provider is in maintenance mode, changes are not accepted."
+			if len(status.Reason) > 0 {
+				message = fmt.Sprintf("The provider is in maintenance mode, changes are not accepted: %s", status.Reason)
+			}
+
+			writeText(w, http.StatusServiceUnavailable, message)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWriteRequest returns true for requests which change the state of the provider. Function
+// invocations are not under /system/ and the maintenance endpoint itself is always allowed
+// so maintenance mode can be turned off.
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	return strings.HasPrefix(r.URL.Path, "/system/") && r.URL.Path != MaintenancePath
+}
+
+// MakeMaintenanceHandler reads maintenance mode with GET and sets it with PUT, the body of
+// both is a MaintenanceStatus
+func MakeMaintenanceHandler(m *Maintenance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			defer r.Body.Close()
+			body, _ := ioutil.ReadAll(r.Body)
+
+			request := MaintenanceStatus{}
+			if err := json.Unmarshal(body, &request); err != nil {
+				writeText(w, http.StatusBadRequest, fmt.Sprintf("Error parsing request: %s", err))
+				return
+			}
+
+			m.Set(request.Enabled, request.Reason)
+			log.Printf("Maintenance mode enabled: %t %s\n", request.Enabled, request.Reason)
+		}
+
+		body, _ := json.Marshal(m.Status())
+		writeJSON(w, http.StatusOK, body)
+	}
+}
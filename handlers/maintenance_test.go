@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_Maintenance_BlocksWrites(t *testing.T) {
+	maintenance := NewMaintenance()
+
+	router := mux.NewRouter()
+	router.Use(maintenance.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/system/functions", ok).Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	router.HandleFunc("/system/scale-function/{name}", ok).Methods(http.MethodPost)
+	router.HandleFunc("/system/secrets", ok).Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+	router.HandleFunc("/system/logs", ok).Methods(http.MethodGet)
+	router.HandleFunc("/function/{name}", ok)
+	router.HandleFunc(MaintenancePath, MakeMaintenanceHandler(maintenance)).Methods(http.MethodGet, http.MethodPut)
+
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := serve(http.MethodPut, MaintenancePath, `{"enabled": true, "reason": "node upgrades"}`); rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	scenarios := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodPost, "/system/functions", http.StatusServiceUnavailable},
+		{http.MethodPut, "/system/functions", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/system/functions", http.StatusServiceUnavailable},
+		{http.MethodPost, "/system/scale-function/figlet", http.StatusServiceUnavailable},
+		{http.MethodPost, "/system/secrets", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/system/secrets", http.StatusServiceUnavailable},
+		{http.MethodGet, "/system/functions", http.StatusOK},
+		{http.MethodGet, "/system/secrets", http.StatusOK},
+		{http.MethodGet, "/system/logs", http.StatusOK},
+		{http.MethodPost, "/function/figlet", http.StatusOK},
+		{http.MethodGet, MaintenancePath, http.StatusOK},
+	}
+
+	for _, s := range scenarios {
+		rr := serve(s.method, s.path, "")
+		if rr.Code != s.wantStatus {
+			t.Errorf("%s %s want: %d got: %d", s.method, s.path, s.wantStatus, rr.Code)
+		}
+		if rr.Code == http.StatusServiceUnavailable && !strings.Contains(rr.Body.String(), "node upgrades") {
+			t.Errorf("%s %s want: reason in the message got: %q", s.method, s.path, rr.Body.String())
+		}
+	}
+
+	if rr := serve(http.MethodPut, MaintenancePath, `{"enabled": false}`); rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+	if rr := serve(http.MethodPost, "/system/functions", ""); rr.Code != http.StatusOK {
+		t.Errorf("want: writes allowed after maintenance got: %d", rr.Code)
+	}
+}
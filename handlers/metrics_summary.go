@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// metricsSummaryCacheTTL is how long a cluster summary is reused before listing again
+const metricsSummaryCacheTTL = time.Second * 5
+
+// MetricsSummaryClient is the subset of Docker Client methods required to summarise the
+// functions of the cluster
+type MetricsSummaryClient interface {
+	ServiceLister
+	TaskLister
+}
+
+// MetricsSummary is a snapshot of the health of every function in the cluster
+type MetricsSummary struct {
+	// Functions is how many functions are deployed
+	Functions int `json:"functions"`
+
+	// DesiredReplicas is the sum of the replicas the functions are scaled to
+	DesiredReplicas uint64 `json:"desiredReplicas"`
+
+	// RunningReplicas is how many tasks of the functions are running
+	RunningReplicas uint64 `json:"runningReplicas"`
+
+	// DegradedFunctions is how many functions have fewer running tasks than replicas
+	DegradedFunctions int `json:"degradedFunctions"`
+}
+
+// metricsSummaryCache holds the last summary until it expires
+type metricsSummaryCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	summary MetricsSummary
+	expires time.Time
+}
+
+// Get returns the summary if it was added within the TTL
+func (c *metricsSummaryCache) Get() (MetricsSummary, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expires.IsZero() || c.now().After(c.expires) {
+		return MetricsSummary{}, false
+	}
+
+	return c.summary, true
+}
+
+// Add stores the summary
+func (c *metricsSummaryCache) Add(summary MetricsSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary = summary
+	c.expires = c.now().Add(c.ttl)
+}
+
+// MakeMetricsSummaryHandler returns a MetricsSummary computed from one listing of the
+// services and one of the running tasks, the summary is cached for metricsSummaryCacheTTL
+func MakeMetricsSummaryHandler(c MetricsSummaryClient, functionLabel string) http.HandlerFunc {
+	cache := &metricsSummaryCache{
+		ttl: metricsSummaryCacheTTL,
+		now: time.Now,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary, cached := cache.Get()
+		if !cached {
+			var err error
+			summary, err = readMetricsSummary(c, functionLabel)
+			if err != nil {
+				log.Printf("MetricsSummaryHandler: %s\n", err)
+				writeText(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			cache.Add(summary)
+		}
+
+		body, _ := json.Marshal(summary)
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// readMetricsSummary lists the function services and the tasks desired to be running, a
+// function is degraded when fewer of its tasks are running than it has replicas. Functions in
+// global mode have no replica count and are never degraded.
+func readMetricsSummary(c MetricsSummaryClient, functionLabel string) (MetricsSummary, error) {
+	services, err := readFunctionServices(c, functionLabel)
+	if err != nil {
+		return MetricsSummary{}, err
+	}
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("desired-state", "running")
+
+	tasks, err := c.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return MetricsSummary{}, fmt.Errorf("error listing tasks: %s", err)
+	}
+
+	running := map[string]uint64{}
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning {
+			running[task.ServiceID]++
+		}
+	}
+
+	summary := MetricsSummary{
+		Functions: len(services),
+	}
+
+	for _, service := range services {
+		summary.RunningReplicas += running[service.ID]
+
+		if service.Spec.Mode.Replicated == nil || service.Spec.Mode.Replicated.Replicas == nil {
+			continue
+		}
+
+		desired := *service.Spec.Mode.Replicated.Replicas
+		summary.DesiredReplicas += desired
+		if running[service.ID] < desired {
+			summary.DegradedFunctions++
+		}
+	}
+
+	return summary, nil
+}
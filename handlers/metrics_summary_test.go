@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func summaryTask(serviceID string, state swarm.TaskState) swarm.Task {
+	return swarm.Task{
+		ServiceID:    serviceID,
+		DesiredState: swarm.TaskStateRunning,
+		Status:       swarm.TaskStatus{State: state},
+	}
+}
+
+func Test_ReadMetricsSummary(t *testing.T) {
+	global := labelledFunction("nodeinfo", nil)
+	global.Spec.Mode = swarm.ServiceMode{Global: &swarm.GlobalService{}}
+
+	c := newFakeDeployClient()
+	c.services = []swarm.Service{
+		functionWithReplicas("figlet", "", 2),
+		functionWithReplicas("env", "", 3),
+		global,
+		{ID: "svc-gateway", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "gateway"}}},
+	}
+	c.tasks = []swarm.Task{
+		summaryTask("svc-figlet", swarm.TaskStateRunning),
+		summaryTask("svc-figlet", swarm.TaskStateRunning),
+		summaryTask("svc-env", swarm.TaskStateRunning),
+		summaryTask("svc-env", swarm.TaskStatePreparing),
+		summaryTask("svc-nodeinfo", swarm.TaskStateRunning),
+		summaryTask("svc-gateway", swarm.TaskStateRunning),
+	}
+
+	summary, err := readMetricsSummary(c, DefaultFunctionLabel)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := MetricsSummary{
+		Functions:         3,
+		DesiredReplicas:   5,
+		RunningReplicas:   4,
+		DegradedFunctions: 1,
+	}
+	if summary != want {
+		t.Errorf("want: %+v got: %+v", want, summary)
+	}
+}
+
+func Test_MetricsSummaryHandler_Cached(t *testing.T) {
+	c := newFakeDeployClient()
+	c.services = []swarm.Service{functionWithReplicas("figlet", "", 1)}
+
+	handler := MakeMetricsSummaryHandler(c, DefaultFunctionLabel)
+	read := func() MetricsSummary {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/system/metrics/summary", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+		}
+
+		summary := MetricsSummary{}
+		json.Unmarshal(rr.Body.Bytes(), &summary)
+		return summary
+	}
+
+	if got := read(); got.Functions != 1 {
+		t.Fatalf("want: %d functions got: %d", 1, got.Functions)
+	}
+
+	c.services = append(c.services, functionWithReplicas("env", "", 1))
+	if got := read(); got.Functions != 1 {
+		t.Errorf("want: cached summary with %d functions got: %d", 1, got.Functions)
+	}
+}
+
+func Test_MetricsSummaryCache_Expires(t *testing.T) {
+	now := time.Now()
+	cache := &metricsSummaryCache{ttl: time.Second, now: func() time.Time { return now }}
+
+	cache.Add(MetricsSummary{Functions: 2})
+	if _, cached := cache.Get(); !cached {
+		t.Errorf("want: summary cached within the TTL")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, cached := cache.Get(); cached {
+		t.Errorf("want: summary expired after the TTL")
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"path"
+	"strings"
+
+	"github.com/docker/cli/opts"
+	"github.com/docker/docker/api/types/mount"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// MountsLabel label listing the bind and volume mounts of a function, separated by ";". Each
+// entry uses the docker service --mount syntax, i.e.
+// "type=bind,source=/var/shared,target=/shared,bind-propagation=rshared" or
+// "type=volume,source=cache,target=/cache,volume-driver=local,volume-label=team=ml".
+// Tmpfs mounts are made with ScratchSizeLabel and ShmSizeLabel instead. Bind mounts are only
+// allowed from the host paths in DeployConfig.BindMountSources.
+const MountsLabel = "com.openfaas.mounts"
+
+var mountConsistencies = []mount.Consistency{
+	mount.ConsistencyFull,
+	mount.ConsistencyCached,
+	mount.ConsistencyDelegated,
+	mount.ConsistencyDefault,
+}
+
+// buildMounts parses MountsLabel into bind and volume mounts, nil when it is not set. Bind
+// mounts must have a source under one of bindSources, so none are allowed when it is empty.
+func buildMounts(request *typesv1.FunctionDeployment, bindSources []string) ([]mount.Mount, error) {
+	if request.Labels == nil {
+		return nil, nil
+	}
+
+	value, exists := (*request.Labels)[MountsLabel]
+	if !exists {
+		return nil, nil
+	}
+
+	mountOpts := new(opts.MountOpt)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if err := mountOpts.Set(entry); err != nil {
+			return nil, newDeployError(ErrCodeInvalidMount, "label %s: invalid mount %s: %s", MountsLabel, entry, err)
+		}
+	}
+
+	mounts := mountOpts.Value()
+	for i := range mounts {
+		if err := validateMount(&mounts[i]); err != nil {
+			return nil, err
+		}
+
+		if mounts[i].Type == mount.TypeBind {
+			if err := checkBindSource(mounts[i], bindSources); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return mounts, nil
+}
+
+// validateMount checks the type, paths and options of a mount from MountsLabel. Empty volume
+// options, which the --mount syntax creates for any volume-* field, are removed.
+func validateMount(m *mount.Mount) error {
+	if m.Type != mount.TypeBind && m.Type != mount.TypeVolume {
+		return newDeployError(ErrCodeInvalidMount, "label %s: mount at %s has type %s, should be bind or volume", MountsLabel, m.Target, m.Type)
+	}
+
+	if !path.IsAbs(m.Target) || path.Clean(m.Target) != m.Target || m.Target == "/" {
+		return newDeployError(ErrCodeInvalidMount, "label %s: invalid target %s, should be a clean absolute path other than /", MountsLabel, m.Target)
+	}
+
+	if m.Type == mount.TypeBind && !path.IsAbs(m.Source) {
+		return newDeployError(ErrCodeInvalidMount, "label %s: bind mount at %s needs an absolute source path, got %q", MountsLabel, m.Target, m.Source)
+	}
+
+	if m.BindOptions != nil && !validPropagation(m.BindOptions.Propagation) {
+		return newDeployError(ErrCodeInvalidMount, "label %s: invalid bind-propagation %s for %s, should be one of %s", MountsLabel, m.BindOptions.Propagation, m.Target, joinPropagations())
+	}
+
+	if len(m.Consistency) > 0 && !validConsistency(m.Consistency) {
+		return newDeployError(ErrCodeInvalidMount, "label %s: invalid consistency %s for %s, should be consistent, cached, delegated or default", MountsLabel, m.Consistency, m.Target)
+	}
+
+	if options := m.VolumeOptions; options != nil {
+		if options.DriverConfig != nil && len(options.DriverConfig.Name) == 0 {
+			if len(options.DriverConfig.Options) > 0 {
+				return newDeployError(ErrCodeInvalidMount, "label %s: volume-opt for %s needs a volume-driver", MountsLabel, m.Target)
+			}
+			options.DriverConfig = nil
+		}
+		if len(options.Labels) == 0 {
+			options.Labels = nil
+		}
+		if !options.NoCopy && options.Labels == nil && options.DriverConfig == nil {
+			m.VolumeOptions = nil
+		}
+	}
+
+	return nil
+}
+
+// checkBindSource rejects a bind mount unless its source is one of bindSources or below it.
+// A bind mount of a host path such as /var/run/docker.sock gives the function root on the
+// node, so operators have to allow the paths functions may mount.
+func checkBindSource(m mount.Mount, bindSources []string) error {
+	if len(bindSources) == 0 {
+		return newDeployError(ErrCodeInvalidMount, "label %s: bind mount at %s is not allowed, bind mounts are not enabled", MountsLabel, m.Target)
+	}
+
+	if path.Clean(m.Source) != m.Source {
+		return newDeployError(ErrCodeInvalidMount, "label %s: invalid source %s for %s, should be a clean absolute path", MountsLabel, m.Source, m.Target)
+	}
+
+	for _, allowed := range bindSources {
+		if m.Source == allowed || strings.HasPrefix(m.Source, strings.TrimSuffix(allowed, "/")+"/") {
+			return nil
+		}
+	}
+
+	return newDeployError(ErrCodeInvalidMount, "label %s: bind mount of %s is not allowed, source should be under one of %s", MountsLabel, m.Source, strings.Join(bindSources, ", "))
+}
+
+func validPropagation(propagation mount.Propagation) bool {
+	for _, valid := range mount.Propagations {
+		if propagation == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func validConsistency(consistency mount.Consistency) bool {
+	for _, valid := range mountConsistencies {
+		if consistency == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPropagations() string {
+	names := make([]string, 0, len(mount.Propagations))
+	for _, propagation := range mount.Propagations {
+		names = append(names, string(propagation))
+	}
+	return strings.Join(names, ", ")
+}
+
+// labelMountTargets returns the targets of the mounts listed in MountsLabel of a service, so
+// they can be told apart from mounts added outside of faas-swarm. Malformed entries were
+// rejected at deploy time and are skipped.
+func labelMountTargets(labels map[string]string) map[string]bool {
+	targets := map[string]bool{}
+	for _, entry := range strings.Split(labels[MountsLabel], ";") {
+		mountOpts := new(opts.MountOpt)
+		if err := mountOpts.Set(strings.TrimSpace(entry)); err != nil {
+			continue
+		}
+
+		for _, m := range mountOpts.Value() {
+			targets[m.Target] = true
+		}
+	}
+
+	return targets
+}
+
+// checkMountTargets returns an error when two mounts of a function share a target, such as a
+// mount from MountsLabel over the scratch space or /tmp
+func checkMountTargets(mounts []mount.Mount) error {
+	seen := map[string]mount.Type{}
+	for _, m := range mounts {
+		if other, exists := seen[m.Target]; exists {
+			return newDeployError(ErrCodeInvalidMount, "the %s mount at %s collides with a %s mount at the same path", m.Type, m.Target, other)
+		}
+		seen[m.Target] = m.Type
+	}
+
+	return nil
+}
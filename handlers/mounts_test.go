@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+func Test_BuildMounts(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		value       string
+		bindSources []string
+		want        []mount.Mount
+		wantErr     bool
+	}{
+		{
+			name:        "bind with propagation",
+			value:       "type=bind,source=/var/shared,target=/shared,bind-propagation=rshared,consistency=cached",
+			bindSources: []string{"/var/shared"},
+			want: []mount.Mount{
+				{
+					Type:        mount.TypeBind,
+					Source:      "/var/shared",
+					Target:      "/shared",
+					Consistency: mount.ConsistencyCached,
+					BindOptions: &mount.BindOptions{Propagation: mount.PropagationRShared},
+				},
+			},
+		},
+		{
+			name:  "volume with options",
+			value: "type=volume,source=cache,target=/cache,volume-driver=local,volume-opt=type=nfs,volume-label=team=ml; type=volume,source=models,target=/models,readonly",
+			want: []mount.Mount{
+				{
+					Type:   mount.TypeVolume,
+					Source: "cache",
+					Target: "/cache",
+					VolumeOptions: &mount.VolumeOptions{
+						Labels:       map[string]string{"team": "ml"},
+						DriverConfig: &mount.Driver{Name: "local", Options: map[string]string{"type": "nfs"}},
+					},
+				},
+				{Type: mount.TypeVolume, Source: "models", Target: "/models", ReadOnly: true},
+			},
+		},
+		{
+			name:        "bind below an allowed source",
+			value:       "type=bind,source=/srv/data/models,target=/models,readonly",
+			bindSources: []string{"/var/shared", "/srv/data"},
+			want:        []mount.Mount{{Type: mount.TypeBind, Source: "/srv/data/models", Target: "/models", ReadOnly: true}},
+		},
+		{name: "bind mounts not enabled", value: "type=bind,source=/var/shared,target=/shared", wantErr: true},
+		{name: "docker socket", value: "type=bind,source=/var/run/docker.sock,target=/var/run/docker.sock", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "host root", value: "type=bind,source=/,target=/host", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "sibling of an allowed source", value: "type=bind,source=/var/shared-secrets,target=/shared", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "escapes an allowed source", value: "type=bind,source=/var/shared/../../etc,target=/etc-host", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "invalid propagation", value: "type=bind,source=/var/shared,target=/shared,bind-propagation=everywhere", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "invalid consistency", value: "type=bind,source=/var/shared,target=/shared,consistency=eventual", bindSources: []string{"/var/shared"}, wantErr: true},
+		{name: "tmpfs", value: "type=tmpfs,target=/cache", wantErr: true},
+		{name: "relative bind source", value: "type=bind,source=shared,target=/shared", wantErr: true},
+		{name: "relative target", value: "type=volume,source=cache,target=cache", wantErr: true},
+		{name: "volume options on bind", value: "type=bind,source=/var/shared,target=/shared,volume-driver=local", wantErr: true},
+		{name: "volume-opt without driver", value: "type=volume,source=cache,target=/cache,volume-opt=type=nfs", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			mounts, err := buildMounts(&typesv1.FunctionDeployment{
+				Labels: &map[string]string{MountsLabel: s.value},
+			}, s.bindSources)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeInvalidMount {
+					t.Errorf("want: error code %s got: %s", ErrCodeInvalidMount, code)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(mounts, s.want) {
+				t.Errorf("want: %+v got: %+v", s.want, mounts)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_MountCollidesWithScratch(t *testing.T) {
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.1",
+		Labels: &map[string]string{
+			ScratchSizeLabel: "64m",
+			MountsLabel:      "type=volume,source=cache,target=/scratch",
+		},
+	}
+
+	_, err := makeSpec(request, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, nil)
+	if code := errorCode(err, ""); code != ErrCodeInvalidMount {
+		t.Errorf("want: error code %s got: %s", ErrCodeInvalidMount, code)
+	}
+}
+
+func Test_UpdateSpec_LabelMountsReplaced(t *testing.T) {
+	spec := existingServiceSpec(nil)
+	spec.Annotations.Labels = map[string]string{MountsLabel: "type=bind,source=/var/old,target=/old"}
+	spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
+		{Type: mount.TypeBind, Source: "/var/old", Target: "/old"},
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+	}
+
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.2",
+		Labels: &map[string]string{
+			MountsLabel: "type=bind,source=/var/shared,target=/shared,bind-propagation=rslave",
+		},
+	}
+
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, BindMountSources: []string{"/var/shared"}}
+	if err := updateSpec(request, &spec, config, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+		{Type: mount.TypeBind, Source: "/var/shared", Target: "/shared", BindOptions: &mount.BindOptions{Propagation: mount.PropagationRSlave}},
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
@@ -4,23 +4,74 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/docker/docker/api/types/swarm"
 )
 
-// Swarm does not use namespaces, so we return an empty list. see https://github.com/openfaas-incubator/connector-sdk/pull/46
-func NamespaceLister() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		namespaces := []string{}
-		nsJSON, err := json.Marshal(namespaces)
+// NamespaceLabel label holding the namespace of a function. Swarm has no namespaces, so
+// this only groups functions, service names are still unique across the whole swarm. A
+// function outside of DefaultNamespace is invoked as <name>.<namespace>, which
+// FunctionLookup resolves to the service.
+const NamespaceLabel = "com.openfaas.namespace"
+
+// DefaultNamespace is the namespace of functions deployed without one
+const DefaultNamespace = "openfaas-fn"
+
+// validNamespace matches a Kubernetes-style DNS label, so names can be shared with
+// faas-netes
+var validNamespace = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// getNamespace returns the namespace of a function from its labels
+func getNamespace(labels map[string]string) string {
+	if namespace := labels[NamespaceLabel]; len(namespace) > 0 {
+		return namespace
+	}
+
+	return DefaultNamespace
+}
+
+// validateNamespace checks that a namespace is a DNS label
+func validateNamespace(namespace string) error {
+	if !validNamespace.MatchString(namespace) {
+		return newDeployError(ErrCodeInvalidRequest, "invalid namespace %s, should be lower case letters, numbers and -", namespace)
+	}
 
+	return nil
+}
+
+// NamespaceLister lists the namespaces of the deployed functions, sorted and including
+// DefaultNamespace. Swarm does not use namespaces, they are read from NamespaceLabel.
+// see https://github.com/openfaas-incubator/connector-sdk/pull/46
+func NamespaceLister(c ServiceLister, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		services, err := readFunctionServices(c, functionLabel)
 		if err != nil {
-			log.Printf("Unable to marshal namespaces into JSON %q", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("\"error\": \"unable to return namespaces\""))
+			log.Printf("Unable to list namespaces: %s\n", err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(nsJSON)
+		nsJSON, _ := json.Marshal(listNamespaces(services))
+		writeJSON(w, http.StatusOK, nsJSON)
 	}
 }
 
+// listNamespaces returns the distinct namespaces of the services and DefaultNamespace, sorted
+func listNamespaces(services []swarm.Service) []string {
+	seen := map[string]bool{DefaultNamespace: true}
+	namespaces := []string{DefaultNamespace}
+
+	for _, service := range services {
+		namespace := getNamespace(service.Spec.Labels)
+		if !seen[namespace] {
+			seen[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func Test_NamespaceLister(t *testing.T) {
+	c := fakeServiceAPIClient{
+		services: []swarm.Service{
+			labelledFunction("figlet", nil),
+			labelledFunction("checkout", map[string]string{NamespaceLabel: "staging"}),
+			labelledFunction("refunds", map[string]string{NamespaceLabel: "staging"}),
+			labelledFunction("invoices", map[string]string{NamespaceLabel: "billing"}),
+			labelledFunction("nodeinfo", map[string]string{NamespaceLabel: DefaultNamespace}),
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/namespaces", nil)
+	rr := httptest.NewRecorder()
+	NamespaceLister(c, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	namespaces := []string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &namespaces); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	if want := []string{"billing", DefaultNamespace, "staging"}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("want: %v got: %v", want, namespaces)
+	}
+}
+
+func Test_NamespaceLister_NoFunctions(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/system/namespaces", nil)
+	rr := httptest.NewRecorder()
+	NamespaceLister(fakeServiceAPIClient{}, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	namespaces := []string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &namespaces); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	if want := []string{DefaultNamespace}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("want: %v got: %v", want, namespaces)
+	}
+}
+
+func Test_Namespace_InvocationURLResolves(t *testing.T) {
+	services := []swarm.Service{
+		labelledFunction("figlet", nil),
+		labelledFunction("checkout", map[string]string{NamespaceLabel: "staging"}),
+		labelledFunction("nodeinfo", map[string]string{NamespaceLabel: DefaultNamespace}),
+	}
+	resolver := NewFunctionLookup(namespacedServiceLister{services: services}, false)
+
+	for _, service := range services {
+		path := invocationURL(service.Spec.Name, getNamespace(service.Spec.Labels))
+
+		u, err := resolver.Resolve(strings.TrimPrefix(path, functionPathPrefix))
+		if err != nil {
+			t.Errorf("%s: want: %s to resolve got: %s", service.Spec.Name, path, err)
+			continue
+		}
+		if u.Host != service.Spec.Name {
+			t.Errorf("%s: want: host %s got: %s", path, service.Spec.Name, u.Host)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// RequireEncryptionLabel label set to "true" to only deploy a function when its network is an
+// overlay network created with the encrypted option
+const RequireEncryptionLabel = "com.openfaas.network.require_encryption"
+
+// encryptedNetworkOption is the driver option set on overlay networks created with
+// --opt encrypted
+const encryptedNetworkOption = "encrypted"
+
+// NetworkInspector is the subset of Docker Client methods required to inspect a network
+type NetworkInspector interface {
+	NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+}
+
+// requiresEncryption returns true when RequireEncryptionLabel is "true"
+func requiresEncryption(request *typesv1.FunctionDeployment) (bool, error) {
+	if request.Labels == nil {
+		return false, nil
+	}
+
+	value, exists := (*request.Labels)[RequireEncryptionLabel]
+	if !exists {
+		return false, nil
+	}
+
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, newFieldError(ErrCodeInvalidLabel, RequireEncryptionLabel, value, "should be true or false")
+}
+
+// checkNetworkEncryption returns an ErrCodeInvalidNetwork error when a function with
+// RequireEncryptionLabel is not attached to an encrypted network. The host network is never
+// encrypted. The network of the request must already have been resolved.
+func checkNetworkEncryption(c NetworkInspector, request *typesv1.FunctionDeployment) error {
+	required, err := requiresEncryption(request)
+	if err != nil || !required {
+		return err
+	}
+
+	if isHostNetworkMode(request) {
+		return newDeployError(ErrCodeInvalidNetwork, "label %s: the host network is not encrypted", RequireEncryptionLabel)
+	}
+
+	network, err := c.NetworkInspect(context.Background(), request.Network, types.NetworkInspectOptions{})
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return newDeployError(ErrCodeInvalidNetwork, "label %s: network %s not found", RequireEncryptionLabel, request.Network)
+		}
+		return err
+	}
+
+	if _, encrypted := network.Options[encryptedNetworkOption]; !encrypted {
+		return newDeployError(ErrCodeInvalidNetwork, "label %s: network %s is not encrypted, create it with --opt encrypted", RequireEncryptionLabel, request.Network)
+	}
+
+	return nil
+}
+
+// networkCheckStatus returns the HTTP status for an error from checkNetworkEncryption, errors
+// inspecting the network are not the fault of the request
+func networkCheckStatus(err error) int {
+	if _, ok := err.(*DeployError); ok {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
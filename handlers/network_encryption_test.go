@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func Test_DeployFunction_RequireEncryption(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		network    string
+		value      string
+		wantStatus int
+	}{
+		{name: "encrypted network", network: "func_secure", value: "true", wantStatus: http.StatusAccepted},
+		{name: "unencrypted network", network: "func_functions", value: "true", wantStatus: http.StatusBadRequest},
+		{name: "not required", network: "func_functions", value: "false", wantStatus: http.StatusAccepted},
+		{name: "missing network", network: "func_missing", value: "true", wantStatus: http.StatusBadRequest},
+		{name: "invalid value", network: "func_secure", value: "yes", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.networks = append(c.networks, types.NetworkResource{
+				Name:    "func_secure",
+				Options: map[string]string{"encrypted": ""},
+			})
+
+			request := batchRequest("figlet")
+			request.Network = s.network
+			request.Labels = &map[string]string{RequireEncryptionLabel: s.value}
+
+			status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, nil)
+			if status != s.wantStatus {
+				t.Errorf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if s.wantStatus != http.StatusAccepted && len(c.created) != 0 {
+				t.Errorf("want: no services created got: %v", c.created)
+			}
+		})
+	}
+}
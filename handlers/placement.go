@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// PlacementClient is the subset of Docker Client methods required to find the nodes a
+// function's replicas are running on
+type PlacementClient interface {
+	ServiceInspector
+	TaskLister
+	NodeLister
+}
+
+// MakePlacementHandler returns how many running replicas of a function are on each node,
+// keyed by the node's hostname. Services without functionLabel are not functions.
+func MakePlacementHandler(c PlacementClient, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+				return
+			}
+
+			log.Printf("PlacementHandler: error inspecting service %s: %s\n", functionName, err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !isFunctionService(service, functionLabel) {
+			writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+			return
+		}
+
+		placement, err := readPlacement(r.Context(), c, service)
+		if err != nil {
+			log.Printf("PlacementHandler: error reading placement for %s: %s\n", functionName, err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		placementBytes, _ := json.Marshal(placement)
+		writeJSON(w, http.StatusOK, placementBytes)
+	}
+}
+
+// readPlacement counts the running tasks of a service on each node. Nodes are named by their
+// hostname, or by their ID when the node is not listed, i.e. it has left the swarm.
+func readPlacement(ctx context.Context, c PlacementClient, service swarm.Service) (map[string]int, error) {
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", service.ID)
+	taskFilter.Add("desired-state", "running")
+
+	tasks, err := c.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := map[string]int{}
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning && len(task.NodeID) > 0 {
+			replicas[task.NodeID]++
+		}
+	}
+
+	if len(replicas) == 0 {
+		return map[string]int{}, nil
+	}
+
+	nodes, err := c.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	hostnames := map[string]string{}
+	for _, node := range nodes {
+		if len(node.Description.Hostname) > 0 {
+			hostnames[node.ID] = node.Description.Hostname
+		}
+	}
+
+	placement := map[string]int{}
+	for nodeID, count := range replicas {
+		name, exists := hostnames[nodeID]
+		if !exists {
+			name = nodeID
+		}
+		placement[name] += count
+	}
+
+	return placement, nil
+}
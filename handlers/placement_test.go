@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakePlacementClient struct {
+	services  map[string]swarm.Service
+	tasks     []swarm.Task
+	hostnames map[string]string
+}
+
+func (f *fakePlacementClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	service, exists := f.services[serviceID]
+	if !exists {
+		return swarm.Service{}, nil, fakeNotFoundError{name: serviceID}
+	}
+	return service, []byte{}, nil
+}
+
+func (f *fakePlacementClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	tasks := []swarm.Task{}
+	for _, task := range f.tasks {
+		if options.Filters.ExactMatch("service", task.ServiceID) {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+func (f *fakePlacementClient) NodeList(ctx context.Context, options types.NodeListOptions) ([]swarm.Node, error) {
+	nodes := []swarm.Node{}
+	for nodeID, hostname := range f.hostnames {
+		node := swarm.Node{ID: nodeID}
+		node.Description.Hostname = hostname
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// functionService returns a function service with the default function label
+func functionService(id string, name string) swarm.Service {
+	service := swarm.Service{ID: id}
+	service.Spec.Name = name
+	service.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Labels: map[string]string{DefaultFunctionLabel: name},
+	}
+	return service
+}
+
+func placedTask(serviceID string, nodeID string, state swarm.TaskState) swarm.Task {
+	task := taskOnNode(nodeID, state)
+	task.ServiceID = serviceID
+	return task
+}
+
+func Test_PlacementHandler_CountsRunningReplicasPerNode(t *testing.T) {
+	c := &fakePlacementClient{
+		services: map[string]swarm.Service{
+			"figlet": functionService("svc-figlet", "figlet"),
+		},
+		tasks: []swarm.Task{
+			placedTask("svc-figlet", "node1", swarm.TaskStateRunning),
+			placedTask("svc-figlet", "node1", swarm.TaskStateRunning),
+			placedTask("svc-figlet", "node2", swarm.TaskStateRunning),
+			placedTask("svc-figlet", "node2", swarm.TaskStatePreparing),
+			placedTask("svc-figlet", "node3", swarm.TaskStateRunning),
+			placedTask("svc-env", "node3", swarm.TaskStateRunning),
+		},
+		hostnames: map[string]string{
+			"node1": "worker-1",
+			"node2": "worker-2",
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/placement", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+
+	MakePlacementHandler(c, DefaultFunctionLabel)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+
+	placement := map[string]int{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &placement); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	// node3 is not listed so is named by its ID
+	want := map[string]int{"worker-1": 2, "worker-2": 1, "node3": 1}
+	if !reflect.DeepEqual(placement, want) {
+		t.Errorf("want: %v got: %v", want, placement)
+	}
+}
+
+func Test_PlacementHandler_NotFound(t *testing.T) {
+	c := &fakePlacementClient{}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/placement", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+
+	MakePlacementHandler(c, DefaultFunctionLabel)(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func Test_PlacementHandler_NotAFunction(t *testing.T) {
+	c := &fakePlacementClient{
+		services: map[string]swarm.Service{
+			"nginx": {ID: "svc-nginx"},
+		},
+		tasks: []swarm.Task{
+			placedTask("svc-nginx", "node1", swarm.TaskStateRunning),
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/nginx/placement", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "nginx"})
+	rr := httptest.NewRecorder()
+
+	MakePlacementHandler(c, DefaultFunctionLabel)(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// PrePullLabel label set to "true" to pull the image of a function on every eligible node
+// before its deploy completes, so the first invocation on a node does not wait for a pull
+const PrePullLabel = "com.openfaas.prepull"
+
+// prePullPollInterval is the delay between reading the tasks of a service being pre-pulled
+const prePullPollInterval = time.Second
+
+// prePullClient is the subset of Docker Client methods required to pre-pull an image
+type prePullClient interface {
+	NodeLister
+	TaskLister
+	ServiceLister
+	ServiceScaler
+}
+
+// isPrePull returns true when PrePullLabel is "true"
+func isPrePull(request *typesv1.FunctionDeployment) (bool, error) {
+	if request.Labels == nil {
+		return false, nil
+	}
+
+	value, exists := (*request.Labels)[PrePullLabel]
+	if !exists {
+		return false, nil
+	}
+
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, newFieldError(ErrCodeInvalidLabel, PrePullLabel, value, "should be true or false")
+}
+
+// prePullImage approximates a pull of the image on every eligible node, as Swarm can not
+// pull an image on a node directly. The service is scaled to one replica per node until a
+// task is running on each of them, or config.PrePullTimeout passes, then scaled back to its
+// replicas. The extra replicas count against the replica quota, the image is not pre-pulled
+// when they would exceed it.
+func prePullImage(c prePullClient, serviceID string, spec swarm.ServiceSpec, config DeployConfig) error {
+	if spec.Mode.Replicated == nil || spec.Mode.Replicated.Replicas == nil {
+		return nil
+	}
+	replicas := *spec.Mode.Replicated.Replicas
+
+	var constraints []string
+	if spec.TaskTemplate.Placement != nil {
+		constraints = spec.TaskTemplate.Placement.Constraints
+	}
+
+	nodes, err := countEligibleNodes(c, constraints)
+	if err != nil {
+		return err
+	}
+
+	if uint64(nodes) <= replicas {
+		return nil
+	}
+
+	if err := checkReplicaQuota(c, config.ReplicaQuota, config.FunctionLabel, getNamespace(spec.Labels), spec.Name, uint64(nodes)); err != nil {
+		return err
+	}
+
+	serviceQuery := NewSwarmServiceQuery(c)
+	if err := serviceQuery.SetReplicas(serviceID, uint64(nodes)); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if config.PrePullTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.PrePullTimeout)
+		defer cancel()
+	}
+
+	waitErr := waitForRunningNodes(ctx, c, serviceID, nodes, prePullPollInterval)
+
+	if err := serviceQuery.SetReplicas(serviceID, replicas); err != nil {
+		return err
+	}
+
+	return waitErr
+}
+
+// countEligibleNodes returns how many ready, active nodes satisfy the constraints
+func countEligibleNodes(c NodeLister, constraints []string) (int, error) {
+	nodes, err := c.NodeList(context.Background(), types.NodeListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, node := range nodes {
+		if node.Status.State != swarm.NodeStateReady || node.Spec.Availability != swarm.NodeAvailabilityActive {
+			continue
+		}
+
+		if nodeMatchesConstraints(node, constraints) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// waitForRunningNodes polls the tasks of a service every interval until a task is running
+// on at least nodes distinct nodes, or ctx is done
+func waitForRunningNodes(ctx context.Context, c TaskLister, serviceID string, nodes int, interval time.Duration) error {
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", serviceID)
+	taskFilter.Add("desired-state", "running")
+
+	for {
+		tasks, err := c.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+		if err != nil {
+			return err
+		}
+
+		running := map[string]bool{}
+		for _, task := range tasks {
+			if task.Status.State == swarm.TaskStateRunning && len(task.NodeID) > 0 {
+				running[task.NodeID] = true
+			}
+		}
+
+		if len(running) >= nodes {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return newDeployError(ErrCodeDeployTimeout, "image pulled on %d of %d nodes before timing out", len(running), nodes)
+		case <-time.After(interval):
+		}
+	}
+}
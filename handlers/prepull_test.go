@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// sequencedTaskLister returns each list of tasks in turn, then the last one forever
+type sequencedTaskLister struct {
+	lists [][]swarm.Task
+	calls int
+}
+
+func (f *sequencedTaskLister) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	list := f.lists[len(f.lists)-1]
+	if f.calls < len(f.lists) {
+		list = f.lists[f.calls]
+	}
+	f.calls++
+
+	return list, nil
+}
+
+func taskOnNode(nodeID string, state swarm.TaskState) swarm.Task {
+	return swarm.Task{NodeID: nodeID, Status: swarm.TaskStatus{State: state}}
+}
+
+func Test_WaitForRunningNodes(t *testing.T) {
+	pending := []swarm.Task{
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node2", swarm.TaskStatePreparing),
+		taskOnNode("node3", swarm.TaskStatePreparing),
+	}
+	twoNodes := []swarm.Task{
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node2", swarm.TaskStateRunning),
+		taskOnNode("node3", swarm.TaskStatePreparing),
+	}
+	allNodes := []swarm.Task{
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node2", swarm.TaskStateRunning),
+		taskOnNode("node3", swarm.TaskStateRunning),
+	}
+
+	scenarios := []struct {
+		name      string
+		lists     [][]swarm.Task
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "running on every node", lists: [][]swarm.Task{allNodes}, wantCalls: 1},
+		{name: "running after pulling", lists: [][]swarm.Task{pending, twoNodes, allNodes}, wantCalls: 3},
+		{name: "two tasks on one node", lists: [][]swarm.Task{twoNodes}, wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := &sequencedTaskLister{lists: s.lists}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := waitForRunningNodes(ctx, c, "figlet", 3, time.Millisecond)
+			if s.wantErr {
+				if errorCode(err, "") != ErrCodeDeployTimeout {
+					t.Errorf("want: error code %s got: %v", ErrCodeDeployTimeout, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("want: no error got: %v", err)
+			}
+			if c.calls != s.wantCalls {
+				t.Errorf("want: %d task lists got: %d", s.wantCalls, c.calls)
+			}
+		})
+	}
+}
+
+func Test_PrePullImage_ScalesBack(t *testing.T) {
+	c := newFakeDeployClient()
+	c.nodes = []swarm.Node{readyNode("node1"), readyNode("node2"), readyNode("node3")}
+	c.tasks = []swarm.Task{
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node2", swarm.TaskStateRunning),
+		taskOnNode("node3", swarm.TaskStateRunning),
+	}
+
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{PrePullLabel: "true"}
+
+	status, warnings, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, PrePullTimeout: time.Second}, &request, nil)
+	if status != http.StatusAccepted || err != nil {
+		t.Fatalf("want: 202 got: %d %v", status, err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("want: no warnings got: %v", warnings)
+	}
+
+	if len(c.scaled) != 2 || c.scaled[0] != 3 || c.scaled[1] != 1 {
+		t.Errorf("want: scaled to %d then back to %d replicas got: %v", 3, 1, c.scaled)
+	}
+}
+
+// slotCheckingClient tries to take a deploy limiter slot each time the tasks are read while
+// the image is pre-pulled
+type slotCheckingClient struct {
+	*fakeDeployClient
+
+	limiter  *DeployLimiter
+	acquired bool
+}
+
+func (f *slotCheckingClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	if err := f.limiter.Acquire(ctx); err == nil {
+		f.acquired = true
+		f.limiter.Release()
+	}
+
+	return f.fakeDeployClient.TaskList(ctx, options)
+}
+
+func Test_PrePullImage_ReleasesDeploySlot(t *testing.T) {
+	c := &slotCheckingClient{fakeDeployClient: newFakeDeployClient(), limiter: NewDeployLimiter(1, 0)}
+	c.nodes = []swarm.Node{readyNode("node1"), readyNode("node2")}
+	c.tasks = []swarm.Task{
+		taskOnNode("node1", swarm.TaskStateRunning),
+		taskOnNode("node2", swarm.TaskStateRunning),
+	}
+
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{PrePullLabel: "true"}
+
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, PrePullTimeout: time.Second, Limiter: c.limiter}
+	if status, _, err := deployFunction(c, config, &request, nil); status != http.StatusAccepted || err != nil {
+		t.Fatalf("want: 202 got: %d %v", status, err)
+	}
+
+	if !c.acquired {
+		t.Errorf("want: the deploy slot free for other deployments during the pre-pull")
+	}
+}
+
+func Test_PrePullImage_ReplicaQuota(t *testing.T) {
+	c := newFakeDeployClient()
+	c.nodes = []swarm.Node{readyNode("node1"), readyNode("node2"), readyNode("node3")}
+
+	request := batchRequest("figlet")
+	request.Labels = &map[string]string{PrePullLabel: "true"}
+
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, PrePullTimeout: time.Second, ReplicaQuota: 2}
+	status, warnings, err := deployFunction(c, config, &request, nil)
+	if status != http.StatusAccepted || err != nil {
+		t.Fatalf("want: 202 got: %d %v", status, err)
+	}
+
+	if len(c.scaled) != 0 {
+		t.Errorf("want: not scaled over the replica quota got: %v", c.scaled)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "quota") {
+		t.Errorf("want: a replica quota warning got: %v", warnings)
+	}
+}
+
+func readyNode(id string) swarm.Node {
+	return swarm.Node{
+		ID:          id,
+		Spec:        swarm.NodeSpec{Availability: swarm.NodeAvailabilityActive},
+		Description: swarm.NodeDescription{Platform: swarm.Platform{OS: "linux"}},
+		Status:      swarm.NodeStatus{State: swarm.NodeStateReady},
+	}
+}
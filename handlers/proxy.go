@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -49,7 +50,8 @@ func NewFunctionLookup(client ServiceLister, dnsRoundRobin bool) *FunctionLookup
 // Resolve implements the openfaas-provider proxy.BaseURLResolver interface. In
 // short it verifies that a function with the given name is resolvable by Docker
 // Swarm.  It can be configured to do this via DNS or by querying the Docker Service
-// list.
+// list. A function outside of the default namespace is named <name>.<namespace>, it
+// only resolves when the service has that namespace in its NamespaceLabel.
 func (l *FunctionLookup) Resolve(name string) (u url.URL, err error) {
 	return l.ResolveContext(context.Background(), name)
 }
@@ -57,11 +59,17 @@ func (l *FunctionLookup) Resolve(name string) (u url.URL, err error) {
 // ResolveContext provides an implementation of openfaas-provider proxy.BaseURLResolver with
 // context support. See `Resolve`
 func (l *FunctionLookup) ResolveContext(ctx context.Context, name string) (u url.URL, err error) {
+	name, namespace := splitFunctionName(name)
 
 	if l.dnsRoundRobin {
+		if len(namespace) > 0 {
+			if _, err = l.byName(ctx, name, namespace); err != nil {
+				return u, err
+			}
+		}
 		u.Host, err = l.byDNSRoundRobin(ctx, name)
 	} else {
-		u.Host, err = l.byName(ctx, name)
+		u.Host, err = l.byName(ctx, name, namespace)
 	}
 
 	if err != nil {
@@ -72,8 +80,19 @@ func (l *FunctionLookup) ResolveContext(ctx context.Context, name string) (u url
 	return u, nil
 }
 
-// resolve the function by checking the available docker VIP based resolution
-func (l *FunctionLookup) byName(ctx context.Context, name string) (string, error) {
+// splitFunctionName splits a function named <name>.<namespace> into the service name and
+// the namespace, which is empty when it is not given. Service names can not contain a dot.
+func splitFunctionName(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return name, ""
+}
+
+// resolve the function by checking the available docker VIP based resolution, when a
+// namespace is given the service must be in it
+func (l *FunctionLookup) byName(ctx context.Context, name string, namespace string) (string, error) {
 	serviceFilter := filters.NewArgs()
 	serviceFilter.Add("name", name)
 	services, err := l.lister.ServiceList(ctx, types.ServiceListOptions{Filters: serviceFilter})
@@ -82,11 +101,21 @@ func (l *FunctionLookup) byName(ctx context.Context, name string) (string, error
 		return "", err
 	}
 
-	if len(services) > 0 {
-		return name, nil
+	if len(namespace) == 0 {
+		if len(services) > 0 {
+			return name, nil
+		}
+
+		return "", fmt.Errorf("could not resolve: %s", name)
+	}
+
+	for _, service := range services {
+		if service.Spec.Name == name && getNamespace(service.Spec.Labels) == namespace {
+			return name, nil
+		}
 	}
 
-	return "", fmt.Errorf("could not resolve: %s", name)
+	return "", fmt.Errorf("could not resolve: %s.%s", name, namespace)
 }
 
 // resolve the function by checking the available docker DNSRR resolution
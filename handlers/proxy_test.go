@@ -56,6 +56,83 @@ func Test_ProxyURLResolver_ByName(t *testing.T) {
 	}
 }
 
+type namespacedServiceLister struct {
+	services []swarm.Service
+}
+
+func (l namespacedServiceLister) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	services := []swarm.Service{}
+	for _, service := range l.services {
+		if options.Filters.Match("name", service.Spec.Name) {
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+func Test_ProxyURLResolver_Namespace(t *testing.T) {
+	figlet := swarm.Service{ID: "figlet"}
+	figlet.Spec.Name = "figlet"
+	env := swarm.Service{ID: "env"}
+	env.Spec.Name = "env"
+	env.Spec.Labels = map[string]string{NamespaceLabel: "staging"}
+
+	docker := namespacedServiceLister{services: []swarm.Service{figlet, env}}
+
+	scenarios := []struct {
+		name     string
+		function string
+		wantHost string
+		wantErr  string
+	}{
+		{"function without a namespace", "env", "env", ""},
+		{"function in its namespace", "env.staging", "env", ""},
+		{"function in another namespace", "env.openfaas-fn", "", "could not resolve: env.openfaas-fn"},
+		{"function in the default namespace", "figlet.openfaas-fn", "figlet", ""},
+		{"missing function", "nodeinfo.staging", "", "could not resolve: nodeinfo.staging"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			u, err := NewFunctionLookup(docker, false).Resolve(s.function)
+			if len(s.wantErr) > 0 {
+				if err == nil || err.Error() != s.wantErr {
+					t.Fatalf("expected resolver error `%s`, got `%v`", s.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected resolver error `%s`", err)
+			}
+			if u.Host != s.wantHost {
+				t.Errorf("expected url host `%s`, got `%s`", s.wantHost, u.Host)
+			}
+		})
+	}
+}
+
+func Test_ProxyURLResolver_RoundRobinNamespace(t *testing.T) {
+	env := swarm.Service{ID: "env"}
+	env.Spec.Name = "dnsrrTestFncExists"
+	env.Spec.Labels = map[string]string{NamespaceLabel: "staging"}
+
+	resolver := NewFunctionLookup(namespacedServiceLister{services: []swarm.Service{env}}, true)
+	resolver.dnsrrLookup = testDNSRRLookup
+
+	u, err := resolver.Resolve("dnsrrTestFncExists.staging")
+	if err != nil {
+		t.Fatalf("unexpected resolver error `%s`", err)
+	}
+	if u.Host != "0.0.0.0" {
+		t.Errorf("expected url host `%s`, got `%s`", "0.0.0.0", u.Host)
+	}
+
+	if _, err := resolver.Resolve("dnsrrTestFncExists.production"); err == nil {
+		t.Errorf("expected an error resolving a function in another namespace")
+	}
+}
+
 func Test_ProxyURLResolver_RoundRobingErrs(t *testing.T) {
 
 	scenarios := []struct {
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+// checkReplicaQuota returns an error when setting the replicas of a function would take the
+// desired replicas of its namespace over quota. Scaling a function down, or keeping its
+// replicas, is always allowed so a namespace over a lowered quota can recover. The check is
+// made before each operation, so concurrent deployments can go over the quota together. No
+// quota is applied when quota is zero.
+func checkReplicaQuota(c ServiceLister, quota uint64, functionLabel string, namespace string, service string, replicas uint64) error {
+	if quota == 0 {
+		return nil
+	}
+
+	services, err := readFunctionServices(c, functionLabel)
+	if err != nil {
+		return err
+	}
+
+	var used, current uint64
+	for _, s := range services {
+		if getNamespace(s.Spec.Labels) != namespace || s.Spec.Mode.Replicated == nil || s.Spec.Mode.Replicated.Replicas == nil {
+			continue
+		}
+
+		if s.Spec.Name == service {
+			current = *s.Spec.Mode.Replicated.Replicas
+			continue
+		}
+
+		used += *s.Spec.Mode.Replicated.Replicas
+	}
+
+	if replicas <= current || used+replicas <= quota {
+		return nil
+	}
+
+	return newDeployError(ErrCodeQuotaExceeded, "namespace %s: %d replicas of %s would exceed the replica quota of %d, %d are used by other functions", namespace, replicas, service, quota, used)
+}
+
+// checkFunctionCount returns an error when deploying service would take the number of
+// functions over max. A function which is already deployed is always allowed, so updates are
+// not blocked at capacity. No limit is applied when max is zero.
+func checkFunctionCount(c ServiceLister, max int, functionLabel string, service string) error {
+	if max <= 0 {
+		return nil
+	}
+
+	services, err := readFunctionServices(c, functionLabel)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range services {
+		if s.Spec.Name == service {
+			return nil
+		}
+	}
+
+	if len(services) < max {
+		return nil
+	}
+
+	return newDeployError(ErrCodeFunctionLimit, "%d functions are deployed, which is the maximum allowed by this provider, remove a function before deploying %s", len(services), service)
+}
+
+// quotaStatus returns the HTTP status for an error from checkReplicaQuota or
+// checkFunctionCount
+func quotaStatus(err error) int {
+	switch errorCode(err, "") {
+	case ErrCodeQuotaExceeded, ErrCodeFunctionLimit:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// requestNamespace returns the namespace a request deploys a function into
+func requestNamespace(request *typesv1.FunctionDeployment) string {
+	if len(request.Namespace) > 0 {
+		return request.Namespace
+	}
+
+	if request.Labels != nil {
+		return getNamespace(*request.Labels)
+	}
+
+	return DefaultNamespace
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func functionWithReplicas(name string, namespace string, replicas uint64) swarm.Service {
+	labels := map[string]string{}
+	if len(namespace) > 0 {
+		labels[NamespaceLabel] = namespace
+	}
+
+	service := labelledFunction(name, labels)
+	service.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	return service
+}
+
+func Test_CheckReplicaQuota(t *testing.T) {
+	c := fakeServiceAPIClient{
+		services: []swarm.Service{
+			functionWithReplicas("figlet", "", 3),
+			functionWithReplicas("nodeinfo", DefaultNamespace, 4),
+			functionWithReplicas("checkout", "staging", 8),
+		},
+	}
+
+	scenarios := []struct {
+		name      string
+		quota     uint64
+		namespace string
+		service   string
+		replicas  uint64
+		wantErr   bool
+	}{
+		{name: "no quota", quota: 0, namespace: DefaultNamespace, service: "env", replicas: 100},
+		{name: "new function under quota", quota: 10, namespace: DefaultNamespace, service: "env", replicas: 2},
+		{name: "new function at quota", quota: 10, namespace: DefaultNamespace, service: "env", replicas: 3},
+		{name: "new function over quota", quota: 10, namespace: DefaultNamespace, service: "env", replicas: 4, wantErr: true},
+		{name: "scale up under quota", quota: 10, namespace: DefaultNamespace, service: "figlet", replicas: 6},
+		{name: "scale up over quota", quota: 10, namespace: DefaultNamespace, service: "figlet", replicas: 7, wantErr: true},
+		{name: "other namespaces not counted", quota: 10, namespace: "staging", service: "checkout", replicas: 10},
+		{name: "scale down while over quota", quota: 5, namespace: "staging", service: "checkout", replicas: 6},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			err := checkReplicaQuota(c, s.quota, DefaultFunctionLabel, s.namespace, s.service, s.replicas)
+			if s.wantErr {
+				if code := errorCode(err, ""); code != ErrCodeQuotaExceeded {
+					t.Errorf("want: error code %s got: %v", ErrCodeQuotaExceeded, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("want: no error got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_DeployFunction_ReplicaQuota(t *testing.T) {
+	c := newFakeDeployClient()
+	c.services = []swarm.Service{functionWithReplicas("figlet", "staging", 4)}
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		ReplicaQuota:        5,
+	}
+
+	under := batchRequest("nodeinfo")
+	under.Namespace = "staging"
+	if status, _, err := deployFunction(c, config, &under, nil); status != http.StatusAccepted {
+		t.Errorf("want: %d got: %d %v", http.StatusAccepted, status, err)
+	}
+
+	over := batchRequest("env")
+	over.Namespace = "staging"
+	over.Labels = &map[string]string{MinScaleLabel: "2"}
+	status, _, err := deployFunction(c, config, &over, nil)
+	if status != http.StatusForbidden || errorCode(err, "") != ErrCodeQuotaExceeded {
+		t.Errorf("want: %d %s got: %d %v", http.StatusForbidden, ErrCodeQuotaExceeded, status, err)
+	}
+
+	if len(c.created) != 1 {
+		t.Errorf("want: only the function under quota created got: %v", c.created)
+	}
+}
+
+func Test_DeployHandler_MaxFunctions(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		service     string
+		max         int
+		wantStatus  int
+		wantCreated bool
+	}{
+		{name: "no limit", service: "env", max: 0, wantStatus: http.StatusAccepted, wantCreated: true},
+		{name: "under capacity", service: "env", max: 3, wantStatus: http.StatusAccepted, wantCreated: true},
+		{name: "at capacity", service: "env", max: 2, wantStatus: http.StatusForbidden},
+		{name: "existing function at capacity", service: "figlet", max: 2, wantStatus: http.StatusAccepted, wantCreated: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.services = []swarm.Service{
+				functionWithReplicas("figlet", "", 1),
+				functionWithReplicas("nodeinfo", "", 1),
+			}
+
+			body, _ := json.Marshal(batchRequest(s.service))
+			req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, MaxFunctions: s.max}).ServeHTTP(rr, req)
+
+			if rr.Code != s.wantStatus {
+				t.Errorf("want: %d got: %d %s", s.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if s.wantStatus == http.StatusForbidden {
+				deployErr := DeployError{}
+				if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil || deployErr.Code != ErrCodeFunctionLimit {
+					t.Errorf("want: code %s got: %q", ErrCodeFunctionLimit, rr.Body.String())
+				}
+			}
+
+			if created := len(c.created) == 1; created != s.wantCreated {
+				t.Errorf("want: created %t got: %v", s.wantCreated, c.created)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often the buckets of idle clients are removed
+const rateLimitSweepInterval = time.Minute
+
+// scaleFunctionPath is the prefix of the endpoint the gateway scales functions with. It is
+// not rate limited, so scaling from zero is never rejected during a burst of invocations.
+const scaleFunctionPath = "/system/scale-function/"
+
+// tokenBucket holds the requests a client can still send, refilled over time
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// ClientRateLimiter limits the write requests each client sends to the provider API with a
+// token bucket per client, so abusive automation can not overload the manager. A client can
+// send burst requests at once, then one more each time a token is added at rate. A nil
+// ClientRateLimiter does not limit anything.
+type ClientRateLimiter struct {
+	rate           float64
+	burst          float64
+	identityHeader string
+	trustedProxies []*net.IPNet
+	now            func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewClientRateLimiter returns a ClientRateLimiter allowing each client perMinute write
+// requests a minute with up to burst at once, or nil when perMinute is not positive. Clients
+// are identified by their IP address. A request from one of trustedProxies is identified by
+// its identityHeader instead, or by the client address the proxy added to X-Forwarded-For.
+func NewClientRateLimiter(perMinute int, burst int, identityHeader string, trustedProxies []*net.IPNet) *ClientRateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &ClientRateLimiter{
+		rate:           float64(perMinute) / time.Minute.Seconds(),
+		burst:          float64(burst),
+		identityHeader: identityHeader,
+		trustedProxies: trustedProxies,
+		now:            time.Now,
+		buckets:        map[string]*tokenBucket{},
+	}
+}
+
+// Allow takes a token from the bucket of client, when the bucket is empty it returns false
+// and how long until the next token is added
+func (l *ClientRateLimiter) Allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets which have refilled, a client starting again gets a full bucket
+// so they do not need to be kept
+func (l *ClientRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientID identifies the client of a request by the IP address it connected from. Only a
+// trusted proxy can name the client it forwards for, with the identity header or otherwise
+// with the last address in X-Forwarded-For which is not another trusted proxy, so clients
+// can not pick their own bucket.
+func (l *ClientRateLimiter) clientID(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !l.isTrustedProxy(remote) {
+		return remote
+	}
+
+	if len(l.identityHeader) > 0 {
+		if identity := r.Header.Get(l.identityHeader); len(identity) > 0 {
+			return identity
+		}
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if len(address) == 0 {
+			continue
+		}
+		if !l.isTrustedProxy(address) {
+			return address
+		}
+	}
+
+	return remote
+}
+
+// isTrustedProxy returns true when address is in one of the trusted proxy networks
+func (l *ClientRateLimiter) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware rejects the write requests of a client with 429 once it has used up its bucket,
+// Retry-After gives the seconds until it can send another. Scaling a function is not limited.
+func (l *ClientRateLimiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWriteRequest(r) || strings.HasPrefix(r.URL.Path, scaleFunctionPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wait := l.Allow(l.clientID(r))
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeText(w, http.StatusTooManyRequests, fmt.Sprintf("Too many requests, try again in %d seconds.", retryAfter))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
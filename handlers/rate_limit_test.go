@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_ClientRateLimiter_TriggersAfterBurst(t *testing.T) {
+	now := time.Now()
+	limiter := NewClientRateLimiter(60, 3, "X-Forwarded-User", nil)
+	limiter.now = func() time.Time { return now }
+
+	router := mux.NewRouter()
+	router.Use(limiter.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/system/functions", ok).Methods(http.MethodGet, http.MethodPost)
+
+	serve := func(method string, remoteAddr string, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/system/functions", nil)
+		req.RemoteAddr = remoteAddr
+		if len(user) > 0 {
+			req.Header.Set("X-Forwarded-User", user)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := serve(http.MethodPost, "10.0.0.1:5000", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d want: %d got: %d", i+1, http.StatusOK, rr.Code)
+		}
+	}
+
+	rr := serve(http.MethodPost, "10.0.0.1:5001", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want: %d after the burst got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("want: Retry-After %s got: %q", "1", retryAfter)
+	}
+
+	if rr := serve(http.MethodGet, "10.0.0.1:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("want: reads not limited got: %d", rr.Code)
+	}
+	if rr := serve(http.MethodPost, "10.0.0.2:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("want: other clients not limited got: %d", rr.Code)
+	}
+	if rr := serve(http.MethodPost, "10.0.0.1:5000", "alice"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want: identity header ignored from an untrusted client got: %d", rr.Code)
+	}
+
+	now = now.Add(time.Second)
+	if rr := serve(http.MethodPost, "10.0.0.1:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("want: a request allowed once a token is added got: %d", rr.Code)
+	}
+	if rr := serve(http.MethodPost, "10.0.0.1:5000", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want: %d got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
+
+func Test_NewClientRateLimiter_Disabled(t *testing.T) {
+	limiter := NewClientRateLimiter(0, 10, "", nil)
+	if limiter != nil {
+		t.Fatalf("want: no limiter got: %v", limiter)
+	}
+
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Errorf("want: a nil limiter to allow every request")
+	}
+}
+
+func Test_ClientRateLimiter_TrustedProxy(t *testing.T) {
+	_, gateway, _ := net.ParseCIDR("10.0.1.0/24")
+	limiter := NewClientRateLimiter(60, 1, "X-Forwarded-User", []*net.IPNet{gateway})
+	limiter.now = func() time.Time { return time.Unix(0, 0) }
+
+	serve := func(remoteAddr string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/system/functions", nil)
+		req.RemoteAddr = remoteAddr
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		rr := httptest.NewRecorder()
+		limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	scenarios := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"identity from the gateway", "10.0.1.5:4000", map[string]string{"X-Forwarded-User": "alice"}, http.StatusOK},
+		{"same identity from the gateway", "10.0.1.6:4000", map[string]string{"X-Forwarded-User": "alice"}, http.StatusTooManyRequests},
+		{"other identity from the gateway", "10.0.1.5:4000", map[string]string{"X-Forwarded-User": "bob"}, http.StatusOK},
+		{"forwarded client", "10.0.1.5:4000", map[string]string{"X-Forwarded-For": "192.168.0.7, 10.0.1.9"}, http.StatusOK},
+		{"same forwarded client", "10.0.1.5:4000", map[string]string{"X-Forwarded-For": "192.168.0.7"}, http.StatusTooManyRequests},
+		{"spoofed forwarded address", "10.0.1.5:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 192.168.0.8"}, http.StatusOK},
+		{"same client spoofing", "10.0.1.5:4000", map[string]string{"X-Forwarded-For": "5.6.7.8, 192.168.0.8"}, http.StatusTooManyRequests},
+		{"direct client", "192.168.0.9:4000", map[string]string{"X-Forwarded-User": "carol"}, http.StatusOK},
+		{"direct client rotating identity", "192.168.0.9:4000", map[string]string{"X-Forwarded-User": "dave"}, http.StatusTooManyRequests},
+	}
+
+	for _, s := range scenarios {
+		if status := serve(s.remoteAddr, s.headers); status != s.wantStatus {
+			t.Errorf("%s: want: %d got: %d", s.name, s.wantStatus, status)
+		}
+	}
+}
+
+func Test_ClientRateLimiter_ScaleFunctionNotLimited(t *testing.T) {
+	limiter := NewClientRateLimiter(60, 1, "", nil)
+	limiter.now = func() time.Time { return time.Unix(0, 0) }
+
+	router := mux.NewRouter()
+	router.Use(limiter.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/system/functions", ok).Methods(http.MethodPost)
+	router.HandleFunc("/system/scale-function/{name}", ok).Methods(http.MethodPost)
+
+	serve := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "10.0.1.5:4000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := serve("/system/functions"); status != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, status)
+	}
+	if status := serve("/system/functions"); status != http.StatusTooManyRequests {
+		t.Fatalf("want: %d after the burst got: %d", http.StatusTooManyRequests, status)
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := serve("/system/scale-function/figlet"); status != http.StatusOK {
+			t.Errorf("scale %d want: %d got: %d", i+1, http.StatusOK, status)
+		}
+	}
+}
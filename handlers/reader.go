@@ -6,37 +6,156 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	typesv1 "github.com/openfaas/faas-provider/types"
 )
 
-// FunctionReader reads functions from Swarm metadata
-func FunctionReader(wildcard bool, c client.ServiceAPIClient) http.HandlerFunc {
+// FunctionReader reads functions from Swarm metadata, listing the namespace in the
+// "namespace" query parameter or DefaultNamespace. Services are functions when they have
+// functionLabel, see isFunctionService.
+func FunctionReader(wildcard bool, c client.ServiceAPIClient, functionLabel string) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		functions, err := readServices(c)
+		namespace := r.FormValue("namespace")
+		if len(namespace) == 0 {
+			namespace = DefaultNamespace
+		}
+
+		if err := validateNamespace(namespace); err != nil {
+			writeText(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		functions, err := readServices(c, namespace, functionLabel)
 		if err != nil {
 			log.Printf("Error getting service list: %s\n", err.Error())
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			writeText(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
 		functionBytes, _ := json.Marshal(functions)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(functionBytes)
+		writeJSON(w, http.StatusOK, functionBytes)
 	}
 }
 
-func readServices(c client.ServiceAPIClient) ([]typesv1.FunctionStatus, error) {
-	functions := []typesv1.FunctionStatus{}
+// FunctionStatusUnknown is the status of a listed function whose tasks could not be read,
+// its available replicas are reported as 0
+const FunctionStatusUnknown = "unknown"
+
+// FunctionSummary is a function in the function listing
+type FunctionSummary struct {
+	typesv1.FunctionStatus
+	ScaleBounds
+
+	// Status is FunctionStatusUnknown when the tasks of the function could not be read,
+	// otherwise it is omitted
+	Status string `json:"status,omitempty"`
+}
+
+// ScaleBounds is the scaling range of a function, read from its scale labels
+type ScaleBounds struct {
+	// MinReplicas is read from MinScaleLabel, 1 when not set
+	MinReplicas uint64 `json:"minReplicas"`
+
+	// MaxReplicas is read from MaxScaleLabel, it is omitted when the function has no upper bound
+	MaxReplicas *uint64 `json:"maxReplicas,omitempty"`
+}
+
+func readServices(c client.ServiceAPIClient, namespace string, functionLabel string) ([]FunctionSummary, error) {
+	functions := []FunctionSummary{}
+
+	services, err := readFunctionServices(c, functionLabel)
+	if err != nil {
+		return functions, err
+	}
+
+	for _, service := range services {
+		if getNamespace(service.Spec.Labels) != namespace {
+			continue
+		}
+
+		function := FunctionSummary{
+			FunctionStatus: toFunctionStatus(service),
+			ScaleBounds:    getScaleBounds(service.Spec.Labels),
+		}
+
+		// one broken function should not fail the whole listing
+		replicas, err := getAvailableReplicas(c, service.Spec.Name)
+		if err != nil {
+			log.Printf("Error reading tasks of %s: %s\n", service.Spec.Name, err)
+			function.Status = FunctionStatusUnknown
+		}
+		function.AvailableReplicas = replicas
+
+		functions = append(functions, function)
+	}
+
+	return functions, nil
+}
+
+// getScaleBounds parses the scale labels of a function, invalid values are ignored
+// in the same way as when scaling
+func getScaleBounds(labels map[string]string) ScaleBounds {
+	bounds := ScaleBounds{
+		MinReplicas: 1,
+	}
+
+	if value, err := strconv.ParseUint(labels[MinScaleLabel], 10, 64); err == nil {
+		bounds.MinReplicas = value
+	}
+
+	if value, err := strconv.ParseUint(labels[MaxScaleLabel], 10, 64); err == nil {
+		bounds.MaxReplicas = &value
+	}
+
+	return bounds
+}
+
+// DefaultFunctionLabel is the label holding the name of a function, which identifies the
+// services deployed as functions
+const DefaultFunctionLabel = "com.openfaas.function"
+
+// legacyFunctionLabel is set to "true" on the functions deployed with DefaultFunctionLabel,
+// it identifies the functions deployed before DefaultFunctionLabel was added
+const legacyFunctionLabel = "function"
+
+// functionLabelKey returns functionLabel, or DefaultFunctionLabel when it is empty
+func functionLabelKey(functionLabel string) string {
+	if len(functionLabel) == 0 {
+		return DefaultFunctionLabel
+	}
+
+	return functionLabel
+}
+
+// isFunctionService returns true when the tasks of service have the function label. The
+// legacy function label is only checked with DefaultFunctionLabel, so that providers with
+// their own function label do not claim each other's functions.
+func isFunctionService(service swarm.Service, functionLabel string) bool {
+	if service.Spec.TaskTemplate.ContainerSpec == nil {
+		return false
+	}
+
+	functionLabel = functionLabelKey(functionLabel)
+
+	labels := service.Spec.TaskTemplate.ContainerSpec.Labels
+	if len(labels[functionLabel]) > 0 {
+		return true
+	}
+
+	return functionLabel == DefaultFunctionLabel && len(labels[legacyFunctionLabel]) > 0
+}
+
+// readFunctionServices lists the Swarm services which are OpenFaaS functions
+func readFunctionServices(c ServiceLister, functionLabel string) ([]swarm.Service, error) {
 	serviceFilter := filters.NewArgs()
 
 	options := types.ServiceListOptions{
@@ -45,32 +164,35 @@ func readServices(c client.ServiceAPIClient) ([]typesv1.FunctionStatus, error) {
 
 	services, err := c.ServiceList(context.Background(), options)
 	if err != nil {
-		return functions, fmt.Errorf("error getting service list: %s", err.Error())
+		return nil, fmt.Errorf("error getting service list: %s", err.Error())
 	}
 
+	functions := []swarm.Service{}
 	for _, service := range services {
-
-		if len(service.Spec.TaskTemplate.ContainerSpec.Labels["function"]) > 0 {
-			envProcess := getEnvProcess(service.Spec.TaskTemplate.ContainerSpec.Env)
-
-			// Required (copy by value)
-			labels, annotations := buildLabelsAndAnnotations(service.Spec.Labels)
-
-			f := typesv1.FunctionStatus{
-				Name:            service.Spec.Name,
-				Image:           service.Spec.TaskTemplate.ContainerSpec.Image,
-				InvocationCount: 0,
-				Replicas:        *service.Spec.Mode.Replicated.Replicas,
-				EnvProcess:      envProcess,
-				Labels:          &labels,
-				Annotations:     &annotations,
-			}
-
-			functions = append(functions, f)
+		if isFunctionService(service, functionLabel) {
+			functions = append(functions, service)
 		}
 	}
 
-	return functions, err
+	return functions, nil
+}
+
+func toFunctionStatus(service swarm.Service) typesv1.FunctionStatus {
+	envProcess := getEnvProcess(service.Spec.TaskTemplate.ContainerSpec.Env)
+
+	// Required (copy by value)
+	labels, annotations := buildLabelsAndAnnotations(service.Spec.Labels)
+
+	return typesv1.FunctionStatus{
+		Name:            service.Spec.Name,
+		Namespace:       service.Spec.Labels[NamespaceLabel],
+		Image:           service.Spec.TaskTemplate.ContainerSpec.Image,
+		InvocationCount: 0,
+		Replicas:        *service.Spec.Mode.Replicated.Replicas,
+		EnvProcess:      envProcess,
+		Labels:          &labels,
+		Annotations:     &annotations,
+	}
 }
 
 func getEnvProcess(envVars []string) string {
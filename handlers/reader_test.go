@@ -1,10 +1,48 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
 	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	typesv1 "github.com/openfaas/faas-provider/types"
 )
 
+// fakeServiceAPIClient lists services and their tasks, the other ServiceAPIClient methods
+// are not used by the function reader
+type fakeServiceAPIClient struct {
+	client.ServiceAPIClient
+
+	services []swarm.Service
+	tasks    map[string][]swarm.Task
+
+	// taskErrors are returned when listing the tasks of a service
+	taskErrors map[string]error
+}
+
+func (f fakeServiceAPIClient) ServiceList(ctx context.Context, options types.ServiceListOptions) ([]swarm.Service, error) {
+	return f.services, nil
+}
+
+func (f fakeServiceAPIClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	for _, service := range options.Filters.Get("service") {
+		if err := f.taskErrors[service]; err != nil {
+			return nil, err
+		}
+		return f.tasks[service], nil
+	}
+
+	return nil, nil
+}
+
 func Test_BuildLabelsAndAnnotationsFromServiceSpec_NoLabels(t *testing.T) {
 	container := make(map[string]string)
 
@@ -61,3 +99,230 @@ func Test_BuildLabelsAndAnnotationsFromServiceSpec_Annotations(t *testing.T) {
 		t.Errorf("want: '%s' entry in annotation map got: key not found", "current-time")
 	}
 }
+
+func Test_FunctionReader_PrometheusAnnotationsRoundTrip(t *testing.T) {
+	annotations := map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   "8081",
+		"prometheus.io/path":   "/metrics",
+	}
+
+	request := &typesv1.FunctionDeployment{
+		Service:     "figlet",
+		Image:       "functions/figlet:latest",
+		Annotations: &annotations,
+	}
+
+	spec, err := makeSpec(request, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	for k, v := range annotations {
+		if got := spec.Labels[annotationLabelPrefix+k]; got != v {
+			t.Errorf("want: label %s%s=%s got: %q", annotationLabelPrefix, k, v, got)
+		}
+	}
+
+	c := fakeServiceAPIClient{
+		services: []swarm.Service{{Spec: spec}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	rr := httptest.NewRecorder()
+	FunctionReader(true, c, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+	}
+
+	functions := []FunctionSummary{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	if len(functions) != 1 || functions[0].Annotations == nil {
+		t.Fatalf("want: %d function with annotations got: %+v", 1, functions)
+	}
+
+	if got := *functions[0].Annotations; !reflect.DeepEqual(got, annotations) {
+		t.Errorf("want: %v got: %v", annotations, got)
+	}
+
+	for k := range annotations {
+		if _, exists := (*functions[0].Labels)[annotationLabelPrefix+k]; exists {
+			t.Errorf("want: annotation %s not listed in labels got: %v", k, *functions[0].Labels)
+		}
+	}
+}
+
+func Test_FunctionReader_TaskListError(t *testing.T) {
+	replicas := uint64(2)
+	services := []swarm.Service{}
+	for _, name := range []string{"figlet", "nodeinfo", "env"} {
+		service := labelledFunction(name, nil)
+		service.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+		services = append(services, service)
+	}
+
+	c := fakeServiceAPIClient{
+		services: services,
+		tasks: map[string][]swarm.Task{
+			"figlet":   {runningTask("figlet-1"), runningTask("figlet-2")},
+			"nodeinfo": {runningTask("nodeinfo-1")},
+		},
+		taskErrors: map[string]error{
+			"env": fmt.Errorf("rpc error: code = Unavailable"),
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	rr := httptest.NewRecorder()
+	FunctionReader(true, c, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	functions := []FunctionSummary{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+	}
+
+	if len(functions) != 3 {
+		t.Fatalf("want: %d functions got: %d", 3, len(functions))
+	}
+
+	want := map[string]struct {
+		available uint64
+		status    string
+	}{
+		"figlet":   {available: 2, status: ""},
+		"nodeinfo": {available: 1, status: ""},
+		"env":      {available: 0, status: FunctionStatusUnknown},
+	}
+
+	for _, function := range functions {
+		w := want[function.Name]
+		if function.AvailableReplicas != w.available {
+			t.Errorf("%s want: %d available replicas got: %d", function.Name, w.available, function.AvailableReplicas)
+		}
+		if function.Status != w.status {
+			t.Errorf("%s want: status %q got: %q", function.Name, w.status, function.Status)
+		}
+	}
+}
+
+func Test_FunctionReader_Namespace(t *testing.T) {
+	replicas := uint64(1)
+	services := []swarm.Service{}
+	for name, namespace := range map[string]string{"figlet": "", "nodeinfo": DefaultNamespace, "checkout": "staging", "refunds": "staging"} {
+		labels := map[string]string{}
+		if len(namespace) > 0 {
+			labels[NamespaceLabel] = namespace
+		}
+		service := labelledFunction(name, labels)
+		service.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+		services = append(services, service)
+	}
+
+	c := fakeServiceAPIClient{services: services}
+
+	scenarios := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "default namespace", query: "", want: []string{"figlet", "nodeinfo"}},
+		{name: "default namespace by name", query: "?namespace=" + DefaultNamespace, want: []string{"figlet", "nodeinfo"}},
+		{name: "populated namespace", query: "?namespace=staging", want: []string{"checkout", "refunds"}},
+		{name: "empty namespace", query: "?namespace=production", want: []string{}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/system/functions"+s.query, nil)
+			rr := httptest.NewRecorder()
+			FunctionReader(true, c, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want: %d got: %d %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			functions := []FunctionSummary{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+				t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+			}
+
+			names := []string{}
+			for _, function := range functions {
+				names = append(names, function.Name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, s.want) {
+				t.Errorf("want: %v got: %v", s.want, names)
+			}
+		})
+	}
+}
+
+func Test_FunctionReader_InvalidNamespace(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/system/functions?namespace=Not_Valid", nil)
+	rr := httptest.NewRecorder()
+	FunctionReader(true, fakeServiceAPIClient{}, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d got: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func Test_FunctionReader_CustomFunctionLabel(t *testing.T) {
+	const functionLabel = "io.example.function"
+
+	c := newFakeDeployClient()
+	request := batchRequest("figlet")
+	status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, FunctionLabel: functionLabel}, &request, nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %v", http.StatusAccepted, status, err)
+	}
+
+	spec := c.specs[0]
+	if got := spec.TaskTemplate.ContainerSpec.Labels[functionLabel]; got != "figlet" {
+		t.Errorf("want: label %s=%s got: %q", functionLabel, "figlet", got)
+	}
+	for _, label := range []string{DefaultFunctionLabel, legacyFunctionLabel} {
+		if _, exists := spec.TaskTemplate.ContainerSpec.Labels[label]; exists {
+			t.Errorf("want: no label %s got: %v", label, spec.TaskTemplate.ContainerSpec.Labels)
+		}
+	}
+
+	other := swarm.ServiceSpec{}
+	other.Name = "nginx"
+	other.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Labels: map[string]string{DefaultFunctionLabel: "nginx"},
+	}
+
+	legacy := swarm.ServiceSpec{}
+	legacy.Name = "env"
+	legacy.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Labels: map[string]string{legacyFunctionLabel: "true"},
+	}
+
+	reader := fakeServiceAPIClient{
+		services: []swarm.Service{{Spec: spec}, {Spec: other}, {Spec: legacy}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	rr := httptest.NewRecorder()
+	FunctionReader(true, reader, functionLabel).ServeHTTP(rr, req)
+
+	functions := []FunctionSummary{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("want: function list got: %q", rr.Body.String())
+	}
+
+	if len(functions) != 1 || functions[0].Name != "figlet" {
+		t.Errorf("want: only %s listed got: %v", "figlet", functions)
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/swarm"
@@ -16,8 +20,76 @@ import (
 	typesv1 "github.com/openfaas/faas-provider/types"
 )
 
-// ReplicaReader reads replica and image status data from a function
-func ReplicaReader(c *client.Client) http.HandlerFunc {
+// FunctionDetail extends the function status with Swarm specific detail
+type FunctionDetail struct {
+	typesv1.FunctionStatus
+
+	// ImageDigest is the content digest Swarm pinned the image to, if any
+	ImageDigest string `json:"imageDigest,omitempty"`
+
+	// EnvVars are the environment variables of the function, excluding fprocess
+	EnvVars map[string]string `json:"envVars,omitempty"`
+
+	// InvocationURL is the path of the function on the gateway
+	InvocationURL string `json:"invocationUrl"`
+
+	// RunningSince is when the oldest running task of the function started, the uptime of
+	// the function is the time since. It is null when no task is running.
+	RunningSince *time.Time `json:"runningSince"`
+
+	// Tasks are the current and recent tasks of the function, newest first, only listed
+	// with ?tasks=true
+	Tasks []FunctionTask `json:"tasks,omitempty"`
+
+	// Secrets are the names of the secrets the function mounts, a secret which no longer
+	// exists is listed by its ID followed by deletedSecretMarker
+	Secrets []string `json:"secrets,omitempty"`
+
+	ScaleBounds
+}
+
+// FunctionTask is a task of a function, with the reason it failed if any
+type FunctionTask struct {
+	ID           string    `json:"id"`
+	NodeID       string    `json:"nodeId,omitempty"`
+	State        string    `json:"state"`
+	DesiredState string    `json:"desiredState"`
+	Timestamp    time.Time `json:"timestamp"`
+
+	// Error is the error Swarm recorded for the task, such as a non-zero exit
+	Error string `json:"error,omitempty"`
+
+	// ExitCode is the exit code of the container, omitted when the task has no container
+	ExitCode *int `json:"exitCode,omitempty"`
+}
+
+// functionPathPrefix is the gateway path functions are invoked under
+const functionPathPrefix = "/function/"
+
+// invocationURL returns the path of a function relative to the gateway, a function outside
+// of DefaultNamespace is invoked as <name>.<namespace>
+func invocationURL(name string, namespace string) string {
+	if len(namespace) == 0 || namespace == DefaultNamespace {
+		return functionPathPrefix + name
+	}
+
+	return functionPathPrefix + name + "." + namespace
+}
+
+// deletedSecretMarker follows the ID of a secret reference whose secret was removed
+const deletedSecretMarker = " (deleted)"
+
+// SecretInspector is the subset of Docker Client methods required to read a secret by ID
+type SecretInspector interface {
+	SecretInspectWithRaw(ctx context.Context, id string) (swarm.Secret, []byte, error)
+}
+
+// redactedValue replaces environment variable values when redaction is enabled
+const redactedValue = "<redacted>"
+
+// ReplicaReader reads replica and image status data from a function, when
+// redactEnvVars is set the values of environment variables are hidden
+func ReplicaReader(c *client.Client, redactEnvVars bool, functionLabel string) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -25,17 +97,18 @@ func ReplicaReader(c *client.Client) http.HandlerFunc {
 
 		log.Printf("ReplicaReader - reading function: %s\n", functionName)
 
-		functions, err := readServices(c)
+		services, err := readFunctionServices(c, functionLabel)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			writeText(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		var found *typesv1.FunctionStatus
-		for _, function := range functions {
-			if function.Name == functionName {
-				found = &function
+		var found *FunctionDetail
+		var secrets []*swarm.SecretReference
+		for _, service := range services {
+			if service.Spec.Name == functionName {
+				found = toFunctionDetail(service, redactEnvVars)
+				secrets = service.Spec.TaskTemplate.ContainerSpec.Secrets
 				break
 			}
 		}
@@ -54,14 +127,81 @@ func ReplicaReader(c *client.Client) http.HandlerFunc {
 
 		found.AvailableReplicas = replicas
 
+		runningSince, err := getRunningSince(c, found.Name)
+		if err != nil {
+			log.Printf("%s\n", err.Error())
+		}
+		found.RunningSince = runningSince
+
+		found.Secrets = getSecretNames(c, secrets)
+
+		if r.URL.Query().Get("tasks") == "true" {
+			tasks, err := getFunctionTasks(c, found.Name)
+			if err != nil {
+				log.Printf("%s\n", err.Error())
+			}
+			found.Tasks = tasks
+		}
+
 		functionBytes, _ := json.Marshal(found)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(200)
-		w.Write(functionBytes)
+		writeJSON(w, 200, functionBytes)
+	}
+}
+
+func toFunctionDetail(service swarm.Service, redactEnvVars bool) *FunctionDetail {
+	function := toFunctionStatus(service)
+	envProcess, envVars := parseEnv(service.Spec.TaskTemplate.ContainerSpec.Env)
+
+	if redactEnvVars {
+		for k := range envVars {
+			envVars[k] = redactedValue
+		}
+	}
+
+	function.EnvProcess = envProcess
+
+	return &FunctionDetail{
+		FunctionStatus: function,
+		ImageDigest:    parseImageDigest(function.Image),
+		EnvVars:        envVars,
+		InvocationURL:  invocationURL(function.Name, function.Namespace),
+		ScaleBounds:    getScaleBounds(service.Spec.Labels),
+	}
+}
+
+// parseEnv splits a container's KEY=value environment on the first "=", returning the
+// fprocess value separately from the other variables
+func parseEnv(env []string) (string, map[string]string) {
+	var envProcess string
+	var envVars map[string]string
+
+	for _, entry := range env {
+		parts := strings.SplitN(entry, "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+
+		if parts[0] == "fprocess" {
+			envProcess = value
+			continue
+		}
+
+		if envVars == nil {
+			envVars = make(map[string]string)
+		}
+		envVars[parts[0]] = value
 	}
+
+	return envProcess, envVars
 }
 
-func getAvailableReplicas(c *client.Client, service string) (uint64, error) {
+// TaskLister is the subset of Docker Client methods required to count the running tasks of a service
+type TaskLister interface {
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+}
+
+func getAvailableReplicas(c TaskLister, service string) (uint64, error) {
 
 	taskFilter := filters.NewArgs()
 	taskFilter.Add("_up-to-date", "true")
@@ -82,3 +222,126 @@ func getAvailableReplicas(c *client.Client, service string) (uint64, error) {
 
 	return replicas, nil
 }
+
+// getRunningSince returns when the oldest running task of a service started, or nil when
+// none is running
+func getRunningSince(c TaskLister, service string) (*time.Time, error) {
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", service)
+	taskFilter.Add("desired-state", "running")
+
+	tasks, err := c.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return nil, fmt.Errorf("getRunningSince for: %s failed %s", service, err.Error())
+	}
+
+	oldest := oldestRunningTask(tasks)
+	if oldest == nil {
+		return nil, nil
+	}
+
+	started := taskStartedAt(*oldest)
+	return &started, nil
+}
+
+// getSecretNames returns the current name of each secret a function references, the values
+// are never read. The name recorded in the reference is used when the secret can not be
+// inspected.
+func getSecretNames(c SecretInspector, references []*swarm.SecretReference) []string {
+	names := []string{}
+	for _, reference := range references {
+		secret, _, err := c.SecretInspectWithRaw(context.Background(), reference.SecretID)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				names = append(names, reference.SecretID+deletedSecretMarker)
+				continue
+			}
+
+			log.Printf("Error inspecting secret %s: %s\n", reference.SecretName, err)
+			names = append(names, reference.SecretName)
+			continue
+		}
+
+		names = append(names, secret.Spec.Name)
+	}
+
+	return names
+}
+
+// getFunctionTasks lists the tasks of a service which Swarm still holds, including the
+// stopped tasks kept in its task history, newest first
+func getFunctionTasks(c TaskLister, service string) ([]FunctionTask, error) {
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", service)
+
+	tasks, err := c.TaskList(context.Background(), types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return nil, fmt.Errorf("getFunctionTasks for: %s failed %s", service, err.Error())
+	}
+
+	functionTasks := make([]FunctionTask, 0, len(tasks))
+	for _, task := range tasks {
+		functionTask := FunctionTask{
+			ID:           task.ID,
+			NodeID:       task.NodeID,
+			State:        string(task.Status.State),
+			DesiredState: string(task.DesiredState),
+			Timestamp:    task.Status.Timestamp,
+			Error:        task.Status.Err,
+		}
+
+		if task.Status.ContainerStatus != nil && len(task.Status.ContainerStatus.ContainerID) > 0 {
+			exitCode := task.Status.ContainerStatus.ExitCode
+			functionTask.ExitCode = &exitCode
+		}
+
+		functionTasks = append(functionTasks, functionTask)
+	}
+
+	sort.SliceStable(functionTasks, func(i, j int) bool {
+		return functionTasks[i].Timestamp.After(functionTasks[j].Timestamp)
+	})
+
+	return functionTasks, nil
+}
+
+// oldestRunningTask returns the running task which started first, or nil when none is running
+func oldestRunningTask(tasks []swarm.Task) *swarm.Task {
+	var oldest *swarm.Task
+	for i, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning {
+			continue
+		}
+
+		if oldest == nil || taskStartedAt(task).Before(taskStartedAt(*oldest)) {
+			oldest = &tasks[i]
+		}
+	}
+
+	return oldest
+}
+
+// taskStartedAt returns when a running task started, the status timestamp is when the task
+// entered the running state
+func taskStartedAt(task swarm.Task) time.Time {
+	if task.Status.Timestamp.IsZero() {
+		return task.CreatedAt
+	}
+
+	return task.Status.Timestamp
+}
+
+// parseImageDigest returns the digest from an image reference such as
+// functions/figlet:latest@sha256:<hex>, or an empty string when it has none
+func parseImageDigest(image string) string {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return ""
+	}
+
+	if digested, ok := ref.(reference.Digested); ok {
+		return digested.Digest().String()
+	}
+
+	return ""
+}
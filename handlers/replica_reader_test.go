@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func Test_ParseImageDigest(t *testing.T) {
+	digest := "sha256:4bd5e5e3e6b8ce3b2d31f4f1de8c4b8b7f6e10a2c3d8c0e7b44a1a37b7f2d1e0"
+
+	scenarios := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{"tag and digest", "functions/figlet:latest@" + digest, digest},
+		{"digest only", "functions/figlet@" + digest, digest},
+		{"registry with port", "registry.local:5000/figlet:0.1@" + digest, digest},
+		{"tag only", "functions/figlet:latest", ""},
+		{"invalid reference", "functions/FIGLET", ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got := parseImageDigest(s.image)
+			if got != s.want {
+				t.Errorf("want: '%s' got: '%s'", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseEnv(t *testing.T) {
+	env := []string{
+		"fprocess=python index.py",
+		"write_timeout=10s",
+		"DSN=postgres://user:pass@db/app?sslmode=disable",
+		"EMPTY=",
+	}
+
+	envProcess, envVars := parseEnv(env)
+
+	if envProcess != "python index.py" {
+		t.Errorf("want: envProcess '%s' got: '%s'", "python index.py", envProcess)
+	}
+
+	want := map[string]string{
+		"write_timeout": "10s",
+		"DSN":           "postgres://user:pass@db/app?sslmode=disable",
+		"EMPTY":         "",
+	}
+
+	if len(envVars) != len(want) {
+		t.Fatalf("want: %d env vars got: %d %v", len(want), len(envVars), envVars)
+	}
+
+	for k, v := range want {
+		if got, ok := envVars[k]; !ok || got != v {
+			t.Errorf("want: %s='%s' got: '%s'", k, v, got)
+		}
+	}
+
+	if _, ok := envVars["fprocess"]; ok {
+		t.Errorf("want: fprocess to be excluded from envVars")
+	}
+}
+
+func Test_ToFunctionDetail_RedactsEnvVars(t *testing.T) {
+	replicas := uint64(1)
+	service := swarm.Service{
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Name: "figlet"},
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{
+					Image: "functions/figlet:latest",
+					Env:   []string{"fprocess=figlet", "API_KEY=s3cr3t"},
+				},
+			},
+			Mode: swarm.ServiceMode{
+				Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+			},
+		},
+	}
+
+	detail := toFunctionDetail(service, true)
+
+	if detail.EnvProcess != "figlet" {
+		t.Errorf("want: envProcess '%s' got: '%s'", "figlet", detail.EnvProcess)
+	}
+
+	if got := detail.EnvVars["API_KEY"]; got != redactedValue {
+		t.Errorf("want: API_KEY to be redacted got: '%s'", got)
+	}
+}
+
+func Test_ToFunctionDetail_ScaleBounds(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		labels  map[string]string
+		wantMin uint64
+		wantMax *uint64
+	}{
+		{
+			name:    "labels absent",
+			labels:  map[string]string{"function": "true"},
+			wantMin: 1,
+			wantMax: nil,
+		},
+		{
+			name:    "labels present",
+			labels:  map[string]string{MinScaleLabel: "2", MaxScaleLabel: "10"},
+			wantMin: 2,
+			wantMax: uint64Ptr(10),
+		},
+		{
+			name:    "invalid values ignored",
+			labels:  map[string]string{MinScaleLabel: "two", MaxScaleLabel: "-1"},
+			wantMin: 1,
+			wantMax: nil,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			replicas := uint64(1)
+			service := swarm.Service{
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "figlet", Labels: s.labels},
+					TaskTemplate: swarm.TaskSpec{
+						ContainerSpec: &swarm.ContainerSpec{Image: "functions/figlet:latest"},
+					},
+					Mode: swarm.ServiceMode{
+						Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+					},
+				},
+			}
+
+			detail := toFunctionDetail(service, false)
+
+			if detail.MinReplicas != s.wantMin {
+				t.Errorf("want: minReplicas %d got: %d", s.wantMin, detail.MinReplicas)
+			}
+
+			if (s.wantMax == nil) != (detail.MaxReplicas == nil) || (s.wantMax != nil && *s.wantMax != *detail.MaxReplicas) {
+				t.Errorf("want: maxReplicas %v got: %v", s.wantMax, detail.MaxReplicas)
+			}
+		})
+	}
+}
+
+func uint64Ptr(value uint64) *uint64 {
+	return &value
+}
+
+func Test_InvocationURL(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		function  string
+		namespace string
+		want      string
+	}{
+		{"no namespace", "figlet", "", "/function/figlet"},
+		{"default namespace", "figlet", DefaultNamespace, "/function/figlet"},
+		{"namespace", "figlet", "staging", "/function/figlet.staging"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := invocationURL(s.function, s.namespace); got != s.want {
+				t.Errorf("want: '%s' got: '%s'", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ToFunctionDetail_InvocationURL(t *testing.T) {
+	replicas := uint64(1)
+	service := swarm.Service{
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Name: "figlet"},
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{Image: "functions/figlet:latest"},
+			},
+			Mode: swarm.ServiceMode{
+				Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+			},
+		},
+	}
+
+	detail := toFunctionDetail(service, false)
+
+	if want := "/function/figlet"; detail.InvocationURL != want {
+		t.Errorf("want: invocationUrl '%s' got: '%s'", want, detail.InvocationURL)
+	}
+}
+
+func Test_GetRunningSince(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	startedAt := func(state swarm.TaskState, started time.Time, created time.Time) swarm.Task {
+		task := swarm.Task{Status: swarm.TaskStatus{State: state, Timestamp: started}}
+		task.CreatedAt = created
+		return task
+	}
+
+	scenarios := []struct {
+		name  string
+		tasks []swarm.Task
+		want  *time.Time
+	}{
+		{
+			name: "oldest running task",
+			tasks: []swarm.Task{
+				startedAt(swarm.TaskStateRunning, now.Add(-time.Minute), now.Add(-2*time.Minute)),
+				startedAt(swarm.TaskStateRunning, now.Add(-time.Hour), now.Add(-2*time.Hour)),
+				startedAt(swarm.TaskStateRunning, now.Add(-10*time.Minute), now.Add(-11*time.Minute)),
+			},
+			want: timePtr(now.Add(-time.Hour)),
+		},
+		{
+			name: "tasks not running are ignored",
+			tasks: []swarm.Task{
+				startedAt(swarm.TaskStatePreparing, now.Add(-2*time.Hour), now.Add(-2*time.Hour)),
+				startedAt(swarm.TaskStateRunning, now.Add(-time.Minute), now.Add(-2*time.Minute)),
+			},
+			want: timePtr(now.Add(-time.Minute)),
+		},
+		{
+			name: "created at when there is no status timestamp",
+			tasks: []swarm.Task{
+				startedAt(swarm.TaskStateRunning, time.Time{}, now.Add(-3*time.Hour)),
+				startedAt(swarm.TaskStateRunning, now.Add(-time.Hour), now.Add(-2*time.Hour)),
+			},
+			want: timePtr(now.Add(-3 * time.Hour)),
+		},
+		{
+			name:  "no running tasks",
+			tasks: []swarm.Task{startedAt(swarm.TaskStatePending, time.Time{}, now)},
+			want:  nil,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := fakeServiceAPIClient{tasks: map[string][]swarm.Task{"figlet": s.tasks}}
+
+			got, err := getRunningSince(c, "figlet")
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if (s.want == nil) != (got == nil) || (s.want != nil && !s.want.Equal(*got)) {
+				t.Errorf("want: %v got: %v", s.want, got)
+			}
+		})
+	}
+}
+
+func timePtr(value time.Time) *time.Time {
+	return &value
+}
+
+func Test_GetFunctionTasks(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	failed := swarm.Task{
+		ID:           "task-failed",
+		NodeID:       "node1",
+		DesiredState: swarm.TaskStateShutdown,
+		Status: swarm.TaskStatus{
+			State:           swarm.TaskStateFailed,
+			Timestamp:       now.Add(-time.Minute),
+			Err:             "task: non-zero exit (137)",
+			ContainerStatus: &swarm.ContainerStatus{ContainerID: "c1", ExitCode: 137},
+		},
+	}
+	pending := swarm.Task{
+		ID:           "task-pending",
+		DesiredState: swarm.TaskStateRunning,
+		Status: swarm.TaskStatus{
+			State:     swarm.TaskStatePending,
+			Timestamp: now,
+			Err:       "no suitable node (insufficient resources on 3 nodes)",
+		},
+	}
+
+	c := fakeServiceAPIClient{tasks: map[string][]swarm.Task{"figlet": {failed, pending}}}
+
+	tasks, err := getFunctionTasks(c, "figlet")
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if len(tasks) != 2 {
+		t.Fatalf("want: %d tasks got: %d", 2, len(tasks))
+	}
+
+	if tasks[0].ID != "task-pending" || tasks[0].ExitCode != nil || tasks[0].Error != pending.Status.Err {
+		t.Errorf("want: pending task first with its error and no exit code got: %+v", tasks[0])
+	}
+
+	got := tasks[1]
+	if got.State != "failed" || got.DesiredState != "shutdown" || got.NodeID != "node1" {
+		t.Errorf("want: failed task on node1 got: %+v", got)
+	}
+	if got.Error != failed.Status.Err {
+		t.Errorf("want: error %q got: %q", failed.Status.Err, got.Error)
+	}
+	if got.ExitCode == nil || *got.ExitCode != 137 {
+		t.Errorf("want: exit code %d got: %v", 137, got.ExitCode)
+	}
+}
+
+func Test_GetSecretNames(t *testing.T) {
+	c := newFakeDockerSecretAPIClient()
+	renamed := c.secrets["foo"]
+	renamed.Spec.Name = "foo-v2"
+	c.secrets["foo"] = renamed
+
+	references := []*swarm.SecretReference{
+		{SecretID: "foo", SecretName: "foo"},
+		{SecretID: "foobar", SecretName: "foobar"},
+		{SecretID: "removed-id", SecretName: "removed"},
+	}
+
+	want := []string{"foo-v2", "foobar", "removed-id" + deletedSecretMarker}
+	if got := getSecretNames(&c, references); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v got: %v", want, got)
+	}
+}
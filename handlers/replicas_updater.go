@@ -3,12 +3,15 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/gorilla/mux"
 )
@@ -19,8 +22,17 @@ type ScaleServiceRequest struct {
 	Replicas    uint64 `json:"replicas"`
 }
 
-// ReplicaUpdater updates a function
-func ReplicaUpdater(c *client.Client) http.HandlerFunc {
+// ReplicaUpdaterClient is the subset of Docker Client methods required to scale a function
+// within the replica quota of its namespace
+type ReplicaUpdaterClient interface {
+	ServiceScaler
+	ServiceLister
+}
+
+// ReplicaUpdater updates a function, scaling up is rejected when it would take the replicas
+// of the function's namespace over replicaQuota. Functions are counted by functionLabel.
+// Each successful scale is sent to webhook.
+func ReplicaUpdater(c ReplicaUpdaterClient, replicaQuota uint64, functionLabel string, webhook *ScaleWebhook) http.HandlerFunc {
 	serviceQuery := NewSwarmServiceQuery(c)
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -42,22 +54,53 @@ func ReplicaUpdater(c *client.Client) http.HandlerFunc {
 
 				log.Println(msg, marshalErr)
 
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(msg))
+				writeText(w, http.StatusBadRequest, msg)
+				return
+			}
+		}
+
+		if replicaQuota > 0 {
+			service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+			if err != nil {
+				if client.IsErrNotFound(err) {
+					writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+					return
+				}
+
+				log.Printf("Error inspecting service %s: %s\n", functionName, err)
+				writeText(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			err = checkReplicaQuota(c, replicaQuota, functionLabel, getNamespace(service.Spec.Labels), functionName, req.Replicas)
+			if err != nil {
+				log.Printf("Error checking the replica quota for %s: %s\n", functionName, err)
+				writeText(w, quotaStatus(err), err.Error())
 				return
 			}
 		}
 
+		var currentReplicas uint64
+		if webhook != nil {
+			currentReplicas, _, _, _ = serviceQuery.GetReplicas(functionName)
+		}
+
 		log.Printf("Scaling %s to %d replicas", functionName, req.Replicas)
 
 		scaleErr := scaleService(functionName, req.Replicas, serviceQuery)
 		if scaleErr != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(scaleErr.Error()))
+			writeText(w, http.StatusInternalServerError, scaleErr.Error())
 			log.Println(scaleErr.Error())
 			return
 		}
 
+		webhook.Notify(ScaleEvent{
+			Function:  functionName,
+			From:      currentReplicas,
+			To:        req.Replicas,
+			Timestamp: time.Now().UTC(),
+		})
+
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
@@ -90,8 +133,14 @@ type ServiceQuery interface {
 	SetReplicas(service string, count uint64) error
 }
 
+// ServiceScaler is the subset of Docker Client methods required to read and set a service's replicas
+type ServiceScaler interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+	ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error)
+}
+
 // NewSwarmServiceQuery create new Docker Swarm implementation
-func NewSwarmServiceQuery(c *client.Client) ServiceQuery {
+func NewSwarmServiceQuery(c ServiceScaler) ServiceQuery {
 	return SwarmServiceQuery{
 		c: c,
 	}
@@ -99,7 +148,7 @@ func NewSwarmServiceQuery(c *client.Client) ServiceQuery {
 
 // SwarmServiceQuery implementation for Docker Swarm
 type SwarmServiceQuery struct {
-	c *client.Client
+	c ServiceScaler
 }
 
 // GetReplicas replica count for function
@@ -144,7 +193,11 @@ func (s SwarmServiceQuery) GetReplicas(serviceName string) (uint64, uint64, uint
 	return currentReplicas, maxReplicas, minReplicas, err
 }
 
-// SetReplicas update the replica count
+// SetReplicas update the replica count.
+// When scaling down, Swarm stops the surplus tasks with the service's stop signal and
+// waits for ContainerSpec.StopGracePeriod before killing them, so in-flight requests
+// get that window to complete. The existing spec is updated in place to keep the grace
+// period, the engine default of 10s is used when none was set at deploy time.
 func (s SwarmServiceQuery) SetReplicas(serviceName string, count uint64) error {
 	opts := types.ServiceInspectOptions{
 		InsertDefaults: true,
@@ -153,6 +206,14 @@ func (s SwarmServiceQuery) SetReplicas(serviceName string, count uint64) error {
 	service, _, err := s.c.ServiceInspectWithRaw(context.Background(), serviceName, opts)
 	if err == nil {
 
+		if current := service.Spec.Mode.Replicated.Replicas; current != nil && count < *current {
+			gracePeriod := "engine default"
+			if service.Spec.TaskTemplate.ContainerSpec != nil && service.Spec.TaskTemplate.ContainerSpec.StopGracePeriod != nil {
+				gracePeriod = service.Spec.TaskTemplate.ContainerSpec.StopGracePeriod.String()
+			}
+			log.Printf("Scaling down %s from %d to %d replicas, stop grace period: %s", serviceName, *current, count, gracePeriod)
+		}
+
 		service.Spec.Mode.Replicated.Replicas = &count
 		updateOpts := types.ServiceUpdateOptions{}
 		updateOpts.RegistryAuthFrom = types.RegistryAuthFromSpec
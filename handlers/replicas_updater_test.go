@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakeServiceScaler struct {
+	service     swarm.Service
+	updatedSpec *swarm.ServiceSpec
+}
+
+func (f *fakeServiceScaler) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	return f.service, []byte{}, nil
+}
+
+func (f *fakeServiceScaler) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error) {
+	f.updatedSpec = &service
+	return types.ServiceUpdateResponse{}, nil
+}
+
+func Test_SetReplicas_ScaleDownPreservesStopGracePeriod(t *testing.T) {
+	replicas := uint64(5)
+	gracePeriod := time.Second * 30
+
+	scaler := &fakeServiceScaler{
+		service: swarm.Service{
+			ID: "figlet",
+			Spec: swarm.ServiceSpec{
+				TaskTemplate: swarm.TaskSpec{
+					ContainerSpec: &swarm.ContainerSpec{
+						StopGracePeriod: &gracePeriod,
+					},
+				},
+				Mode: swarm.ServiceMode{
+					Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+				},
+			},
+		},
+	}
+
+	err := NewSwarmServiceQuery(scaler).SetReplicas("figlet", 1)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if scaler.updatedSpec == nil {
+		t.Fatal("want: service to be updated got: no update")
+	}
+
+	if got := *scaler.updatedSpec.Mode.Replicated.Replicas; got != 1 {
+		t.Errorf("want: %d replicas got: %d", 1, got)
+	}
+
+	got := scaler.updatedSpec.TaskTemplate.ContainerSpec.StopGracePeriod
+	if got == nil || *got != gracePeriod {
+		t.Errorf("want: stop grace period %s got: %v", gracePeriod, got)
+	}
+}
+
+func doScale(c ReplicaUpdaterClient, quota uint64, name string, replicas uint64) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ScaleServiceRequest{ServiceName: name, Replicas: replicas})
+	req := httptest.NewRequest(http.MethodPost, "/system/scale-function/"+name, bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": name})
+	rr := httptest.NewRecorder()
+
+	ReplicaUpdater(c, quota, DefaultFunctionLabel, nil).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func Test_ReplicaUpdater_ReplicaQuota(t *testing.T) {
+	c := newFakeDeployClient()
+	figlet := functionWithReplicas("figlet", "staging", 2)
+	c.services = []swarm.Service{figlet, functionWithReplicas("env", "staging", 2)}
+	c.specs = []swarm.ServiceSpec{figlet.Spec}
+
+	scenarios := []struct {
+		name       string
+		replicas   uint64
+		wantStatus int
+	}{
+		{name: "scale up under quota", replicas: 3, wantStatus: http.StatusAccepted},
+		{name: "scale up over quota", replicas: 4, wantStatus: http.StatusForbidden},
+		{name: "scale down", replicas: 1, wantStatus: http.StatusAccepted},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if rr := doScale(c, 5, "figlet", s.replicas); rr.Code != s.wantStatus {
+				t.Errorf("want: %d got: %d %s", s.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	want := []uint64{3, 1}
+	if !reflect.DeepEqual(c.scaled, want) {
+		t.Errorf("want: scaled to %v got: %v", want, c.scaled)
+	}
+}
+
+func Test_ReplicaUpdater_ReplicaQuotaNotFound(t *testing.T) {
+	if rr := doScale(newFakeDeployClient(), 5, "figlet", 1); rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
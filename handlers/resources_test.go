@@ -38,7 +38,10 @@ func Test_ParseMemoryLimits(t *testing.T) {
 		},
 	}
 
-	res := buildResources(&req)
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
 
 	if res.Limits.MemoryBytes != megaBytes(want) {
 		t.Fatalf("Limits.MemoryBytes want: %d, got: %d", megaBytes(want), res.Limits.MemoryBytes)
@@ -54,7 +57,10 @@ func Test_ParseMemoryRequests(t *testing.T) {
 		Limits: &typesv1.FunctionResources{},
 	}
 
-	res := buildResources(&req)
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
 
 	if res.Reservations.MemoryBytes != megaBytes(want) {
 		t.Fatalf("Reservations.MemoryBytes want: %d, got: %d", megaBytes(want), res.Reservations.MemoryBytes)
@@ -69,14 +75,14 @@ func Test_ParseInvalidMemoryInMegabytes(t *testing.T) {
 		Limits: &typesv1.FunctionResources{},
 	}
 
-	res := buildResources(&req)
+	_, err := buildResources(&req, nil)
 
-	if res.Reservations != nil {
-		t.Fatalf("Expected nil reservation due to incorrect value provided")
+	if code := errorCode(err, ""); code != ErrCodeInvalidMemory {
+		t.Fatalf("Expected error code %s due to incorrect value provided, got: %s", ErrCodeInvalidMemory, code)
 	}
 }
 
-func TestInvalidMemoryRequests_Ignored(t *testing.T) {
+func TestInvalidMemoryRequests_Rejected(t *testing.T) {
 	req := typesv1.FunctionDeployment{
 		Requests: &typesv1.FunctionResources{
 			Memory: "invalid",
@@ -84,14 +90,14 @@ func TestInvalidMemoryRequests_Ignored(t *testing.T) {
 		Limits: &typesv1.FunctionResources{},
 	}
 
-	res := buildResources(&req)
+	_, err := buildResources(&req, nil)
 
-	if res.Reservations != nil {
-		t.Fatalf("Expected reservations to be nil due to invalid input")
+	if code := errorCode(err, ""); code != ErrCodeInvalidMemory {
+		t.Fatalf("Expected error code %s due to invalid input, got: %s", ErrCodeInvalidMemory, code)
 	}
 }
 
-func TestInvalidMemoryLimits_Ignored(t *testing.T) {
+func TestInvalidMemoryLimits_Rejected(t *testing.T) {
 	req := typesv1.FunctionDeployment{
 		Limits: &typesv1.FunctionResources{
 			Memory: "invalid",
@@ -99,10 +105,10 @@ func TestInvalidMemoryLimits_Ignored(t *testing.T) {
 		Requests: &typesv1.FunctionResources{},
 	}
 
-	res := buildResources(&req)
+	_, err := buildResources(&req, nil)
 
-	if res.Limits != nil {
-		t.Fatalf("Expected limits to be nil due to invalid input")
+	if code := errorCode(err, ""); code != ErrCodeInvalidMemory {
+		t.Fatalf("Expected error code %s due to invalid input, got: %s", ErrCodeInvalidMemory, code)
 	}
 }
 
@@ -116,7 +122,10 @@ func TestBuildSwarmResourcesAddsCPULimits(t *testing.T) {
 		},
 	}
 
-	res := buildResources(&req)
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
 
 	if res.Limits.NanoCPUs != want {
 		t.Fatalf("Expected CPU limit of %d, got %d", want, res.Limits.NanoCPUs)
@@ -132,14 +141,17 @@ func TestBuildSwarmResourcesAddsCPUReservations(t *testing.T) {
 		Limits: &typesv1.FunctionResources{},
 	}
 
-	res := buildResources(&req)
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
 
 	if res.Reservations.NanoCPUs != want {
 		t.Fatalf("Expected CPU limit of %d, got %d", want, res.Reservations.NanoCPUs)
 	}
 }
 
-func TestInvalidCPULimits_Ignored(t *testing.T) {
+func TestInvalidCPULimits_Rejected(t *testing.T) {
 	req := typesv1.FunctionDeployment{
 		Requests: &typesv1.FunctionResources{},
 		Limits: &typesv1.FunctionResources{
@@ -147,14 +159,14 @@ func TestInvalidCPULimits_Ignored(t *testing.T) {
 		},
 	}
 
-	res := buildResources(&req)
+	_, err := buildResources(&req, nil)
 
-	if res.Limits != nil {
-		t.Fatalf("Expected Limits to be nil due to invalid input")
+	if code := errorCode(err, ""); code != ErrCodeInvalidCPU {
+		t.Fatalf("Expected error code %s due to invalid input, got: %s", ErrCodeInvalidCPU, code)
 	}
 }
 
-func TestInvalidCPURequests_Ignored(t *testing.T) {
+func TestInvalidCPURequests_Rejected(t *testing.T) {
 	req := typesv1.FunctionDeployment{
 		Limits: &typesv1.FunctionResources{},
 		Requests: &typesv1.FunctionResources{
@@ -162,9 +174,129 @@ func TestInvalidCPURequests_Ignored(t *testing.T) {
 		},
 	}
 
-	res := buildResources(&req)
+	_, err := buildResources(&req, nil)
 
+	if code := errorCode(err, ""); code != ErrCodeInvalidCPU {
+		t.Fatalf("Expected error code %s due to invalid input, got: %s", ErrCodeInvalidCPU, code)
+	}
+}
+
+func TestEmptyResources_NoLimitsOrReservations(t *testing.T) {
+	req := typesv1.FunctionDeployment{
+		Limits:   &typesv1.FunctionResources{},
+		Requests: &typesv1.FunctionResources{},
+	}
+
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if res.Limits != nil || res.Reservations != nil {
+		t.Fatalf("Expected Limits and Reservations to be nil when no values are set")
+	}
+}
+
+func Test_BuildResources_DefaultLimitsApplied(t *testing.T) {
+	req := typesv1.FunctionDeployment{}
+	defaults := &typesv1.FunctionResources{
+		Memory: "128 m",
+		CPU:    "500000000",
+	}
+
+	res, err := buildResources(&req, defaults)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if res == nil || res.Limits == nil {
+		t.Fatalf("want: default limits got: %v", res)
+	}
+	if want := int64(128 * 1024 * 1024); res.Limits.MemoryBytes != want {
+		t.Errorf("want: memory limit %d got: %d", want, res.Limits.MemoryBytes)
+	}
+	if want := int64(500000000); res.Limits.NanoCPUs != want {
+		t.Errorf("want: cpu limit %d got: %d", want, res.Limits.NanoCPUs)
+	}
 	if res.Reservations != nil {
-		t.Fatalf("Expected Requests to be nil due to invalid input")
+		t.Errorf("want: no reservations got: %v", res.Reservations)
+	}
+}
+
+func Test_BuildResources_FunctionLimitsOverrideDefaults(t *testing.T) {
+	req := typesv1.FunctionDeployment{
+		Limits: &typesv1.FunctionResources{
+			Memory: "256 m",
+		},
+	}
+	defaults := &typesv1.FunctionResources{
+		Memory: "128 m",
+		CPU:    "500000000",
+	}
+
+	res, err := buildResources(&req, defaults)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if want := int64(256 * 1024 * 1024); res.Limits.MemoryBytes != want {
+		t.Errorf("want: memory limit %d got: %d", want, res.Limits.MemoryBytes)
+	}
+	if want := int64(500000000); res.Limits.NanoCPUs != want {
+		t.Errorf("want: default cpu limit %d got: %d", want, res.Limits.NanoCPUs)
+	}
+	if req.Limits.CPU != "" {
+		t.Errorf("want: request left unchanged got: cpu %s", req.Limits.CPU)
+	}
+}
+
+func Test_BuildResources_NoDefaults(t *testing.T) {
+	req := typesv1.FunctionDeployment{}
+
+	res, err := buildResources(&req, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if res != nil {
+		t.Errorf("want: no resources got: %v", res)
+	}
+}
+
+func Test_ParseCPU(t *testing.T) {
+	scenarios := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "500m", want: 500000000},
+		{value: "1500m", want: 1500000000},
+		{value: "1000m", want: 1000000000},
+		{value: "1", want: 1},
+		{value: "250000000", want: 250000000},
+		{value: "0.5", want: 500000000},
+		{value: "1.25", want: 1250000000},
+		{value: "m", wantErr: true},
+		{value: "-100m", wantErr: true},
+		{value: "1.5.0", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.value, func(t *testing.T) {
+			got, err := parseCPU(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error got: %d", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if got != s.want {
+				t.Errorf("want: %d got: %d", s.want, got)
+			}
+		})
 	}
 }
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// RollbackClient is the subset of Docker Client methods required to roll back a function
+type RollbackClient interface {
+	ServiceInspector
+	ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error)
+}
+
+// MakeRollbackHandler reverts a function to the spec it had before its last update, which
+// Swarm keeps as the previous spec of the service. It returns 400 when the function has
+// never been updated, and 404 when the service does not have functionLabel.
+func MakeRollbackHandler(c RollbackClient, limiter *DeployLimiter, functionLabel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+				return
+			}
+
+			log.Printf("RollbackHandler: error inspecting service %s: %s\n", functionName, err)
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !isFunctionService(service, functionLabel) {
+			writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+			return
+		}
+
+		if service.PreviousSpec == nil {
+			writeDeployError(w, http.StatusBadRequest, newDeployError(ErrCodeNoPreviousVersion, "function %s has no previous version to roll back to", functionName), ErrCodeNoPreviousVersion)
+			return
+		}
+
+		if err := limiter.Acquire(r.Context()); err != nil {
+			log.Printf("Error waiting to roll back service %s: %s\n", functionName, err)
+			writeDeployError(w, throttleStatus(err), err, ErrCodeDeployThrottled)
+			return
+		}
+		defer limiter.Release()
+
+		response, err := c.ServiceUpdate(r.Context(), service.ID, service.Version, service.Spec, types.ServiceUpdateOptions{
+			Rollback: "previous",
+		})
+		if err != nil {
+			log.Printf("Error rolling back service %s: %s\n", functionName, err)
+			writeDeployError(w, http.StatusBadRequest, err, ErrCodeDeployFailed)
+			return
+		}
+
+		if response.Warnings != nil {
+			log.Println(response.Warnings)
+		}
+
+		log.Printf("Rolled back %s to its previous version\n", functionName)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakeRollbackClient struct {
+	fakeServiceInspector
+
+	updates []types.ServiceUpdateOptions
+}
+
+func (f *fakeRollbackClient) ServiceUpdate(ctx context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, options types.ServiceUpdateOptions) (types.ServiceUpdateResponse, error) {
+	f.updates = append(f.updates, options)
+	return types.ServiceUpdateResponse{}, nil
+}
+
+func Test_RollbackHandler(t *testing.T) {
+	updated := labelledFunction("figlet", nil)
+	previous := updated.Spec
+	updated.PreviousSpec = &previous
+
+	scenarios := []struct {
+		name        string
+		function    string
+		wantStatus  int
+		wantUpdated bool
+	}{
+		{name: "previous spec", function: "figlet", wantStatus: http.StatusAccepted, wantUpdated: true},
+		{name: "no previous spec", function: "nodeinfo", wantStatus: http.StatusBadRequest},
+		{name: "not found", function: "env", wantStatus: http.StatusNotFound},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := &fakeRollbackClient{
+				fakeServiceInspector: fakeServiceInspector{
+					services: map[string]swarm.Service{
+						"figlet":   updated,
+						"nodeinfo": labelledFunction("nodeinfo", nil),
+					},
+				},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/system/function/"+s.function+"/rollback", nil)
+			req = mux.SetURLVars(req, map[string]string{"name": s.function})
+			rr := httptest.NewRecorder()
+			MakeRollbackHandler(c, nil, DefaultFunctionLabel).ServeHTTP(rr, req)
+
+			if rr.Code != s.wantStatus {
+				t.Errorf("want: %d got: %d %s", s.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if !s.wantUpdated {
+				if len(c.updates) != 0 {
+					t.Errorf("want: no update got: %v", c.updates)
+				}
+				return
+			}
+
+			if len(c.updates) != 1 || c.updates[0].Rollback != "previous" {
+				t.Errorf("want: one update with rollback %q got: %v", "previous", c.updates)
+			}
+		})
+	}
+}
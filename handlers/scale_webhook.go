@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// scaleWebhookTimeout is how long each attempt to send a scale event can take
+const scaleWebhookTimeout = time.Second * 10
+
+// scaleWebhookBackoff is the delay before the first retry of a scale event, it doubles
+// after each attempt
+const scaleWebhookBackoff = time.Second
+
+// ScaleEvent is sent to the scale webhook after a function is scaled
+type ScaleEvent struct {
+	Function  string    `json:"function"`
+	From      uint64    `json:"from"`
+	To        uint64    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ScaleWebhook posts a ScaleEvent to a URL each time a function is scaled. Failed requests
+// are retried and then logged, they never fail the scale. A nil ScaleWebhook sends nothing.
+type ScaleWebhook struct {
+	url     string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// NewScaleWebhook returns a ScaleWebhook posting to url with up to retries more attempts
+// for each event, or nil when url is empty
+func NewScaleWebhook(url string, retries int) *ScaleWebhook {
+	if len(url) == 0 {
+		return nil
+	}
+
+	return &ScaleWebhook{
+		url:     url,
+		retries: retries,
+		backoff: scaleWebhookBackoff,
+		client:  &http.Client{Timeout: scaleWebhookTimeout},
+	}
+}
+
+// Notify sends the event in the background
+func (h *ScaleWebhook) Notify(event ScaleEvent) {
+	if h == nil {
+		return
+	}
+
+	go func() {
+		if err := h.send(event); err != nil {
+			log.Printf("Error sending scale event for %s: %s\n", event.Function, err)
+		}
+	}()
+}
+
+// send posts the event until the webhook answers with a 2xx status or the retries are used up
+func (h *ScaleWebhook) send(event ScaleEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := h.backoff
+	for attempt := 0; ; attempt++ {
+		err = h.post(body)
+		if err == nil || attempt >= h.retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (h *ScaleWebhook) post(body []byte) error {
+	res, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_ScaleWebhook_Retries(t *testing.T) {
+	attempts := 0
+	var received ScaleEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("want: JSON scale event got: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	webhook := NewScaleWebhook(server.URL, 3)
+	webhook.backoff = time.Millisecond
+
+	event := ScaleEvent{Function: "figlet", From: 1, To: 5, Timestamp: time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)}
+	if err := webhook.send(event); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("want: %d attempts got: %d", 3, attempts)
+	}
+	if received != event {
+		t.Errorf("want: %v got: %v", event, received)
+	}
+}
+
+func Test_ScaleWebhook_GivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhook := NewScaleWebhook(server.URL, 2)
+	webhook.backoff = time.Millisecond
+
+	if err := webhook.send(ScaleEvent{Function: "figlet", From: 1, To: 0}); err == nil {
+		t.Errorf("want: error after the retries got: nil")
+	}
+	if attempts != 3 {
+		t.Errorf("want: %d attempts got: %d", 3, attempts)
+	}
+}
+
+func Test_ScaleWebhook_Disabled(t *testing.T) {
+	webhook := NewScaleWebhook("", 3)
+	if webhook != nil {
+		t.Fatalf("want: no webhook without a URL got: %v", webhook)
+	}
+
+	webhook.Notify(ScaleEvent{Function: "figlet"})
+}
@@ -9,6 +9,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/swarm"
@@ -20,8 +24,16 @@ import (
 var (
 	ownerLabel      = "com.openfaas.owner"
 	ownerLabelValue = "openfaas"
+
+	// inlineSecretLabel marks a one-shot secret created from a deploy request, its value
+	// is the name of the function the secret belongs to
+	inlineSecretLabel = "com.openfaas.inline_secret.function"
 )
 
+// DefaultSecretMountPath is the directory secrets are mounted in, the same path is used by
+// the other OpenFaaS providers so functions read their secrets from one place
+const DefaultSecretMountPath = "/var/openfaas/secrets"
+
 func MakeSecretsHandler(c client.SecretAPIClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
@@ -67,15 +79,9 @@ func MakeSecretsHandler(c client.SecretAPIClient) http.HandlerFunc {
 		}
 
 		if responseBody != nil {
-			_, writeErr := w.Write(responseBody)
-
-			if writeErr != nil {
-				log.Println("cannot write body of a response")
+			writeJSON(w, responseStatus, responseBody)
 
-				w.WriteHeader(http.StatusInternalServerError)
-
-				return
-			}
+			return
 		}
 
 		w.WriteHeader(responseStatus)
@@ -256,7 +262,9 @@ func deleteSecret(c client.SecretAPIClient, body []byte) (responseStatus int, re
 	return http.StatusOK, nil, nil
 }
 
-func makeSecretsArray(c *client.Client, secretNames []string) ([]*swarm.SecretReference, error) {
+// makeSecretsArray references the named secrets, each one is mounted as a file of the same
+// name within mountPath, or DefaultSecretMountPath when empty
+func makeSecretsArray(c client.SecretAPIClient, secretNames []string, mountPath string) ([]*swarm.SecretReference, error) {
 	values := []*swarm.SecretReference{}
 
 	if len(secretNames) == 0 {
@@ -265,9 +273,9 @@ func makeSecretsArray(c *client.Client, secretNames []string) ([]*swarm.SecretRe
 
 	secretOpts := new(opts.SecretOpt)
 	for _, secret := range secretNames {
-		secretSpec := fmt.Sprintf("source=%s,target=/var/openfaas/secrets/%s", secret, secret)
+		secretSpec := fmt.Sprintf("source=%s,target=%s", secret, secretTarget(mountPath, secret))
 		if err := secretOpts.Set(secretSpec); err != nil {
-			return nil, err
+			return nil, newDeployError(ErrCodeInvalidSecret, "invalid secret %s: %s", secret, err)
 		}
 	}
 
@@ -296,27 +304,180 @@ func makeSecretsArray(c *client.Client, secretNames []string) ([]*swarm.SecretRe
 		foundSecretNames = append(foundSecretNames, secret.Spec.Annotations.Name)
 	}
 
+	// every missing secret is reported together so they can all be created before deploying
+	// again, the function is not deployed with only some of its secrets
+	missingSecrets := []string{}
+
 	// mimics the simple syntax for `docker service create --secret foo`
 	// and the code is based on the docker cli
 	for _, opts := range secretOpts.Value() {
 
 		secretName := opts.SecretName
 		if _, exists := requestedSecrets[secretName]; exists {
-			return nil, fmt.Errorf("duplicate secret target for %s not allowed", secretName)
+			return nil, newDeployError(ErrCodeInvalidSecret, "duplicate secret target for %s not allowed", secretName)
 		}
+		requestedSecrets[secretName] = true
 
 		id, ok := foundSecrets[secretName]
 		if !ok {
-			return nil, fmt.Errorf("secret not found: %s; possible choices:\n%v", secretName, foundSecretNames)
+			missingSecrets = append(missingSecrets, secretName)
+			continue
 		}
 
 		options := new(swarm.SecretReference)
 		*options = *opts
 		options.SecretID = id
 
-		requestedSecrets[secretName] = true
 		values = append(values, options)
 	}
 
+	switch len(missingSecrets) {
+	case 0:
+		return values, nil
+	case 1:
+		return nil, newDeployError(ErrCodeSecretNotFound, "secret not found: %s; possible choices:\n%v", missingSecrets[0], foundSecretNames)
+	}
+
+	return nil, newDeployError(ErrCodeSecretNotFound, "%d secrets not found: %s; possible choices:\n%v", len(missingSecrets), strings.Join(missingSecrets, ", "), foundSecretNames)
+}
+
+// createInlineSecrets creates a one-shot Swarm secret for each inline value in a deploy request,
+// labelled with the function name so that it can be removed along with the function. The
+// secrets are mounted by their given name within mountPath, as with the secrets referenced by name.
+func createInlineSecrets(c client.SecretAPIClient, service string, inline map[string]string, mountPath string) ([]*swarm.SecretReference, error) {
+	values := []*swarm.SecretReference{}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	for name, value := range inline {
+		secretName := fmt.Sprintf("%s-%s-%s", service, name, suffix)
+
+		response, err := c.SecretCreate(context.Background(), swarm.SecretSpec{
+			Annotations: swarm.Annotations{
+				Name: secretName,
+				Labels: map[string]string{
+					inlineSecretLabel: service,
+				},
+			},
+			Data: []byte(value),
+		})
+		if err != nil {
+			removeSecretReferences(c, values)
+			return nil, fmt.Errorf("error creating inline secret %s: %s", name, err)
+		}
+
+		values = append(values, &swarm.SecretReference{
+			File: &swarm.SecretReferenceFileTarget{
+				Name: secretTarget(mountPath, name),
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+			SecretID:   response.ID,
+			SecretName: secretName,
+		})
+	}
+
 	return values, nil
 }
+
+// planInlineSecrets returns the references createInlineSecrets would make for a dry run,
+// without creating the secrets. The references have no ID and are named after the function
+// and secret without the unique suffix.
+func planInlineSecrets(service string, inline map[string]string, mountPath string) []*swarm.SecretReference {
+	values := []*swarm.SecretReference{}
+	for name := range inline {
+		values = append(values, &swarm.SecretReference{
+			File: &swarm.SecretReferenceFileTarget{
+				Name: secretTarget(mountPath, name),
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+			SecretName: fmt.Sprintf("%s-%s", service, name),
+		})
+	}
+
+	return values
+}
+
+// secretTarget is the file a secret is mounted as
+func secretTarget(mountPath string, name string) string {
+	if len(mountPath) == 0 {
+		mountPath = DefaultSecretMountPath
+	}
+
+	return path.Join(mountPath, name)
+}
+
+// checkMountCollisions returns an error naming the secret and mount when a secret file is
+// at or within the target of a mount, which would hide the secret from the function. Swarm
+// configs are not supported, so the scratch, /dev/shm and /tmp mounts are the only other
+// files a secret can collide with.
+func checkMountCollisions(containerSpec *swarm.ContainerSpec) error {
+	for _, secret := range containerSpec.Secrets {
+		if secret.File == nil {
+			continue
+		}
+
+		for _, m := range containerSpec.Mounts {
+			if secret.File.Name == m.Target || strings.HasPrefix(secret.File.Name, strings.TrimSuffix(m.Target, "/")+"/") {
+				return newDeployError(ErrCodeInvalidSecret, "secret %s at %s collides with the %s mount at %s", secret.SecretName, secret.File.Name, m.Type, m.Target)
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeSecretReferences removes the given secrets, it is used to roll back inline secrets
+// when a deployment fails. References without an ID were planned by a dry run and never
+// created.
+func removeSecretReferences(c client.SecretAPIClient, secrets []*swarm.SecretReference) {
+	for _, secret := range secrets {
+		if len(secret.SecretID) == 0 {
+			continue
+		}
+
+		if err := c.SecretRemove(context.Background(), secret.SecretID); err != nil {
+			log.Printf("Error removing inline secret %s: %s\n", secret.SecretName, err)
+		}
+	}
+}
+
+// removeInlineSecrets removes the one-shot secrets created for a function
+func removeInlineSecrets(c client.SecretAPIClient, service string) error {
+	return removeReplacedInlineSecrets(c, service, nil)
+}
+
+// removeReplacedInlineSecrets removes the one-shot secrets created for a function which are
+// not in references, such as the secrets replaced by the inline secrets of an update. An
+// update passes the references of both its new and previous spec, so a rollback still finds
+// the secrets it mounts.
+func removeReplacedInlineSecrets(c client.SecretAPIClient, service string, references []*swarm.SecretReference) error {
+	secrets, err := getSecretsWithLabel(c, inlineSecretLabel, service)
+	if err != nil {
+		return err
+	}
+
+	inUse := map[string]bool{}
+	for _, reference := range references {
+		inUse[reference.SecretID] = true
+	}
+
+	var removeErrors []string
+	for _, secret := range secrets {
+		if inUse[secret.ID] {
+			continue
+		}
+
+		if err := c.SecretRemove(context.Background(), secret.ID); err != nil {
+			removeErrors = append(removeErrors, fmt.Sprintf("%s: %s", secret.Spec.Name, err))
+		}
+	}
+
+	if len(removeErrors) > 0 {
+		return fmt.Errorf("error removing inline secrets for %s: %s", service, strings.Join(removeErrors, ", "))
+	}
+
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/swarm"
+	typesv1 "github.com/openfaas/faas-provider/types"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
@@ -87,10 +88,8 @@ func (c *fakeDockerSecretAPIClient) SecretCreate(
 		ID: id,
 		Spec: swarm.SecretSpec{
 			Annotations: swarm.Annotations{
-				Name: secretDesc.Name,
-				Labels: map[string]string{
-					ownerLabel: ownerLabelValue,
-				},
+				Name:   secretDesc.Name,
+				Labels: secretDesc.Labels,
 			},
 			Data: secretDesc.Data,
 		},
@@ -115,7 +114,13 @@ func (c *fakeDockerSecretAPIClient) SecretInspectWithRaw(
 	_ context.Context,
 	name string,
 ) (swarm.Secret, []byte, error) {
-	return swarm.Secret{}, nil, fmt.Errorf("SecretInspectWithRaw Not Implemented")
+	for _, secret := range c.secrets {
+		if secret.ID == name {
+			return secret, nil, nil
+		}
+	}
+
+	return swarm.Secret{}, nil, fakeNotFoundError{name: name}
 }
 
 func (c *fakeDockerSecretAPIClient) SecretUpdate(
@@ -234,3 +239,175 @@ func Test_SecretsHandler(t *testing.T) {
 		}
 	})
 }
+
+func Test_InlineSecrets(t *testing.T) {
+	dockerClient := newFakeDockerSecretAPIClient()
+
+	t.Run("creates labelled secrets and references them", func(t *testing.T) {
+		defer dockerClient.Reset()
+
+		refs, err := createInlineSecrets(&dockerClient, "figlet", map[string]string{"api-key": "s3cr3t"}, "")
+		if err != nil {
+			t.Fatalf("want: no error got: %v", err)
+		}
+
+		if len(refs) != 1 {
+			t.Fatalf("want: %d secret reference got: %d", 1, len(refs))
+		}
+
+		secret, ok := dockerClient.secrets[refs[0].SecretID]
+		if !ok {
+			t.Fatalf("want: secret `%s` to be created", refs[0].SecretName)
+		}
+
+		if string(secret.Spec.Data) != "s3cr3t" {
+			t.Errorf("want: secret data `%s` got: `%s`", "s3cr3t", string(secret.Spec.Data))
+		}
+
+		if secret.Spec.Labels[inlineSecretLabel] != "figlet" {
+			t.Errorf("want: label %s=%s got: %v", inlineSecretLabel, "figlet", secret.Spec.Labels)
+		}
+
+		if target := refs[0].File.Name; target != "/var/openfaas/secrets/api-key" {
+			t.Errorf("want: target `%s` got: `%s`", "/var/openfaas/secrets/api-key", target)
+		}
+	})
+
+	t.Run("removes only the function's secrets on delete", func(t *testing.T) {
+		defer dockerClient.Reset()
+
+		figletRefs, _ := createInlineSecrets(&dockerClient, "figlet", map[string]string{"api-key": "s3cr3t", "token": "t0k3n"}, "")
+		echoRefs, _ := createInlineSecrets(&dockerClient, "echo", map[string]string{"api-key": "other"}, "")
+
+		err := removeInlineSecrets(&dockerClient, "figlet")
+		if err != nil {
+			t.Fatalf("want: no error got: %v", err)
+		}
+
+		for _, ref := range figletRefs {
+			if _, exists := dockerClient.secrets[ref.SecretID]; exists {
+				t.Errorf("want: secret `%s` to be removed", ref.SecretName)
+			}
+		}
+
+		if _, exists := dockerClient.secrets[echoRefs[0].SecretID]; !exists {
+			t.Errorf("want: secret `%s` to be kept", echoRefs[0].SecretName)
+		}
+
+		if _, exists := dockerClient.secrets["foo"]; !exists {
+			t.Errorf("want: managed secret `%s` to be kept", "foo")
+		}
+	})
+}
+
+func Test_MakeSecretsArray_SecretNotFound(t *testing.T) {
+	dockerClient := newFakeDockerSecretAPIClient()
+
+	_, err := makeSecretsArray(&dockerClient, []string{"foo", "missing"}, "")
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+
+	if code := errorCode(err, ""); code != ErrCodeSecretNotFound {
+		t.Errorf("want: error code %s got: %s", ErrCodeSecretNotFound, code)
+	}
+}
+
+func Test_MakeSecretsArray_ListsEveryMissingSecret(t *testing.T) {
+	dockerClient := newFakeDockerSecretAPIClient()
+
+	refs, err := makeSecretsArray(&dockerClient, []string{"missing-a", "foo", "missing-b", "missing-c"}, "")
+	if err == nil {
+		t.Fatal("want: an error got: nil")
+	}
+
+	if refs != nil {
+		t.Errorf("want: no secret references got: %v", refs)
+	}
+
+	if code := errorCode(err, ""); code != ErrCodeSecretNotFound {
+		t.Errorf("want: error code %s got: %s", ErrCodeSecretNotFound, code)
+	}
+
+	want := "3 secrets not found: missing-a, missing-b, missing-c;"
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("want: message starting %q got: %q", want, err.Error())
+	}
+}
+
+func Test_MakeSecretsArray_MountPath(t *testing.T) {
+	dockerClient := newFakeDockerSecretAPIClient()
+
+	scenarios := []struct {
+		name      string
+		mountPath string
+		want      string
+	}{
+		{name: "default", mountPath: "", want: "/var/openfaas/secrets/foo"},
+		{name: "custom", mountPath: "/run/secrets", want: "/run/secrets/foo"},
+		{name: "trailing slash", mountPath: "/run/secrets/", want: "/run/secrets/foo"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			refs, err := makeSecretsArray(&dockerClient, []string{"foo"}, s.mountPath)
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if len(refs) != 1 {
+				t.Fatalf("want: %d secret reference got: %d", 1, len(refs))
+			}
+
+			if target := refs[0].File.Name; target != s.want {
+				t.Errorf("want: target `%s` got: `%s`", s.want, target)
+			}
+		})
+	}
+}
+
+func Test_MakeSpec_SecretMountCollision(t *testing.T) {
+	secrets := []*swarm.SecretReference{
+		{
+			SecretName: "api-key",
+			File:       &swarm.SecretReferenceFileTarget{Name: "/var/openfaas/secrets/api-key"},
+		},
+	}
+
+	scenarios := []struct {
+		name        string
+		scratchPath string
+		wantErr     bool
+	}{
+		{name: "separate paths", scratchPath: "/scratch"},
+		{name: "sibling with the same prefix", scratchPath: "/var/openfaas/secrets-cache"},
+		{name: "same directory", scratchPath: "/var/openfaas/secrets", wantErr: true},
+		{name: "parent directory", scratchPath: "/var/openfaas", wantErr: true},
+		{name: "same file", scratchPath: "/var/openfaas/secrets/api-key", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet",
+				Labels:  &map[string]string{ScratchSizeLabel: "64m", ScratchPathLabel: s.scratchPath},
+			}
+
+			_, err := makeSpec(request, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, secrets)
+			if !s.wantErr {
+				if err != nil {
+					t.Errorf("want: no error got: %v", err)
+				}
+				return
+			}
+
+			if errorCode(err, "") != ErrCodeInvalidSecret {
+				t.Errorf("want: error code %s got: %v", ErrCodeInvalidSecret, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "api-key") {
+				t.Errorf("want: error naming the secret got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+// statsCacheTTL is how long the stats of a function are reused before sampling again
+const statsCacheTTL = time.Second * 5
+
+// statsWorkers is how many container stats are read at the same time for a function
+const statsWorkers = 4
+
+// StatsClient is the subset of Docker Client methods required to read the stats of a function
+type StatsClient interface {
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error)
+	TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error)
+}
+
+// FunctionStats is the resource usage of a function summed over its running tasks
+type FunctionStats struct {
+	Name string `json:"name"`
+
+	// Tasks is how many running tasks were sampled. Stats are read from the Docker daemon
+	// the provider is connected to, so tasks on other nodes are not included.
+	Tasks int `json:"tasks"`
+
+	// CPUPercent is the total CPU usage, where 100 is one CPU fully used
+	CPUPercent float64 `json:"cpuPercent"`
+
+	// MemoryBytes is the total memory usage, excluding the page cache
+	MemoryBytes uint64 `json:"memoryBytes"`
+}
+
+// statsCache holds the last stats sampled for each function until they expire
+type statsCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	stats   FunctionStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]statsCacheEntry),
+	}
+}
+
+// Get returns the stats for a function if they were added within the TTL
+func (c *statsCache) Get(name string) (FunctionStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[name]
+	if !exists || c.now().After(entry.expires) {
+		delete(c.entries, name)
+		return FunctionStats{}, false
+	}
+
+	return entry.stats, true
+}
+
+// Add stores the stats for a function
+func (c *statsCache) Add(name string, stats FunctionStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[name] = statsCacheEntry{
+		stats:   stats,
+		expires: c.now().Add(c.ttl),
+	}
+}
+
+// MakeStatsHandler returns the CPU and memory usage of a function's running tasks, services
+// without functionLabel are reported as not found
+func MakeStatsHandler(c StatsClient, functionLabel string) http.HandlerFunc {
+	cache := newStatsCache(statsCacheTTL)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		stats, cached := cache.Get(functionName)
+		if !cached {
+			service, _, err := c.ServiceInspectWithRaw(r.Context(), functionName, types.ServiceInspectOptions{})
+			if err != nil {
+				if client.IsErrNotFound(err) {
+					writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+					return
+				}
+
+				log.Printf("StatsHandler: error inspecting service %s: %s\n", functionName, err)
+				writeText(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			if !isFunctionService(service, functionLabel) {
+				writeText(w, http.StatusNotFound, fmt.Sprintf("No such service found: %s.", functionName))
+				return
+			}
+
+			stats, err = readFunctionStats(r.Context(), c, service)
+			if err != nil {
+				log.Printf("StatsHandler: error reading stats for %s: %s\n", functionName, err)
+				writeText(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			cache.Add(functionName, stats)
+		}
+
+		statsBytes, _ := json.Marshal(stats)
+		writeJSON(w, http.StatusOK, statsBytes)
+	}
+}
+
+// readFunctionStats sums the stats of the running task containers of a service, containers
+// which can not be read, i.e. because they run on another node, are skipped
+func readFunctionStats(ctx context.Context, c StatsClient, service swarm.Service) (FunctionStats, error) {
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", service.ID)
+	taskFilter.Add("desired-state", "running")
+
+	tasks, err := c.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+	if err != nil {
+		return FunctionStats{}, err
+	}
+
+	containerIDs := make(chan string)
+	results := make(chan *types.StatsJSON)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < statsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for containerID := range containerIDs {
+				containerStats, err := readContainerStats(ctx, c, containerID)
+				if err != nil {
+					log.Printf("StatsHandler: skipping container %s: %s\n", containerID, err)
+					containerStats = nil
+				}
+				results <- containerStats
+			}
+		}()
+	}
+
+	go func() {
+		for _, task := range tasks {
+			if task.Status.State == swarm.TaskStateRunning && task.Status.ContainerStatus != nil {
+				containerIDs <- task.Status.ContainerStatus.ContainerID
+			}
+		}
+		close(containerIDs)
+
+		wg.Wait()
+		close(results)
+	}()
+
+	stats := FunctionStats{
+		Name: service.Spec.Name,
+	}
+	for containerStats := range results {
+		if containerStats == nil {
+			continue
+		}
+
+		stats.Tasks++
+		stats.CPUPercent += cpuPercent(containerStats)
+		stats.MemoryBytes += memoryUsage(containerStats)
+	}
+
+	return stats, nil
+}
+
+func readContainerStats(ctx context.Context, c StatsClient, containerID string) (*types.StatsJSON, error) {
+	response, err := c.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	stats := types.StatsJSON{}
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// cpuPercent calculates the CPU usage between the two samples of a stats response in the
+// same way as the docker stats command
+func cpuPercent(stats *types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	return (cpuDelta / systemDelta) * onlineCPUs * 100
+}
+
+// memoryUsage returns the memory used by a container, excluding the page cache
+func memoryUsage(stats *types.StatsJSON) uint64 {
+	cache := stats.MemoryStats.Stats["cache"]
+	if cache > stats.MemoryStats.Usage {
+		return 0
+	}
+
+	return stats.MemoryStats.Usage - cache
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+type fakeStatsClient struct {
+	// notFunction makes ServiceInspectWithRaw return a service without the function label
+	notFunction bool
+
+	tasks []swarm.Task
+	stats map[string]types.StatsJSON
+
+	mu         sync.Mutex
+	statsCalls int
+}
+
+func (f *fakeStatsClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, options types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	if f.notFunction {
+		service := swarm.Service{ID: "svc-" + serviceID}
+		service.Spec.Name = serviceID
+		return service, []byte{}, nil
+	}
+
+	return labelledFunction(serviceID, nil), []byte{}, nil
+}
+
+func (f *fakeStatsClient) TaskList(ctx context.Context, options types.TaskListOptions) ([]swarm.Task, error) {
+	return f.tasks, nil
+}
+
+func (f *fakeStatsClient) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	f.mu.Lock()
+	f.statsCalls++
+	f.mu.Unlock()
+
+	stats, exists := f.stats[containerID]
+	if !exists {
+		return types.ContainerStats{}, fmt.Errorf("No such container: %s", containerID)
+	}
+
+	body, _ := json.Marshal(stats)
+	return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func runningTask(containerID string) swarm.Task {
+	return swarm.Task{
+		Status: swarm.TaskStatus{
+			State:           swarm.TaskStateRunning,
+			ContainerStatus: &swarm.ContainerStatus{ContainerID: containerID},
+		},
+	}
+}
+
+func containerStats(cpuDelta uint64, memory uint64, cache uint64) types.StatsJSON {
+	stats := types.StatsJSON{}
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1000
+	stats.PreCPUStats.SystemUsage = 10000
+	stats.CPUStats.CPUUsage.TotalUsage = 1000 + cpuDelta
+	stats.CPUStats.SystemUsage = 20000
+	stats.CPUStats.OnlineCPUs = 2
+	stats.MemoryStats.Usage = memory
+	stats.MemoryStats.Stats = map[string]uint64{"cache": cache}
+	return stats
+}
+
+func Test_StatsHandler_SumsRunningTasks(t *testing.T) {
+	c := &fakeStatsClient{
+		tasks: []swarm.Task{
+			runningTask("container-1"),
+			runningTask("container-2"),
+			runningTask("remote-container"),
+			{Status: swarm.TaskStatus{State: swarm.TaskStatePending}},
+		},
+		stats: map[string]types.StatsJSON{
+			// 10% and 5% of the system delta on 2 CPUs
+			"container-1": containerStats(1000, 64*1024*1024, 4*1024*1024),
+			"container-2": containerStats(500, 32*1024*1024, 0),
+		},
+	}
+
+	handler := MakeStatsHandler(c, DefaultFunctionLabel)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/stats", nil)
+		req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+		rr := httptest.NewRecorder()
+
+		handler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want: %d got: %d", http.StatusOK, rr.Code)
+		}
+
+		stats := FunctionStats{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("unexpected response body %q: %s", rr.Body.String(), err)
+		}
+
+		if stats.Name != "figlet" {
+			t.Errorf("want: name %s got: %s", "figlet", stats.Name)
+		}
+		if stats.Tasks != 2 {
+			t.Errorf("want: %d tasks got: %d", 2, stats.Tasks)
+		}
+		if stats.CPUPercent < 29.99 || stats.CPUPercent > 30.01 {
+			t.Errorf("want: %v CPU percent got: %v", 30.0, stats.CPUPercent)
+		}
+		if want := uint64(92 * 1024 * 1024); stats.MemoryBytes != want {
+			t.Errorf("want: %d memory bytes got: %d", want, stats.MemoryBytes)
+		}
+	}
+
+	if c.statsCalls != 3 {
+		t.Errorf("want: second request served from the cache with %d stats calls got: %d", 3, c.statsCalls)
+	}
+}
+
+func Test_StatsHandler_NotAFunction(t *testing.T) {
+	c := &fakeStatsClient{
+		notFunction: true,
+		tasks:       []swarm.Task{runningTask("container-1")},
+		stats:       map[string]types.StatsJSON{"container-1": containerStats(1000, 1024, 0)},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/nginx/stats", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "nginx"})
+	rr := httptest.NewRecorder()
+
+	MakeStatsHandler(c, DefaultFunctionLabel)(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d got: %d", http.StatusNotFound, rr.Code)
+	}
+	if c.statsCalls != 0 {
+		t.Errorf("want: no stats read got: %d calls", c.statsCalls)
+	}
+}
+
+func Test_CPUPercent_NoPreviousSample(t *testing.T) {
+	stats := types.StatsJSON{}
+	stats.CPUStats.CPUUsage.TotalUsage = 1000
+	stats.CPUStats.SystemUsage = 20000
+
+	if got := cpuPercent(&stats); got != 0 {
+		t.Errorf("want: %v got: %v", 0, got)
+	}
+}
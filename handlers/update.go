@@ -7,150 +7,404 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	types "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
 
 	typesv1 "github.com/openfaas/faas-provider/types"
 )
 
+// previousImageLabel records the image a function ran before its last update, it is
+// returned as the "previous_image" annotation
+const previousImageLabel = annotationLabelPrefix + "previous_image"
+
 // UpdateHandler updates an existng function
-func UpdateHandler(c *client.Client, maxRestarts uint64, restartDelay time.Duration) http.HandlerFunc {
+func UpdateHandler(c DeployClient, config DeployConfig) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.Background()
-
 		defer r.Body.Close()
 		body, _ := ioutil.ReadAll(r.Body)
 
-		request := typesv1.FunctionDeployment{}
+		request := CreateFunctionRequest{}
 		err := json.Unmarshal(body, &request)
 		if err != nil {
 			log.Println("Error parsing request:", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeDeployError(w, http.StatusBadRequest, err, ErrCodeInvalidRequest)
 			return
 		}
 
-		serviceInspectopts := types.ServiceInspectOptions{
-			InsertDefaults: true,
-		}
-
-		service, _, err := c.ServiceInspectWithRaw(ctx, request.Service, serviceInspectopts)
+		status, warnings, err := updateFunction(r.Context(), c, config, request.FunctionDeployment, request.InlineSecrets, false)
 		if err != nil {
-			log.Println("Error inspecting service", err)
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(err.Error()))
+			if status == http.StatusNotFound {
+				writeText(w, status, err.Error())
+				return
+			}
+
+			writeDeployError(w, status, err, ErrCodeDeployFailed)
 			return
 		}
 
-		secrets, err := makeSecretsArray(c, request.Secrets)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Deployment error: " + err.Error()))
-			return
+		for _, warning := range warnings {
+			w.Header().Add("Warning", fmt.Sprintf("199 - %q", warning))
 		}
 
-		if len(request.Network) == 0 {
-			networkValue, networkErr := lookupNetwork(c)
-			if networkErr != nil {
-				log.Println("Error querying networks", networkErr)
-			} else {
-				request.Network = networkValue
-			}
+		w.WriteHeader(status)
+	}
+}
+
+// updateFunction updates the service of an existing function and returns the HTTP status to
+// report along with any warnings. The service is scaled to the minimum replicas of the
+// request unless keepReplicas is set, which keeps the replicas it is scaled to. ctx only
+// bounds the wait for the deploy limiter. A missing service is reported with 404 Not Found.
+// Inline secrets are created as new one-shot secrets. Once the service is updated, the
+// one-shot secrets referenced by neither the new spec nor the previous one are removed, the
+// previous spec is kept by Swarm to roll back to.
+func updateFunction(ctx context.Context, c DeployClient, config DeployConfig, request typesv1.FunctionDeployment, inline map[string]string, keepReplicas bool) (int, []string, error) {
+	request.Image = qualifyImage(request.Image, config.DefaultRegistry)
+	warnings := renameDeprecatedLabels(&request, config.DeprecatedLabels)
+
+	if err := validateRequest(c, config, &CreateFunctionRequest{FunctionDeployment: request, InlineSecrets: inline}, true); err != nil {
+		log.Printf("Invalid request for %s: %s\n", request.Service, err)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeInvalidRequest)
+	}
+
+	warnings = append(warnings, containerSettingWarnings(&request)...)
+
+	if err := checkImageAllowed(request.Image, config.AllowedRegistries); err != nil {
+		log.Printf("Rejected image for %s: %s\n", request.Service, err)
+		return imageCheckStatus(err), nil, toDeployError(err, ErrCodeInvalidRequest)
+	}
+
+	serviceInspectopts := types.ServiceInspectOptions{
+		InsertDefaults: true,
+	}
+
+	service, _, err := c.ServiceInspectWithRaw(context.Background(), request.Service, serviceInspectopts)
+	if err != nil {
+		log.Println("Error inspecting service", err)
+		return http.StatusNotFound, nil, err
+	}
+
+	if err := validatePlacementNodes(c, &request); err != nil {
+		log.Println("Error validating placement:", err)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeInvalidPlacement)
+	}
+
+	secrets, err := makeSecretsArray(c, request.Secrets, config.SecretMountPath)
+	if err != nil {
+		log.Println(err)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeInvalidSecret)
+	}
+
+	if len(request.Network) == 0 && !isHostNetworkMode(&request) {
+		networkValue, networkErr := defaultNetwork(c, config, &request)
+		if networkErr != nil {
+			log.Println("Error querying networks", networkErr)
+			return http.StatusInternalServerError, nil, toDeployError(networkErr, ErrCodeInvalidNetwork)
 		}
+		request.Network = networkValue
 
-		if err := updateSpec(&request, &service.Spec, maxRestarts, restartDelay, secrets); err != nil {
-			log.Println("Error updating service spec:", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Update spc error: " + err.Error()))
-			return
+		if len(request.Network) == 0 {
+			return http.StatusBadRequest, nil, toDeployError(errNoNetwork(config.NetworkLabel), ErrCodeInvalidNetwork)
 		}
+	}
 
-		updateOpts := types.ServiceUpdateOptions{}
-		updateOpts.RegistryAuthFrom = types.RegistryAuthFromSpec
+	if err := checkNetworkEncryption(c, &request); err != nil {
+		log.Printf("Error checking the network of %s: %s\n", request.Service, err)
+		return networkCheckStatus(err), nil, toDeployError(err, ErrCodeInvalidNetwork)
+	}
 
-		if len(request.RegistryAuth) > 0 {
-			auth, err := BuildEncodedAuthConfig(request.RegistryAuth, request.Image)
-			if err != nil {
-				log.Println("Error building registry auth configuration:", err)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("Invalid registry auth"))
-				return
-			}
-			updateOpts.EncodedRegistryAuth = auth
+	// inline secrets were checked by validateRequest, they are removed again unless the
+	// service is updated to use them
+	updated := false
+	if len(inline) > 0 {
+		inlineSecrets, err := createInlineSecrets(c, request.Service, inline, config.SecretMountPath)
+		if err != nil {
+			log.Printf("Error updating %s: %s\n", request.Service, err)
+			return http.StatusInternalServerError, nil, toDeployError(err, ErrCodeDeployFailed)
 		}
+		secrets = append(secrets, inlineSecrets...)
 
-		service.Spec.UpdateConfig.Order = "start-first"
+		defer func() {
+			if !updated {
+				removeSecretReferences(c, inlineSecrets)
+			}
+		}()
+	}
 
-		response, err := c.ServiceUpdate(ctx, service.ID, service.Version, service.Spec, updateOpts)
+	// updateSpec replaces the labels, which record the node a sticky function is pinned to
+	pinnedNode := service.Spec.Labels[stickyNodeLabel]
 
+	// the current spec becomes the previous spec, which a rollback goes back to
+	previousSecrets := service.Spec.TaskTemplate.ContainerSpec.Secrets
+
+	var replicas *uint64
+	if service.Spec.Mode.Replicated != nil {
+		replicas = service.Spec.Mode.Replicated.Replicas
+	}
+
+	if err := updateSpec(&request, &service.Spec, config, secrets); err != nil {
+		log.Println("Error updating service spec:", err)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeInvalidRequest)
+	}
+
+	if keepReplicas && replicas != nil && service.Spec.Mode.Replicated != nil {
+		service.Spec.Mode.Replicated.Replicas = replicas
+	}
+
+	if isStickyPlacement(&request) {
+		nodeID, err := findStickyNode(c, service.ID, pinnedNode)
 		if err != nil {
-			log.Println("Error updating service:", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Update error: " + err.Error()))
-			return
+			log.Printf("Error finding the node of %s, it will not be pinned: %s\n", request.Service, err)
+		} else if len(nodeID) > 0 {
+			pinToNode(&service.Spec, nodeID)
+		}
+	}
+
+	// the label was validated by updateSpec
+	antiAffinity, _ := getAntiAffinity(&request)
+	if warning, err := applyAntiAffinity(c, &service.Spec, antiAffinity); err != nil {
+		log.Printf("Error listing the nodes to spread %s over, anti-affinity with %s is not checked: %s\n", request.Service, strings.Join(antiAffinity, ", "), err)
+	} else if len(warning) > 0 {
+		warnings = append(warnings, warning)
+	}
+
+	if service.Spec.Mode.Replicated != nil && service.Spec.Mode.Replicated.Replicas != nil {
+		err := checkReplicaQuota(c, config.ReplicaQuota, config.FunctionLabel, getNamespace(service.Spec.Labels), request.Service, *service.Spec.Mode.Replicated.Replicas)
+		if err != nil {
+			log.Printf("Error checking the replica quota for %s: %s\n", request.Service, err)
+			return quotaStatus(err), nil, toDeployError(err, ErrCodeDeployFailed)
 		}
+	}
+
+	// the pull policy was validated by updateSpec
+	pullPolicy, _ := getPullPolicy(&request)
+
+	updateOpts := types.ServiceUpdateOptions{}
+	updateOpts.RegistryAuthFrom = types.RegistryAuthFromSpec
+	updateOpts.QueryRegistry = pullPolicy == PullPolicyAlways
 
-		if response.Warnings != nil {
-			log.Println(response.Warnings)
+	if len(request.RegistryAuth) > 0 {
+		auth, err := BuildEncodedAuthConfig(request.RegistryAuth, request.Image)
+		if err != nil {
+			log.Println("Error building registry auth configuration:", err)
+			return http.StatusBadRequest, nil, newDeployError(ErrCodeInvalidRegistryAuth, "Invalid registry auth: %s", err)
 		}
+		updateOpts.EncodedRegistryAuth = auth
+	}
+
+	service.Spec.UpdateConfig.Order = "start-first"
 
-		w.WriteHeader(http.StatusAccepted)
+	if err := config.Limiter.Acquire(ctx); err != nil {
+		log.Printf("Error waiting to update service %s: %s\n", request.Service, err)
+		return throttleStatus(err), nil, toDeployError(err, ErrCodeDeployThrottled)
 	}
+	defer config.Limiter.Release()
+
+	response, err := c.ServiceUpdate(context.Background(), service.ID, service.Version, service.Spec, updateOpts)
+
+	if err != nil {
+		log.Println("Error updating service:", err)
+		return http.StatusBadRequest, nil, toDeployError(err, ErrCodeDeployFailed)
+	}
+	updated = true
+
+	references := append(service.Spec.TaskTemplate.ContainerSpec.Secrets, previousSecrets...)
+	if err := removeReplacedInlineSecrets(c, request.Service, references); err != nil {
+		log.Printf("Error removing the replaced inline secrets of %s: %s\n", request.Service, err)
+	}
+
+	if response.Warnings != nil {
+		log.Println(response.Warnings)
+	}
+
+	for _, warning := range warnings {
+		log.Printf("Updating %s: %s\n", request.Service, warning)
+	}
+
+	return http.StatusAccepted, warnings, nil
 }
 
-func updateSpec(request *typesv1.FunctionDeployment, spec *swarm.ServiceSpec, maxRestarts uint64, restartDelay time.Duration, secrets []*swarm.SecretReference) error {
+func updateSpec(request *typesv1.FunctionDeployment, spec *swarm.ServiceSpec, config DeployConfig, secrets []*swarm.SecretReference) error {
 
-	constraints := []string{}
-	if request.Constraints != nil && len(request.Constraints) > 0 {
-		constraints = request.Constraints
-	} else {
-		constraints = linuxOnlyConstraints
+	maxRestarts, err := getMaxRestarts(request, config.MaxRestarts)
+	if err != nil {
+		return err
+	}
+
+	restartCondition, err := getRestartCondition(request, config.RestartCondition)
+	if err != nil {
+		return err
+	}
+
+	restartWindow, err := getRestartWindow(request)
+	if err != nil {
+		return err
+	}
+
+	stopGracePeriod, err := getStopGracePeriod(request)
+	if err != nil {
+		return err
+	}
+	restartDelay := getRestartDelay(config.RestartDelay, stopGracePeriod)
+
+	stopSignal, err := getStopSignal(request)
+	if err != nil {
+		return err
+	}
+
+	pullPolicy, err := getPullPolicy(request)
+	if err != nil {
+		return err
+	}
+
+	// Swarm only replaces tasks when the spec changes, so an unchanged tag is re-pulled
+	// by forcing the update
+	if pullPolicy == PullPolicyAlways {
+		spec.TaskTemplate.ForceUpdate++
 	}
 
 	spec.TaskTemplate.RestartPolicy.MaxAttempts = &maxRestarts
-	spec.TaskTemplate.RestartPolicy.Condition = swarm.RestartPolicyConditionAny
+	spec.TaskTemplate.RestartPolicy.Condition = restartCondition
 	spec.TaskTemplate.RestartPolicy.Delay = &restartDelay
+	spec.TaskTemplate.RestartPolicy.Window = restartWindow
+	spec.TaskTemplate.ContainerSpec.StopGracePeriod = stopGracePeriod
+	spec.TaskTemplate.ContainerSpec.StopSignal = stopSignal
+
+	previousImage := previousImageOf(spec, request.Image)
+	previousLabelMounts := labelMountTargets(spec.Annotations.Labels)
+	previousTmpfsMounts := tmpfsLabelTargets(spec.Annotations.Labels)
 	spec.TaskTemplate.ContainerSpec.Image = request.Image
 
-	labels, err := buildLabels(request)
+	labels, err := buildLabels(request, config.BaseLabels, config.FunctionLabel, config.MaxLabelValueLength)
+	if err != nil {
+		return err
+	}
+
+	if len(previousImage) > 0 {
+		labels[previousImageLabel] = previousImage
+	}
+
+	if err := requireLabels(request, config.RequiredLabels); err != nil {
+		return err
+	}
+
+	labels["com.openfaas.uid"] = fmt.Sprintf("%d", time.Now().Nanosecond())
+
+	serviceLabels, containerLabels, err := splitTaskLabels(labels)
+	if err != nil {
+		return err
+	}
+
+	spec.Annotations.Labels = serviceLabels
+	spec.TaskTemplate.ContainerSpec.Labels = containerLabels
+
+	networks, err := buildNetworks(request)
+	if err != nil {
+		return err
+	}
+	spec.TaskTemplate.Networks = networks
+
+	ports, err := buildPorts(request)
+	if err != nil {
+		return err
+	}
+	if len(ports) > 0 {
+		if spec.EndpointSpec == nil {
+			spec.EndpointSpec = &swarm.EndpointSpec{}
+		}
+		spec.EndpointSpec.Ports = ports
+	} else if spec.EndpointSpec != nil {
+		spec.EndpointSpec.Ports = nil
+	}
+
+	healthcheck, err := buildHealthcheck(request)
 	if err != nil {
 		return err
 	}
+	spec.TaskTemplate.ContainerSpec.Healthcheck = healthcheck
 
-	spec.Annotations.Labels = labels
-	spec.TaskTemplate.ContainerSpec.Labels = labels
-	spec.TaskTemplate.ContainerSpec.Labels["com.openfaas.uid"] = fmt.Sprintf("%d", time.Now().Nanosecond())
+	hostname, err := getHostname(request)
+	if err != nil {
+		return err
+	}
+	spec.TaskTemplate.ContainerSpec.Hostname = hostname
 
-	spec.TaskTemplate.Networks = []swarm.NetworkAttachmentConfig{
-		{
-			Target: request.Network,
-		},
+	privileges, err := buildPrivileges(request)
+	if err != nil {
+		return err
 	}
+	spec.TaskTemplate.ContainerSpec.Privileges = privileges
 
 	spec.TaskTemplate.ContainerSpec.Secrets = secrets
 	spec.TaskTemplate.ContainerSpec.ReadOnly = request.ReadOnlyRootFilesystem
 
 	spec.TaskTemplate.ContainerSpec.Mounts = removeMounts(spec.TaskTemplate.ContainerSpec.Mounts, "/tmp")
 	if request.ReadOnlyRootFilesystem {
-		spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
-			{
-				Type:   mount.TypeTmpfs,
-				Target: "/tmp",
-			},
+		spec.TaskTemplate.ContainerSpec.Mounts = append(spec.TaskTemplate.ContainerSpec.Mounts, mount.Mount{
+			Type:   mount.TypeTmpfs,
+			Target: "/tmp",
+		})
+	}
+
+	// the scratch space, /dev/shm and the mounts from MountsLabel are the only other mounts
+	// made by faas-swarm, so they are rebuilt in case their labels were changed or removed.
+	// Mounts added to the service outside of faas-swarm are kept.
+	scratch, err := buildScratchMount(request)
+	if err != nil {
+		return err
+	}
+	shm, err := buildShmMount(request)
+	if err != nil {
+		return err
+	}
+	labelMounts, err := buildMounts(request, config.BindMountSources)
+	if err != nil {
+		return err
+	}
+	mounts := spec.TaskTemplate.ContainerSpec.Mounts[:0]
+	for _, m := range spec.TaskTemplate.ContainerSpec.Mounts {
+		if m.Type == mount.TypeTmpfs && previousTmpfsMounts[m.Target] {
+			continue
+		}
+		if m.Type != mount.TypeTmpfs && previousLabelMounts[m.Target] {
+			continue
 		}
+		mounts = append(mounts, m)
+	}
+	if scratch != nil {
+		mounts = append(mounts, *scratch)
+	}
+	if shm != nil {
+		mounts = append(mounts, *shm)
 	}
+	mounts = append(mounts, labelMounts...)
+	spec.TaskTemplate.ContainerSpec.Mounts = mounts
 
-	spec.TaskTemplate.Resources = buildResources(request)
+	if err := checkMountTargets(mounts); err != nil {
+		return err
+	}
+
+	if err := checkMountCollisions(spec.TaskTemplate.ContainerSpec); err != nil {
+		return err
+	}
 
-	spec.TaskTemplate.Placement = &swarm.Placement{
-		Constraints: constraints,
+	resources, err := buildResources(request, config.DefaultLimits)
+	if err != nil {
+		return err
 	}
+	spec.TaskTemplate.Resources = resources
+
+	// Placement is rebuilt from the request on every update, so constraints removed
+	// from the stack are not carried over from the previous spec
+	placement, err := buildPlacement(request, config.LabelConstraints, config.DefaultConstraints)
+	if err != nil {
+		return err
+	}
+	spec.TaskTemplate.Placement = placement
 
 	spec.Annotations.Name = request.Service
 
@@ -163,7 +417,12 @@ func updateSpec(request *typesv1.FunctionDeployment, spec *swarm.ServiceSpec, ma
 		FailureAction: "rollback",
 	}
 
-	env := buildEnv(request.EnvProcess, request.EnvVars)
+	envProcess, envVars, err := resolveEnvProcess(request)
+	if err != nil {
+		return err
+	}
+
+	env := buildEnv(envProcess, envVars, useEnvPlaceholders(request))
 
 	if len(env) > 0 {
 		spec.TaskTemplate.ContainerSpec.Env = env
@@ -176,6 +435,18 @@ func updateSpec(request *typesv1.FunctionDeployment, spec *swarm.ServiceSpec, ma
 	return nil
 }
 
+// previousImageOf returns the image to record as the previous image of a service which is
+// being updated to image. When the image is unchanged the image recorded by the last
+// update is kept, so a redeploy of the same image does not lose the rollback target.
+func previousImageOf(spec *swarm.ServiceSpec, image string) string {
+	current := spec.TaskTemplate.ContainerSpec.Image
+	if current != image {
+		return current
+	}
+
+	return spec.Annotations.Labels[previousImageLabel]
+}
+
 // removeMounts returns a mount.Mount slice with any mounts matching target removed
 // Uses the filter without allocation technique as described here
 // https://github.com/golang/go/wiki/SliceTricks#filtering-without-allocating
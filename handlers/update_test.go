@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+func existingServiceSpec(constraints []string) swarm.ServiceSpec {
+	replicas := uint64(1)
+
+	return swarm.ServiceSpec{
+		Annotations: swarm.Annotations{
+			Name: "figlet",
+		},
+		TaskTemplate: swarm.TaskSpec{
+			RestartPolicy: &swarm.RestartPolicy{},
+			ContainerSpec: &swarm.ContainerSpec{
+				Image: "functions/figlet:0.1",
+			},
+			Placement: &swarm.Placement{
+				Constraints: constraints,
+			},
+		},
+		Mode: swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+		},
+	}
+}
+
+func Test_UpdateSpec_RemovesConstraints(t *testing.T) {
+	spec := existingServiceSpec([]string{"node.role == worker", "node.labels.zone == eu"})
+	request := &typesv1.FunctionDeployment{
+		Service:     "figlet",
+		Image:       "functions/figlet:0.2",
+		Constraints: []string{"node.role == worker"},
+	}
+
+	err := updateSpec(request, &spec, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	constraints := spec.TaskTemplate.Placement.Constraints
+	if len(constraints) != 1 {
+		t.Fatalf("want: %d constraint got: %d %v", 1, len(constraints), constraints)
+	}
+
+	if constraints[0] != "node.role == worker" {
+		t.Errorf("want: constraint '%s' got: '%s'", "node.role == worker", constraints[0])
+	}
+}
+
+func Test_UpdateSpec_NoConstraintsUsesDefault(t *testing.T) {
+	spec := existingServiceSpec([]string{"node.role == worker"})
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.2",
+	}
+
+	err := updateSpec(request, &spec, DeployConfig{}, nil)
+	if err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	constraints := spec.TaskTemplate.Placement.Constraints
+	if len(constraints) != len(linuxOnlyConstraints) || constraints[0] != linuxOnlyConstraints[0] {
+		t.Errorf("want: constraints %v got: %v", linuxOnlyConstraints, constraints)
+	}
+}
+
+func Test_UpdateSpec_PullPolicy(t *testing.T) {
+	scenarios := []struct {
+		policy          string
+		wantForceUpdate uint64
+	}{
+		{policy: PullPolicyAlways, wantForceUpdate: 4},
+		{policy: PullPolicyIfNotPresent, wantForceUpdate: 3},
+		{policy: PullPolicyNever, wantForceUpdate: 3},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.policy, func(t *testing.T) {
+			spec := existingServiceSpec(nil)
+			spec.TaskTemplate.ForceUpdate = 3
+
+			request := &typesv1.FunctionDeployment{
+				Service: "figlet",
+				Image:   "functions/figlet:0.2",
+				Labels:  &map[string]string{PullPolicyLabel: s.policy},
+			}
+
+			if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+
+			if spec.TaskTemplate.ForceUpdate != s.wantForceUpdate {
+				t.Errorf("want: ForceUpdate %d got: %d", s.wantForceUpdate, spec.TaskTemplate.ForceUpdate)
+			}
+		})
+	}
+}
+
+func Test_UpdateSpec_RequiredLabelMissing(t *testing.T) {
+	spec := existingServiceSpec(nil)
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.2",
+	}
+
+	err := updateSpec(request, &spec, DeployConfig{RequiredLabels: []string{"com.openfaas.cost.team"}}, nil)
+	if code := errorCode(err, ""); code != ErrCodeMissingLabel {
+		t.Errorf("want: error code %s got: %s", ErrCodeMissingLabel, code)
+	}
+}
+
+func Test_UpdateSpec_RecordsPreviousImage(t *testing.T) {
+	spec := existingServiceSpec(nil)
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.2",
+	}
+
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := "functions/figlet:0.1"
+	if got := spec.Annotations.Labels[previousImageLabel]; got != want {
+		t.Errorf("want: previous image %s got: %s", want, got)
+	}
+
+	_, annotations := buildLabelsAndAnnotations(spec.Annotations.Labels)
+	if got := annotations["previous_image"]; got != want {
+		t.Errorf("want: previous_image annotation %s got: %s", want, got)
+	}
+
+	// redeploying the same image keeps the rollback target
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	if got := spec.Annotations.Labels[previousImageLabel]; got != want {
+		t.Errorf("want: previous image %s after redeploy got: %s", want, got)
+	}
+}
+
+func Test_UpdateSpec_ScratchMountRemoved(t *testing.T) {
+	spec := existingServiceSpec(nil)
+	spec.Annotations.Labels = map[string]string{ScratchSizeLabel: "1k"}
+	spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
+		{Type: mount.TypeTmpfs, Target: "/scratch", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1024}},
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+	}
+
+	request := &typesv1.FunctionDeployment{
+		Service: "figlet",
+		Image:   "functions/figlet:0.2",
+	}
+
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
+
+func Test_UpdateSpec_KeepsExternalMounts(t *testing.T) {
+	spec := existingServiceSpec(nil)
+	spec.Annotations.Labels = map[string]string{ScratchSizeLabel: "1k", ScratchPathLabel: "/work", ShmSizeLabel: "1k"}
+	spec.TaskTemplate.ContainerSpec.Mounts = []mount.Mount{
+		{Type: mount.TypeTmpfs, Target: "/work", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1024}},
+		{Type: mount.TypeTmpfs, Target: "/dev/shm", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1024}},
+		{Type: mount.TypeTmpfs, Target: "/cache"},
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+	}
+
+	request := &typesv1.FunctionDeployment{
+		Service:                "figlet",
+		Image:                  "functions/figlet:0.2",
+		ReadOnlyRootFilesystem: true,
+		Labels:                 &map[string]string{ScratchSizeLabel: "2k"},
+	}
+
+	if err := updateSpec(request, &spec, DeployConfig{}, nil); err != nil {
+		t.Fatalf("want: no error got: %v", err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeTmpfs, Target: "/cache"},
+		{Type: mount.TypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+		{Type: mount.TypeTmpfs, Target: "/tmp"},
+		{Type: mount.TypeTmpfs, Target: "/scratch", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 2048}},
+	}
+	if got := spec.TaskTemplate.ContainerSpec.Mounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v got: %+v", want, got)
+	}
+}
+
+func doUpdate(c DeployClient, config DeployConfig, request CreateFunctionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPut, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	UpdateHandler(c, config).ServeHTTP(rr, req)
+
+	return rr
+}
+
+// inlineSecretValues returns the values of the one-shot secrets of a function
+func inlineSecretValues(c *fakeDeployClient, service string) map[string]string {
+	values := map[string]string{}
+	for _, secret := range c.secrets {
+		if secret.Spec.Labels[inlineSecretLabel] == service {
+			values[secret.ID] = string(secret.Spec.Data)
+		}
+	}
+	return values
+}
+
+func Test_UpdateHandler_ReplacesInlineSecrets(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, EnableInlineSecrets: true}
+
+	existing := batchRequest("figlet")
+	existing.InlineSecrets = map[string]string{"api-key": "old"}
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	request := batchRequest("figlet")
+	request.InlineSecrets = map[string]string{"api-key": "new"}
+
+	rr := doUpdate(c, config, request)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	values := inlineSecretValues(c, "figlet")
+	if len(values) != 2 {
+		t.Fatalf("want: the new inline secret and the old one of the previous spec got: %v", values)
+	}
+
+	if len(c.updated) != 1 {
+		t.Fatalf("want: service updated once got: %d", len(c.updated))
+	}
+	references := c.updated[0].TaskTemplate.ContainerSpec.Secrets
+	if len(references) != 1 || values[references[0].SecretID] != "new" {
+		t.Errorf("want: service to reference the new inline secret got: %+v with secrets %v", references, values)
+	}
+	if want := "/var/openfaas/secrets/api-key"; len(references) == 1 && references[0].File.Name != want {
+		t.Errorf("want: mounted at %s got: %s", want, references[0].File.Name)
+	}
+}
+
+func Test_UpdateHandler_InlineSecretsKeptForRollback(t *testing.T) {
+	c := newFakeDeployClient()
+	c.recordUpdates = true
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, EnableInlineSecrets: true}
+
+	existing := batchRequest("figlet")
+	existing.InlineSecrets = map[string]string{"api-key": "first"}
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	for _, value := range []string{"second", "third"} {
+		request := batchRequest("figlet")
+		request.InlineSecrets = map[string]string{"api-key": value}
+
+		if rr := doUpdate(c, config, request); rr.Code != http.StatusAccepted {
+			t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+		}
+	}
+
+	values := inlineSecretValues(c, "figlet")
+	if len(values) != 2 {
+		t.Errorf("want: the inline secrets of the current and previous spec got: %v", values)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/system/function/figlet/rollback", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+	MakeRollbackHandler(c, nil, DefaultFunctionLabel).ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	service, _, _ := c.ServiceInspectWithRaw(context.Background(), "figlet", types.ServiceInspectOptions{})
+	references := service.Spec.TaskTemplate.ContainerSpec.Secrets
+	if len(references) != 1 {
+		t.Fatalf("want: rolled back spec to reference one secret got: %+v", references)
+	}
+	if value, ok := values[references[0].SecretID]; !ok || value != "second" {
+		t.Errorf("want: rolled back spec to mount the existing %q secret got: %q (exists: %t)", "second", value, ok)
+	}
+}
+
+func Test_UpdateHandler_InlineSecretsRemovedWhenUpdateFails(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, EnableInlineSecrets: true}
+
+	existing := batchRequest("figlet")
+	existing.InlineSecrets = map[string]string{"api-key": "old"}
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+	before := inlineSecretValues(c, "figlet")
+
+	c.updateErr = fmt.Errorf("update out of sequence")
+
+	request := batchRequest("figlet")
+	request.InlineSecrets = map[string]string{"api-key": "new"}
+
+	rr := doUpdate(c, config, request)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("want: %d got: %d %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+
+	if after := inlineSecretValues(c, "figlet"); !reflect.DeepEqual(after, before) {
+		t.Errorf("want: inline secrets unchanged %v got: %v", before, after)
+	}
+}
+
+func Test_UpdateHandler_InlineSecretsNotEnabled(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}
+
+	existing := batchRequest("figlet")
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	request := batchRequest("figlet")
+	request.InlineSecrets = map[string]string{"api-key": "new"}
+
+	rr := doUpdate(c, config, request)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("want: %d got: %d %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+
+	if len(c.updated) != 0 {
+		t.Errorf("want: service not updated got: %d updates", len(c.updated))
+	}
+}
+
+func Test_UpdateHandler_PullPolicyQueriesRegistry(t *testing.T) {
+	scenarios := []struct {
+		policy string
+		want   bool
+	}{
+		{policy: PullPolicyAlways, want: true},
+		{policy: PullPolicyIfNotPresent, want: false},
+		{policy: "", want: false},
+	}
+
+	for _, s := range scenarios {
+		c := newFakeDeployClient()
+		config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}
+
+		existing := batchRequest("figlet")
+		if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+			t.Fatalf("want: no error got: %d %v", status, err)
+		}
+
+		request := batchRequest("figlet")
+		if len(s.policy) > 0 {
+			request.Labels = &map[string]string{PullPolicyLabel: s.policy}
+		}
+
+		if rr := doUpdate(c, config, request); rr.Code != http.StatusAccepted {
+			t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+		}
+		if len(c.updates) != 1 || c.updates[0].QueryRegistry != s.want {
+			t.Errorf("policy %q want: QueryRegistry %v got: %v", s.policy, s.want, c.updates)
+		}
+	}
+}
+
+func Test_UpdateHandler_ReplicaQuota(t *testing.T) {
+	c := newFakeDeployClient()
+	c.services = []swarm.Service{functionWithReplicas("env", "staging", 4)}
+	config := DeployConfig{
+		MaxLabelValueLength: DefaultMaxLabelValueLength,
+		ReplicaQuota:        5,
+	}
+
+	existing := batchRequest("figlet")
+	existing.Namespace = "staging"
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	over := batchRequest("figlet")
+	over.Namespace = "staging"
+	over.Labels = &map[string]string{MinScaleLabel: "2"}
+
+	rr := doUpdate(c, config, over)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("want: %d got: %d %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	envelope := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil || envelope.Code != ErrCodeQuotaExceeded {
+		t.Errorf("want: code %s got: %s", ErrCodeQuotaExceeded, rr.Body.String())
+	}
+
+	if len(c.updated) != 0 {
+		t.Errorf("want: service not updated got: %d updates", len(c.updated))
+	}
+
+	under := batchRequest("figlet")
+	under.Namespace = "staging"
+	if rr := doUpdate(c, config, under); rr.Code != http.StatusAccepted {
+		t.Errorf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+}
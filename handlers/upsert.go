@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// isUpsert reports whether a deploy request asks to update the function when it already
+// exists with ?upsert=true
+func isUpsert(r *http.Request) bool {
+	upsert, _ := strconv.ParseBool(r.URL.Query().Get("upsert"))
+	return upsert
+}
+
+// serviceExists returns true when a service named name exists
+func serviceExists(c ServiceInspector, name string) (bool, error) {
+	_, _, err := c.ServiceInspectWithRaw(context.Background(), name, types.ServiceInspectOptions{})
+	if err == nil {
+		return true, nil
+	}
+
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// upsertFunction updates an existing function with a deploy request, keeping the replicas it
+// is scaled to. Inline secrets replace the one-shot secrets of the function as they do for
+// an update.
+func upsertFunction(ctx context.Context, c DeployClient, config DeployConfig, request *CreateFunctionRequest) (int, []string, error) {
+	return updateFunction(ctx, c, config, request.FunctionDeployment, request.InlineSecrets, true)
+}
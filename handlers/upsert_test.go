@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func doUpsert(c DeployClient, config DeployConfig, request CreateFunctionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/system/functions?upsert=true", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	DeployHandler(c, config).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func Test_DeployHandler_UpsertCreates(t *testing.T) {
+	c := newFakeDeployClient()
+
+	rr := doUpsert(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, batchRequest("figlet"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	if want := []string{"figlet"}; !reflect.DeepEqual(c.created, want) {
+		t.Errorf("want: created %v got: %v", want, c.created)
+	}
+
+	if len(c.scaled) != 0 {
+		t.Errorf("want: no services updated got: %v", c.scaled)
+	}
+}
+
+func Test_DeployHandler_UpsertUpdatesKeepingReplicas(t *testing.T) {
+	c := newFakeDeployClient()
+
+	existing := batchRequest("figlet")
+	if status, _, err := deployFunction(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	replicas := uint64(5)
+	c.specs[0].Mode.Replicated.Replicas = &replicas
+
+	request := batchRequest("figlet")
+	request.Image = "functions/alpine:0.2"
+
+	rr := doUpsert(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, request)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	if len(c.created) != 1 {
+		t.Errorf("want: no other services created got: %v", c.created)
+	}
+
+	if want := []uint64{5}; !reflect.DeepEqual(c.scaled, want) {
+		t.Errorf("want: service updated with replicas %v got: %v", want, c.scaled)
+	}
+}
+
+func Test_DeployHandler_UpsertReplacesInlineSecrets(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, EnableInlineSecrets: true}
+
+	existing := batchRequest("figlet")
+	existing.InlineSecrets = map[string]string{"api-key": "old"}
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	request := batchRequest("figlet")
+	request.InlineSecrets = map[string]string{"api-key": "new"}
+
+	rr := doUpsert(c, config, request)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d got: %d %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	if len(c.created) != 1 || len(c.updated) != 1 {
+		t.Fatalf("want: function updated once got: %d created %d updated", len(c.created), len(c.updated))
+	}
+
+	values := inlineSecretValues(c, "figlet")
+	references := c.updated[0].TaskTemplate.ContainerSpec.Secrets
+	if len(references) != 1 || values[references[0].SecretID] != "new" {
+		t.Errorf("want: service to reference the new inline secret got: %+v with secrets %v", references, values)
+	}
+}
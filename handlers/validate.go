@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// maxFunctionNameLength is the longest service name Swarm accepts
+const maxFunctionNameLength = 63
+
+// serviceNameExpression is the rule Swarm applies to service names, which allows "_" unlike
+// a hostname
+var serviceNameExpression = regexp.MustCompile(`^[a-zA-Z0-9](?:[-_]*[A-Za-z0-9]+)*$`)
+
+// maxSecretNameLength is the longest secret name Swarm accepts
+const maxSecretNameLength = 64
+
+// inlineSecretSuffixLength is the longest unique suffix createInlineSecrets adds to the name
+// of a one-shot secret, a UnixNano timestamp in base 36
+const inlineSecretSuffixLength = 13
+
+// secretNameExpression is the rule Swarm applies to secret names, an inline secret name must
+// also pass it to be used as a file name and within the name of its one-shot secret
+var secretNameExpression = regexp.MustCompile(`^[a-zA-Z0-9]+(?:[a-zA-Z0-9-_.]*[a-zA-Z0-9])?$`)
+
+// validateRequest checks every part of a request which can be checked before the service is
+// built, so all of its problems are reported at once rather than one per deploy. A single
+// problem is returned as its own DeployError, more are returned as an ErrCodeInvalidRequest
+// DeployError listing each of them in Errors. Errors which are not about the request, such
+// as a failure to list the secrets, are returned straight away. The function name is not
+// checked on update, the service already exists with that name.
+func validateRequest(c client.SecretAPIClient, config DeployConfig, request *CreateFunctionRequest, update bool) error {
+	problems := []*DeployError{}
+	check := func(err error) error {
+		if err == nil {
+			return nil
+		}
+
+		deployErr, ok := err.(*DeployError)
+		if !ok {
+			return err
+		}
+
+		problems = append(problems, deployErr)
+		return nil
+	}
+
+	deployment := &request.FunctionDeployment
+
+	checks := []func() error{
+		func() error {
+			if update {
+				return nil
+			}
+			return validateFunctionName(request.Service)
+		},
+		func() error {
+			_, err := buildLabels(deployment, config.BaseLabels, config.FunctionLabel, config.MaxLabelValueLength)
+			return err
+		},
+		func() error { return requireLabels(deployment, config.RequiredLabels) },
+		func() error {
+			_, err := buildResources(deployment, config.DefaultLimits)
+			return err
+		},
+		func() error {
+			_, err := getStopGracePeriod(deployment)
+			return err
+		},
+		func() error {
+			_, err := getMaxRestarts(deployment, config.MaxRestarts)
+			return err
+		},
+		func() error {
+			_, err := getRestartCondition(deployment, config.RestartCondition)
+			return err
+		},
+		func() error {
+			_, err := getRestartWindow(deployment)
+			return err
+		},
+		func() error {
+			_, err := getStopSignal(deployment)
+			return err
+		},
+		func() error {
+			_, err := getDependency(deployment)
+			return err
+		},
+		func() error {
+			_, err := getPullPolicy(deployment)
+			return err
+		},
+		func() error {
+			_, err := buildPlacement(deployment, config.LabelConstraints, config.DefaultConstraints)
+			return err
+		},
+		func() error {
+			_, err := buildHealthcheck(deployment)
+			return err
+		},
+		func() error {
+			_, err := buildNetworks(deployment)
+			return err
+		},
+		func() error {
+			_, err := buildPorts(deployment)
+			return err
+		},
+		func() error {
+			_, err := buildMounts(deployment, config.BindMountSources)
+			return err
+		},
+		func() error {
+			_, err := cgroupParentWarning(deployment)
+			return err
+		},
+		func() error {
+			_, err := oomScoreAdjWarning(deployment)
+			return err
+		},
+		func() error {
+			_, err := makeSecretsArray(c, request.Secrets, config.SecretMountPath)
+			return err
+		},
+		func() error { return validateInlineSecrets(config, request) },
+	}
+
+	for _, run := range checks {
+		if err := check(run()); err != nil {
+			return err
+		}
+	}
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return problems[0]
+	}
+
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.Message)
+	}
+
+	return &DeployError{
+		Code:    ErrCodeInvalidRequest,
+		Message: fmt.Sprintf("%d problems found in the request: %s", len(problems), strings.Join(messages, "; ")),
+		Errors:  problems,
+	}
+}
+
+// validateFunctionName checks that a function name can be used as a Swarm service name
+func validateFunctionName(name string) error {
+	if len(name) == 0 {
+		return newFieldError(ErrCodeInvalidRequest, "service", name, "a function name is required")
+	}
+
+	if len(name) > maxFunctionNameLength || !serviceNameExpression.MatchString(name) {
+		return newFieldError(ErrCodeInvalidRequest, "service", name, fmt.Sprintf("should be at most %d letters, digits, hyphens and underscores, starting and ending with a letter or digit", maxFunctionNameLength))
+	}
+
+	return nil
+}
+
+// validateInlineSecrets checks that inline secrets are enabled when a request has any, that
+// each name is a plain file name which fits the one-shot secret name Swarm is given, and that
+// none of them has the name of a secret referenced by the request
+func validateInlineSecrets(config DeployConfig, request *CreateFunctionRequest) error {
+	if len(request.InlineSecrets) == 0 {
+		return nil
+	}
+
+	if !config.EnableInlineSecrets {
+		return newDeployError(ErrCodeInvalidSecret, "inline secrets are not enabled")
+	}
+
+	names := make([]string, 0, len(request.InlineSecrets))
+	for name := range request.InlineSecrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !secretNameExpression.MatchString(name) {
+			return newFieldError(ErrCodeInvalidSecret, "inlineSecrets", name, "should be a file name of letters, digits, \"-\", \"_\" and \".\", starting and ending with a letter or digit")
+		}
+
+		// the one-shot secret is named service-name-suffix
+		if len(request.Service)+len(name)+inlineSecretSuffixLength+2 > maxSecretNameLength {
+			return newFieldError(ErrCodeInvalidSecret, "inlineSecrets", name, fmt.Sprintf("the one-shot secret %s-%s and its %d character suffix should be at most %d characters", request.Service, name, inlineSecretSuffixLength, maxSecretNameLength))
+		}
+	}
+
+	for _, secret := range request.Secrets {
+		if _, exists := request.InlineSecrets[secret]; exists {
+			return newDeployError(ErrCodeInvalidSecret, "duplicate secret target for %s not allowed", secret)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	typesv1 "github.com/openfaas/faas-provider/types"
+)
+
+func Test_DeployHandler_ReportsAllProblems(t *testing.T) {
+	c := newFakeDeployClient()
+
+	request := batchRequest("figlet.v2")
+	request.Limits = &typesv1.FunctionResources{Memory: "lots"}
+	request.Labels = &map[string]string{
+		annotationLabelPrefix + "topic": "orders",
+		StopGracePeriodLabel:            "soon",
+	}
+	request.Annotations = &map[string]string{"topic": "payments"}
+	request.Secrets = []string{"missing-secret"}
+	body, _ := json.Marshal(request)
+
+	req := httptest.NewRequest(http.MethodPost, "/system/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	DeployHandler(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("want: %d got: %d %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+
+	deployErr := DeployError{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployErr); err != nil {
+		t.Fatalf("want: JSON error envelope got: %q", rr.Body.String())
+	}
+	if deployErr.Code != ErrCodeInvalidRequest {
+		t.Errorf("want: code %s got: %s", ErrCodeInvalidRequest, deployErr.Code)
+	}
+
+	codes := []string{}
+	for _, problem := range deployErr.Errors {
+		codes = append(codes, problem.Code)
+	}
+	want := []string{ErrCodeInvalidRequest, ErrCodeAnnotationClash, ErrCodeInvalidMemory, ErrCodeInvalidLabel, ErrCodeSecretNotFound}
+	if len(codes) != len(want) {
+		t.Fatalf("want: codes %v got: %v", want, codes)
+	}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("want: codes %v got: %v", want, codes)
+			break
+		}
+	}
+
+	if len(c.created) != 0 {
+		t.Errorf("want: no services created got: %v", c.created)
+	}
+}
+
+func Test_ValidateRequest_SingleProblem(t *testing.T) {
+	c := newFakeDeployClient()
+	request := batchRequest("figlet")
+	request.Limits = &typesv1.FunctionResources{Memory: "lots"}
+
+	err := validateRequest(c, DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, false)
+	deployErr, ok := err.(*DeployError)
+	if !ok {
+		t.Fatalf("want: DeployError got: %v", err)
+	}
+	if deployErr.Code != ErrCodeInvalidMemory || len(deployErr.Errors) != 0 {
+		t.Errorf("want: code %s with no list got: %s %v", ErrCodeInvalidMemory, deployErr.Code, deployErr.Errors)
+	}
+}
+
+func Test_ValidateRequest_InlineSecretNames(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		service string
+		secret  string
+		wantErr bool
+	}{
+		{name: "file name", service: "figlet", secret: "api-key.txt"},
+		{name: "path", service: "figlet", secret: "../../etc/passwd", wantErr: true},
+		{name: "directory", service: "figlet", secret: "keys/api-key", wantErr: true},
+		{name: "dot", service: "figlet", secret: ".", wantErr: true},
+		{name: "space", service: "figlet", secret: "api key", wantErr: true},
+		{name: "empty", service: "figlet", secret: "", wantErr: true},
+		{name: "longest name", service: "figlet", secret: strings.Repeat("a", 43)},
+		{name: "name too long", service: "figlet", secret: strings.Repeat("a", 44), wantErr: true},
+		{name: "long function name", service: strings.Repeat("f", 48), secret: "api-key", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, EnableInlineSecrets: true}
+			request := batchRequest(s.service)
+			request.InlineSecrets = map[string]string{s.secret: "s3cr3t"}
+
+			err := validateRequest(c, config, &request, false)
+			if !s.wantErr {
+				if err != nil {
+					t.Errorf("want: no error got: %v", err)
+				}
+				return
+			}
+
+			deployErr, ok := err.(*DeployError)
+			if !ok {
+				t.Fatalf("want: DeployError got: %v", err)
+			}
+			if deployErr.Code != ErrCodeInvalidSecret || deployErr.Field != "inlineSecrets" || deployErr.Value != s.secret {
+				t.Errorf("want: %s error for inlineSecrets %q got: %+v", ErrCodeInvalidSecret, s.secret, deployErr)
+			}
+		})
+	}
+}
+
+func Test_ValidateRequest_HostNetworkMode(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		network   string
+		mode      string
+		ports     string
+		wantField string
+		wantValue string
+	}{
+		{name: "unsupported mode", mode: "bridge", wantField: NetworkModeLabel, wantValue: "bridge"},
+		{name: "host with a network", network: "func_functions", mode: "host", wantField: "network", wantValue: "func_functions"},
+		{name: "host with published ports", mode: "host", ports: "8080:8080", wantField: PortsLabel, wantValue: "8080:8080"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := batchRequest("figlet")
+			request.Network = s.network
+			request.Labels = &map[string]string{NetworkModeLabel: s.mode}
+			if len(s.ports) > 0 {
+				(*request.Labels)[PortsLabel] = s.ports
+			}
+
+			err := validateRequest(newFakeDeployClient(), DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}, &request, false)
+			deployErr, ok := err.(*DeployError)
+			if !ok {
+				t.Fatalf("want: DeployError got: %v", err)
+			}
+
+			if deployErr.Code != ErrCodeInvalidNetwork || deployErr.Field != s.wantField || deployErr.Value != s.wantValue {
+				t.Errorf("want: %s for %s=%s got: %s for %s=%s", ErrCodeInvalidNetwork, s.wantField, s.wantValue, deployErr.Code, deployErr.Field, deployErr.Value)
+			}
+		})
+	}
+}
+
+func Test_ValidateFunctionName(t *testing.T) {
+	for _, name := range []string{"figlet", "fn0", "node-info", "figlet_v2", "node__info", "a-_b", strings.Repeat("a", 63)} {
+		if err := validateFunctionName(name); err != nil {
+			t.Errorf("want: %s to be valid got: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "-figlet", "_figlet", "figlet_", "figlet.v2", "figlet-", strings.Repeat("a", 64)} {
+		if err := validateFunctionName(name); err == nil {
+			t.Errorf("want: %q to be rejected", name)
+		}
+	}
+}
+
+func Test_UpdateFunction_NameWithUnderscore(t *testing.T) {
+	c := newFakeDeployClient()
+	config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength}
+
+	existing := batchRequest("figlet_v2")
+	if status, _, err := deployFunction(c, config, &existing, nil); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+
+	request := batchRequest("figlet_v2")
+	request.Image = "functions/alpine:0.2"
+	if status, _, err := updateFunction(context.Background(), c, config, request.FunctionDeployment, nil, false); err != nil {
+		t.Fatalf("want: no error got: %d %v", status, err)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// DistributionInspector is the subset of Docker Client methods required to look up an image
+// in its registry
+type DistributionInspector interface {
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registrytypes.DistributionInspect, error)
+}
+
+// imageNotFoundMessages are the reasons given by the daemon when a registry does not have
+// an image, the daemon returns them as plain errors without a status
+var imageNotFoundMessages = []string{"manifest unknown", "not found", "name unknown"}
+
+// registryAuthMessages are the reasons given by the daemon when a registry refuses the
+// registry auth of the deploy. Docker Hub also reports a repository which does not exist
+// as "pull access denied", so it is treated as an auth error.
+var registryAuthMessages = []string{"unauthorized", "authentication required", "denied", "incorrect username or password"}
+
+// verifyImage returns an error unless the registry of an image resolves it with the registry
+// auth of the deploy. It is an ErrCodeImageNotFound error when the registry does not have
+// the image, ErrCodeRegistryAuth when the registry refuses the registry auth and
+// ErrCodeRegistryUnavailable when the registry could not be asked, such as a timeout.
+func verifyImage(c DistributionInspector, image string, encodedRegistryAuth string) error {
+	_, err := c.DistributionInspect(context.Background(), image, encodedRegistryAuth)
+	if err == nil {
+		return nil
+	}
+
+	reason := strings.ToLower(err.Error())
+
+	switch {
+	case client.IsErrNotFound(err) || containsAny(reason, imageNotFoundMessages):
+		return newDeployError(ErrCodeImageNotFound, "image not found: %s: %s", image, err)
+	case client.IsErrUnauthorized(err) || containsAny(reason, registryAuthMessages):
+		return newDeployError(ErrCodeRegistryAuth, "registry refused access to image %s: %s", image, err)
+	default:
+		return newDeployError(ErrCodeRegistryUnavailable, "could not look up image %s in its registry: %s", image, err)
+	}
+}
+
+// verifyImageStatus returns the HTTP status for an error from verifyImage, a missing image
+// is the fault of the request while the other errors are the fault of the registry
+func verifyImageStatus(err error) int {
+	switch errorCode(err, "") {
+	case ErrCodeImageNotFound:
+		return http.StatusBadRequest
+	case ErrCodeRegistryAuth:
+		return http.StatusBadGateway
+	}
+
+	return http.StatusServiceUnavailable
+}
+
+// containsAny returns true when value contains any of substrings
+func containsAny(value string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(value, substring) {
+			return true
+		}
+	}
+
+	return false
+}
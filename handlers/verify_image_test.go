@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func Test_DeployFunction_VerifyImage(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		image       string
+		verifyImage bool
+		pullPolicy  string
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "image exists", image: "functions/alpine:latest", verifyImage: true, wantStatus: http.StatusAccepted},
+		{name: "image missing", image: "functions/alpnie:latest", verifyImage: true, wantStatus: http.StatusBadRequest, wantCode: ErrCodeImageNotFound},
+		{name: "check disabled", image: "functions/alpnie:latest", verifyImage: false, wantStatus: http.StatusAccepted},
+		{name: "pull policy never", image: "functions/alpnie:latest", verifyImage: true, pullPolicy: PullPolicyNever, wantStatus: http.StatusAccepted},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.images = map[string]bool{"functions/alpine:latest": true}
+
+			request := batchRequest("figlet")
+			request.Image = s.image
+			if len(s.pullPolicy) > 0 {
+				request.Labels = &map[string]string{PullPolicyLabel: s.pullPolicy}
+			}
+
+			config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, VerifyImage: s.verifyImage}
+			status, _, err := deployFunction(c, config, &request, nil)
+			if status != s.wantStatus {
+				t.Fatalf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if len(s.wantCode) > 0 {
+				if code := errorCode(err, ""); code != s.wantCode {
+					t.Errorf("want code: %s got: %s", s.wantCode, code)
+				}
+
+				if len(c.created) != 0 {
+					t.Errorf("want: no services created got: %v", c.created)
+				}
+			}
+		})
+	}
+}
+
+func Test_DeployFunction_VerifyImageRegistryErrors(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "manifest unknown", err: errors.New("Error response from daemon: manifest unknown: manifest unknown"), wantStatus: http.StatusBadRequest, wantCode: ErrCodeImageNotFound},
+		{name: "not found", err: fakeNotFoundError{name: "functions/alpnie"}, wantStatus: http.StatusBadRequest, wantCode: ErrCodeImageNotFound},
+		{name: "unauthorized", err: errors.New("Error response from daemon: unauthorized: authentication required"), wantStatus: http.StatusBadGateway, wantCode: ErrCodeRegistryAuth},
+		{name: "pull access denied", err: errors.New("Error response from daemon: pull access denied for functions/alpnie"), wantStatus: http.StatusBadGateway, wantCode: ErrCodeRegistryAuth},
+		{name: "timeout", err: context.DeadlineExceeded, wantStatus: http.StatusServiceUnavailable, wantCode: ErrCodeRegistryUnavailable},
+		{name: "registry unreachable", err: errors.New("Error response from daemon: Get https://registry.example/v2/: dial tcp: lookup registry.example: no such host"), wantStatus: http.StatusServiceUnavailable, wantCode: ErrCodeRegistryUnavailable},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			c := newFakeDeployClient()
+			c.distributionErr = s.err
+
+			request := batchRequest("figlet")
+			config := DeployConfig{MaxLabelValueLength: DefaultMaxLabelValueLength, VerifyImage: true}
+
+			status, _, err := deployFunction(c, config, &request, nil)
+			if status != s.wantStatus {
+				t.Fatalf("want: %d got: %d %v", s.wantStatus, status, err)
+			}
+
+			if code := errorCode(err, ""); code != s.wantCode {
+				t.Errorf("want code: %s got: %s", s.wantCode, code)
+			}
+
+			if len(c.created) != 0 {
+				t.Errorf("want: no services created got: %v", c.created)
+			}
+		})
+	}
+}
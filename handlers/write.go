@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// writeJSON writes body, which must already be encoded as JSON, with the JSON content type
+func writeJSON(w http.ResponseWriter, statusCode int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// writeText writes a plain text message
+func writeText(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(message))
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/gorilla/mux"
+)
+
+func Test_ContentTypePerEndpoint(t *testing.T) {
+	secrets := newFakeDockerSecretAPIClient()
+
+	scenarios := []struct {
+		name    string
+		handler http.HandlerFunc
+		request *http.Request
+		want    string
+	}{
+		{
+			name:    "info",
+			handler: MakeInfoHandler("0.1.0", "sha"),
+			request: httptest.NewRequest(http.MethodGet, "/system/info", nil),
+			want:    "application/json",
+		},
+		{
+			name:    "list secrets",
+			handler: MakeSecretsHandler(&secrets),
+			request: httptest.NewRequest(http.MethodGet, "/system/secrets", nil),
+			want:    "application/json",
+		},
+		{
+			name:    "deploy error",
+			handler: DeployHandler(newFakeDeployClient(), DeployConfig{}),
+			request: httptest.NewRequest(http.MethodPost, "/system/functions", strings.NewReader("{")),
+			want:    "application/json",
+		},
+		{
+			name:    "batch deploy",
+			handler: MakeBatchDeployHandler(newFakeDeployClient(), DeployConfig{}),
+			request: httptest.NewRequest(http.MethodPost, "/system/functions/batch", strings.NewReader(`[{"service":"figlet","image":"functions/figlet"}]`)),
+			want:    "application/json",
+		},
+		{
+			name:    "delete not found",
+			handler: DeleteHandler(newFakeDeleteClient(), DefaultFunctionLabel),
+			request: httptest.NewRequest(http.MethodDelete, "/system/functions", bytes.NewReader([]byte(`{"functionName":"missing"}`))),
+			want:    "text/plain; charset=utf-8",
+		},
+		{
+			name:    "delete by selector without confirm",
+			handler: DeleteHandler(newFakeDeleteClient(), DefaultFunctionLabel),
+			request: httptest.NewRequest(http.MethodDelete, "/system/functions?label=team%3Dtools", nil),
+			want:    "text/plain; charset=utf-8",
+		},
+		{
+			name:    "stats",
+			handler: MakeStatsHandler(&fakeStatsClient{tasks: []swarm.Task{}, stats: map[string]types.StatsJSON{}}, DefaultFunctionLabel),
+			request: mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/system/function/figlet/stats", nil), map[string]string{"name": "figlet"}),
+			want:    "application/json",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.handler.ServeHTTP(rr, s.request)
+
+			if got := rr.Header().Get("Content-Type"); got != s.want {
+				t.Errorf("want: Content-Type %s got: %s (status %d)", s.want, got, rr.Code)
+			}
+		})
+	}
+}
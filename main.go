@@ -6,11 +6,16 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/openfaas/faas-provider/logs"
+	"github.com/openfaas/faas-provider/auth"
 	"github.com/openfaas/faas-provider/proxy"
 
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 
 	bootstrap "github.com/openfaas/faas-provider"
@@ -22,8 +27,14 @@ import (
 
 func main() {
 
-	var err error
-	dockerClient, err := client.NewEnvClient()
+	readConfig := types.ReadConfig{}
+	osEnv := bootTypes.OsEnv{}
+	cfg, err := readConfig.Read(osEnv)
+	if err != nil {
+		log.Fatalf("Error reading config: %s", err.Error())
+	}
+
+	dockerClient, err := newDockerClient(cfg)
 	if err != nil {
 		log.Fatalf("Error with Docker client: %s.", err.Error())
 	}
@@ -40,32 +51,69 @@ func main() {
 	// Delay between container restarts
 	restartDelay := time.Second * 5
 
-	readConfig := types.ReadConfig{}
-	osEnv := bootTypes.OsEnv{}
-	cfg, err := readConfig.Read(osEnv)
-	if err != nil {
-		log.Fatalf("Error reading config: %s", err.Error())
-	}
-
 	log.Printf("HTTP Read Timeout: %s\n", cfg.FaaSConfig.GetReadTimeout())
 	log.Printf("HTTP Write Timeout: %s\n", cfg.FaaSConfig.WriteTimeout)
 
 	funcProxyHandler := handlers.NewFunctionLookup(dockerClient, cfg.DNSRoundRobin)
 
+	deployConfig := handlers.DeployConfig{
+		MaxRestarts:         maxRestarts,
+		RestartCondition:    swarm.RestartPolicyCondition(cfg.RestartCondition),
+		RestartDelay:        restartDelay,
+		MaxLabelValueLength: cfg.MaxLabelValueLength,
+		EnableInlineSecrets: cfg.EnableInlineSecrets,
+		NetworkLabel:        cfg.NetworkLabel,
+		TeamNetworks:        cfg.TeamNetworks,
+		IdempotencyTTL:      cfg.IdempotencyTTL,
+		DeployTimeout:       cfg.DeployTimeout,
+		PrePullTimeout:      cfg.PrePullTimeout,
+		DependencyTimeout:   cfg.DependencyTimeout,
+		RequiredLabels:      cfg.CostLabels,
+		BaseLabels:          cfg.BaseLabels,
+		SecretMountPath:     cfg.FunctionSecretMountPath,
+		ConstraintCheck:     cfg.ConstraintCheck,
+		VerifyImage:         cfg.VerifyImage,
+		DefaultRegistry:     cfg.DefaultRegistry,
+		AllowedRegistries:   cfg.AllowedRegistries,
+		BindMountSources:    cfg.BindMountSources,
+		ReplicaQuota:        cfg.NamespaceReplicaQuota,
+		MaxFunctions:        cfg.MaxFunctions,
+		DeprecatedLabels:    cfg.DeprecatedLabels,
+		FunctionLabel:       cfg.FunctionLabel,
+		LabelConstraints:    cfg.LabelConstraints,
+		DefaultConstraints:  cfg.DefaultConstraints,
+		Limiter:             handlers.NewDeployLimiter(cfg.DeployConcurrency, cfg.DeployQueueLength),
+		Metrics:             handlers.NewDeployMetrics(),
+	}
+
+	if len(cfg.BindMountSources) > 0 {
+		log.Printf("Bind mount sources: %q\n", cfg.BindMountSources)
+	}
+
+	if cfg.DefaultConstraints != nil {
+		log.Printf("Default constraints: %q\n", cfg.DefaultConstraints)
+	}
+
+	if len(cfg.DefaultLimitMemory) > 0 || len(cfg.DefaultLimitCPU) > 0 {
+		deployConfig.DefaultLimits = &bootTypes.FunctionResources{
+			Memory: cfg.DefaultLimitMemory,
+			CPU:    cfg.DefaultLimitCPU,
+		}
+		log.Printf("Default limits: memory: %q, cpu: %q\n", cfg.DefaultLimitMemory, cfg.DefaultLimitCPU)
+	}
+
 	bootstrapHandlers := bootTypes.FaaSHandlers{
-		DeleteHandler:  handlers.DeleteHandler(dockerClient),
-		DeployHandler:  handlers.DeployHandler(dockerClient, maxRestarts, restartDelay),
-		FunctionReader: handlers.FunctionReader(true, dockerClient),
+		DeleteHandler:  handlers.DeleteHandler(dockerClient, cfg.FunctionLabel),
+		DeployHandler:  handlers.DeployHandler(dockerClient, deployConfig),
+		FunctionReader: handlers.FunctionReader(true, dockerClient, cfg.FunctionLabel),
 		FunctionProxy:  proxy.NewHandlerFunc(cfg.FaaSConfig, funcProxyHandler),
-		ReplicaReader:  handlers.ReplicaReader(dockerClient),
-		ReplicaUpdater: handlers.ReplicaUpdater(dockerClient),
-		UpdateHandler:  handlers.UpdateHandler(dockerClient, maxRestarts, restartDelay),
+		ReplicaReader:  handlers.ReplicaReader(dockerClient, cfg.RedactEnvVars, cfg.FunctionLabel),
+		ReplicaUpdater: handlers.ReplicaUpdater(dockerClient, cfg.NamespaceReplicaQuota, cfg.FunctionLabel, handlers.NewScaleWebhook(cfg.ScaleWebhookURL, cfg.ScaleWebhookRetries)),
+		UpdateHandler:  handlers.UpdateHandler(dockerClient, deployConfig),
 		HealthHandler:  handlers.Health(),
 		InfoHandler:    handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommit),
 		SecretHandler:  handlers.MakeSecretsHandler(dockerClient),
-		LogHandler:     logs.NewLogHandlerFunc(handlers.NewLogRequester(dockerClient), cfg.FaaSConfig.WriteTimeout),
-		ListNamespaceHandler: handlers.NamespaceLister(),
-
+		LogHandler:     handlers.MakeLogHandler(handlers.NewLogRequester(dockerClient), cfg.FaaSConfig.WriteTimeout, cfg.LogBufferLines, cfg.LogHeartbeatInterval),
 	}
 
 	bootstrapConfig := bootTypes.FaaSConfig{
@@ -78,5 +126,85 @@ func main() {
 
 	log.Printf("Basic authentication: %v\n", bootstrapConfig.EnableBasicAuth)
 
+	withAuth := func(next http.HandlerFunc) http.HandlerFunc { return next }
+	if bootstrapConfig.EnableBasicAuth {
+		reader := auth.ReadBasicAuthFromDisk{
+			SecretMountPath: bootstrapConfig.SecretMountPath,
+		}
+
+		credentials, err := reader.Read()
+		if err != nil {
+			log.Fatalf("Error reading basic auth credentials: %s", err.Error())
+		}
+
+		withAuth = func(next http.HandlerFunc) http.HandlerFunc {
+			return auth.DecorateWithBasicAuth(next, credentials)
+		}
+	}
+
+	// faas-provider does not add basic auth to the namespaces endpoint
+	bootstrapHandlers.ListNamespaceHandler = withAuth(handlers.NamespaceLister(dockerClient, cfg.FunctionLabel))
+
+	// Routes specific to faas-swarm, registered alongside the faas-provider routes
+	functionPath := "/system/function/{name:[" + bootstrap.NameExpression + "]+}"
+	router := bootstrap.Router()
+
+	drainer := handlers.NewDrainer()
+	router.Use(drainer.Middleware)
+	go drainOnSignal(drainer, cfg.ShutdownTimeout)
+
+	maintenance := handlers.NewMaintenance()
+	router.Use(maintenance.Middleware)
+
+	rateLimiter := handlers.NewClientRateLimiter(cfg.ClientRateLimit, cfg.ClientRateBurst, cfg.ClientIdentityHeader, cfg.ClientTrustedProxies)
+	router.Use(rateLimiter.Middleware)
+	if cfg.ClientRateLimit > 0 {
+		log.Printf("Client rate limit: %d write requests per minute, burst: %d, trusted proxies: %v\n", cfg.ClientRateLimit, cfg.ClientRateBurst, cfg.ClientTrustedProxies)
+	}
+
+	router.HandleFunc(handlers.MaintenancePath, withAuth(handlers.MakeMaintenanceHandler(maintenance))).Methods(http.MethodGet, http.MethodPut)
+	router.HandleFunc("/system/metrics/deploy", withAuth(handlers.MakeDeployMetricsHandler(deployConfig.Metrics))).Methods(http.MethodGet)
+	router.HandleFunc("/system/metrics/summary", withAuth(handlers.MakeMetricsSummaryHandler(dockerClient, cfg.FunctionLabel))).Methods(http.MethodGet)
+	router.HandleFunc("/system/capabilities", withAuth(handlers.MakeCapabilitiesHandler())).Methods(http.MethodGet)
+	router.HandleFunc("/system/functions/batch", withAuth(handlers.MakeBatchDeployHandler(dockerClient, deployConfig))).Methods(http.MethodPost)
+	router.HandleFunc(functionPath, withAuth(handlers.MakeFunctionExistsHandler(dockerClient, cfg.FunctionLabel))).Methods(http.MethodHead)
+	router.HandleFunc(functionPath+"/events", withAuth(handlers.MakeEventsHandler(dockerClient, cfg.FunctionLabel))).Methods(http.MethodGet)
+	router.HandleFunc(functionPath+"/stats", withAuth(handlers.MakeStatsHandler(dockerClient, cfg.FunctionLabel))).Methods(http.MethodGet)
+	router.HandleFunc(functionPath+"/placement", withAuth(handlers.MakePlacementHandler(dockerClient, cfg.FunctionLabel))).Methods(http.MethodGet)
+	router.HandleFunc(functionPath+"/export", withAuth(handlers.MakeExportHandler(dockerClient, cfg.RedactEnvVars, cfg.FunctionLabel))).Methods(http.MethodGet)
+	router.HandleFunc(functionPath+"/rollback", withAuth(handlers.MakeRollbackHandler(dockerClient, deployConfig.Limiter, cfg.FunctionLabel))).Methods(http.MethodPost)
+
 	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
 }
+
+// drainOnSignal waits for SIGTERM or SIGINT, then drains the requests in progress for up to
+// timeout before exiting
+func drainOnSignal(drainer *handlers.Drainer, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-signals
+	log.Printf("Received %s, draining requests for up to %s\n", sig, timeout)
+
+	if err := drainer.Drain(timeout); err != nil {
+		log.Printf("Error draining requests: %s\n", err)
+	}
+
+	os.Exit(0)
+}
+
+// newDockerClient creates a Docker client from the environment, optionally pointing
+// it at a remote daemon and securing the connection with the configured TLS files
+func newDockerClient(cfg types.SwarmConfig) (*client.Client, error) {
+	opts := []func(*client.Client) error{client.FromEnv}
+
+	if len(cfg.DockerHost) > 0 {
+		opts = append(opts, client.WithHost(cfg.DockerHost))
+	}
+
+	if cfg.UseDockerTLS() {
+		opts = append(opts, client.WithTLSClientConfig(cfg.DockerTLSCACert, cfg.DockerTLSCert, cfg.DockerTLSKey))
+	}
+
+	return client.NewClientWithOpts(opts...)
+}
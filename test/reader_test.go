@@ -60,7 +60,7 @@ func TestReaderSuccessReturnsOK(t *testing.T) {
 		serviceListServices: []swarm.Service{},
 		serviceListError:    nil,
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
@@ -78,7 +78,7 @@ func TestReaderSuccessReturnsJsonContent(t *testing.T) {
 		serviceListServices: []swarm.Service{},
 		serviceListError:    nil,
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
@@ -96,7 +96,7 @@ func TestReaderSuccessReturnsCorrectBodyWithZeroFunctions(t *testing.T) {
 		serviceListServices: []swarm.Service{},
 		serviceListError:    nil,
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
@@ -143,21 +143,26 @@ func TestReaderSuccessReturnsCorrectBodyWithOneFunction(t *testing.T) {
 		serviceListServices: services,
 		serviceListError:    nil,
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
 	handler.ServeHTTP(w, r)
 
-	functions := []typesv1.FunctionStatus{
+	functions := []handlers.FunctionSummary{
 		{
-			Name:            "bar",
-			Image:           "foo/bar:latest",
-			InvocationCount: 0,
-			Replicas:        5,
-			EnvProcess:      "bar",
-			Labels: &map[string]string{
-				"function": "bar",
+			FunctionStatus: typesv1.FunctionStatus{
+				Name:            "bar",
+				Image:           "foo/bar:latest",
+				InvocationCount: 0,
+				Replicas:        5,
+				EnvProcess:      "bar",
+				Labels: &map[string]string{
+					"function": "bar",
+				},
+			},
+			ScaleBounds: handlers.ScaleBounds{
+				MinReplicas: 1,
 			},
 		},
 	}
@@ -176,7 +181,7 @@ func TestReaderErrorReturnsInternalServerError(t *testing.T) {
 		serviceListServices: nil,
 		serviceListError:    errors.New("error"),
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
@@ -195,7 +200,7 @@ func TestReaderErrorReturnsCorrectBody(t *testing.T) {
 		serviceListServices: nil,
 		serviceListError:    fmt.Errorf("unable to fetch list"),
 	}
-	handler := handlers.FunctionReader(true, c)
+	handler := handlers.FunctionReader(true, c, handlers.DefaultFunctionLabel)
 
 	w := httptest.NewRecorder()
 	r := &http.Request{}
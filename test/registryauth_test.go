@@ -21,6 +21,8 @@ func TestBuildEncodedAuthConfig(t *testing.T) {
 	testValidEncodedAuthConfig(t, "user", "weird:password:", "my.repository.com/user/imagename", "my.repository.com")
 	testValidEncodedAuthConfig(t, "userWithNoPassword", "", "my.repository.com/user/imagename", "my.repository.com")
 	testValidEncodedAuthConfig(t, "", "", "my.repository.com/user/imagename", "my.repository.com")
+	testValidEncodedAuthConfig(t, "user", "password", "registry.local:5000/imagename", "registry.local:5000")
+	testValidEncodedAuthConfig(t, "user", "password", "localhost/imagename", "localhost")
 
 	// docker hub default repository
 	testValidEncodedAuthConfig(t, "user", "password", "user/imagename", "docker.io")
@@ -30,6 +32,7 @@ func TestBuildEncodedAuthConfig(t *testing.T) {
 	testValidEncodedAuthConfig(t, "user", "password", "docker.io/user/imagename:v0.1", "docker.io")
 	testValidEncodedAuthConfig(t, "user", "password", "docker.io/user/imagename:latest", "docker.io")
 	testValidEncodedAuthConfig(t, "", "", "docker.io/user/imagename", "docker.io")
+	testValidEncodedAuthConfig(t, "user", "password", "alpine:3.8", "docker.io")
 
 	// invalid base64 basic auth
 	assertEncodedAuthError(t, "invalidBasicAuth", "my.repository.com/user/imagename")
@@ -3,9 +3,72 @@
 package types
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
+// defaultMaxLabelValueLength is the maximum size in bytes of a label or annotation value
+const defaultMaxLabelValueLength = 4096
+
+// defaultNetworkLabel is the label selector for the network functions are attached to
+const defaultNetworkLabel = "openfaas=true"
+
+// defaultIdempotencyTTL is how long a deploy's Idempotency-Key is remembered
+const defaultIdempotencyTTL = time.Minute * 10
+
+// defaultDeployTimeout is how long to wait for Swarm to create a function's service
+const defaultDeployTimeout = time.Second * 30
+
+// defaultShutdownTimeout is how long the provider waits for requests in progress on shutdown
+const defaultShutdownTimeout = time.Second * 30
+
+// defaultScaleWebhookRetries is how many times a scale event is sent again after a failure
+const defaultScaleWebhookRetries = 3
+
+// defaultFunctionLabel is the label holding the name of a function, which identifies the
+// services deployed as functions
+const defaultFunctionLabel = "com.openfaas.function"
+
+// defaultPrePullTimeout is how long a deploy waits for a function's image to be pre-pulled
+const defaultPrePullTimeout = time.Minute * 2
+
+// costLabelPrefix is the reserved prefix of the cost allocation labels
+const costLabelPrefix = "com.openfaas.cost."
+
+// defaultCostLabels are the labels required on every function in cost-tracking mode
+const defaultCostLabels = "com.openfaas.cost.team,com.openfaas.cost.project"
+
+// defaultFunctionSecretMountPath is the directory function secrets are mounted in
+const defaultFunctionSecretMountPath = "/var/openfaas/secrets"
+
+// defaultLogHeartbeatInterval is how long a followed log stream can be idle before an empty
+// line is written to keep it open
+const defaultLogHeartbeatInterval = time.Second * 30
+
+// defaultLogBufferLines is how many log messages are held for a slow log client
+const defaultLogBufferLines = 256
+
+// defaultDeployQueueLength is how many deployments wait for a slot when deploy_concurrency
+// is set, before more are rejected
+const defaultDeployQueueLength = 100
+
+// defaultClientRateBurst is how many write requests a client can send at once when
+// client_rate_limit is set
+const defaultClientRateBurst = 10
+
+// defaultRestartCondition restarts function tasks whenever they exit
+const defaultRestartCondition = "any"
+
 // ReadConfig constitutes config from env variables
 type ReadConfig struct {
 }
@@ -20,11 +83,424 @@ func (ReadConfig) Read(hasEnv ftypes.HasEnv) (SwarmConfig, error) {
 	}
 
 	cfg.DNSRoundRobin = ftypes.ParseBoolValue(hasEnv.Getenv("dnsrr"), false)
+	cfg.MaxLabelValueLength = ftypes.ParseIntValue(hasEnv.Getenv("max_label_value_length"), defaultMaxLabelValueLength)
+	cfg.EnableInlineSecrets = ftypes.ParseBoolValue(hasEnv.Getenv("inline_secrets"), false)
+
+	cfg.RedactEnvVars = ftypes.ParseBoolValue(hasEnv.Getenv("redact_env_vars"), false)
+	cfg.LogHeartbeatInterval = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("log_heartbeat_interval"), defaultLogHeartbeatInterval)
+
+	cfg.LogBufferLines = ftypes.ParseIntValue(hasEnv.Getenv("log_buffer_lines"), defaultLogBufferLines)
+	if cfg.LogBufferLines <= 0 {
+		return cfg, fmt.Errorf("invalid value for log_buffer_lines: %d, should be greater than zero", cfg.LogBufferLines)
+	}
+
+	if value := hasEnv.Getenv("namespace_replica_quota"); len(value) > 0 {
+		quota, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid value for namespace_replica_quota: %s, should be zero for no quota or greater", value)
+		}
+		cfg.NamespaceReplicaQuota = quota
+	}
+
+	cfg.MaxFunctions = ftypes.ParseIntValue(hasEnv.Getenv("max_functions"), 0)
+	if cfg.MaxFunctions < 0 {
+		return cfg, fmt.Errorf("invalid value for max_functions: %d, should be zero for no limit or greater", cfg.MaxFunctions)
+	}
+
+	cfg.DeployConcurrency = ftypes.ParseIntValue(hasEnv.Getenv("deploy_concurrency"), 0)
+	if cfg.DeployConcurrency < 0 {
+		return cfg, fmt.Errorf("invalid value for deploy_concurrency: %d, should be zero for no limit or greater", cfg.DeployConcurrency)
+	}
+
+	cfg.DeployQueueLength = ftypes.ParseIntValue(hasEnv.Getenv("deploy_queue_length"), defaultDeployQueueLength)
+	if cfg.DeployQueueLength < 0 {
+		return cfg, fmt.Errorf("invalid value for deploy_queue_length: %d, should be zero or greater", cfg.DeployQueueLength)
+	}
+
+	cfg.ClientRateLimit = ftypes.ParseIntValue(hasEnv.Getenv("client_rate_limit"), 0)
+	if cfg.ClientRateLimit < 0 {
+		return cfg, fmt.Errorf("invalid value for client_rate_limit: %d, should be zero for no limit or greater", cfg.ClientRateLimit)
+	}
+
+	cfg.ClientRateBurst = ftypes.ParseIntValue(hasEnv.Getenv("client_rate_burst"), defaultClientRateBurst)
+	if cfg.ClientRateBurst < 1 {
+		return cfg, fmt.Errorf("invalid value for client_rate_burst: %d, should be 1 or greater", cfg.ClientRateBurst)
+	}
+
+	cfg.ClientIdentityHeader = hasEnv.Getenv("client_identity_header")
+
+	if value := hasEnv.Getenv("client_trusted_proxies"); len(value) > 0 {
+		for _, proxy := range strings.Split(value, ",") {
+			proxy = strings.TrimSpace(proxy)
+			if len(proxy) == 0 {
+				continue
+			}
+			network, err := parseTrustedProxy(proxy)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid value for client_trusted_proxies: %s, should be an IP address or CIDR", proxy)
+			}
+			cfg.ClientTrustedProxies = append(cfg.ClientTrustedProxies, network)
+		}
+	}
+
+	cfg.IdempotencyTTL = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("idempotency_ttl"), defaultIdempotencyTTL)
+	cfg.DeployTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("deploy_timeout"), defaultDeployTimeout)
+	cfg.DependencyTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("dependency_timeout"), 0)
+	cfg.PrePullTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("prepull_timeout"), defaultPrePullTimeout)
+	cfg.ShutdownTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("shutdown_timeout"), defaultShutdownTimeout)
+
+	cfg.NetworkLabel = ftypes.ParseString(hasEnv.Getenv("network_label"), defaultNetworkLabel)
+	if parts := strings.SplitN(cfg.NetworkLabel, "=", 2); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return cfg, fmt.Errorf("invalid value for network_label: %s, should be key=value", cfg.NetworkLabel)
+	}
+
+	if ftypes.ParseBoolValue(hasEnv.Getenv("cost_tracking"), false) {
+		for _, label := range strings.Split(ftypes.ParseString(hasEnv.Getenv("cost_labels"), defaultCostLabels), ",") {
+			label = strings.TrimSpace(label)
+			if len(label) == 0 {
+				continue
+			}
+			if !strings.HasPrefix(label, costLabelPrefix) || len(label) == len(costLabelPrefix) {
+				return cfg, fmt.Errorf("invalid value for cost_labels: %s, labels must start with %s", label, costLabelPrefix)
+			}
+			cfg.CostLabels = append(cfg.CostLabels, label)
+		}
+	}
+
+	cfg.DefaultLimitMemory = hasEnv.Getenv("default_limit_memory")
+	if len(cfg.DefaultLimitMemory) > 0 {
+		if _, err := units.RAMInBytes(cfg.DefaultLimitMemory); err != nil {
+			return cfg, fmt.Errorf("invalid value for default_limit_memory: %s", cfg.DefaultLimitMemory)
+		}
+	}
+
+	cfg.DefaultLimitCPU = hasEnv.Getenv("default_limit_cpu")
+	if len(cfg.DefaultLimitCPU) > 0 {
+		if !isValidCPU(cfg.DefaultLimitCPU) {
+			return cfg, fmt.Errorf("invalid value for default_limit_cpu: %s, should be nano CPUs, millicores such as 500m or cores such as 0.5", cfg.DefaultLimitCPU)
+		}
+	}
+
+	cfg.FunctionLabel = ftypes.ParseString(hasEnv.Getenv("function_label"), defaultFunctionLabel)
+	if strings.ContainsAny(cfg.FunctionLabel, "=, ") {
+		return cfg, fmt.Errorf("invalid value for function_label: %s, should be a label key", cfg.FunctionLabel)
+	}
+
+	deprecatedLabels, err := parseDeprecatedLabels(hasEnv.Getenv("deprecated_labels"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.DeprecatedLabels = deprecatedLabels
+
+	labelConstraints, err := parseLabelConstraints(hasEnv.Getenv("label_constraints"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LabelConstraints = labelConstraints
+
+	defaultConstraints, err := parseDefaultConstraints(hasEnv.Getenv("default_constraints"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.DefaultConstraints = defaultConstraints
+
+	teamNetworks, err := parseTeamNetworks(hasEnv.Getenv("team_networks"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TeamNetworks = teamNetworks
+
+	baseLabels, err := parseBaseLabels(hasEnv.Getenv("base_labels"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.BaseLabels = baseLabels
+
+	cfg.FunctionSecretMountPath = ftypes.ParseString(hasEnv.Getenv("function_secret_mount_path"), defaultFunctionSecretMountPath)
+	if !path.IsAbs(cfg.FunctionSecretMountPath) {
+		return cfg, fmt.Errorf("invalid value for function_secret_mount_path: %s, should be an absolute path", cfg.FunctionSecretMountPath)
+	}
+
+	cfg.DefaultRegistry = hasEnv.Getenv("default_registry")
+	if len(cfg.DefaultRegistry) > 0 && !isRegistryHost(cfg.DefaultRegistry) {
+		return cfg, fmt.Errorf("invalid value for default_registry: %s, should be a registry host such as registry.example.com:5000", cfg.DefaultRegistry)
+	}
+
+	if value := hasEnv.Getenv("allowed_registries"); len(value) > 0 {
+		for _, prefix := range strings.Split(value, ",") {
+			prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+			if len(prefix) == 0 {
+				continue
+			}
+			if !isRegistryHost(strings.SplitN(prefix, "/", 2)[0]) {
+				return cfg, fmt.Errorf("invalid value for allowed_registries: %s, should start with a registry host such as docker.io or registry.example.com:5000", prefix)
+			}
+			cfg.AllowedRegistries = append(cfg.AllowedRegistries, prefix)
+		}
+	}
+
+	if value := hasEnv.Getenv("bind_mount_sources"); len(value) > 0 {
+		for _, source := range strings.Split(value, ",") {
+			source = strings.TrimSpace(source)
+			if len(source) == 0 {
+				continue
+			}
+			if !path.IsAbs(source) || path.Clean(source) != source {
+				return cfg, fmt.Errorf("invalid value for bind_mount_sources: %s, should be a clean absolute path", source)
+			}
+			cfg.BindMountSources = append(cfg.BindMountSources, source)
+		}
+	}
+
+	cfg.ScaleWebhookURL = hasEnv.Getenv("scale_webhook_url")
+	if len(cfg.ScaleWebhookURL) > 0 {
+		webhookURL, err := url.Parse(cfg.ScaleWebhookURL)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || len(webhookURL.Host) == 0 {
+			return cfg, fmt.Errorf("invalid value for scale_webhook_url: %s, should be an http or https URL", cfg.ScaleWebhookURL)
+		}
+	}
+
+	cfg.ScaleWebhookRetries = ftypes.ParseIntValue(hasEnv.Getenv("scale_webhook_retries"), defaultScaleWebhookRetries)
+	if cfg.ScaleWebhookRetries < 0 {
+		return cfg, fmt.Errorf("invalid value for scale_webhook_retries: %d, should be zero or more", cfg.ScaleWebhookRetries)
+	}
+
+	cfg.RestartCondition = ftypes.ParseString(hasEnv.Getenv("restart_condition"), defaultRestartCondition)
+	switch cfg.RestartCondition {
+	case "any", "on-failure", "none":
+	default:
+		return cfg, fmt.Errorf("invalid value for restart_condition: %s, should be any, on-failure or none", cfg.RestartCondition)
+	}
+
+	cfg.VerifyImage = ftypes.ParseBoolValue(hasEnv.Getenv("verify_image"), false)
+
+	switch constraintCheck := ftypes.ParseString(hasEnv.Getenv("constraint_check"), "off"); constraintCheck {
+	case "off":
+	case "warn", "error":
+		cfg.ConstraintCheck = constraintCheck
+	default:
+		return cfg, fmt.Errorf("invalid value for constraint_check: %s, should be off, warn or error", constraintCheck)
+	}
+
 	cfg.FaaSConfig = *faasCfg
 
+	cfg.DockerHost = hasEnv.Getenv("docker_host")
+	cfg.DockerTLSCACert = hasEnv.Getenv("docker_tls_ca_cert")
+	cfg.DockerTLSCert = hasEnv.Getenv("docker_tls_cert")
+	cfg.DockerTLSKey = hasEnv.Getenv("docker_tls_key")
+
+	if err := validateTLSFiles(cfg); err != nil {
+		return cfg, err
+	}
+
 	return cfg, nil
 }
 
+// isRegistryHost returns true for a host which docker recognises as a registry rather than
+// a Docker Hub user, i.e. it has a domain, a port or is localhost
+func isRegistryHost(host string) bool {
+	if strings.ContainsAny(host, "/ ") || strings.Contains(host, "://") {
+		return false
+	}
+
+	return strings.ContainsAny(host, ".:") || host == "localhost"
+}
+
+// isValidCPU returns true for the CPU formats accepted for function limits
+func isValidCPU(value string) bool {
+	if strings.HasSuffix(value, "m") {
+		_, err := strconv.ParseUint(strings.TrimSuffix(value, "m"), 10, 64)
+		return err == nil
+	}
+
+	if strings.Contains(value, ".") {
+		cores, err := strconv.ParseFloat(value, 64)
+		return err == nil && cores >= 0
+	}
+
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// parseDeprecatedLabels parses a comma-separated list of old=new label keys
+func parseDeprecatedLabels(value string) (map[string]string, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+
+	keys := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 || parts[0] == parts[1] {
+			return nil, fmt.Errorf("invalid value for deprecated_labels: %s, should be old=new", pair)
+		}
+		keys[parts[0]] = parts[1]
+	}
+
+	return keys, nil
+}
+
+// parseLabelConstraints parses a comma-separated list of label=constraint templates, such as
+// gpu=node.labels.gpu=={value}
+func parseLabelConstraints(value string) (map[string]string, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+
+	templates := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || (!strings.Contains(parts[1], "==") && !strings.Contains(parts[1], "!=")) {
+			return nil, fmt.Errorf("invalid value for label_constraints: %s, should be label=constraint such as gpu=node.labels.gpu=={value}", pair)
+		}
+		templates[parts[0]] = strings.TrimSpace(parts[1])
+	}
+
+	return templates, nil
+}
+
+// constraintKeyExpression matches the key of a placement constraint, such as node.labels.zone
+var constraintKeyExpression = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+
+// parseDefaultConstraints parses a comma-separated list of placement constraints, such as
+// node.platform.os==windows. It returns nil when the value is empty so the Linux default is
+// kept, and an empty list for "none".
+func parseDefaultConstraints(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	if value == "none" {
+		return []string{}, nil
+	}
+
+	constraints := []string{}
+	for _, constraint := range strings.Split(value, ",") {
+		constraint = strings.TrimSpace(constraint)
+		if !isValidConstraint(constraint) {
+			return nil, fmt.Errorf("invalid value for default_constraints: %s, should be a key, the operator == or != and a value, such as node.platform.os==linux", constraint)
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	return constraints, nil
+}
+
+// isValidConstraint returns true for a constraint with a key, one == or != operator and a value
+func isValidConstraint(constraint string) bool {
+	for _, operator := range []string{"==", "!="} {
+		parts := strings.SplitN(constraint, operator, 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		return constraintKeyExpression.MatchString(key) && len(value) > 0 && !strings.ContainsAny(value, "=!<>")
+	}
+
+	return false
+}
+
+// parseTeamNetworks parses a comma-separated list of team=network pairs, such as
+// payments=payments_net
+func parseTeamNetworks(value string) (map[string]string, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+
+	networks := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid value for team_networks: %s, should be team=network", pair)
+		}
+		networks[parts[0]] = parts[1]
+	}
+
+	return networks, nil
+}
+
+// parseBaseLabels parses a comma-separated list of key=value labels, labels prefixed with
+// com.openfaas. are reserved and rejected
+func parseBaseLabels(value string) (map[string]string, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid value for base_labels: %s, should be key=value", pair)
+		}
+
+		if parts[0] == "function" || strings.HasPrefix(parts[0], "com.openfaas.") {
+			return nil, fmt.Errorf("invalid value for base_labels: %s is reserved", parts[0])
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+// parseTrustedProxy parses an IP address or CIDR into a network, an address is a network
+// of just itself
+func parseTrustedProxy(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", value)
+	}
+
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	} else {
+		ip = ip.To4()
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// validateTLSFiles checks that either none or all of the Docker TLS files are set
+// and that each of them exists, so that a bad path fails at startup
+func validateTLSFiles(cfg SwarmConfig) error {
+	files := map[string]string{
+		"docker_tls_ca_cert": cfg.DockerTLSCACert,
+		"docker_tls_cert":    cfg.DockerTLSCert,
+		"docker_tls_key":     cfg.DockerTLSKey,
+	}
+
+	if !cfg.UseDockerTLS() {
+		for name, path := range files {
+			if len(path) > 0 {
+				return fmt.Errorf("%s is set, docker_tls_ca_cert, docker_tls_cert and docker_tls_key must be set together", name)
+			}
+		}
+		return nil
+	}
+
+	for name, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
 // SwarmConfig contains the configuration for the process.
 type SwarmConfig struct {
 	// DNSRoundRobin controls how faas-swarm will lookup functions when proxying requests.
@@ -35,6 +511,115 @@ type SwarmConfig struct {
 	// 	DNSRoundRObin = false
 	// faas-swarm will attempt to resolve the function by name, validating using the Swarm API
 	DNSRoundRobin bool
+	// MaxLabelValueLength is the maximum size in bytes accepted for a function label or annotation value
+	MaxLabelValueLength int
+	// EnableInlineSecrets allows deploy requests to carry secret values which are created as
+	// one-shot secrets owned by the function
+	EnableInlineSecrets bool
+	// NetworkLabel is the key=value label selector used to find the default function network
+	NetworkLabel string
+	// RedactEnvVars hides environment variable values in the function detail response
+	RedactEnvVars bool
+	// ScaleWebhookURL receives a POST each time a function is scaled, empty for none
+	ScaleWebhookURL string
+	// ScaleWebhookRetries is how many times a scale event is sent again after a failure
+	ScaleWebhookRetries int
+	// LogHeartbeatInterval is how long a followed log stream can be idle before an empty line
+	// is written to keep it open, zero disables the heartbeat
+	LogHeartbeatInterval time.Duration
+	// LogBufferLines is how many log messages are held for a client which is slower than the
+	// function writing them, a client which stays behind is disconnected
+	LogBufferLines int
+	// IdempotencyTTL is how long a successful deploy is remembered by its Idempotency-Key header
+	IdempotencyTTL time.Duration
+	// DefaultConstraints are the placement constraints of functions which do not set their
+	// own, nil for the Linux-only default and empty for none
+	DefaultConstraints []string
+	// LabelConstraints maps label keys to placement constraint templates
+	LabelConstraints map[string]string
+	// TeamNetworks maps the values of the com.openfaas.cost.team label to the default network
+	// of the team's functions
+	TeamNetworks map[string]string
+	// FunctionLabel is the label holding the name of a function, which identifies the services
+	// deployed as functions
+	FunctionLabel string
+	// DeprecatedLabels maps deprecated label keys to the keys which replace them
+	DeprecatedLabels map[string]string
+	// NamespaceReplicaQuota caps the desired replicas of the functions in each namespace,
+	// zero for no quota
+	NamespaceReplicaQuota uint64
+	// MaxFunctions caps how many functions can be deployed, zero for no limit
+	MaxFunctions int
+	// DeployConcurrency is how many service creates and updates are sent to Swarm at the
+	// same time, zero for no limit
+	DeployConcurrency int
+	// DeployQueueLength is how many deployments wait when DeployConcurrency are in
+	// progress, more are rejected with 429
+	DeployQueueLength int
+	// ClientRateLimit is how many write requests each client can send per minute, zero for
+	// no limit
+	ClientRateLimit int
+	// ClientRateBurst is how many write requests a client can send at once before
+	// ClientRateLimit applies
+	ClientRateBurst int
+	// ClientIdentityHeader names the header identifying the client of a request for rate
+	// limiting, such as one set by an authenticating proxy. It is only read from the
+	// ClientTrustedProxies.
+	ClientIdentityHeader string
+	// ClientTrustedProxies are the proxies, such as the gateway, whose ClientIdentityHeader
+	// and X-Forwarded-For headers are trusted to identify the client of a request
+	ClientTrustedProxies []*net.IPNet
+	// DeployTimeout is how long a deploy waits for Swarm to create the service, zero waits forever
+	DeployTimeout time.Duration
+	// DependencyTimeout is how long a deploy waits for the function named in its
+	// com.openfaas.depends_on label to have a running replica, zero does not wait
+	DependencyTimeout time.Duration
+	// PrePullTimeout is how long a deploy waits for an image to be pre-pulled on every node
+	PrePullTimeout time.Duration
+	// ShutdownTimeout is how long the provider waits for requests in progress on SIGTERM
+	ShutdownTimeout time.Duration
+	// CostLabels are the cost allocation labels required on every function, only set when
+	// the provider is started in cost-tracking mode
+	CostLabels []string
+	// DefaultLimitMemory is the memory limit for functions which do not set one, empty for no limit
+	DefaultLimitMemory string
+	// DefaultLimitCPU is the CPU limit in nano CPUs for functions which do not set one, empty for no limit
+	DefaultLimitCPU string
+	// BaseLabels are added to every function unless the function sets the same label
+	BaseLabels map[string]string
+	// FunctionSecretMountPath is the directory function secrets are mounted in
+	FunctionSecretMountPath string
+	// DefaultRegistry is the registry host for function images which do not name one, Docker
+	// Hub when empty
+	DefaultRegistry string
+	// AllowedRegistries are the registry and repository prefixes function images can be
+	// deployed from, any image is allowed when empty
+	AllowedRegistries []string
+	// BindMountSources are the host paths functions can bind mount, bind mounts are not
+	// allowed when empty
+	BindMountSources []string
+	// RestartCondition is when function tasks are restarted by default, one of "any",
+	// "on-failure" or "none"
+	RestartCondition string
+	// VerifyImage looks up the image of a function in its registry before it is deployed
+	VerifyImage bool
+	// ConstraintCheck is "warn" or "error" to check that a node satisfies the placement
+	// constraints of a function before it is deployed, empty when the check is off
+	ConstraintCheck string
 	// FaasConfig contains the standard OpenFaaS provider configuration
 	FaaSConfig ftypes.FaaSConfig
+	// DockerHost is the address of a remote Docker daemon, i.e. tcp://manager:2376. When
+	// empty the DOCKER_HOST environment variable or the local socket is used
+	DockerHost string
+	// DockerTLSCACert is the path to the CA certificate used to verify the Docker daemon
+	DockerTLSCACert string
+	// DockerTLSCert is the path to the client certificate presented to the Docker daemon
+	DockerTLSCert string
+	// DockerTLSKey is the path to the key for DockerTLSCert
+	DockerTLSKey string
+}
+
+// UseDockerTLS returns true when all of the Docker TLS file paths have been configured
+func (c SwarmConfig) UseDockerTLS() bool {
+	return len(c.DockerTLSCACert) > 0 && len(c.DockerTLSCert) > 0 && len(c.DockerTLSKey) > 0
 }
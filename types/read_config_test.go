@@ -0,0 +1,270 @@
+package types
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseDeprecatedLabels(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: " "},
+		{name: "one pair", value: "old=new", want: map[string]string{"old": "new"}},
+		{name: "pairs with spaces", value: "a=b, c=d", want: map[string]string{"a": "b", "c": "d"}},
+		{name: "no new key", value: "old=", wantErr: true},
+		{name: "no old key", value: "=new", wantErr: true},
+		{name: "no separator", value: "old", wantErr: true},
+		{name: "same key", value: "old=old", wantErr: true},
+		{name: "empty pair", value: "a=b,", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := parseDeprecatedLabels(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error for %q got: %v", s.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %v got: %v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseLabelConstraints(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{name: "equal template", value: "gpu=node.labels.gpu=={value}", want: map[string]string{"gpu": "node.labels.gpu=={value}"}},
+		{name: "not equal template", value: "zone=node.labels.zone!={value}, tier=node.labels.tier=={value}", want: map[string]string{"zone": "node.labels.zone!={value}", "tier": "node.labels.tier=={value}"}},
+		{name: "no operator", value: "gpu=node.labels.gpu", wantErr: true},
+		{name: "no label", value: "=node.labels.gpu=={value}", wantErr: true},
+		{name: "no separator", value: "gpu", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := parseLabelConstraints(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error for %q got: %v", s.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %v got: %v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseDefaultConstraints(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty keeps the default", value: ""},
+		{name: "none", value: "none", want: []string{}},
+		{name: "constraints", value: "node.platform.os==windows, node.labels.zone != eu", want: []string{"node.platform.os==windows", "node.labels.zone != eu"}},
+		{name: "no operator", value: "node.platform.os", wantErr: true},
+		{name: "no value", value: "node.platform.os==", wantErr: true},
+		{name: "bad key", value: "node platform==linux", wantErr: true},
+		{name: "two operators", value: "node.role==manager==worker", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := parseDefaultConstraints(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error for %q got: %v", s.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %#v got: %#v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseTeamNetworks(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{name: "pairs", value: "payments=payments_net, tools=tools_net", want: map[string]string{"payments": "payments_net", "tools": "tools_net"}},
+		{name: "no network", value: "payments=", wantErr: true},
+		{name: "no team", value: "=payments_net", wantErr: true},
+		{name: "no separator", value: "payments", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := parseTeamNetworks(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error for %q got: %v", s.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %v got: %v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseBaseLabels(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{name: "labels", value: "logging=enabled, team=tools", want: map[string]string{"logging": "enabled", "team": "tools"}},
+		{name: "empty pairs skipped", value: "logging=enabled,,", want: map[string]string{"logging": "enabled"}},
+		{name: "value with separator", value: "query=a=b", want: map[string]string{"query": "a=b"}},
+		{name: "no value", value: "logging=", wantErr: true},
+		{name: "no key", value: "=enabled", wantErr: true},
+		{name: "reserved prefix", value: "com.openfaas.scale.min=5", wantErr: true},
+		{name: "reserved function label", value: "function=false", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got, err := parseBaseLabels(s.value)
+			if s.wantErr {
+				if err == nil {
+					t.Errorf("want: error for %q got: %v", s.value, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("want: no error got: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Errorf("want: %v got: %v", s.want, got)
+			}
+		})
+	}
+}
+
+func Test_ParseTrustedProxy(t *testing.T) {
+	scenarios := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "10.0.0.1", want: "10.0.0.1/32"},
+		{value: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{value: "fd00::1", want: "fd00::1/128"},
+		{value: "fd00::/8", want: "fd00::/8"},
+		{value: "proxy.local", wantErr: true},
+		{value: "10.0.0.0/33", wantErr: true},
+	}
+
+	for _, s := range scenarios {
+		network, err := parseTrustedProxy(s.value)
+		if s.wantErr {
+			if err == nil {
+				t.Errorf("want: error for %q got: %v", s.value, network)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("want: no error for %q got: %v", s.value, err)
+			continue
+		}
+		if network.String() != s.want {
+			t.Errorf("want: %s got: %s", s.want, network)
+		}
+	}
+}
+
+func Test_ValidateTLSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-tls")
+	if err != nil {
+		t.Fatalf("unexpected error creating a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{}
+	for _, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		files[name] = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(files[name], []byte("-----BEGIN-----"), 0600); err != nil {
+			t.Fatalf("unexpected error writing %s: %s", name, err)
+		}
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	scenarios := []struct {
+		name    string
+		cfg     SwarmConfig
+		wantErr string
+	}{
+		{name: "no TLS", cfg: SwarmConfig{}},
+		{name: "valid set", cfg: SwarmConfig{DockerTLSCACert: files["ca.pem"], DockerTLSCert: files["cert.pem"], DockerTLSKey: files["key.pem"]}},
+		{name: "cert not set", cfg: SwarmConfig{DockerTLSCACert: files["ca.pem"], DockerTLSKey: files["key.pem"]}, wantErr: "must be set together"},
+		{name: "key not set", cfg: SwarmConfig{DockerTLSCACert: files["ca.pem"], DockerTLSCert: files["cert.pem"]}, wantErr: "must be set together"},
+		{name: "CA not set", cfg: SwarmConfig{DockerTLSCert: files["cert.pem"], DockerTLSKey: files["key.pem"]}, wantErr: "must be set together"},
+		{name: "cert missing", cfg: SwarmConfig{DockerTLSCACert: files["ca.pem"], DockerTLSCert: missing, DockerTLSKey: files["key.pem"]}, wantErr: "docker_tls_cert"},
+		{name: "key missing", cfg: SwarmConfig{DockerTLSCACert: files["ca.pem"], DockerTLSCert: files["cert.pem"], DockerTLSKey: missing}, wantErr: "docker_tls_key"},
+		{name: "CA missing", cfg: SwarmConfig{DockerTLSCACert: missing, DockerTLSCert: files["cert.pem"], DockerTLSKey: files["key.pem"]}, wantErr: "docker_tls_ca_cert"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			err := validateTLSFiles(s.cfg)
+			if len(s.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("want: no error got: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), s.wantErr) {
+				t.Errorf("want: error containing %q got: %v", s.wantErr, err)
+			}
+		})
+	}
+}